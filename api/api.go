@@ -0,0 +1,690 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api deploys and manages sandboxes from other Go programs, such as integration tests.
+// Its functions return errors of type *Error instead of terminating the program,
+// and describe the sandboxes with the Sandbox structure.
+//
+// The context given to each function is checked before every step of the operation.
+// Start and Stop also interrupt the sandbox scripts when the context is done,
+// while a deployment or a removal that has started runs to completion.
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+)
+
+// Options for the deployment of a single sandbox, and for the nodes of a replication sandbox
+type DeployOptions struct {
+	Version          string   // MySQL version (e.g. 8.0.16, or 8.0 for the latest 8.0 available). Required
+	BasedirName      string   // Directory with the binaries, inside SandboxBinary. Defaults to Version
+	Flavor           string   // Flavor of the binaries. Detected from the binaries when empty
+	ClientFrom       string   // Directory with the client binaries, inside SandboxBinary. Required by flavors without a client
+	SandboxHome      string   // Where the sandbox is created. Defaults to sandbox-home
+	SandboxBinary    string   // Where the binaries are. Defaults to sandbox-binary
+	SandboxDirectory string   // Name of the sandbox directory. Defaults to the name that dbdeployer would use
+	Port             int      // Port of a single sandbox. Defaults to the port derived from the version
+	BasePort         int      // Base port of a replication sandbox. Defaults to the one derived from the version
+	DbUser           string   // Defaults to msandbox
+	DbPassword       string   // Defaults to msandbox
+	RplUser          string   // Defaults to rsandbox
+	RplPassword      string   // Defaults to rsandbox
+	RemoteAccess     string   // Defaults to 127.%
+	BindAddress      string   // Defaults to 127.0.0.1
+	MyCnfOptions     []string // Options to add to my.sandbox.cnf
+	InitOptions      []string // Options for the initialization of the database
+	PreGrantsSql     []string // Queries to run before loading the grants
+	PostGrantsSql    []string // Queries to run after loading the grants
+	Gtid             bool     // Enables GTID
+	SkipStart        bool     // Does not start the database after the deployment
+	Force            bool     // Replaces an existing sandbox with the same directory
+}
+
+// Options for the deployment of a replication sandbox
+type ReplicationOptions struct {
+	DeployOptions
+	Topology        string // master-slave (default), group, fan-in, all-masters
+	Nodes           int    // Defaults to 3
+	SinglePrimary   bool   // Uses single-primary mode with group replication
+	MasterIp        string // Defaults to 127.0.0.1
+	MasterList      string // Masters of fan-in and all-masters topologies. Defaults to "1,2"
+	SlaveList       string // Slaves of fan-in and all-masters topologies. Defaults to "3"
+	RunConcurrently bool   // Deploys the nodes concurrently
+}
+
+// A database user of a sandbox
+type User struct {
+	Name     string
+	Password string
+	Purpose  string // admin, database, or replication
+}
+
+// A database server belonging to a sandbox. A single sandbox has only one node
+type Node struct {
+	Name    string // Name of the node directory (the sandbox directory for a single sandbox)
+	Dir     string
+	Role    string // single, master, slave, or node
+	Host    string // Address to connect to the node
+	Port    int
+	Socket  string
+	Running bool
+}
+
+// The description of a deployed sandbox
+type Sandbox struct {
+	Name    string // Name of the sandbox directory
+	Dir     string // Full path of the sandbox
+	Type    string // single, multiple, master-slave, group, fan-in, all-masters
+	Version string
+	Flavor  string
+	Ports   []int  // All the ports used by the sandbox
+	State   string // running, stopped, or partial
+	Locked  bool
+	Nodes   []Node
+	Users   []User
+}
+
+// Returns the directory of the first node, where the database users can be found
+func (sb Sandbox) firstNodeDir() string {
+	if len(sb.Nodes) > 0 {
+		return sb.Nodes[0].Dir
+	}
+	return sb.Dir
+}
+
+// Returns the user of the sandbox with the given purpose
+func (sb Sandbox) User(purpose string) (User, bool) {
+	for _, user := range sb.Users {
+		if user.Purpose == purpose {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// Returns the database flavor of a directory of binaries.
+// A user defined flavor must match the one recorded in the FLAVOR file, when there is one.
+func DetectFlavor(userDefinedFlavor, basedir string) (string, error) {
+	flavorOrigin := ""
+	flavor := userDefinedFlavor
+	if userDefinedFlavor != "" {
+		flavorOrigin = "flag"
+	}
+	flavorFile := path.Join(basedir, globals.FlavorFileName)
+	if common.FileExists(flavorFile) {
+		flavorText, err := common.SlurpAsString(flavorFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading flavor file %s: %s", flavorFile, err)
+		}
+		flavorText = strings.TrimSpace(flavorText)
+		if userDefinedFlavor != "" && userDefinedFlavor != flavorText {
+			return "", fmt.Errorf("user defined flavor %s doesn't match found flavor %s", userDefinedFlavor, flavorText)
+		}
+		flavor = flavorText
+		flavorOrigin = "FLAVOR file"
+	}
+	// Flavor detection based on tarball contents
+	if flavor == "" {
+		flavor = common.DetectBinaryFlavor(basedir)
+		flavorOrigin = "Binary examination"
+	}
+	err := common.CheckFlavorSupport(flavor)
+	if err != nil {
+		return "", fmt.Errorf("flavor detected from %s unsupported: %s", flavorOrigin, err)
+	}
+	return flavor, nil
+}
+
+// Returns the ports that a new sandbox in sandboxHome can't use: the ones of the installed sandboxes,
+// the reserved ports, and the ones leased to sandboxes in other sandbox homes
+func UnavailablePorts(sandboxHome string) ([]int, error) {
+	ports, err := common.GetInstalledPorts(sandboxHome)
+	if err != nil {
+		return nil, err
+	}
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return nil, err
+	}
+	ports = append(ports, currentDefaults.ReservedPorts...)
+	leasedPorts, err := defaults.LeasedPorts()
+	if err != nil {
+		return nil, err
+	}
+	return append(ports, leasedPorts...), nil
+}
+
+func stringOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// Returns the sandbox home to use, after checking that the defaults can be read
+func resolveSandboxHome(op, sandboxHome string) (string, error) {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return "", newError(KindConfiguration, op, "", err)
+	}
+	sandboxHome, err = common.AbsolutePath(stringOrDefault(sandboxHome, currentDefaults.SandboxHome))
+	if err != nil {
+		return "", newError(KindInvalidOptions, op, "", err)
+	}
+	return sandboxHome, nil
+}
+
+// Fills a sandbox definition from the deployment options, the same way "dbdeployer deploy" does from its flags
+func sandboxDefinition(op string, options DeployOptions) (sandbox.SandboxDef, error) {
+	var sd sandbox.SandboxDef
+	if options.Version == "" {
+		return sd, newErrorf(KindInvalidOptions, op, "", "version is required")
+	}
+	if options.DbUser == "root" || options.RplUser == "root" {
+		return sd, newErrorf(KindInvalidOptions, op, "", "database and replication users cannot be 'root'")
+	}
+	sandboxHome, err := resolveSandboxHome(op, options.SandboxHome)
+	if err != nil {
+		return sd, err
+	}
+	currentDefaults, _ := defaults.LoadDefaults()
+	sandboxBinary, err := common.AbsolutePath(stringOrDefault(options.SandboxBinary, currentDefaults.SandboxBinary))
+	if err != nil {
+		return sd, newError(KindInvalidOptions, op, "", err)
+	}
+
+	sd.Version = options.Version
+	if regexp.MustCompile(`^\d+\.\d+$`).MatchString(sd.Version) && common.DirExists(sandboxBinary) {
+		fullVersion := common.LatestVersion(sandboxBinary, sd.Version)
+		if fullVersion == "" {
+			return sd, newErrorf(KindBinaries, op, "", "no full version found for %s in %s", sd.Version, sandboxBinary)
+		}
+		sd.Version = fullVersion
+	}
+	if !common.IsVersion(sd.Version) {
+		return sd, newErrorf(KindInvalidOptions, op, "", "invalid version %s", sd.Version)
+	}
+	sd.BasedirName = stringOrDefault(options.BasedirName, sd.Version)
+	sd.Basedir = path.Join(sandboxBinary, sd.BasedirName)
+	if !common.DirExists(sd.Basedir) {
+		return sd, newErrorf(KindBinaries, op, "", globals.ErrBaseDirectoryNotFound, sd.Basedir)
+	}
+	err = common.CheckTarballOperatingSystem(sd.Basedir)
+	if err != nil {
+		return sd, newError(KindBinaries, op, "", err)
+	}
+	sd.Flavor, err = DetectFlavor(options.Flavor, sd.Basedir)
+	if err != nil {
+		return sd, newError(KindBinaries, op, "", err)
+	}
+	if options.ClientFrom != "" {
+		sd.ClientBasedir = path.Join(sandboxBinary, options.ClientFrom)
+		if !common.DirExists(sd.ClientBasedir) {
+			return sd, newErrorf(KindBinaries, op, "", globals.ErrDirectoryNotFound, sd.ClientBasedir)
+		}
+	}
+
+	sd.Port, err = common.VersionToPort(sd.Version)
+	if err != nil || sd.Port < 0 {
+		return sd, newErrorf(KindInvalidOptions, op, "", "can't convert '%s' into port number", sd.Version)
+	}
+	if options.Port > 0 {
+		sd.UserPort = options.Port
+		sd.Port = options.Port
+	}
+	sd.BasePort = options.BasePort
+
+	err = common.CheckSandboxDir(sandboxHome)
+	if err != nil {
+		return sd, newError(KindInvalidOptions, op, "", err)
+	}
+	sd.SandboxDir = sandboxHome
+	sd.DirName = options.SandboxDirectory
+	sd.InstalledPorts, err = UnavailablePorts(sandboxHome)
+	if err != nil {
+		return sd, newError(KindConfiguration, op, "", err)
+	}
+
+	sd.DbUser = stringOrDefault(options.DbUser, globals.DbUserValue)
+	sd.DbPassword = stringOrDefault(options.DbPassword, globals.DbPasswordValue)
+	sd.RplUser = stringOrDefault(options.RplUser, globals.RplUserValue)
+	sd.RplPassword = stringOrDefault(options.RplPassword, globals.RplPasswordValue)
+	sd.RemoteAccess = stringOrDefault(options.RemoteAccess, globals.RemoteAccessValue)
+	sd.BindAddress = stringOrDefault(options.BindAddress, globals.BindAddressValue)
+	sd.MyCnfOptions = options.MyCnfOptions
+	sd.InitOptions = options.InitOptions
+	sd.PreGrantsSql = options.PreGrantsSql
+	sd.PostGrantsSql = options.PostGrantsSql
+	sd.SkipStart = options.SkipStart
+	sd.LoadGrants = !options.SkipStart
+	sd.Force = options.Force
+	if options.Gtid {
+		isMinimumGtid, err := common.HasCapability(sd.Flavor, common.GTID, sd.Version)
+		if err != nil {
+			return sd, newError(KindInvalidOptions, op, "", err)
+		}
+		if !isMinimumGtid {
+			return sd, newErrorf(KindInvalidOptions, op, "", globals.ErrOptionRequiresVersion,
+				globals.GtidLabel, common.IntSliceToDottedString(globals.MinimumGtidVersion))
+		}
+		templateName := "gtid_options_56"
+		isEnhancedGtid, err := common.HasCapability(sd.Flavor, common.EnhancedGTID, sd.Version)
+		if err == nil && isEnhancedGtid {
+			templateName = "gtid_options_57"
+		}
+		sd.GtidOptions = sandbox.SingleTemplates[templateName].Contents
+		sd.ReplCrashSafeOptions = sandbox.SingleTemplates["repl_crash_safe_options"].Contents
+		sd.ReplOptions = sandbox.SingleTemplates["replication_options"].Contents
+		sd.ServerId = sd.Port
+	}
+	return sd, nil
+}
+
+// Returns the sandbox definition for the deployment options, with the checks of DeploySingle.
+// The "dbdeployer deploy" commands use it to fill their definitions from the flags
+func SandboxDefinition(options DeployOptions) (sandbox.SandboxDef, error) {
+	return sandboxDefinition("SandboxDefinition", options)
+}
+
+// Checks whether a sandbox can be created in the given directory
+func checkTarget(op, sandboxDir string, force bool) error {
+	if !common.DirExists(sandboxDir) {
+		return nil
+	}
+	if !force {
+		return newErrorf(KindSandboxExists, op, sandboxDir, "use the Force option to replace it")
+	}
+	if isLocked(sandboxDir) {
+		return newErrorf(KindSandboxLocked, op, sandboxDir, "a locked sandbox can't be replaced")
+	}
+	return nil
+}
+
+// Runs a deployment function, removing the partially created sandbox directory when it fails.
+// A directory that existed before the deployment (a sandbox replaced with Force) is left alone
+func deploy(ctx context.Context, op, sandboxDir string, deployFunc func() error) (Sandbox, error) {
+	err := checkContext(ctx, op, sandboxDir)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	existed := common.DirExists(sandboxDir)
+	registeredActions := common.CleanupActionsCount()
+	err = deployFunc()
+	// The clean-up actions registered by the sandbox package terminate the program when they fail.
+	// The ones added by this deployment are discarded, and the API removes the sandbox directory by itself.
+	// The ones that the caller registered before are kept
+	common.DiscardCleanupActions(registeredActions)
+	if err != nil {
+		if !existed {
+			removeErr := os.RemoveAll(sandboxDir)
+			if removeErr != nil {
+				err = fmt.Errorf("%s (error removing %s: %s)", err, sandboxDir, removeErr)
+			}
+		}
+		// The ports reserved for a sandbox that was not created go back to the registry
+		if !common.DirExists(sandboxDir) {
+			releaseErr := defaults.ReleasePorts(sandboxDir)
+			if releaseErr != nil {
+				err = fmt.Errorf("%s (error releasing ports of %s: %s)", err, sandboxDir, releaseErr)
+			}
+		}
+		return Sandbox{}, newError(KindDeployment, op, sandboxDir, err)
+	}
+	sb, err := describeSandbox(sandboxDir)
+	if err != nil {
+		return Sandbox{}, newError(KindDeployment, op, sandboxDir, err)
+	}
+	return sb, nil
+}
+
+// Deploys a single sandbox
+func DeploySingle(ctx context.Context, options DeployOptions) (Sandbox, error) {
+	op := "DeploySingle"
+	sd, err := sandboxDefinition(op, options)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	if sd.DirName == "" {
+		currentDefaults, _ := defaults.LoadDefaults()
+		// The same name given by sandbox.CreateStandaloneSandbox
+		sd.DirName = currentDefaults.SandboxPrefix + common.VersionToName(sd.Version)
+		if sd.Version != sd.BasedirName {
+			sd.DirName = currentDefaults.SandboxPrefix + sd.BasedirName
+		}
+	}
+	sandboxDir := path.Join(sd.SandboxDir, sd.DirName)
+	err = checkTarget(op, sandboxDir, sd.Force)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	return deploy(ctx, op, sandboxDir, func() error {
+		return sandbox.CreateStandaloneSandbox(sd)
+	})
+}
+
+// Deploys a replication sandbox
+func DeployReplication(ctx context.Context, options ReplicationOptions) (Sandbox, error) {
+	op := "DeployReplication"
+	sd, err := sandboxDefinition(op, options.DeployOptions)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	if sd.Flavor == common.TiDbFlavor {
+		return Sandbox{}, newErrorf(KindInvalidOptions, op, "", "flavor '%s' is not suitable to create replication sandboxes", common.TiDbFlavor)
+	}
+	topology := stringOrDefault(options.Topology, globals.MasterSlaveLabel)
+	nodes := options.Nodes
+	if nodes == 0 {
+		nodes = globals.NodesValue
+	}
+	masterIp := stringOrDefault(options.MasterIp, globals.MasterIpValue)
+	masterList := ""
+	slaveList := ""
+	if topology == globals.FanInLabel || topology == globals.AllMastersLabel {
+		masterList = stringOrDefault(options.MasterList, globals.MasterListValue)
+		slaveList = stringOrDefault(options.SlaveList, globals.SlaveListValue)
+	}
+	if options.SinglePrimary && topology != globals.GroupLabel {
+		return Sandbox{}, newErrorf(KindInvalidOptions, op, "", "option 'single-primary' can only be used with 'group' topology")
+	}
+	sd.SinglePrimary = options.SinglePrimary
+	sd.RunConcurrently = options.RunConcurrently
+	sd.ReplOptions = sandbox.SingleTemplates["replication_options"].Contents
+
+	if sd.DirName == "" {
+		currentDefaults, _ := defaults.LoadDefaults()
+		// The same names given by sandbox.CreateReplicationSandbox
+		prefixes := map[string]string{
+			globals.MasterSlaveLabel: currentDefaults.MasterSlavePrefix,
+			globals.GroupLabel:       currentDefaults.GroupPrefix,
+			globals.FanInLabel:       currentDefaults.FanInPrefix,
+			globals.AllMastersLabel:  currentDefaults.AllMastersPrefix,
+		}
+		if sd.SinglePrimary {
+			prefixes[globals.GroupLabel] = currentDefaults.GroupSpPrefix
+		}
+		prefix, ok := prefixes[topology]
+		if !ok {
+			return Sandbox{}, newErrorf(KindInvalidOptions, op, "", "unrecognized topology %s", topology)
+		}
+		sd.DirName = prefix + common.VersionToName(sd.BasedirName)
+	}
+	sandboxDir := path.Join(sd.SandboxDir, sd.DirName)
+	err = checkTarget(op, sandboxDir, sd.Force)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	return deploy(ctx, op, sandboxDir, func() error {
+		return sandbox.CreateReplicationSandbox(sd, sd.BasedirName, topology, nodes, masterIp, masterList, slaveList)
+	})
+}
+
+func isLocked(sandboxDir string) bool {
+	return common.FileExists(path.Join(sandboxDir, globals.ScriptNoClear)) ||
+		common.FileExists(path.Join(sandboxDir, globals.ScriptNoClearAll))
+}
+
+// Returns the full path of an existing sandbox
+func findSandbox(op, sandboxHome, name string) (string, error) {
+	if name == "" {
+		return "", newErrorf(KindInvalidOptions, op, "", "sandbox name is required")
+	}
+	sandboxHome, err := resolveSandboxHome(op, sandboxHome)
+	if err != nil {
+		return "", err
+	}
+	sandboxDir := path.Join(sandboxHome, name)
+	if !common.FileExists(path.Join(sandboxDir, globals.SandboxDescriptionName)) {
+		return "", newErrorf(KindSandboxNotFound, op, sandboxDir, "no sandbox description found")
+	}
+	return sandboxDir, nil
+}
+
+// Removes a sandbox and its entry in the catalog. An empty sandboxHome means the default sandbox home
+func Delete(ctx context.Context, sandboxHome, name string) error {
+	op := "Delete"
+	sandboxDir, err := findSandbox(op, sandboxHome, name)
+	if err != nil {
+		return err
+	}
+	if isLocked(sandboxDir) {
+		return newErrorf(KindSandboxLocked, op, sandboxDir, "unlock it with 'dbdeployer admin unlock %s'", name)
+	}
+	err = checkContext(ctx, op, sandboxDir)
+	if err != nil {
+		return err
+	}
+	_, err = sandbox.RemoveSandbox(common.DirName(sandboxDir), name, false)
+	if err != nil {
+		return newError(KindOperation, op, sandboxDir, err)
+	}
+	err = defaults.DeleteFromCatalog(sandboxDir)
+	if err != nil {
+		return newError(KindOperation, op, sandboxDir, err)
+	}
+	return nil
+}
+
+// Runs the first script of the list that exists in the sandbox directory
+func runSandboxScript(ctx context.Context, op, sandboxDir string, scripts ...string) error {
+	err := checkContext(ctx, op, sandboxDir)
+	if err != nil {
+		return err
+	}
+	for _, script := range scripts {
+		scriptPath := path.Join(sandboxDir, script)
+		if !common.ExecExists(scriptPath) {
+			continue
+		}
+		// The output goes to a file rather than to a pipe, so that processes started
+		// by the script don't delay the return when the context is canceled
+		output, err := ioutil.TempFile("", "dbdeployer-"+script)
+		if err != nil {
+			return newError(KindOperation, op, sandboxDir, err)
+		}
+		defer os.Remove(output.Name())
+		command := exec.CommandContext(ctx, scriptPath)
+		command.Stdout = output
+		command.Stderr = output
+		err = command.Run()
+		_ = output.Close()
+		if ctx.Err() != nil {
+			return newError(KindCanceled, op, sandboxDir, ctx.Err())
+		}
+		if err != nil {
+			text, _ := common.SlurpAsString(output.Name())
+			return newErrorf(KindOperation, op, sandboxDir, "%s: %s\n%s", script, err, text)
+		}
+		return nil
+	}
+	return newErrorf(KindOperation, op, sandboxDir, "none of the scripts %v found", scripts)
+}
+
+// Starts all the nodes of a sandbox. An empty sandboxHome means the default sandbox home
+func Start(ctx context.Context, sandboxHome, name string) error {
+	sandboxDir, err := findSandbox("Start", sandboxHome, name)
+	if err != nil {
+		return err
+	}
+	return runSandboxScript(ctx, "Start", sandboxDir, globals.ScriptStartAll, globals.ScriptStart)
+}
+
+// Stops all the nodes of a sandbox. An empty sandboxHome means the default sandbox home
+func Stop(ctx context.Context, sandboxHome, name string) error {
+	sandboxDir, err := findSandbox("Stop", sandboxHome, name)
+	if err != nil {
+		return err
+	}
+	return runSandboxScript(ctx, "Stop", sandboxDir, globals.ScriptStopAll, globals.ScriptStop)
+}
+
+// Returns the database user found in the client section of a node configuration file,
+// for sandboxes whose description does not list the users
+func readUsers(nodeDir string) ([]User, error) {
+	config, err := common.ParseConfigFile(path.Join(nodeDir, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return nil, err
+	}
+	user := User{Purpose: common.UserPurposeDatabase}
+	for _, kv := range config["client"] {
+		switch kv.Key {
+		case "user":
+			user.Name = strings.TrimSpace(kv.Value)
+		case "password":
+			user.Password = strings.TrimSpace(kv.Value)
+		}
+	}
+	if user.Name == "" {
+		return nil, nil
+	}
+	return []User{user}, nil
+}
+
+// Returns the address to connect to a node, from the bind-address of its configuration file
+func nodeHost(nodeDir string) string {
+	host := globals.BindAddressValue
+	config, err := common.ParseConfigFile(path.Join(nodeDir, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return host
+	}
+	for _, kv := range config["mysqld"] {
+		if kv.Key == globals.BindAddressLabel {
+			address := strings.TrimSpace(kv.Value)
+			// A server listening on all interfaces is reached locally
+			if address != "" && address != "0.0.0.0" && address != "*" && address != "::" {
+				host = address
+			}
+		}
+	}
+	return host
+}
+
+// Returns the description of a deployed sandbox
+func describeSandbox(sandboxDir string) (Sandbox, error) {
+	sbd, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	sb := Sandbox{
+		Name:    common.BaseName(sandboxDir),
+		Dir:     sandboxDir,
+		Type:    sbd.SBType,
+		Version: sbd.Version,
+		Flavor:  sbd.Flavor,
+		Ports:   sbd.Port,
+		Locked:  isLocked(sandboxDir),
+	}
+	status, err := common.GetSandboxStatus(sandboxDir, false)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	sb.State = status.State
+	for _, node := range status.Nodes {
+		role := ""
+		// Each node of a composite sandbox has its own description
+		nodeDescription, err := common.ReadSandboxDescription(node.Dir)
+		if err == nil {
+			role = nodeDescription.Role
+		}
+		sb.Nodes = append(sb.Nodes, Node{
+			Name:    node.Name,
+			Dir:     node.Dir,
+			Role:    role,
+			Host:    nodeHost(node.Dir),
+			Port:    node.Port,
+			Socket:  node.Socket,
+			Running: node.Running,
+		})
+	}
+	// The ports of the nodes follow their numbering (master, node1, node2, ...)
+	sort.SliceStable(sb.Nodes, func(i, j int) bool {
+		return sb.Nodes[i].Port < sb.Nodes[j].Port
+	})
+	for _, user := range sbd.Users {
+		sb.Users = append(sb.Users, User{Name: user.Username, Password: user.Password, Purpose: user.Description})
+	}
+	if len(sb.Users) == 0 {
+		sb.Users, err = readUsers(sb.firstNodeDir())
+		if err != nil {
+			return Sandbox{}, err
+		}
+	}
+	return sb, nil
+}
+
+// Returns the description of a deployed sandbox. An empty sandboxHome means the default sandbox home
+func DescribeSandbox(ctx context.Context, sandboxHome, name string) (Sandbox, error) {
+	op := "DescribeSandbox"
+	sandboxDir, err := findSandbox(op, sandboxHome, name)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	err = checkContext(ctx, op, sandboxDir)
+	if err != nil {
+		return Sandbox{}, err
+	}
+	sb, err := describeSandbox(sandboxDir)
+	if err != nil {
+		return Sandbox{}, newError(KindOperation, op, sandboxDir, err)
+	}
+	return sb, nil
+}
+
+// Returns the sandboxes installed in a sandbox home. An empty sandboxHome means the default sandbox home
+func ListSandboxes(ctx context.Context, sandboxHome string) ([]Sandbox, error) {
+	op := "ListSandboxes"
+	sandboxHome, err := resolveSandboxHome(op, sandboxHome)
+	if err != nil {
+		return nil, err
+	}
+	var sandboxes []Sandbox
+	if !common.DirExists(sandboxHome) {
+		return sandboxes, nil
+	}
+	installed, err := common.GetInstalledSandboxes(sandboxHome)
+	if err != nil {
+		return nil, newError(KindOperation, op, "", err)
+	}
+	for _, info := range installed {
+		sandboxDir := path.Join(sandboxHome, info.SandboxName)
+		err = checkContext(ctx, op, sandboxDir)
+		if err != nil {
+			return nil, err
+		}
+		// Directories without a description are left over by older versions
+		if !common.FileExists(path.Join(sandboxDir, globals.SandboxDescriptionName)) {
+			continue
+		}
+		sb, err := describeSandbox(sandboxDir)
+		if err != nil {
+			return nil, newError(KindOperation, op, sandboxDir, err)
+		}
+		sandboxes = append(sandboxes, sb)
+	}
+	return sandboxes, nil
+}
@@ -0,0 +1,343 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Points the configuration and the catalog to a temporary directory
+func setTestEnvironment(t *testing.T, baseDir string) {
+	configDir := path.Join(baseDir, "config")
+	err := os.MkdirAll(configDir, 0755)
+	compare.OkIsNil("configuration directory", err, t)
+	defaults.ActiveProfile = ""
+	defaults.ConfigurationDir = configDir
+	defaults.ConfigurationFile = path.Join(configDir, defaults.ConfigurationFileName)
+	defaults.SandboxRegistry = path.Join(configDir, defaults.SandboxRegistryName)
+	defaults.SandboxRegistryLock = path.Join(configDir, defaults.SandboxRegistryLockName)
+}
+
+// Creates a directory that looks like expanded MySQL binaries
+func makeTestBinaries(t *testing.T, basedir string) {
+	for _, dir := range []string{"bin", "lib"} {
+		err := os.MkdirAll(path.Join(basedir, dir), 0755)
+		compare.OkIsNil("binaries directory", err, t)
+	}
+	err := common.WriteString("", path.Join(basedir, "bin", "mysqld"))
+	compare.OkIsNil("mysqld", err, t)
+	err = common.WriteString("", path.Join(basedir, "lib", "libmysqlclient.so"))
+	compare.OkIsNil("client library", err, t)
+	err = common.WriteString(common.MySQLFlavor, path.Join(basedir, globals.FlavorFileName))
+	compare.OkIsNil("flavor file", err, t)
+}
+
+// Creates a single sandbox with a description, a configuration file and start/stop scripts
+func makeTestSandbox(t *testing.T, sandboxDir string, port int, stopScript string) {
+	err := os.MkdirAll(path.Join(sandboxDir, "data"), 0755)
+	compare.OkIsNil("sandbox directory", err, t)
+	config := fmt.Sprintf("[client]\nuser = msandbox\npassword = secret\n\n"+
+		"[mysqld]\nport = %d\nsocket = %s\npid-file = %s\ndatadir = %s\n",
+		port, path.Join(sandboxDir, "mysql.sock"), path.Join(sandboxDir, "mysql.pid"), path.Join(sandboxDir, "data"))
+	err = common.WriteString(config, path.Join(sandboxDir, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("sandbox configuration", err, t)
+	err = common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		Basedir: "/opt/mysql/8.0.16",
+		SBType:  "single",
+		Version: "8.0.16",
+		Flavor:  common.MySQLFlavor,
+		Port:    []int{port},
+	})
+	compare.OkIsNil("sandbox description", err, t)
+	scripts := map[string]string{
+		globals.ScriptStart: "#!/bin/sh\ntouch $(dirname $0)/started\n",
+		globals.ScriptStop:  stopScript,
+	}
+	for name, contents := range scripts {
+		err = common.WriteString(contents, path.Join(sandboxDir, name))
+		compare.OkIsNil("script "+name, err, t)
+		err = os.Chmod(path.Join(sandboxDir, name), 0755)
+		compare.OkIsNil("permissions of "+name, err, t)
+	}
+}
+
+func okErrorKind(t *testing.T, label string, err error, kind ErrorKind) {
+	if IsKind(err, kind) {
+		t.Logf("ok - %s: %s", label, err)
+		return
+	}
+	t.Logf("not ok - %s: expected error of kind '%s' - got %v", label, kind, err)
+	t.Fail()
+}
+
+func TestError(t *testing.T) {
+	cause := fmt.Errorf("directory not found")
+	err := newError(KindSandboxNotFound, "Start", "/sandboxes/msb_8_0_16", cause)
+	compare.OkEqualString("error message", err.Error(), "Start: sandbox not found (/sandboxes/msb_8_0_16): directory not found", t)
+	compare.OkEqualString("kind", string(KindOf(err)), string(KindSandboxNotFound), t)
+	compare.OkEqualBool("is kind", IsKind(err, KindSandboxNotFound), true, t)
+	compare.OkEqualBool("is other kind", IsKind(err, KindSandboxLocked), false, t)
+	compare.OkEqualBool("unwrap", err.(*Error).Unwrap() == cause, true, t)
+	compare.OkEqualString("kind of foreign error", string(KindOf(cause)), "", t)
+	compare.OkEqualBool("nil error", IsKind(nil, KindSandboxNotFound), false, t)
+}
+
+func TestDeploySingleErrors(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-api-deploy-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestEnvironment(t, baseDir)
+	sandboxBinary := path.Join(baseDir, "opt", "mysql")
+	sandboxHome := path.Join(baseDir, "sandboxes")
+	makeTestBinaries(t, path.Join(sandboxBinary, "8.0.16"))
+	err := os.MkdirAll(path.Join(sandboxHome, "existing"), 0755)
+	compare.OkIsNil("existing sandbox", err, t)
+
+	options := DeployOptions{
+		Version:       "8.0.16",
+		SandboxHome:   sandboxHome,
+		SandboxBinary: sandboxBinary,
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	type deployTest struct {
+		label   string
+		ctx     context.Context
+		options func(DeployOptions) DeployOptions
+		kind    ErrorKind
+	}
+	var data = []deployTest{
+		{"no version", context.Background(),
+			func(o DeployOptions) DeployOptions { o.Version = ""; return o }, KindInvalidOptions},
+		{"invalid version", context.Background(),
+			func(o DeployOptions) DeployOptions { o.Version = "latest"; return o }, KindInvalidOptions},
+		{"root user", context.Background(),
+			func(o DeployOptions) DeployOptions { o.DbUser = "root"; return o }, KindInvalidOptions},
+		{"missing binaries", context.Background(),
+			func(o DeployOptions) DeployOptions { o.Version = "5.7.25"; return o }, KindBinaries},
+		{"no binaries for abridged version", context.Background(),
+			func(o DeployOptions) DeployOptions { o.Version = "5.7"; return o }, KindBinaries},
+		{"wrong flavor", context.Background(),
+			func(o DeployOptions) DeployOptions { o.Flavor = common.PerconaServerFlavor; return o }, KindBinaries},
+		{"existing sandbox", context.Background(),
+			func(o DeployOptions) DeployOptions { o.SandboxDirectory = "existing"; return o }, KindSandboxExists},
+		{"canceled", canceled,
+			func(o DeployOptions) DeployOptions { return o }, KindCanceled},
+	}
+	for _, dt := range data {
+		_, err := DeploySingle(dt.ctx, dt.options(options))
+		okErrorKind(t, dt.label, err, dt.kind)
+	}
+	_, err = DeployReplication(canceled, ReplicationOptions{DeployOptions: options, Topology: globals.GroupLabel, SinglePrimary: true})
+	okErrorKind(t, "canceled replication", err, KindCanceled)
+	_, err = DeployReplication(context.Background(), ReplicationOptions{DeployOptions: options, SinglePrimary: true})
+	okErrorKind(t, "single primary without group", err, KindInvalidOptions)
+	_, err = DeployReplication(context.Background(), ReplicationOptions{DeployOptions: options, Topology: "ring"})
+	okErrorKind(t, "unknown topology", err, KindInvalidOptions)
+	compare.OkEqualBool("no sandbox created", common.DirExists(path.Join(sandboxHome, "msb_8_0_16")), false, t)
+}
+
+func TestDeployCleanup(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-api-cleanup-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	newSandbox := path.Join(baseDir, "new")
+	existingSandbox := path.Join(baseDir, "existing")
+	err := os.MkdirAll(existingSandbox, 0755)
+	compare.OkIsNil("existing sandbox", err, t)
+
+	cleanupCalls := 0
+	callerCleanupCalls := 0
+	// Clean-up actions registered by the caller before the deployment are not affected
+	common.AddToCleanupStack(func(string) { callerCleanupCalls++ }, "caller", baseDir)
+	failingDeployment := func(sandboxDir string) func() error {
+		return func() error {
+			common.AddToCleanupStack(func(string) { cleanupCalls++ }, "count", sandboxDir)
+			err := os.MkdirAll(path.Join(sandboxDir, "data"), 0755)
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("deployment failed")
+		}
+	}
+	_, err = deploy(context.Background(), "test", newSandbox, failingDeployment(newSandbox))
+	okErrorKind(t, "failed deployment", err, KindDeployment)
+	compare.OkEqualBool("partial sandbox removed", common.DirExists(newSandbox), false, t)
+
+	_, err = deploy(context.Background(), "test", existingSandbox, failingDeployment(existingSandbox))
+	okErrorKind(t, "failed deployment over existing sandbox", err, KindDeployment)
+	compare.OkEqualBool("existing sandbox kept", common.DirExists(existingSandbox), true, t)
+
+	// The clean-up actions of the sandbox package are discarded, not run
+	common.RunCleanupActions()
+	compare.OkEqualInt("clean-up actions run", cleanupCalls, 0, t)
+	compare.OkEqualInt("caller clean-up actions run", callerCleanupCalls, 1, t)
+}
+
+// Creates binaries of the given flavor whose server does nothing, and a client that does nothing
+func makeRunnableBinaries(t *testing.T, basedir, flavor string, executables ...string) {
+	for _, dir := range []string{"bin", "lib"} {
+		err := os.MkdirAll(path.Join(basedir, dir), 0755)
+		compare.OkIsNil("binaries directory", err, t)
+	}
+	for _, executable := range executables {
+		fileName := path.Join(basedir, "bin", executable)
+		err := common.WriteString("#!/bin/sh\nexit 0\n", fileName)
+		compare.OkIsNil(executable, err, t)
+		err = os.Chmod(fileName, 0755)
+		compare.OkIsNil("permissions of "+executable, err, t)
+	}
+	err := common.WriteString("", path.Join(basedir, "lib", "libmysqlclient.so"))
+	compare.OkIsNil("client library", err, t)
+	err = common.WriteString(flavor, path.Join(basedir, globals.FlavorFileName))
+	compare.OkIsNil("flavor file", err, t)
+}
+
+func TestDeployFlavorsInSequence(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-api-flavors-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestEnvironment(t, baseDir)
+	sandboxHome := path.Join(baseDir, "sandboxes")
+	sandboxBinary := path.Join(baseDir, "binaries")
+	err := os.MkdirAll(sandboxHome, 0755)
+	compare.OkIsNil("sandbox home", err, t)
+	makeRunnableBinaries(t, path.Join(sandboxBinary, "8.0.16"), common.MySQLFlavor, "mysqld", "mysqld_safe", "mysql")
+	makeRunnableBinaries(t, path.Join(sandboxBinary, "3.0.0"), common.TiDbFlavor, "tidb-server")
+	ctx := context.Background()
+	options := DeployOptions{
+		SandboxHome:   sandboxHome,
+		SandboxBinary: sandboxBinary,
+		SkipStart:     true,
+	}
+
+	// The templates of a flavor are used only for its own deployment
+	tidbOptions := options
+	tidbOptions.Version = "3.0.0"
+	tidbOptions.Flavor = common.TiDbFlavor
+	tidbOptions.ClientFrom = "8.0.16"
+	tidbSandbox, err := DeploySingle(ctx, tidbOptions)
+	compare.OkIsNil("TiDB deployment", err, t)
+	compare.OkEqualString("TiDB flavor", tidbSandbox.Flavor, common.TiDbFlavor, t)
+	compare.OkEqualBool("TiDB configuration", common.FileExists(path.Join(tidbSandbox.Dir, "tidb.toml")), true, t)
+
+	mysqlOptions := options
+	mysqlOptions.Version = "8.0.16"
+	mysqlSandbox, err := DeploySingle(ctx, mysqlOptions)
+	compare.OkIsNil("MySQL deployment", err, t)
+	compare.OkEqualString("MySQL flavor", mysqlSandbox.Flavor, common.MySQLFlavor, t)
+	compare.OkEqualBool("no TiDB configuration for MySQL", common.FileExists(path.Join(mysqlSandbox.Dir, "tidb.toml")), false, t)
+	startScript, err := common.SlurpAsString(path.Join(mysqlSandbox.Dir, globals.ScriptStart))
+	compare.OkIsNil("MySQL start script", err, t)
+	compare.OkMatchesString("MySQL start script", startScript, "mysqld_safe", t)
+}
+
+func TestSandboxOperations(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-api-operations-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestEnvironment(t, baseDir)
+	sandboxHome := path.Join(baseDir, "sandboxes")
+	ctx := context.Background()
+
+	makeTestSandbox(t, path.Join(sandboxHome, "msb_a"), 5001, "#!/bin/sh\nexit 0\n")
+	makeTestSandbox(t, path.Join(sandboxHome, "msb_b"), 5002, "#!/bin/sh\nsleep 10\n")
+	makeTestSandbox(t, path.Join(sandboxHome, "msb_c"), 5003, "#!/bin/sh\nexit 1\n")
+
+	sandboxes, err := ListSandboxes(ctx, sandboxHome)
+	compare.OkIsNil("list sandboxes", err, t)
+	compare.OkEqualInt("number of sandboxes", len(sandboxes), 3, t)
+	if len(sandboxes) == 3 {
+		sb := sandboxes[0]
+		compare.OkEqualString("name", sb.Name, "msb_a", t)
+		compare.OkEqualString("type", sb.Type, "single", t)
+		compare.OkEqualString("version", sb.Version, "8.0.16", t)
+		compare.OkEqualString("state", sb.State, common.StatusStopped, t)
+		compare.OkEqualIntSlices(t, sb.Ports, []int{5001})
+		compare.OkEqualInt("nodes", len(sb.Nodes), 1, t)
+		if len(sb.Nodes) == 1 {
+			compare.OkEqualInt("node port", sb.Nodes[0].Port, 5001, t)
+			compare.OkEqualString("node socket", sb.Nodes[0].Socket, path.Join(sandboxHome, "msb_a", "mysql.sock"), t)
+		}
+		compare.OkEqualInt("users", len(sb.Users), 1, t)
+		if len(sb.Users) == 1 {
+			compare.OkEqualString("user name", sb.Users[0].Name, "msandbox", t)
+			compare.OkEqualString("user password", sb.Users[0].Password, "secret", t)
+			compare.OkEqualString("user purpose", sb.Users[0].Purpose, common.UserPurposeDatabase, t)
+		}
+	}
+
+	sbDir := path.Join(sandboxHome, "msb_b")
+	sbd, err := common.ReadSandboxDescription(sbDir)
+	compare.OkIsNil("read description", err, t)
+	sbd.Users = []common.SandboxUser{
+		{Description: common.UserPurposeAdmin, Username: "root", Password: "secret", Privileges: "all"},
+		{Description: common.UserPurposeReplication, Username: "rsandbox", Password: "rsecret", Privileges: "replication slave"},
+	}
+	err = common.WriteSandboxDescription(sbDir, sbd)
+	compare.OkIsNil("write description", err, t)
+	sb, err := DescribeSandbox(ctx, sandboxHome, "msb_b")
+	compare.OkIsNil("describe sandbox", err, t)
+	compare.OkEqualInt("described users", len(sb.Users), 2, t)
+	rplUser, found := sb.User(common.UserPurposeReplication)
+	compare.OkEqualBool("replication user found", found, true, t)
+	compare.OkEqualString("replication user", rplUser.Name, "rsandbox", t)
+	compare.OkEqualString("replication password", rplUser.Password, "rsecret", t)
+	_, found = sb.User(common.UserPurposeDatabase)
+	compare.OkEqualBool("database user not found", found, false, t)
+	if len(sb.Nodes) == 1 {
+		compare.OkEqualString("node host", sb.Nodes[0].Host, "127.0.0.1", t)
+	}
+	_, err = DescribeSandbox(ctx, sandboxHome, "msb_none")
+	okErrorKind(t, "describe missing sandbox", err, KindSandboxNotFound)
+
+	err = Start(ctx, sandboxHome, "msb_a")
+	compare.OkIsNil("start", err, t)
+	compare.OkEqualBool("start script ran", common.FileExists(path.Join(sandboxHome, "msb_a", "started")), true, t)
+	err = Stop(ctx, sandboxHome, "msb_c")
+	okErrorKind(t, "failing stop script", err, KindOperation)
+
+	timeout, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	err = Stop(timeout, sandboxHome, "msb_b")
+	okErrorKind(t, "stop interrupted by the context", err, KindCanceled)
+
+	err = Start(ctx, sandboxHome, "msb_none")
+	okErrorKind(t, "start missing sandbox", err, KindSandboxNotFound)
+	err = Delete(ctx, sandboxHome, "msb_none")
+	okErrorKind(t, "delete missing sandbox", err, KindSandboxNotFound)
+
+	err = common.WriteString("", path.Join(sandboxHome, "msb_a", globals.ScriptNoClear))
+	compare.OkIsNil("lock", err, t)
+	err = Delete(ctx, sandboxHome, "msb_a")
+	okErrorKind(t, "delete locked sandbox", err, KindSandboxLocked)
+	err = os.Remove(path.Join(sandboxHome, "msb_a", globals.ScriptNoClear))
+	compare.OkIsNil("unlock", err, t)
+
+	err = Delete(ctx, sandboxHome, "msb_a")
+	compare.OkIsNil("delete", err, t)
+	compare.OkEqualBool("sandbox removed", common.DirExists(path.Join(sandboxHome, "msb_a")), false, t)
+	sandboxes, err = ListSandboxes(ctx, sandboxHome)
+	compare.OkIsNil("list sandboxes after delete", err, t)
+	compare.OkEqualInt("number of sandboxes after delete", len(sandboxes), 2, t)
+}
@@ -0,0 +1,95 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+)
+
+// The category of an error returned by the API
+type ErrorKind string
+
+const (
+	KindInvalidOptions  ErrorKind = "invalid options"
+	KindConfiguration   ErrorKind = "configuration error"
+	KindBinaries        ErrorKind = "binaries not usable"
+	KindSandboxExists   ErrorKind = "sandbox already exists"
+	KindSandboxNotFound ErrorKind = "sandbox not found"
+	KindSandboxLocked   ErrorKind = "sandbox is locked"
+	KindDeployment      ErrorKind = "deployment failed"
+	KindOperation       ErrorKind = "operation failed"
+	KindCanceled        ErrorKind = "operation canceled"
+)
+
+// Error is the type of all the errors returned by the API
+type Error struct {
+	Kind    ErrorKind // Category of the error
+	Op      string    // API function that failed (e.g. "DeploySingle")
+	Sandbox string    // Directory of the sandbox, when known
+	Err     error     // Underlying error
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.Op, e.Kind)
+	if e.Sandbox != "" {
+		msg += fmt.Sprintf(" (%s)", e.Sandbox)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Returns the underlying error, for github.com/pkg/errors.Cause
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// Returns the underlying error, for the standard errors package
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Returns the kind of an error returned by the API, or an empty string
+// when the error does not come from the API
+func KindOf(err error) ErrorKind {
+	if apiErr, ok := err.(*Error); ok {
+		return apiErr.Kind
+	}
+	return ""
+}
+
+// Returns true if the error was returned by the API with the given kind
+func IsKind(err error, kind ErrorKind) bool {
+	return err != nil && KindOf(err) == kind
+}
+
+func newError(kind ErrorKind, op, sandboxDir string, err error) error {
+	return &Error{Kind: kind, Op: op, Sandbox: sandboxDir, Err: err}
+}
+
+func newErrorf(kind ErrorKind, op, sandboxDir, format string, args ...interface{}) error {
+	return newError(kind, op, sandboxDir, fmt.Errorf(format, args...))
+}
+
+// Returns a KindCanceled error if the context is done
+func checkContext(ctx context.Context, op, sandboxDir string) error {
+	if ctx.Err() != nil {
+		return newError(KindCanceled, op, sandboxDir, ctx.Err())
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/datacharmer/dbdeployer/sandbox"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// A sandbox in an environment specification
+type sandboxSpec struct {
+	// Name of the sandbox directory, also used in dependencies
+	Name string `yaml:"name" json:"name"`
+	// single (default), multiple, master-slave, group, fan-in, all-masters
+	Topology          string   `yaml:"topology" json:"topology"`
+	Version           string   `yaml:"version" json:"version"`
+	Flavor            string   `yaml:"flavor" json:"flavor"`
+	Nodes             int      `yaml:"nodes" json:"nodes"`
+	SinglePrimary     bool     `yaml:"single-primary" json:"single-primary"`
+	MyCnfOptions      []string `yaml:"my-cnf-options" json:"my-cnf-options"`
+	PreGrantsSql      []string `yaml:"pre-grants-sql" json:"pre-grants-sql"`
+	PostGrantsSql     []string `yaml:"post-grants-sql" json:"post-grants-sql"`
+	PostGrantsSqlFile string   `yaml:"post-grants-sql-file" json:"post-grants-sql-file"`
+	// Sandboxes that must be deployed before this one
+	DependsOn []string `yaml:"depends-on" json:"depends-on"`
+	// Other options for "dbdeployer deploy", such as "--gtid"
+	Options []string `yaml:"options" json:"options"`
+}
+
+// A set of sandboxes deployed and removed together
+type environmentSpec struct {
+	SandboxHome string        `yaml:"sandbox-home" json:"sandbox-home"`
+	Sandboxes   []sandboxSpec `yaml:"sandboxes" json:"sandboxes"`
+}
+
+// Reads an environment specification. Files with extension .json are read as JSON,
+// all others as YAML
+func readEnvironmentSpec(fileName string) (environmentSpec, error) {
+	var spec environmentSpec
+	contents, err := common.SlurpAsBytes(fileName)
+	if err != nil {
+		return spec, err
+	}
+	if strings.HasSuffix(fileName, ".json") {
+		err = json.Unmarshal(contents, &spec)
+	} else {
+		err = yaml.UnmarshalStrict(contents, &spec)
+	}
+	if err != nil {
+		return spec, fmt.Errorf("error reading %s: %s", fileName, err)
+	}
+	for N := range spec.Sandboxes {
+		sb := &spec.Sandboxes[N]
+		if sb.Topology == "" {
+			sb.Topology = "single"
+		}
+		if sb.Nodes == 0 && sb.Topology != "single" {
+			sb.Nodes = globals.NodesValue
+		}
+	}
+	return spec, checkEnvironmentSpec(spec)
+}
+
+// Returns the sandbox type, as recorded in the sandbox description, for a sandbox specification
+func specSandboxType(sb sandboxSpec) string {
+	if sb.Topology == globals.GroupLabel {
+		if sb.SinglePrimary {
+			return "group-single-primary"
+		}
+		return "group-multi-primary"
+	}
+	return sb.Topology
+}
+
+func checkEnvironmentSpec(spec environmentSpec) error {
+	if len(spec.Sandboxes) == 0 {
+		return fmt.Errorf("no sandboxes defined")
+	}
+	var names = make(map[string]bool)
+	for _, sb := range spec.Sandboxes {
+		if sb.Name == "" {
+			return fmt.Errorf("sandbox without name")
+		}
+		if strings.Contains(sb.Name, "/") || sb.Name == "." || sb.Name == ".." {
+			return fmt.Errorf("sandbox name '%s' is not a valid directory name", sb.Name)
+		}
+		if names[sb.Name] {
+			return fmt.Errorf("sandbox '%s' defined more than once", sb.Name)
+		}
+		names[sb.Name] = true
+		if sb.Version == "" {
+			return fmt.Errorf("sandbox '%s' has no version", sb.Name)
+		}
+		switch sb.Topology {
+		case "single":
+			if sb.Nodes != 0 {
+				return fmt.Errorf("sandbox '%s': a single sandbox can't have nodes", sb.Name)
+			}
+		case "multiple", globals.MasterSlaveLabel, globals.GroupLabel, globals.FanInLabel, globals.AllMastersLabel:
+			if sb.Nodes < 2 {
+				return fmt.Errorf("sandbox '%s': topology %s requires at least 2 nodes", sb.Name, sb.Topology)
+			}
+		default:
+			return fmt.Errorf("sandbox '%s': unknown topology '%s'. Accepted: single, multiple, %s, %s, %s, %s",
+				sb.Name, sb.Topology, globals.MasterSlaveLabel, globals.GroupLabel, globals.FanInLabel, globals.AllMastersLabel)
+		}
+		if sb.SinglePrimary && sb.Topology != globals.GroupLabel {
+			return fmt.Errorf("sandbox '%s': single-primary requires topology %s", sb.Name, globals.GroupLabel)
+		}
+	}
+	for _, sb := range spec.Sandboxes {
+		for _, dependency := range sb.DependsOn {
+			if !names[dependency] {
+				return fmt.Errorf("sandbox '%s' depends on unknown sandbox '%s'", sb.Name, dependency)
+			}
+		}
+	}
+	_, err := deploymentOrder(spec)
+	return err
+}
+
+// Returns the sandboxes in an order where every sandbox comes after the ones it depends on.
+// Independent sandboxes keep the order of the specification
+func deploymentOrder(spec environmentSpec) ([]sandboxSpec, error) {
+	var ordered []sandboxSpec
+	var done = make(map[string]bool)
+	var visiting = make(map[string]bool)
+	var byName = make(map[string]sandboxSpec)
+	for _, sb := range spec.Sandboxes {
+		byName[sb.Name] = sb
+	}
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		if done[name] {
+			return nil
+		}
+		chain = append(chain, name)
+		if visiting[name] {
+			return fmt.Errorf("circular dependency: %s", strings.Join(chain, " -> "))
+		}
+		visiting[name] = true
+		for _, dependency := range byName[name].DependsOn {
+			err := visit(dependency, chain)
+			if err != nil {
+				return err
+			}
+		}
+		visiting[name] = false
+		done[name] = true
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, sb := range spec.Sandboxes {
+		err := visit(sb.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Checks whether a sandbox exists and matches its specification.
+// Returns an error if the sandbox exists with different characteristics
+func sandboxMatchesSpec(sandboxHome string, sb sandboxSpec) (exists bool, err error) {
+	sandboxDir := path.Join(sandboxHome, sb.Name)
+	if !common.DirExists(sandboxDir) {
+		return false, nil
+	}
+	description, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return true, fmt.Errorf("sandbox %s exists, but its description can't be read: %s", sandboxDir, err)
+	}
+	var differences []string
+	if description.SBType != specSandboxType(sb) {
+		differences = append(differences, fmt.Sprintf("type %s instead of %s", description.SBType, specSandboxType(sb)))
+	}
+	if description.Version != sb.Version && !strings.HasPrefix(description.Version, sb.Version+".") {
+		differences = append(differences, fmt.Sprintf("version %s instead of %s", description.Version, sb.Version))
+	}
+	if sb.Flavor != "" && description.Flavor != sb.Flavor {
+		differences = append(differences, fmt.Sprintf("flavor %s instead of %s", description.Flavor, sb.Flavor))
+	}
+	// A master-slave description counts the slaves only
+	nodes := description.Nodes
+	if description.SBType == globals.MasterSlaveLabel {
+		nodes++
+	}
+	if sb.Topology != "single" && nodes != sb.Nodes {
+		differences = append(differences, fmt.Sprintf("%d nodes instead of %d", nodes, sb.Nodes))
+	}
+	if len(differences) > 0 {
+		return true, fmt.Errorf("sandbox %s exists, but does not match the specification: %s",
+			sandboxDir, strings.Join(differences, ", "))
+	}
+	return true, nil
+}
+
+// Returns the arguments of the "dbdeployer deploy" command for a sandbox
+func deployArgs(sb sandboxSpec) []string {
+	var args []string
+	switch sb.Topology {
+	case "single":
+		args = []string{"deploy", "single", sb.Version}
+	case "multiple":
+		args = []string{"deploy", "multiple", sb.Version, fmt.Sprintf("--%s=%d", globals.NodesLabel, sb.Nodes)}
+	default:
+		args = []string{"deploy", "replication", sb.Version,
+			fmt.Sprintf("--%s=%s", globals.TopologyLabel, sb.Topology),
+			fmt.Sprintf("--%s=%d", globals.NodesLabel, sb.Nodes)}
+		if sb.SinglePrimary {
+			args = append(args, "--"+globals.SinglePrimaryLabel)
+		}
+	}
+	args = append(args, fmt.Sprintf("--%s=%s", globals.SandboxDirectoryLabel, sb.Name))
+	if sb.Flavor != "" {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.FlavorLabel, sb.Flavor))
+	}
+	for _, option := range sb.MyCnfOptions {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.MyCnfOptionsLabel, option))
+	}
+	for _, query := range sb.PreGrantsSql {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.PreGrantsSqlLabel, query))
+	}
+	for _, query := range sb.PostGrantsSql {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.PostGrantsSqlLabel, query))
+	}
+	if sb.PostGrantsSqlFile != "" {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.PostGrantsSqlFileLabel, sb.PostGrantsSqlFile))
+	}
+	return append(args, sb.Options...)
+}
+
+// Returns the options that a dbdeployer sub-command needs to use the same configuration as the current one
+func configurationArgs(sandboxHome, sandboxBinary string) []string {
+	args := []string{
+		fmt.Sprintf("--%s=%s", globals.SandboxHomeLabel, sandboxHome),
+		fmt.Sprintf("--%s=%s", globals.SandboxBinaryLabel, sandboxBinary),
+	}
+	if defaults.ActiveProfile != "" {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.ProfileLabel, defaults.ActiveProfile))
+	} else if defaults.ConfigurationFile != path.Join(defaults.ConfigurationDir, defaults.ConfigurationFileName) {
+		args = append(args, fmt.Sprintf("--%s=%s", globals.ConfigLabel, defaults.ConfigurationFile))
+	}
+	return args
+}
+
+var reShellSafe = regexp.MustCompile(`^[\w@%+=:,./-]+$`)
+
+// Quotes a value for the shell, unless it only contains characters that the shell leaves alone
+func shellQuote(value string) string {
+	if value != "" && reShellSafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// Returns a command line that can be copied into a shell
+func shellCommandLine(args []string) string {
+	var quoted []string
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return "dbdeployer " + strings.Join(quoted, " ")
+}
+
+// Runs dbdeployer with the given arguments, showing its output
+func runDbdeployer(args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	command := exec.Command(executable, args...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	return command.Run()
+}
+
+// Reads the specification and the configuration used by "apply" and "destroy"
+func prepareEnvironment(cmd *cobra.Command, args []string, commandName string) ([]sandboxSpec, string, []string) {
+	if len(args) < 1 {
+		common.Exitf(1, "'%s' requires a specification file", commandName)
+	}
+	spec, err := readEnvironmentSpec(args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+	ordered, err := deploymentOrder(spec)
+	common.ErrCheckExitf(err, 1, "%s", err)
+
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	if spec.SandboxHome != "" {
+		sandboxHome, err = common.AbsolutePath(os.ExpandEnv(spec.SandboxHome))
+		common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", spec.SandboxHome)
+	}
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxBinaryLabel)
+	return ordered, sandboxHome, configurationArgs(sandboxHome, sandboxBinary)
+}
+
+func applyEnvironment(cmd *cobra.Command, args []string) {
+	dryRun, _ := cmd.Flags().GetBool(globals.DryRunLabel)
+	ordered, sandboxHome, configArgs := prepareEnvironment(cmd, args, "apply")
+
+	// All the existing sandboxes are checked before deploying anything
+	var missing []sandboxSpec
+	for _, sb := range ordered {
+		exists, err := sandboxMatchesSpec(sandboxHome, sb)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		if exists {
+			common.CondPrintf("# Sandbox %s already deployed\n", sb.Name)
+		} else {
+			missing = append(missing, sb)
+		}
+	}
+	if len(missing) > 0 && !dryRun && !common.DirExists(sandboxHome) {
+		err := os.MkdirAll(sandboxHome, globals.PublicDirectoryAttr)
+		common.ErrCheckExitf(err, 1, "error creating %s: %s", sandboxHome, err)
+	}
+	for _, sb := range missing {
+		deployCommand := append(append([]string{}, configArgs...), deployArgs(sb)...)
+		common.CondPrintf("# Deploying %s\n", sb.Name)
+		if dryRun {
+			fmt.Println(shellCommandLine(deployCommand))
+			continue
+		}
+		err := runDbdeployer(deployCommand)
+		common.ErrCheckExitf(err, 1, "error deploying sandbox %s: %s", sb.Name, err)
+	}
+	if !dryRun {
+		common.CondPrintf("# %d sandboxes deployed, %d already existing\n", len(missing), len(ordered)-len(missing))
+	}
+}
+
+func destroyEnvironment(cmd *cobra.Command, args []string) {
+	dryRun, _ := cmd.Flags().GetBool(globals.DryRunLabel)
+	ordered, sandboxHome, configArgs := prepareEnvironment(cmd, args, "destroy")
+
+	// Sandboxes that don't match the specification were not deployed by "apply", and are not removed
+	var existing []sandboxSpec
+	for _, sb := range ordered {
+		exists, err := sandboxMatchesSpec(sandboxHome, sb)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		if exists {
+			existing = append(existing, sb)
+		} else {
+			common.CondPrintf("# Sandbox %s not found\n", sb.Name)
+		}
+	}
+	// Dependent sandboxes are removed before the ones they depend on
+	removed := 0
+	for N := len(existing) - 1; N >= 0; N-- {
+		sb := existing[N]
+		if sandbox.IsLocked(path.Join(sandboxHome, sb.Name)) {
+			common.CondPrintf("# Sandbox %s is locked: skipped\n", sb.Name)
+			continue
+		}
+		removed++
+		deleteCommand := append(append([]string{}, configArgs...), "delete", sb.Name)
+		common.CondPrintf("# Removing %s\n", sb.Name)
+		if dryRun {
+			fmt.Println(shellCommandLine(deleteCommand))
+			continue
+		}
+		err := runDbdeployer(deleteCommand)
+		common.ErrCheckExitf(err, 1, "error removing sandbox %s: %s", sb.Name, err)
+	}
+	if !dryRun {
+		common.CondPrintf("# %d sandboxes removed, %d locked sandboxes skipped\n", removed, len(existing)-removed)
+	}
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply specification_file",
+	Short: "Deploys the sandboxes of a specification file",
+	Long: `Deploys the sandboxes listed in a specification file (YAML, or JSON when the file name ends with .json).
+Sandboxes that already exist and match the specification are left alone, so the command can run again
+to create only what is missing. A sandbox that exists with a different type, version, flavor, or number
+of nodes stops the operation before anything is deployed.
+Sandboxes are deployed after the ones listed in their "depends-on" field.
+
+Fields of a sandbox:
+  name                  directory of the sandbox, inside sandbox-home (required)
+  topology              single (default), multiple, master-slave, group, fan-in, all-masters
+  version               version of the binaries (required)
+  flavor                flavor of the binaries
+  nodes                 number of nodes (default 3, not used by single sandboxes)
+  single-primary        uses single-primary mode for group replication
+  my-cnf-options        list of options for my.cnf
+  pre-grants-sql        list of queries to run before loading the grants
+  post-grants-sql       list of queries to run after loading the grants
+  post-grants-sql-file  SQL file to run after loading the grants
+  depends-on            list of sandboxes that must be deployed before this one
+  options               list of other options for "dbdeployer deploy"
+The top level can also contain "sandbox-home", which replaces --sandbox-home.`,
+	Example: `
+	$ cat env.yaml
+	sandbox-home: $HOME/sandboxes/env
+	sandboxes:
+	  - name: source
+	    topology: master-slave
+	    version: 8.0.16
+	    nodes: 3
+	    my-cnf-options:
+	      - log-slave-updates
+	    options:
+	      - --gtid
+	  - name: reports
+	    version: 5.7.25
+	    post-grants-sql:
+	      - create schema reports
+	    depends-on:
+	      - source
+	$ dbdeployer apply env.yaml
+	$ dbdeployer apply env.yaml --dry-run
+	# Sandbox source already deployed
+	# Sandbox reports already deployed
+`,
+	Run: applyEnvironment,
+}
+
+var destroyCmd = &cobra.Command{
+	Use:   "destroy specification_file",
+	Short: "Removes the sandboxes of a specification file",
+	Long: `Removes the sandboxes listed in a specification file, in the reverse order of deployment.
+Sandboxes that don't exist or are locked are skipped. A sandbox that exists but does not match the specification
+stops the operation before anything is removed. See "dbdeployer apply" for the file format.`,
+	Example: `
+	$ dbdeployer destroy env.yaml
+`,
+	Run: destroyEnvironment,
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	rootCmd.AddCommand(destroyCmd)
+	applyCmd.Flags().BoolP(globals.DryRunLabel, "", false, "Shows the deployment commands without running them")
+	destroyCmd.Flags().BoolP(globals.DryRunLabel, "", false, "Shows the removal commands without running them")
+}
@@ -0,0 +1,256 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Checks that err is nil when wantedError is empty, or that it contains wantedError
+func okError(label string, err error, wantedError string, t *testing.T) {
+	if wantedError == "" {
+		compare.OkIsNil(label, err, t)
+		return
+	}
+	compare.OkIsNotNil(label, err, t)
+	if err != nil {
+		compare.OkMatchesString(label+" message", err.Error(), wantedError, t)
+	}
+}
+
+func specNames(sandboxes []sandboxSpec) string {
+	var names []string
+	for _, sb := range sandboxes {
+		names = append(names, sb.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestReadEnvironmentSpec(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-spec-%d", os.Getpid()))
+	err := os.MkdirAll(baseDir, globals.PublicDirectoryAttr)
+	compare.OkIsNil("spec directory", err, t)
+	defer os.RemoveAll(baseDir)
+
+	type specTest struct {
+		fileName    string
+		contents    string
+		wantedError string
+		wantedNames string
+		wantedNodes []int
+	}
+	var tests = []specTest{
+		{"env.yaml", "sandbox-home: /tmp/env\nsandboxes:\n" +
+			"  - name: one\n    version: 8.0.16\n" +
+			"  - name: two\n    topology: group\n    version: 8.0.16\n    depends-on: [one]\n",
+			"", "one,two", []int{0, globals.NodesValue}},
+		{"env.json", `{"sandboxes": [{"name": "one", "topology": "multiple", "nodes": 2, "version": "5.7.25"}]}`,
+			"", "one", []int{2}},
+		{"unknown-field.yaml", "sandboxes:\n  - name: one\n    version: 8.0.16\n    no-such-field: 1\n",
+			"error reading", "", nil},
+		{"broken.json", `{"sandboxes": [`, "error reading", "", nil},
+		{"empty.yaml", "sandbox-home: /tmp/env\n", "no sandboxes defined", "", nil},
+		{"single-nodes.yaml", "sandboxes:\n  - name: one\n    version: 8.0.16\n    nodes: 2\n",
+			"can't have nodes", "", nil},
+	}
+	for _, test := range tests {
+		fileName := path.Join(baseDir, test.fileName)
+		err = common.WriteString(test.contents, fileName)
+		compare.OkIsNil("writing "+test.fileName, err, t)
+		spec, err := readEnvironmentSpec(fileName)
+		okError("reading "+test.fileName, err, test.wantedError, t)
+		if test.wantedError != "" {
+			continue
+		}
+		compare.OkEqualString("sandboxes of "+test.fileName, specNames(spec.Sandboxes), test.wantedNames, t)
+		for N, sb := range spec.Sandboxes {
+			compare.OkEqualInt(fmt.Sprintf("nodes of %s in %s", sb.Name, test.fileName), sb.Nodes, test.wantedNodes[N], t)
+		}
+	}
+	_, err = readEnvironmentSpec(path.Join(baseDir, "no-such-file.yaml"))
+	compare.OkIsNotNil("reading missing file", err, t)
+}
+
+func TestCheckEnvironmentSpec(t *testing.T) {
+	type specTest struct {
+		label       string
+		sandboxes   []sandboxSpec
+		wantedError string
+	}
+	var tests = []specTest{
+		{"valid", []sandboxSpec{
+			{Name: "one", Topology: "single", Version: "8.0.16"},
+			{Name: "two", Topology: globals.MasterSlaveLabel, Version: "8.0.16", Nodes: 3, DependsOn: []string{"one"}},
+			{Name: "three", Topology: globals.GroupLabel, Version: "8.0.16", Nodes: 3, SinglePrimary: true},
+		}, ""},
+		{"no sandboxes", nil, "no sandboxes defined"},
+		{"no name", []sandboxSpec{{Topology: "single", Version: "8.0.16"}}, "sandbox without name"},
+		{"name with slash", []sandboxSpec{{Name: "a/b", Topology: "single", Version: "8.0.16"}}, "not a valid directory name"},
+		{"name with dots", []sandboxSpec{{Name: "..", Topology: "single", Version: "8.0.16"}}, "not a valid directory name"},
+		{"duplicate name", []sandboxSpec{
+			{Name: "one", Topology: "single", Version: "8.0.16"},
+			{Name: "one", Topology: "single", Version: "5.7.25"},
+		}, "defined more than once"},
+		{"no version", []sandboxSpec{{Name: "one", Topology: "single"}}, "has no version"},
+		{"single with nodes", []sandboxSpec{{Name: "one", Topology: "single", Version: "8.0.16", Nodes: 2}}, "can't have nodes"},
+		{"too few nodes", []sandboxSpec{{Name: "one", Topology: globals.FanInLabel, Version: "8.0.16", Nodes: 1}}, "at least 2 nodes"},
+		{"unknown topology", []sandboxSpec{{Name: "one", Topology: "ring", Version: "8.0.16", Nodes: 3}}, "unknown topology 'ring'"},
+		{"single-primary without group", []sandboxSpec{
+			{Name: "one", Topology: "multiple", Version: "8.0.16", Nodes: 3, SinglePrimary: true},
+		}, "single-primary requires topology"},
+		{"unknown dependency", []sandboxSpec{
+			{Name: "one", Topology: "single", Version: "8.0.16", DependsOn: []string{"two"}},
+		}, "depends on unknown sandbox 'two'"},
+		{"circular dependency", []sandboxSpec{
+			{Name: "one", Topology: "single", Version: "8.0.16", DependsOn: []string{"two"}},
+			{Name: "two", Topology: "single", Version: "8.0.16", DependsOn: []string{"one"}},
+		}, "circular dependency"},
+	}
+	for _, test := range tests {
+		err := checkEnvironmentSpec(environmentSpec{Sandboxes: test.sandboxes})
+		okError(test.label, err, test.wantedError, t)
+	}
+}
+
+func TestDeploymentOrder(t *testing.T) {
+	type orderTest struct {
+		label       string
+		sandboxes   []sandboxSpec
+		wantedOrder string
+		wantedError string
+	}
+	var tests = []orderTest{
+		{"no dependencies", []sandboxSpec{{Name: "c"}, {Name: "a"}, {Name: "b"}}, "c,a,b", ""},
+		{"dependency listed later", []sandboxSpec{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b"},
+		}, "b,a", ""},
+		{"chain", []sandboxSpec{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"c"}},
+			{Name: "c"},
+			{Name: "d"},
+		}, "c,b,a,d", ""},
+		{"shared dependency", []sandboxSpec{
+			{Name: "a", DependsOn: []string{"c"}},
+			{Name: "b", DependsOn: []string{"c", "a"}},
+			{Name: "c"},
+		}, "c,a,b", ""},
+		{"self dependency", []sandboxSpec{{Name: "a", DependsOn: []string{"a"}}}, "", "circular dependency: a -> a"},
+		{"cycle", []sandboxSpec{
+			{Name: "a", DependsOn: []string{"b"}},
+			{Name: "b", DependsOn: []string{"c"}},
+			{Name: "c", DependsOn: []string{"a"}},
+		}, "", "circular dependency: a -> b -> c -> a"},
+		{"cycle after independent sandboxes", []sandboxSpec{
+			{Name: "a"},
+			{Name: "b", DependsOn: []string{"c"}},
+			{Name: "c", DependsOn: []string{"b"}},
+		}, "", "circular dependency: b -> c -> b"},
+	}
+	for _, test := range tests {
+		ordered, err := deploymentOrder(environmentSpec{Sandboxes: test.sandboxes})
+		okError(test.label, err, test.wantedError, t)
+		if test.wantedError == "" {
+			compare.OkEqualString(test.label, specNames(ordered), test.wantedOrder, t)
+		}
+	}
+}
+
+func TestSandboxMatchesSpec(t *testing.T) {
+	sandboxHome := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-spec-match-%d", os.Getpid()))
+	defer os.RemoveAll(sandboxHome)
+
+	var descriptions = []common.SandboxDescription{
+		{SBType: "single", Version: "8.0.16", Flavor: common.MySQLFlavor, Port: []int{8016}},
+		{SBType: globals.MasterSlaveLabel, Version: "8.0.16", Flavor: common.MySQLFlavor, Nodes: 2},
+		{SBType: "group-single-primary", Version: "8.0.16", Flavor: common.MySQLFlavor, Nodes: 3},
+	}
+	var names = []string{"single", "ms", "group"}
+	for N, description := range descriptions {
+		sandboxDir := path.Join(sandboxHome, names[N])
+		err := os.MkdirAll(sandboxDir, globals.PublicDirectoryAttr)
+		compare.OkIsNil("creating "+sandboxDir, err, t)
+		err = common.WriteSandboxDescription(sandboxDir, description)
+		compare.OkIsNil("description of "+sandboxDir, err, t)
+	}
+	err := os.MkdirAll(path.Join(sandboxHome, "no-description"), globals.PublicDirectoryAttr)
+	compare.OkIsNil("creating sandbox without description", err, t)
+
+	type matchTest struct {
+		label        string
+		sb           sandboxSpec
+		wantedExists bool
+		wantedError  string
+	}
+	var tests = []matchTest{
+		{"missing", sandboxSpec{Name: "missing", Topology: "single", Version: "8.0.16"}, false, ""},
+		{"single", sandboxSpec{Name: "single", Topology: "single", Version: "8.0.16"}, true, ""},
+		{"short version", sandboxSpec{Name: "single", Topology: "single", Version: "8.0"}, true, ""},
+		{"flavor", sandboxSpec{Name: "single", Topology: "single", Version: "8.0.16", Flavor: common.MySQLFlavor}, true, ""},
+		{"different flavor", sandboxSpec{Name: "single", Topology: "single", Version: "8.0.16", Flavor: "percona"},
+			true, "flavor mysql instead of percona"},
+		{"different version", sandboxSpec{Name: "single", Topology: "single", Version: "8.0.1"},
+			true, "version 8.0.16 instead of 8.0.1"},
+		{"different type", sandboxSpec{Name: "single", Topology: "multiple", Version: "8.0.16", Nodes: 3},
+			true, "type single instead of multiple"},
+		{"master-slave", sandboxSpec{Name: "ms", Topology: globals.MasterSlaveLabel, Version: "8.0.16", Nodes: 3}, true, ""},
+		{"master-slave nodes", sandboxSpec{Name: "ms", Topology: globals.MasterSlaveLabel, Version: "8.0.16", Nodes: 4},
+			true, "3 nodes instead of 4"},
+		{"single-primary group", sandboxSpec{Name: "group", Topology: globals.GroupLabel, Version: "8.0.16", Nodes: 3,
+			SinglePrimary: true}, true, ""},
+		{"multi-primary group", sandboxSpec{Name: "group", Topology: globals.GroupLabel, Version: "8.0.16", Nodes: 3},
+			true, "type group-single-primary instead of group-multi-primary"},
+		{"no description", sandboxSpec{Name: "no-description", Topology: "single", Version: "8.0.16"},
+			true, "description can't be read"},
+	}
+	for _, test := range tests {
+		exists, err := sandboxMatchesSpec(sandboxHome, test.sb)
+		compare.OkEqualBool(test.label+" exists", exists, test.wantedExists, t)
+		okError(test.label, err, test.wantedError, t)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	var data = []struct {
+		value    string
+		expected string
+	}{
+		{"msandbox", "msandbox"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"/tmp/mysql_sandbox8016.sock", "/tmp/mysql_sandbox8016.sock"},
+		{"", "''"},
+		{"my pass", "'my pass'"},
+		{"pa$$word", "'pa$$word'"},
+		{"it's", `'it'\''s'`},
+		{"a#b", "'a#b'"},
+		{"!secret", "'!secret'"},
+		{"`id`", "'`id`'"},
+	}
+	for _, d := range data {
+		compare.OkEqualString(d.value, shellQuote(d.value), d.expected, t)
+	}
+	compare.OkEqualString("command line", shellCommandLine([]string{"deploy", "single", "8.0.16", "--my-cnf-options=max_connections=10 # test", ""}),
+		`dbdeployer deploy single 8.0.16 '--my-cnf-options=max_connections=10 # test' ''`, t)
+}
@@ -0,0 +1,126 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/spf13/cobra"
+)
+
+// Returns the sandbox homes to scan, including the one given on the command line
+func getCatalogSandboxHomes(cmd *cobra.Command) []string {
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error defining absolute path for '%s'", globals.SandboxHomeLabel)
+	return defaults.CatalogSandboxHomes(sandboxHome)
+}
+
+func showCatalogIssues(issues []defaults.CatalogIssue) {
+	template := "%-14s %-50s %s\n"
+	for _, issue := range issues {
+		fmt.Printf(template, issue.Kind, common.ReplaceLiteralHome(issue.Name), issue.Details)
+	}
+}
+
+func checkCatalog(cmd *cobra.Command, args []string) {
+	homes := getCatalogSandboxHomes(cmd)
+	issues, err := defaults.CheckCatalog(homes)
+	common.ErrCheckExitf(err, 1, "error checking catalog: %s", err)
+	if len(issues) == 0 {
+		fmt.Printf("# Catalog is consistent with the sandboxes in %v\n", homes)
+		return
+	}
+	showCatalogIssues(issues)
+	common.Exitf(1, "# %d catalog issues found. Run 'dbdeployer catalog fix' to repair them", len(issues))
+}
+
+func fixCatalog(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	prune, _ := flags.GetBool(globals.PruneLabel)
+	rebuild, _ := flags.GetBool(globals.RebuildLabel)
+	homes := getCatalogSandboxHomes(cmd)
+	if rebuild {
+		catalog, err := defaults.RebuildCatalog(homes)
+		common.ErrCheckExitf(err, 1, "error rebuilding catalog: %s", err)
+		fmt.Printf("# Catalog rebuilt with %d sandboxes found in %v\n", len(catalog), homes)
+		return
+	}
+	issues, err := defaults.CheckCatalog(homes)
+	common.ErrCheckExitf(err, 1, "error checking catalog: %s", err)
+	if len(issues) == 0 {
+		fmt.Printf("# Nothing to fix\n")
+		return
+	}
+	fixed, err := defaults.FixCatalog(issues, prune)
+	common.ErrCheckExitf(err, 1, "error fixing catalog: %s", err)
+	showCatalogIssues(fixed)
+	fmt.Printf("# %d catalog issues fixed\n", len(fixed))
+	if len(fixed) < len(issues) {
+		fmt.Printf("# %d orphan entries left in the catalog. Use --%s to remove them\n",
+			len(issues)-len(fixed), globals.PruneLabel)
+	}
+}
+
+var (
+	catalogCmd = &cobra.Command{
+		Use:   "catalog",
+		Short: "Checks and repairs the sandboxes catalog",
+		Long: `The sandboxes catalog keeps track of all deployed sandboxes.
+It may drift from the actual contents of the sandbox directories when a
+sandbox is removed manually, or a deployment is interrupted.
+The commands in this group compare the catalog with the sandboxes found on disk,
+looking in the default sandbox home, in the one given with --sandbox-home,
+and in the directories of the sandboxes already in the catalog.`,
+	}
+
+	catalogCheckCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Reports differences between catalog and sandboxes on disk",
+		Long: `Reports the differences between the catalog and the sandboxes on disk:
+  orphan-entry:  the catalog lists a sandbox that is no longer on disk
+  missing-entry: a sandbox on disk is not in the catalog
+  stale-ports:   the ports in the catalog differ from the ones used by the sandbox
+  mismatch:      type, version, or nodes differ from the sandbox description
+Exits with an error when any difference is found.`,
+		Run: checkCatalog,
+	}
+
+	catalogFixCmd = &cobra.Command{
+		Use:   "fix",
+		Short: "Repairs the catalog using the sandboxes on disk",
+		Long: `Adds the sandboxes missing from the catalog and updates the entries
+that differ from the sandboxes on disk.
+Orphan entries are only removed when using --prune.
+With --rebuild, the catalog is replaced entirely by the sandboxes found on disk.`,
+		Example: `
+	$ dbdeployer catalog fix
+	$ dbdeployer catalog fix --prune
+	$ dbdeployer catalog fix --rebuild --sandbox-home=/opt/sandboxes
+`,
+		Run: fixCatalog,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	catalogCmd.AddCommand(catalogCheckCmd)
+	catalogCmd.AddCommand(catalogFixCmd)
+
+	catalogFixCmd.Flags().Bool(globals.PruneLabel, false, "Removes catalog entries for sandboxes that are no longer on disk")
+	catalogFixCmd.Flags().Bool(globals.RebuildLabel, false, "Rebuilds the catalog from scratch, scanning all known sandbox homes")
+}
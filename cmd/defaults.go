@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/spf13/cobra"
 )
 
@@ -27,8 +28,8 @@ func showDefaults(cmd *cobra.Command, args []string) {
 }
 
 func writeDefaults(cmd *cobra.Command, args []string) {
-	defaults.WriteDefaultsFile(defaults.ConfigurationFile, defaults.Defaults())
-	common.CondPrintf("# Default values exported to %s\n", defaults.ConfigurationFile)
+	defaults.StoreDefaults(defaults.Defaults())
+	common.CondPrintf("# Default values exported to %s\n", defaults.DefaultsDestination())
 }
 
 func removeDefaults(cmd *cobra.Command, args []string) {
@@ -42,11 +43,11 @@ func loadDefaults(cmd *cobra.Command, args []string) {
 	filename := args[0]
 	newDefaults := defaults.ReadDefaultsFile(filename)
 	if defaults.ValidateDefaults(newDefaults) {
-		defaults.WriteDefaultsFile(defaults.ConfigurationFile, newDefaults)
+		defaults.StoreDefaults(newDefaults)
 	} else {
 		return
 	}
-	common.CondPrintf("Defaults imported from %s into %s\n", filename, defaults.ConfigurationFile)
+	common.CondPrintf("Defaults imported from %s into %s\n", filename, defaults.DefaultsDestination())
 }
 
 func exportDefaults(cmd *cobra.Command, args []string) {
@@ -73,6 +74,37 @@ func updateDefaults(cmd *cobra.Command, args []string) {
 	defaults.ShowDefaults(defaults.Defaults())
 }
 
+func createProfile(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1, "'profile create' requires a profile name")
+	}
+	err := defaults.CreateProfile(args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+	common.CondPrintf("# Profile %s created in %s\n", args[0], defaults.ProfileFile(args[0]))
+	common.CondPrintf("# Set its values with 'dbdeployer --%s=%s defaults update label value'\n", globals.ProfileLabel, args[0])
+}
+
+func listProfiles(cmd *cobra.Command, args []string) {
+	names, err := defaults.ProfileNames()
+	common.ErrCheckExitf(err, 1, "error reading profiles: %s", err)
+	for _, name := range names {
+		marker := " "
+		if name == defaults.ActiveProfile {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+}
+
+func removeProfile(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1, "'profile remove' requires a profile name")
+	}
+	err := defaults.RemoveProfile(args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+	common.CondPrintf("# Profile %s removed\n", args[0])
+}
+
 var (
 	defaultsCmd = &cobra.Command{
 		Use:     "defaults",
@@ -132,6 +164,45 @@ Afterwards, dbdeployer will use the internally stored defaults.
 `,
 		Run: removeDefaults,
 	}
+
+	defaultsProfileCmd = &cobra.Command{
+		Use:   "profile",
+		Short: "Named sets of defaults",
+		Long: fmt.Sprintf(`Manages profiles, named sets of defaults stored in %s.
+A profile contains only the values that it changes. All the others come from the factory defaults.
+The profile is chosen with --%s=name or the environment variable DBDEPLOYER_PROFILE.
+While a profile is active, "defaults update", "store", "load", and "reset" change the profile
+instead of the configuration file, and "defaults show" tells which values the profile sets.`,
+			common.ReplaceLiteralHome(defaults.ProfilesDir), globals.ProfileLabel),
+		Example: `
+	$ dbdeployer defaults profile create ci
+	$ dbdeployer --profile=ci defaults update sandbox-home /opt/ci/sandboxes
+	$ dbdeployer --profile=ci defaults show
+	$ DBDEPLOYER_PROFILE=ci dbdeployer deploy single 8.0.16
+`,
+	}
+
+	defaultsProfileCreateCmd = &cobra.Command{
+		Use:   "create profile_name",
+		Short: "Creates a profile",
+		Long:  `Creates a profile that does not change any value. Until it is updated, it uses the factory defaults.`,
+		Run:   createProfile,
+	}
+
+	defaultsProfileListCmd = &cobra.Command{
+		Use:     "list",
+		Short:   "Lists the available profiles",
+		Aliases: []string{"ls"},
+		Long:    `Lists the available profiles. The active one is marked by '*'.`,
+		Run:     listProfiles,
+	}
+
+	defaultsProfileRemoveCmd = &cobra.Command{
+		Use:     "remove profile_name",
+		Short:   "Removes a profile",
+		Aliases: []string{"delete"},
+		Run:     removeProfile,
+	}
 )
 
 func init() {
@@ -142,4 +213,8 @@ func init() {
 	defaultsCmd.AddCommand(defaultsLoadCmd)
 	defaultsCmd.AddCommand(defaultsUpdateCmd)
 	defaultsCmd.AddCommand(defaultsExportCmd)
+	defaultsCmd.AddCommand(defaultsProfileCmd)
+	defaultsProfileCmd.AddCommand(defaultsProfileCreateCmd)
+	defaultsProfileCmd.AddCommand(defaultsProfileListCmd)
+	defaultsProfileCmd.AddCommand(defaultsProfileRemoveCmd)
 }
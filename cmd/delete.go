@@ -43,6 +43,9 @@ func deleteSandbox(cmd *cobra.Command, args []string) {
 		runConcurrently = true
 	}
 	skipConfirm, _ := flags.GetBool(globals.SkipConfirmLabel)
+	hookDefinitions, _ := flags.GetStringSlice(globals.HookLabel)
+	hooks, err := sandbox.ParseHooks(hookDefinitions)
+	common.ErrCheckExitf(err, 1, "error in hook definition: %s", err)
 	sandboxDir, err := getAbsolutePathFromFlag(cmd, "sandbox-home")
 	common.ErrCheckExitf(err, 1, "error finding absolute path for 'sandbox-home'")
 
@@ -98,7 +101,7 @@ func deleteSandbox(cmd *cobra.Command, args []string) {
 		if sb.Locked {
 			common.CondPrintf("Sandbox %s is locked\n", sb.SandboxName)
 		} else {
-			execList, err := sandbox.RemoveSandbox(sandboxDir, sb.SandboxName, runConcurrently)
+			execList, err := sandbox.RemoveSandboxWithHooks(sandboxDir, sb.SandboxName, runConcurrently, hooks)
 			if err != nil {
 				common.Exitf(1, globals.ErrWhileDeletingSandbox, err)
 			}
@@ -107,7 +110,10 @@ func deleteSandbox(cmd *cobra.Command, args []string) {
 			}
 		}
 	}
-	concurrent.RunParallelTasksByPriority(execLists)
+	err = concurrent.RunParallelTasksByPriority(execLists)
+	if err != nil {
+		common.Exitf(1, globals.ErrWhileDeletingSandbox, err)
+	}
 	for _, sb := range deletionList {
 		fullPath := path.Join(sandboxDir, sb.SandboxName)
 		if !sb.Locked {
@@ -138,4 +144,5 @@ func init() {
 	deleteCmd.Flags().BoolP(globals.SkipConfirmLabel, "", false, "Skips confirmation with multiple deletions.")
 	deleteCmd.Flags().BoolP(globals.ConfirmLabel, "", false, "Requires confirmation.")
 	deleteCmd.Flags().BoolP(globals.ConcurrentLabel, "", false, "Runs multiple deletion tasks concurrently.")
+	deleteCmd.Flags().StringSliceP(globals.HookLabel, "", []string{}, "Scripts or SQL files to run before or after the deletion (pre-delete:file_name, post-delete:file_name)")
 }
@@ -60,8 +60,9 @@ func init() {
 	deployCmd.PersistentFlags().Bool(globals.EnableGeneralLogLabel, false, "Enables general log for the sandbox (MySQL 5.1+)")
 	deployCmd.PersistentFlags().Bool(globals.InitGeneralLogLabel, false, "uses general log during initialization (MySQL 5.1+)")
 	deployCmd.PersistentFlags().Bool(globals.LogSBOperationsLabel, defaults.LogSBOperations, "Logs sandbox operations to a file")
+	deployCmd.PersistentFlags().Bool(globals.DownloadLabel, false, "Downloads and unpacks the tarball from the remote repositories if the version is not in sandbox-binary")
 
-	setPflag(deployCmd, globals.LogLogDirectoryLabel, "", "", defaults.Defaults().LogDirectory, "Where to store dbdeployer logs", false)
+	setPflag(deployCmd, globals.LogLogDirectoryLabel, "", "", initialDefaults().LogDirectory, "Where to store dbdeployer logs", false)
 	setPflag(deployCmd, globals.RemoteAccessLabel, "", "", globals.RemoteAccessValue, "defines the database access ", false)
 	setPflag(deployCmd, globals.BindAddressLabel, "", "", globals.BindAddressValue, "defines the database bind-address ", false)
 	setPflag(deployCmd, globals.CustomMysqldLabel, "", "", "", "Uses an alternative mysqld (must be in the same directory as regular mysqld)", false)
@@ -80,6 +81,8 @@ func init() {
 	setPflag(deployCmd, globals.RplUserLabel, "", "", globals.RplUserValue, "replication user", false)
 	setPflag(deployCmd, globals.DbPasswordLabel, "p", "", globals.DbPasswordValue, "database password", false)
 	setPflag(deployCmd, globals.RplPasswordLabel, "", "", globals.RplPasswordValue, "replication password", false)
+	setPflag(deployCmd, globals.ExtraScriptsLabel, "", "", "", "Sets of user scripts to add to the sandbox, from the extra-scripts directory", true)
+	setPflag(deployCmd, globals.HookLabel, "", "", "", "Scripts or SQL files to run at events of the sandbox life cycle (event:file_name)", true)
 	setPflag(deployCmd, globals.UseTemplateLabel, "", "", "", "[template_name:file_name] Replace existing template with one from file", true)
 	setPflag(deployCmd, globals.SandboxDirectoryLabel, "", "", "", "Changes the default sandbox directory", false)
 	setPflag(deployCmd, globals.HistoryDirLabel, "", "", "", "Where to store mysql client history (default: in sandbox directory)", false)
@@ -16,59 +16,432 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/spf13/cobra"
+	"os/exec"
 	"path"
+	"strings"
+	"sync"
 )
 
+type globalRunResult struct {
+	sandbox    string
+	executable string
+	output     string
+	skipped    bool
+	notRun     bool // not started, because the operation stopped at an earlier failure
+	err        error
+}
+
+// How a global command runs through the selected sandboxes
+type globalRunOptions struct {
+	skipMissing     bool
+	continueOnError bool
+	concurrent      bool
+}
+
+// Reads the sandbox selection criteria from the command line
+func getSandboxFilter(cmd *cobra.Command) common.SandboxFilter {
+	flags := cmd.Flags()
+	types, _ := flags.GetStringSlice(globals.TypeLabel)
+	versions, _ := flags.GetStringSlice(globals.VersionLabel)
+	flavors, _ := flags.GetStringSlice(globals.FlavorLabel)
+	names, _ := flags.GetStringSlice(globals.NameLabel)
+	excludes, _ := flags.GetStringSlice(globals.ExcludeLabel)
+	return common.SandboxFilter{
+		Types:    types,
+		Versions: versions,
+		Flavors:  flavors,
+		Names:    names,
+		Excludes: excludes,
+	}
+}
+
+// Runs an executable in a single sandbox.
+// When silent is set, the output is collected instead of being printed.
+func globalRunInSandbox(sandboxDir, sb, executable string, args []string, skipMissing, silent bool) globalRunResult {
+	result := globalRunResult{sandbox: sb, executable: executable}
+	singleUse := true
+	fullDirPath := path.Join(sandboxDir, sb)
+	cmdFile := path.Join(fullDirPath, executable)
+	if !common.ExecExists(cmdFile) {
+		cmdFile = path.Join(fullDirPath, executable+"_all")
+		result.executable = executable + "_all"
+		singleUse = false
+	}
+	if !common.ExecExists(cmdFile) {
+		if skipMissing {
+			result.skipped = true
+			result.output = fmt.Sprintf("# Sandbox %s: executable %s not found\n", fullDirPath, executable)
+			return result
+		}
+		result.err = fmt.Errorf("no %s or %s found in %s", executable, executable+"_all", fullDirPath)
+		return result
+	}
+	var cmdArgs []string
+
+	if singleUse && executable == "use" {
+		cmdArgs = append(cmdArgs, "-e")
+	}
+	for _, arg := range args {
+		cmdArgs = append(cmdArgs, arg)
+	}
+	result.output, result.err = common.RunCmdWithArgsCtrl(cmdFile, cmdArgs, silent)
+	return result
+}
+
+// Runs an executable in the given sandboxes, one after the other or all at once.
+// Both modes follow the same error policy: unless continueOnError is set,
+// no sandbox is started after the first failure, and the ones left out are marked as not run.
+// In concurrent mode the output is collected and shown sandbox by sandbox,
+// to avoid mixing the lines of different commands.
+func globalRunInSandboxes(sandboxDir string, runList []string, executable string, args []string, options globalRunOptions) []globalRunResult {
+	results := make([]globalRunResult, len(runList))
+	var mutex sync.Mutex
+	failed := false
+	// Runs the executable in one sandbox, unless an earlier failure stopped the operation
+	runOne := func(N int, silent bool) bool {
+		mutex.Lock()
+		stopped := failed && !options.continueOnError
+		mutex.Unlock()
+		if stopped {
+			results[N] = globalRunResult{sandbox: runList[N], executable: executable, notRun: true}
+			return false
+		}
+		if !silent {
+			common.CondPrintf("# Running \"%s\" on %s\n", executable, runList[N])
+		}
+		result := globalRunInSandbox(sandboxDir, runList[N], executable, args, options.skipMissing, silent)
+		if result.err != nil {
+			mutex.Lock()
+			failed = true
+			mutex.Unlock()
+		}
+		results[N] = result
+		return true
+	}
+	if options.concurrent {
+		var wg sync.WaitGroup
+		for N := range runList {
+			wg.Add(1)
+			go func(N int) {
+				defer wg.Done()
+				runOne(N, true)
+			}(N)
+		}
+		wg.Wait()
+		for _, result := range results {
+			if result.notRun {
+				continue
+			}
+			fmt.Printf("# Running \"%s\" on %s\n", result.executable, result.sandbox)
+			fmt.Printf("%s\n", result.output)
+		}
+		return results
+	}
+	for N := range runList {
+		if !runOne(N, false) {
+			continue
+		}
+		if results[N].skipped {
+			common.CondPrintf("%s", results[N].output)
+			continue
+		}
+		fmt.Println("")
+	}
+	return results
+}
+
+// Returns the error of a global operation, or nil if it succeeded in every sandbox.
+// When the operation stops at the first failure, the error names the failing sandbox
+func globalRunError(results []globalRunResult, executable string, continueOnError bool) error {
+	failures := 0
+	var firstFailure globalRunResult
+	for _, result := range results {
+		if result.err != nil {
+			if failures == 0 {
+				firstFailure = result
+			}
+			failures++
+		}
+	}
+	if failures == 0 {
+		return nil
+	}
+	if !continueOnError {
+		return fmt.Errorf("error while running %s in %s: %s", firstFailure.executable, firstFailure.sandbox, firstFailure.err)
+	}
+	return fmt.Errorf("global %s failed in %d sandboxes out of %d", executable, failures, len(results))
+}
+
+// Returns the outcome of a global operation for every sandbox, as a table
+func globalRunSummary(results []globalRunResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+	template := "%-30s %-20s %s\n"
+	summary := "# Summary\n"
+	summary += fmt.Sprintf(template, "sandbox", "command", "result")
+	summary += fmt.Sprintf(template, "-------", "-------", "------")
+	for _, result := range results {
+		outcome := "ok"
+		if result.skipped {
+			outcome = "skipped"
+		}
+		if result.notRun {
+			outcome = "not run"
+		}
+		if result.err != nil {
+			outcome = fmt.Sprintf("FAILED (%s)", result.err)
+		}
+		summary += fmt.Sprintf(template, result.sandbox, result.executable, outcome)
+	}
+	return summary
+}
+
 func globalRunCommand(cmd *cobra.Command, executable string, args []string, requireArgs bool, skipMissing bool) {
 	sandboxDir, err := getAbsolutePathFromFlag(cmd, "sandbox-home")
 	common.ErrCheckExitf(err, 1, "error defining absolute path for 'sandbox-home'")
 	sandboxList, err := common.GetInstalledSandboxes(sandboxDir)
 	common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
+	if len(sandboxList) == 0 {
+		common.Exitf(1, "no sandboxes found in %s", sandboxDir)
+	}
+	filter := getSandboxFilter(cmd)
+	sandboxList, err = common.FilterInstalledSandboxes(sandboxDir, sandboxList, filter)
+	common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
 	runList := common.SandboxInfoToFileNames(sandboxList)
 	if len(runList) == 0 {
-		common.Exitf(1, "no sandboxes found in %s", sandboxDir)
+		common.Exitf(1, "no sandboxes matching the requested criteria found in %s", sandboxDir)
 	}
 	if requireArgs && len(args) < 1 {
 		common.Exitf(1, "arguments required for command %s", executable)
 	}
-	for _, sb := range runList {
-		singleUse := true
-		fullDirPath := path.Join(sandboxDir, sb)
-		cmdFile := path.Join(fullDirPath, executable)
-		realExecutable := executable
-		if !common.ExecExists(cmdFile) {
-			cmdFile = path.Join(fullDirPath, executable+"_all")
-			realExecutable = executable + "_all"
-			singleUse = false
-		}
-		if !common.ExecExists(cmdFile) {
-			if skipMissing {
-				common.CondPrintf("# Sandbox %s: executable %s not found\n", fullDirPath, executable)
-				continue
+	flags := cmd.Flags()
+	continueOnError, _ := flags.GetBool(globals.ContinueOnErrorLabel)
+	runConcurrently, _ := flags.GetBool(globals.ConcurrentLabel)
+
+	results := globalRunInSandboxes(sandboxDir, runList, executable, args, globalRunOptions{
+		skipMissing:     skipMissing,
+		continueOnError: continueOnError,
+		concurrent:      runConcurrently,
+	})
+	fmt.Print(globalRunSummary(results))
+	err = globalRunError(results, executable, continueOnError)
+	common.ErrCheckExitf(err, 1, "%s", err)
+}
+
+type nodeQueryResult struct {
+	Sandbox string     `json:"sandbox"`
+	Node    string     `json:"node"`
+	Port    int        `json:"port"`
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// Runs a query in one node, using its "use" script in batch mode.
+// The values of each row are in the same order as the columns
+func queryNode(sandbox, nodeDir, query string) nodeQueryResult {
+	// Empty results are shown as empty lists rather than null
+	result := nodeQueryResult{Sandbox: sandbox, Node: "-", Columns: []string{}, Rows: [][]string{}}
+	if common.BaseName(nodeDir) != sandbox {
+		result.Node = common.BaseName(nodeDir)
+	}
+	nodeStatus, err := common.GetNodeStatus(nodeDir, false)
+	if err == nil {
+		result.Port = nodeStatus.Port
+	}
+	useScript := path.Join(nodeDir, globals.ScriptUse)
+	if !common.ExecExists(useScript) {
+		result.Error = fmt.Sprintf(globals.ErrScriptNotFoundInUpper, globals.ScriptUse, nodeDir)
+		return result
+	}
+	out, err := common.RunCmdWithArgsCtrl(useScript, []string{"-B", "-e", query}, true)
+	if err != nil {
+		result.Error = err.Error()
+		// The client error message is more useful than the exit status
+		if exitError, ok := err.(*exec.ExitError); ok {
+			stderr := strings.TrimSpace(string(exitError.Stderr))
+			if stderr != "" {
+				result.Error += ": " + stderr
 			}
-			common.Exitf(1, "no %s or %s found in %s", executable, executable+"_all", fullDirPath)
 		}
-		var cmdArgs []string
+		return result
+	}
+	columns, rows := common.ParseBatchOutput(out)
+	if len(columns) > 0 {
+		result.Columns = columns
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		copy(record, row)
+		result.Rows = append(result.Rows, record)
+	}
+	return result
+}
 
-		if singleUse && executable == "use" {
-			cmdArgs = append(cmdArgs, "-e")
+// A column of the merged query table. Columns with the same name in one result
+// are kept apart by their occurrence number
+type queryColumn struct {
+	name       string
+	occurrence int
+}
+
+// Returns the columns of a query result with their occurrence numbers
+func queryResultColumns(result nodeQueryResult) []queryColumn {
+	var columns []queryColumn
+	seen := make(map[string]int)
+	for _, name := range result.Columns {
+		seen[name]++
+		columns = append(columns, queryColumn{name, seen[name]})
+	}
+	return columns
+}
+
+// Shows the query results of all nodes in a single table
+func showQueryResultsAsTable(results []nodeQueryResult) {
+	header := []string{"sandbox", "node", "port"}
+	var tableColumns []queryColumn
+	seenColumns := make(map[queryColumn]bool)
+	for _, result := range results {
+		for _, column := range queryResultColumns(result) {
+			if !seenColumns[column] {
+				seenColumns[column] = true
+				tableColumns = append(tableColumns, column)
+				header = append(header, column.name)
+			}
 		}
-		for _, arg := range args {
-			cmdArgs = append(cmdArgs, arg)
+	}
+	var table [][]string
+	for _, result := range results {
+		prefix := []string{result.Sandbox, result.Node, fmt.Sprintf("%d", result.Port)}
+		if result.Error != "" {
+			table = append(table, append(prefix, "ERROR: "+result.Error))
+			continue
 		}
-		var err error
-		common.CondPrintf("# Running \"%s\" on %s\n", realExecutable, sb)
-		if len(cmdArgs) > 0 {
-			_, err = common.RunCmdWithArgs(cmdFile, cmdArgs)
-		} else {
-			_, err = common.RunCmd(cmdFile)
+		if len(result.Rows) == 0 {
+			table = append(table, append(prefix, "(no rows)"))
+			continue
+		}
+		positions := make(map[queryColumn]int)
+		for N, column := range queryResultColumns(result) {
+			positions[column] = N
+		}
+		for _, row := range result.Rows {
+			line := append([]string{}, prefix...)
+			for _, column := range tableColumns {
+				value := ""
+				if N, found := positions[column]; found && N < len(row) {
+					value = row[N]
+				}
+				line = append(line, value)
+			}
+			table = append(table, line)
+		}
+	}
+	widths := make([]int, len(header))
+	for N, column := range header {
+		widths[N] = len(column)
+	}
+	for _, line := range table {
+		// Values in the last column, error messages, and empty results do not affect the alignment
+		last := len(header) - 1
+		if len(line) < len(header) {
+			last = len(line) - 1
+		}
+		for N := 0; N < last; N++ {
+			if len(line[N]) > widths[N] {
+				widths[N] = len(line[N])
+			}
+		}
+	}
+	printLine := func(line []string) {
+		text := ""
+		for N, value := range line {
+			if N == len(line)-1 {
+				text += value
+			} else {
+				text += fmt.Sprintf("%-*s  ", widths[N], value)
+			}
+		}
+		fmt.Println(strings.TrimRight(text, " "))
+	}
+	printLine(header)
+	var dashes []string
+	for _, w := range widths {
+		dashes = append(dashes, strings.Repeat("-", w))
+	}
+	printLine(dashes)
+	for _, line := range table {
+		printLine(line)
+	}
+}
+
+func queryAllSandboxes(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exitf(1, globals.ErrArgumentRequired, "query")
+	}
+	query := args[0]
+	flags := cmd.Flags()
+	format, _ := flags.GetString(globals.FormatLabel)
+	if format != "table" && format != "json" {
+		common.Exitf(1, "unsupported format '%s'. Accepted: 'table', 'json'", format)
+	}
+	runConcurrently, _ := flags.GetBool(globals.ConcurrentLabel)
+	sandboxDir, err := getAbsolutePathFromFlag(cmd, "sandbox-home")
+	common.ErrCheckExitf(err, 1, "error defining absolute path for 'sandbox-home'")
+	sandboxList, err := common.GetInstalledSandboxes(sandboxDir)
+	common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
+	sandboxList, err = common.FilterInstalledSandboxes(sandboxDir, sandboxList, getSandboxFilter(cmd))
+	common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
+	if len(sandboxList) == 0 {
+		common.Exitf(1, "no sandboxes matching the requested criteria found in %s", sandboxDir)
+	}
+
+	type nodeRef struct {
+		sandbox string
+		dir     string
+	}
+	var nodes []nodeRef
+	for _, sb := range common.SandboxInfoToFileNames(sandboxList) {
+		nodeDirs, err := common.GetSandboxNodeDirs(path.Join(sandboxDir, sb))
+		common.ErrCheckExitf(err, 1, "error retrieving nodes for sandbox %s: %s", sb, err)
+		for _, nodeDir := range nodeDirs {
+			nodes = append(nodes, nodeRef{sb, nodeDir})
+		}
+	}
+	results := make([]nodeQueryResult, len(nodes))
+	if runConcurrently {
+		var wg sync.WaitGroup
+		for N, node := range nodes {
+			wg.Add(1)
+			go func(N int, node nodeRef) {
+				defer wg.Done()
+				results[N] = queryNode(node.sandbox, node.dir, query)
+			}(N, node)
+		}
+		wg.Wait()
+	} else {
+		for N, node := range nodes {
+			results[N] = queryNode(node.sandbox, node.dir, query)
+		}
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(results, "", "  ")
+		common.ErrCheckExitf(err, 1, "error encoding query results: %s", err)
+		fmt.Println(string(out))
+	} else {
+		showQueryResultsAsTable(results)
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			common.Exitf(1, "query failed in one or more nodes")
 		}
-		common.ErrCheckExitf(err, 1, "error while running %s\n", cmdFile)
-		fmt.Println("")
 	}
 }
 
@@ -104,11 +477,25 @@ var (
 	globalCmd = &cobra.Command{
 		Use:   "global",
 		Short: "Runs a given command in every sandbox",
-		Long:  `This command can propagate the given action through all sandboxes.`,
+		Long: `This command can propagate the given action through all sandboxes.
+The sandboxes affected by the command can be restricted using
+--type, --version, --flavor, and --name. Sandboxes matching --exclude are
+always skipped. All selectors accept shell patterns and can be repeated or
+given as comma-separated lists.
+By default, the operation stops at the first failure. Use --continue-on-error
+to run the command in all the selected sandboxes regardless of errors, or
+--concurrent to run it in all the sandboxes at once. With --concurrent, a
+failure prevents the sandboxes not yet started from running, unless
+--continue-on-error is also used.
+A summary of successes and failures is shown at the end.`,
 		Example: `
 	$ dbdeployer global use "select version()"
 	$ dbdeployer global status
 	$ dbdeployer global stop
+	$ dbdeployer global restart --type=group --version=8.0.*
+	$ dbdeployer global stop --flavor=percona --exclude='*5_6*'
+	$ dbdeployer global test --name='rsandbox_*' --continue-on-error
+	$ dbdeployer global start --concurrent
 	`,
 	}
 
@@ -165,6 +552,23 @@ For example, a query using @@port won't run in MySQL 5.0.x`,
 	$ dbdeployer global use "select @@server_id, @@port"`,
 		Run: useAllSandboxes,
 	}
+
+	globalQueryCmd = &cobra.Command{
+		Use:   "query {query}",
+		Short: "Runs a query in all nodes and shows the merged results",
+		Long: `Runs a query in every node of the selected sandboxes, including the
+nodes of replication and multiple sandboxes.
+The results are merged into a single table, with one row for each row
+returned by each node, identified by sandbox name, node name, and port.
+Using --format=json, the results are returned as a JSON list, one item
+per node.
+Nodes where the query fails are reported with their error message.`,
+		Example: `
+	$ dbdeployer global query "select @@version, @@server_id"
+	$ dbdeployer global query --type=group "select @@gtid_executed"
+	$ dbdeployer global query --format=json --version=8.0 "select @@port"`,
+		Run: queryAllSandboxes,
+	}
 )
 
 func init() {
@@ -176,5 +580,16 @@ func init() {
 	globalCmd.AddCommand(globalTestCmd)
 	globalCmd.AddCommand(globalTestReplicationCmd)
 	globalCmd.AddCommand(globalUseCmd)
+	globalCmd.AddCommand(globalQueryCmd)
+
+	globalCmd.PersistentFlags().StringSlice(globals.TypeLabel, []string{}, "Runs only in sandboxes of the given type (single, master-slave, group, ...)")
+	globalCmd.PersistentFlags().StringSlice(globals.VersionLabel, []string{}, "Runs only in sandboxes with the given version (accepts patterns such as '8.0.*')")
+	globalCmd.PersistentFlags().StringSlice(globals.FlavorLabel, []string{}, "Runs only in sandboxes of the given flavor")
+	globalCmd.PersistentFlags().StringSlice(globals.NameLabel, []string{}, "Runs only in sandboxes whose name matches the given pattern")
+	globalCmd.PersistentFlags().StringSlice(globals.ExcludeLabel, []string{}, "Skips sandboxes whose name matches the given pattern")
+	globalCmd.PersistentFlags().Bool(globals.ContinueOnErrorLabel, false, "Does not stop at the first failing sandbox")
+	globalCmd.PersistentFlags().Bool(globals.ConcurrentLabel, false, "Runs the command in all sandboxes at once")
+
+	globalQueryCmd.Flags().String(globals.FormatLabel, "table", "Output format for query results: table or json")
 
 }
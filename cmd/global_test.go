@@ -0,0 +1,142 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Creates a sandbox directory with a status script that exits with the given code.
+// With a negative exit code, the sandbox has no status script
+func makeMockSandbox(t *testing.T, sandboxDir, name string, exitCode int) {
+	dir := path.Join(sandboxDir, name)
+	err := os.Mkdir(dir, globals.PublicDirectoryAttr)
+	compare.OkIsNil("mock sandbox dir "+name, err, t)
+	if exitCode < 0 {
+		return
+	}
+	script := path.Join(dir, globals.ScriptStatus)
+	err = ioutil.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\necho %s\nexit %d\n", name, exitCode)), globals.ExecutableFileAttr)
+	compare.OkIsNil("mock script "+name, err, t)
+}
+
+// Returns the outcome of each sandbox, as shown in the summary
+func globalOutcomes(results []globalRunResult) []string {
+	var outcomes []string
+	for _, result := range results {
+		outcome := "ok"
+		switch {
+		case result.err != nil:
+			outcome = "failed"
+		case result.notRun:
+			outcome = "not run"
+		case result.skipped:
+			outcome = "skipped"
+		}
+		outcomes = append(outcomes, result.sandbox+":"+outcome)
+	}
+	return outcomes
+}
+
+func TestGlobalRunInSandboxes(t *testing.T) {
+	sandboxDir, err := ioutil.TempDir("", "global_test")
+	compare.OkIsNil("temp dir", err, t)
+	defer os.RemoveAll(sandboxDir)
+
+	makeMockSandbox(t, sandboxDir, "sb1", 0)
+	makeMockSandbox(t, sandboxDir, "sb2", 1)
+	makeMockSandbox(t, sandboxDir, "sb3", 0)
+	makeMockSandbox(t, sandboxDir, "sb4", -1)
+	runList := []string{"sb1", "sb2", "sb3"}
+
+	stopError := "error while running status in sb2: exit status 1"
+	continueError := "global status failed in 1 sandboxes out of 3"
+
+	// Sequential, stopping at the first failure
+	results := globalRunInSandboxes(sandboxDir, runList, globals.ScriptStatus, nil, globalRunOptions{})
+	compare.OkEqualStringSlices(t, globalOutcomes(results), []string{"sb1:ok", "sb2:failed", "sb3:not run"})
+	err = globalRunError(results, globals.ScriptStatus, false)
+	compare.OkIsNotNil("sequential stop error", err, t)
+	compare.OkEqualString("sequential stop error", err.Error(), stopError, t)
+
+	// Sequential, continuing after failures
+	results = globalRunInSandboxes(sandboxDir, runList, globals.ScriptStatus, nil, globalRunOptions{continueOnError: true})
+	compare.OkEqualStringSlices(t, globalOutcomes(results), []string{"sb1:ok", "sb2:failed", "sb3:ok"})
+	err = globalRunError(results, globals.ScriptStatus, true)
+	compare.OkIsNotNil("sequential continue error", err, t)
+	compare.OkEqualString("sequential continue error", err.Error(), continueError, t)
+
+	// Concurrent, continuing after failures: same outcome as the sequential run
+	results = globalRunInSandboxes(sandboxDir, runList, globals.ScriptStatus, nil, globalRunOptions{continueOnError: true, concurrent: true})
+	compare.OkEqualStringSlices(t, globalOutcomes(results), []string{"sb1:ok", "sb2:failed", "sb3:ok"})
+	compare.OkEqualString("concurrent output", results[0].output, "sb1\n", t)
+	err = globalRunError(results, globals.ScriptStatus, true)
+	compare.OkIsNotNil("concurrent continue error", err, t)
+	compare.OkEqualString("concurrent continue error", err.Error(), continueError, t)
+
+	// Concurrent, stopping at the first failure: the sandboxes already started complete,
+	// and the failure is reported as in a sequential run
+	results = globalRunInSandboxes(sandboxDir, runList, globals.ScriptStatus, nil, globalRunOptions{concurrent: true})
+	compare.OkIsNotNil("concurrent stop sb2", results[1].err, t)
+	compare.OkIsNil("concurrent stop sb1", results[0].err, t)
+	compare.OkIsNil("concurrent stop sb3", results[2].err, t)
+	err = globalRunError(results, globals.ScriptStatus, false)
+	compare.OkIsNotNil("concurrent stop error", err, t)
+	compare.OkEqualString("concurrent stop error", err.Error(), stopError, t)
+
+	// A sandbox without the executable is an error, unless missing executables are skipped
+	for _, concurrent := range []bool{false, true} {
+		results = globalRunInSandboxes(sandboxDir, []string{"sb1", "sb4"}, globals.ScriptStatus, nil,
+			globalRunOptions{concurrent: concurrent, continueOnError: true})
+		compare.OkEqualStringSlices(t, globalOutcomes(results), []string{"sb1:ok", "sb4:failed"})
+		results = globalRunInSandboxes(sandboxDir, []string{"sb4", "sb1"}, globals.ScriptStatus, nil,
+			globalRunOptions{concurrent: concurrent, skipMissing: true})
+		compare.OkEqualStringSlices(t, globalOutcomes(results), []string{"sb4:skipped", "sb1:ok"})
+		compare.OkIsNil("skipped sandbox", globalRunError(results, globals.ScriptStatus, false), t)
+	}
+}
+
+func TestGlobalRunSummary(t *testing.T) {
+	compare.OkEqualString("empty summary", globalRunSummary(nil), "", t)
+	results := []globalRunResult{
+		{sandbox: "sb1", executable: "status"},
+		{sandbox: "sb2", executable: "status_all", err: errors.New("exit status 1")},
+		{sandbox: "sb3", executable: "status", notRun: true},
+		{sandbox: "sb4", executable: "status", skipped: true},
+	}
+	lines := strings.Split(strings.TrimSpace(globalRunSummary(results)), "\n")
+	compare.OkEqualInt("summary lines", len(lines), 7, t)
+	compare.OkEqualString("summary title", lines[0], "# Summary", t)
+	template := "%-30s %-20s %s"
+	expected := []string{
+		fmt.Sprintf(template, "sandbox", "command", "result"),
+		fmt.Sprintf(template, "-------", "-------", "------"),
+		fmt.Sprintf(template, "sb1", "status", "ok"),
+		fmt.Sprintf(template, "sb2", "status_all", "FAILED ("+"exit status 1"+")"),
+		fmt.Sprintf(template, "sb3", "status", "not run"),
+		fmt.Sprintf(template, "sb4", "status", "skipped"),
+	}
+	compare.OkEqualStringSlices(t, lines[1:], expected)
+}
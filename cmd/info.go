@@ -0,0 +1,237 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/api"
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/spf13/cobra"
+)
+
+const (
+	infoFormatDsn   = "dsn"
+	infoFormatEnv   = "env"
+	infoFormatJson  = "json"
+	infoFormatMycnf = "mycnf"
+)
+
+var infoFormats = []string{infoFormatDsn, infoFormatEnv, infoFormatJson, infoFormatMycnf}
+
+type infoNode struct {
+	Name   string            `json:"name"`
+	Role   string            `json:"role,omitempty"`
+	Host   string            `json:"host"`
+	Port   int               `json:"port"`
+	Socket string            `json:"socket"`
+	Dsn    map[string]string `json:"dsn"`
+}
+
+type infoUser struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Purpose  string `json:"purpose"`
+}
+
+type infoDocument struct {
+	Sandbox string     `json:"sandbox"`
+	Dir     string     `json:"dir"`
+	Type    string     `json:"type"`
+	Version string     `json:"version"`
+	Flavor  string     `json:"flavor,omitempty"`
+	Users   []infoUser `json:"users"`
+	Nodes   []infoNode `json:"nodes"`
+}
+
+// Returns the connection strings of a node for Go, JDBC, and Python clients.
+// The Go driver reads user and password verbatim, up to the first ':' and the last '@',
+// while the JDBC and Python URLs need their credentials escaped
+func nodeDsn(node api.Node, user api.User) map[string]string {
+	address := fmt.Sprintf("%s:%d", node.Host, node.Port)
+	python := url.URL{
+		Scheme: "mysql+pymysql",
+		User:   url.UserPassword(user.Name, user.Password),
+		Host:   address,
+		Path:   "/",
+	}
+	return map[string]string{
+		"go":        fmt.Sprintf("%s:%s@tcp(%s)/", user.Name, user.Password, address),
+		"go-socket": fmt.Sprintf("%s:%s@unix(%s)/", user.Name, user.Password, node.Socket),
+		"jdbc": fmt.Sprintf("jdbc:mysql://%s/?user=%s&password=%s", address,
+			queryValueEscape(user.Name), queryValueEscape(user.Password)),
+		"python": python.String(),
+	}
+}
+
+// Escapes a value for a URL query, encoding spaces as %20 instead of '+'
+func queryValueEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+func showDsn(nodes []api.Node, user api.User) {
+	for N, node := range nodes {
+		if N > 0 {
+			fmt.Println()
+		}
+		dsn := nodeDsn(node, user)
+		role := ""
+		if node.Role != "" {
+			role = " (" + node.Role + ")"
+		}
+		fmt.Printf("# %s%s\n", node.Name, role)
+		for _, client := range []string{"go", "go-socket", "jdbc", "python"} {
+			fmt.Printf("%-10s %s\n", client, dsn[client])
+		}
+	}
+}
+
+func showEnv(node api.Node, user api.User) {
+	fmt.Printf("export MYSQL_HOST=%s\n", shellQuote(node.Host))
+	fmt.Printf("export MYSQL_TCP_PORT=%s\n", shellQuote(fmt.Sprintf("%d", node.Port)))
+	fmt.Printf("export MYSQL_UNIX_PORT=%s\n", shellQuote(node.Socket))
+	fmt.Printf("export MYSQL_USER=%s\n", shellQuote(user.Name))
+	fmt.Printf("export MYSQL_PWD=%s\n", shellQuote(user.Password))
+}
+
+// Shows client option groups. The groups of a composite sandbox are named after the nodes,
+// and can be selected with the client option --defaults-group-suffix=_nodename
+func showMycnf(sb api.Sandbox, nodes []api.Node, user api.User) {
+	for N, node := range nodes {
+		if N > 0 {
+			fmt.Println()
+		}
+		group := "client"
+		if len(sb.Nodes) > 1 {
+			group = "client_" + node.Name
+		}
+		fmt.Printf("[%s]\n", group)
+		fmt.Printf("host     = %s\n", node.Host)
+		fmt.Printf("port     = %d\n", node.Port)
+		fmt.Printf("socket   = %s\n", node.Socket)
+		fmt.Printf("user     = %s\n", user.Name)
+		fmt.Printf("password = %s\n", user.Password)
+	}
+}
+
+func showJson(sb api.Sandbox, nodes []api.Node, user api.User) error {
+	document := infoDocument{
+		Sandbox: sb.Name,
+		Dir:     sb.Dir,
+		Type:    sb.Type,
+		Version: sb.Version,
+		Flavor:  sb.Flavor,
+	}
+	for _, u := range sb.Users {
+		document.Users = append(document.Users, infoUser{Name: u.Name, Password: u.Password, Purpose: u.Purpose})
+	}
+	for _, node := range nodes {
+		document.Nodes = append(document.Nodes, infoNode{
+			Name:   node.Name,
+			Role:   node.Role,
+			Host:   node.Host,
+			Port:   node.Port,
+			Socket: node.Socket,
+			Dsn:    nodeDsn(node, user),
+		})
+	}
+	// Keeps the ampersands of the JDBC connection strings readable
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+func showSandboxInfo(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exitf(1, globals.ErrArgumentRequired, "sandbox name")
+	}
+	flags := cmd.Flags()
+	format, _ := flags.GetString(globals.FormatLabel)
+	nodeNum, _ := flags.GetInt(globals.NodeLabel)
+	validFormat := false
+	for _, f := range infoFormats {
+		if f == format {
+			validFormat = true
+		}
+	}
+	if !validFormat {
+		common.Exitf(1, "unknown format '%s'. Allowed formats: %s", format, strings.Join(infoFormats, ", "))
+	}
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error defining absolute path for '%s'", globals.SandboxHomeLabel)
+
+	sb, err := api.DescribeSandbox(context.Background(), sandboxHome, args[0])
+	common.ErrCheckExitf(err, 1, "%s", err)
+	if len(sb.Nodes) == 0 {
+		common.Exitf(1, "no nodes found in sandbox %s", sb.Dir)
+	}
+	user, found := sb.User(common.UserPurposeDatabase)
+	if !found {
+		common.Exitf(1, "no database user found in sandbox %s", sb.Dir)
+	}
+	nodes := sb.Nodes
+	if nodeNum != 0 {
+		if nodeNum < 0 || nodeNum > len(sb.Nodes) {
+			common.Exitf(1, "node %d not found. Sandbox %s has %d node(s)", nodeNum, sb.Name, len(sb.Nodes))
+		}
+		nodes = sb.Nodes[nodeNum-1 : nodeNum]
+	}
+
+	switch format {
+	case infoFormatDsn:
+		showDsn(nodes, user)
+	case infoFormatEnv:
+		// Environment variables can describe only one server
+		showEnv(nodes[0], user)
+	case infoFormatMycnf:
+		showMycnf(sb, nodes, user)
+	case infoFormatJson:
+		err = showJson(sb, nodes, user)
+		common.ErrCheckExitf(err, 1, "error encoding sandbox information: %s", err)
+	}
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info sandbox_name",
+	Short: "Shows connection information for a sandbox",
+	Long: `Shows how to connect to the nodes of a sandbox with the database user:
+  dsn    connection strings for Go, JDBC, and Python clients
+  env    environment variables for the mysql client (first node, unless --node is given)
+  json   a document with the sandbox users and every node with its role and connection strings
+  mycnf  client option groups. Composite sandboxes get a group for each node,
+         which can be used with --defaults-group-suffix=_nodename
+Nodes are numbered by port: in a master/slave sandbox, node 1 is the master.`,
+	Example: `
+	$ dbdeployer info msb_8_0_16
+	$ eval $(dbdeployer info msb_8_0_16 --format=env)
+	$ dbdeployer info rsandbox_8_0_16 --node=2 --format=json
+	$ dbdeployer info rsandbox_8_0_16 --format=mycnf > client.cnf
+`,
+	Run: showSandboxInfo,
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().String(globals.FormatLabel, infoFormatDsn, "Output format: "+strings.Join(infoFormats, ", "))
+	infoCmd.Flags().Int(globals.NodeLabel, 0, "Shows only the given node (1 to number of nodes)")
+}
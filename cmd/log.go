@@ -0,0 +1,166 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/spf13/cobra"
+)
+
+// Returns the directory containing the operations log of a sandbox.
+// The sandbox description is checked first, then the catalog
+func findSandboxLogDirectory(sandboxDir string) (string, error) {
+	if common.FileExists(path.Join(sandboxDir, globals.SandboxDescriptionName)) {
+		sbd, err := common.ReadSandboxDescription(sandboxDir)
+		if err != nil {
+			return "", err
+		}
+		if sbd.LogFile != "" {
+			return common.DirName(common.ReplaceHomeVar(sbd.LogFile)), nil
+		}
+	}
+	catalog, err := defaults.ReadCatalog()
+	if err == nil {
+		item, ok := catalog[sandboxDir]
+		if ok && item.LogDirectory != "" {
+			return common.ReplaceHomeVar(item.LogDirectory), nil
+		}
+	}
+	return "", fmt.Errorf("no operations log found for sandbox %s. "+
+		"Logging is enabled with --%s or DBDEPLOYER_LOGGING", sandboxDir, globals.LogSBOperationsLabel)
+}
+
+// Returns true if the entry satisfies all the filters given on the command line
+func logEntryMatches(entry defaults.LogEntry, minLevel defaults.LogLevel, node, operation string) bool {
+	level, err := defaults.ParseLogLevel(entry.Level)
+	if err == nil && level < minLevel {
+		return false
+	}
+	if node != "" && entry.Node != node {
+		return false
+	}
+	if operation != "" {
+		matches, _ := filepath.Match(operation, entry.Operation)
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
+func showLogEntry(entry defaults.LogEntry) {
+	where := entry.Sandbox
+	if entry.Node != "" {
+		where = entry.Node
+	}
+	message := strings.TrimSpace(entry.Message)
+	if entry.Outcome != "" && entry.Outcome != "started" {
+		message = fmt.Sprintf("%s (%dms)", message, entry.DurationMs)
+	}
+	fmt.Printf("%-26s %-7s %-14s %s\n", entry.Time, entry.Level, where, message)
+}
+
+func showSandboxLog(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exitf(1, globals.ErrArgumentRequired, "sandbox name")
+	}
+	flags := cmd.Flags()
+	levelName, _ := flags.GetString(globals.LogLevelLabel)
+	node, _ := flags.GetString(globals.NodeLabel)
+	operation, _ := flags.GetString(globals.OperationLabel)
+	last, _ := flags.GetInt(globals.LastLabel)
+	asJson, _ := flags.GetBool(globals.JsonLabel)
+
+	minLevel, err := defaults.ParseLogLevel(levelName)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error defining absolute path for '%s'", globals.SandboxHomeLabel)
+	sandboxDir := args[0]
+	if !path.IsAbs(sandboxDir) {
+		sandboxDir = path.Join(sandboxHome, sandboxDir)
+	}
+	logDir, err := findSandboxLogDirectory(sandboxDir)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	if !common.DirExists(logDir) {
+		common.Exitf(1, globals.ErrDirectoryNotFound, logDir)
+	}
+	entries, err := defaults.ReadLogEntries(logDir)
+	common.ErrCheckExitf(err, 1, "error reading log entries from %s: %s", logDir, err)
+
+	var selected []defaults.LogEntry
+	for _, entry := range entries {
+		if logEntryMatches(entry, minLevel, node, operation) {
+			selected = append(selected, entry)
+		}
+	}
+	if last > 0 && len(selected) > last {
+		selected = selected[len(selected)-last:]
+	}
+	for _, entry := range selected {
+		if asJson {
+			text, err := json.Marshal(entry)
+			common.ErrCheckExitf(err, 1, "error encoding log entry: %s", err)
+			fmt.Println(string(text))
+		} else {
+			showLogEntry(entry)
+		}
+	}
+}
+
+var (
+	logCmd = &cobra.Command{
+		Use:   "log",
+		Short: "Inspects the operations log of sandboxes",
+		Long: `When logging is enabled (--log-sb-operations or DBDEPLOYER_LOGGING),
+dbdeployer records every operation performed on a sandbox as a JSON line,
+with level, sandbox, node, operation, duration, and outcome.
+Log files are rotated when they exceed DBDEPLOYER_LOG_MAX_SIZE megabytes.`,
+	}
+
+	logShowCmd = &cobra.Command{
+		Use:   "show sandbox_name",
+		Short: "Shows the operations log of a sandbox",
+		Long: `Shows the operations recorded for a sandbox, including rotated log files,
+in chronological order. The entries can be filtered by minimum level, node,
+and operation name (wildcards allowed).`,
+		Example: `
+	$ dbdeployer log show msb_8_0_16
+	$ dbdeployer log show rsandbox_8_0_16 --node=node1 --level=warning
+	$ dbdeployer log show rsandbox_8_0_16 --operation='*grants' --last=10
+	$ dbdeployer log show msb_8_0_16 --json
+`,
+		Run: showSandboxLog,
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+	logCmd.AddCommand(logShowCmd)
+
+	logShowCmd.Flags().String(globals.LogLevelLabel, "debug", "Minimum level of the entries to show (debug, info, warning, error)")
+	logShowCmd.Flags().String(globals.NodeLabel, "", "Shows only entries for the given node")
+	logShowCmd.Flags().String(globals.OperationLabel, "", "Shows only entries for the given operation")
+	logShowCmd.Flags().Int(globals.LastLabel, 0, "Shows only the last N entries")
+	logShowCmd.Flags().Bool(globals.JsonLabel, false, "Shows the entries in JSON format")
+}
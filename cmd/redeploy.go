@@ -0,0 +1,279 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/spf13/cobra"
+)
+
+func hasFlag(args []string, label string) bool {
+	for _, arg := range args {
+		if arg == "--"+label || strings.HasPrefix(arg, "--"+label+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the arguments without the given flag
+func removeFlag(args []string, label string) []string {
+	var result []string
+	for N := 0; N < len(args); N++ {
+		if args[N] == "--"+label {
+			// The value is the next argument
+			N++
+			continue
+		}
+		if strings.HasPrefix(args[N], "--"+label+"=") {
+			continue
+		}
+		result = append(result, args[N])
+	}
+	return result
+}
+
+// Returns the arguments that deploy again a sandbox, in the same directory and with the same ports,
+// from the arguments of the command that deployed it.
+// The configuration flags of the original command (sandbox-binary, profile, config) are kept,
+// and the current ones are used when the original command did not have them
+func replayArgs(sandboxDir, sandboxBinary, sbType string, ports []int, commandArgs []string, commandLine string) ([]string, error) {
+	args := commandArgs
+	if len(args) == 0 && commandLine != "" {
+		// Descriptions written by older versions only have the command line, which can't be split
+		// reliably into arguments when they contain spaces or quotes
+		return nil, fmt.Errorf("sandbox %s was deployed by an older version of dbdeployer, "+
+			"which did not record the command arguments. Deploy it again manually (command: %s)", sandboxDir, commandLine)
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("no deployment command recorded for %s", sandboxDir)
+	}
+	// The first argument is the executable
+	args = removeFlag(args[1:], globals.SandboxHomeLabel)
+	isDeploy := false
+	for _, arg := range args {
+		if arg == "deploy" {
+			isDeploy = true
+			break
+		}
+	}
+	if !isDeploy {
+		return nil, fmt.Errorf("sandbox %s was not created by 'dbdeployer deploy' (command: %s)", sandboxDir, commandLine)
+	}
+	var fullArgs []string
+	for _, arg := range configurationArgs(common.DirName(sandboxDir), sandboxBinary) {
+		label := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)[0]
+		if label == globals.SandboxHomeLabel || !hasFlag(args, label) {
+			fullArgs = append(fullArgs, arg)
+		}
+	}
+	fullArgs = append(fullArgs, args...)
+	if !hasFlag(args, globals.SandboxDirectoryLabel) {
+		fullArgs = append(fullArgs, fmt.Sprintf("--%s=%s", globals.SandboxDirectoryLabel, common.BaseName(sandboxDir)))
+	}
+	if len(ports) > 0 && !hasFlag(args, globals.PortLabel) && !hasFlag(args, globals.BasePortLabel) {
+		if sbType == "single" {
+			fullArgs = append(fullArgs, fmt.Sprintf("--%s=%d", globals.PortLabel, ports[0]))
+		} else {
+			// The first port of a composite sandbox is the one of its first node
+			fullArgs = append(fullArgs, fmt.Sprintf("--%s=%d", globals.BasePortLabel, ports[0]-1))
+		}
+	}
+	return fullArgs, nil
+}
+
+// Checks that a deployment can be replayed: the command and its flags are known,
+// and the binaries are available or can be downloaded.
+// It is called before removing a sandbox, so that a failed replay does not leave it removed
+func checkReplayArgs(args []string) error {
+	command, commandArgs, err := rootCmd.Find(args)
+	if err != nil {
+		return err
+	}
+	if command.Parent() != deployCmd {
+		return fmt.Errorf("'%s' is not a deployment command", shellCommandLine(args))
+	}
+	flags := command.Flags()
+	err = command.ParseFlags(commandArgs)
+	if err != nil {
+		return fmt.Errorf("error in deployment command '%s': %s", shellCommandLine(args), err)
+	}
+	if len(flags.Args()) < 1 {
+		return fmt.Errorf("no version in deployment command '%s'", shellCommandLine(args))
+	}
+	download, _ := flags.GetBool(globals.DownloadLabel)
+	if download {
+		return nil
+	}
+	sandboxBinary, _ := flags.GetString(globals.SandboxBinaryLabel)
+	version := flags.Args()[0]
+	if common.DirExists(version) || common.DirExists(path.Join(sandboxBinary, version)) {
+		return nil
+	}
+	// Short versions (such as 8.0) use the latest matching version in sandbox-binary
+	if regexp.MustCompile(`^\d+\.\d+$`).MatchString(version) && !isVersionMissing(version, sandboxBinary) {
+		return nil
+	}
+	return fmt.Errorf("binaries for %s not found in %s", version, sandboxBinary)
+}
+
+func redeploySandbox(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exit(1, "sandbox name required",
+			"You can run 'dbdeployer sandboxes' for a list of available deployments")
+	}
+	dryRun, _ := cmd.Flags().GetBool(globals.DryRunLabel)
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxHomeLabel)
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxBinaryLabel)
+	sandboxName := args[0]
+	sandboxDir := path.Join(sandboxHome, sandboxName)
+	if !common.DirExists(sandboxDir) {
+		common.Exitf(1, globals.ErrDirectoryNotFound, sandboxDir)
+	}
+	if common.FileExists(path.Join(sandboxDir, globals.ScriptNoClear)) ||
+		common.FileExists(path.Join(sandboxDir, globals.ScriptNoClearAll)) {
+		common.Exitf(1, "sandbox %s is locked. Run 'dbdeployer admin unlock %s' first", sandboxName, sandboxName)
+	}
+	sbd, err := common.ReadSandboxDescription(sandboxDir)
+	common.ErrCheckExitf(err, 1, "error reading description of %s: %s", sandboxDir, err)
+	deployCommand, err := replayArgs(sandboxDir, sandboxBinary, sbd.SBType, sbd.Port, sbd.CommandArgs, sbd.CommandLine)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	err = checkReplayArgs(deployCommand)
+	common.ErrCheckExitf(err, 1, "can't redeploy %s: %s", sandboxName, err)
+	deleteCommand := append(configurationArgs(sandboxHome, sandboxBinary), "delete", sandboxName)
+	if dryRun {
+		fmt.Println(shellCommandLine(deleteCommand))
+		fmt.Println(shellCommandLine(deployCommand))
+		return
+	}
+	common.CondPrintf("# Removing %s\n", sandboxName)
+	err = runDbdeployer(deleteCommand)
+	common.ErrCheckExitf(err, 1, "error removing sandbox %s: %s", sandboxName, err)
+	common.CondPrintf("# Deploying %s\n", sandboxName)
+	err = runDbdeployer(deployCommand)
+	common.ErrCheckExitf(err, 1, "error deploying sandbox %s: %s", sandboxName, err)
+}
+
+// Returns the state of a past deployment compared with the catalog
+func historyItemState(item defaults.HistoryItem, catalog defaults.SandboxCatalog) string {
+	current, found := catalog[item.Sandbox]
+	if !found {
+		return "removed"
+	}
+	if current.Timestamp != item.Timestamp {
+		return "replaced"
+	}
+	return "deployed"
+}
+
+func replayDeployment(cmd *cobra.Command, item defaults.HistoryItem, number int) {
+	dryRun, _ := cmd.Flags().GetBool(globals.DryRunLabel)
+	if common.DirExists(item.Sandbox) {
+		common.Exitf(1, "sandbox %s already exists. Use 'dbdeployer redeploy %s' to deploy it again",
+			item.Sandbox, common.BaseName(item.Sandbox))
+	}
+	sandboxBinary, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
+	common.ErrCheckExitf(err, 1, "error finding absolute path for '%s'", globals.SandboxBinaryLabel)
+	deployCommand, err := replayArgs(item.Sandbox, sandboxBinary, item.SBType, item.Port, item.CommandArgs, item.CommandLine)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	err = checkReplayArgs(deployCommand)
+	common.ErrCheckExitf(err, 1, "can't replay deployment %d: %s", number, err)
+	if dryRun {
+		fmt.Println(shellCommandLine(deployCommand))
+		return
+	}
+	common.CondPrintf("# Replaying deployment %d (%s)\n", number, item.Sandbox)
+	err = runDbdeployer(deployCommand)
+	common.ErrCheckExitf(err, 1, "error deploying sandbox %s: %s", item.Sandbox, err)
+}
+
+func showHistory(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	full, _ := flags.GetBool(globals.FullLabel)
+	replay, _ := flags.GetInt(globals.ReplayLabel)
+	history, err := defaults.ReadHistory()
+	common.ErrCheckExitf(err, 1, "error reading deployment history: %s", err)
+	if replay != 0 {
+		if replay < 0 || replay > len(history) {
+			common.Exitf(1, "deployment %d not found. Available deployments: 1 to %d", replay, len(history))
+		}
+		replayDeployment(cmd, history[replay-1], replay)
+		return
+	}
+	if len(history) == 0 {
+		common.CondPrintf("No deployments recorded in %s\n", defaults.DeploymentHistoryFile())
+		return
+	}
+	catalog, err := defaults.ReadCatalog()
+	common.ErrCheckExitf(err, 1, "error reading sandbox catalog: %s", err)
+	for N, item := range history {
+		fmt.Printf("%3d  %-28s  %-40s %-12s %-10s %s\n", N+1, item.Timestamp,
+			common.ReplaceLiteralHome(item.Sandbox), item.SBType, item.Version, historyItemState(item, catalog))
+		if full {
+			fmt.Printf("     %s\n", item.CommandLine)
+		}
+	}
+}
+
+var redeployCmd = &cobra.Command{
+	Use:   "redeploy sandbox_name",
+	Short: "Removes a sandbox and deploys it again",
+	Long: `Removes a sandbox and deploys it again with the command that created it, in the same directory
+and with the same ports. Locked sandboxes are not redeployed.
+Only the command line is replayed: environment variables that affected the original deployment
+(such as DBDEPLOYER_PROFILE or SANDBOX_BINARY) take their current values.`,
+	Example: `
+	$ dbdeployer redeploy msb_8_0_16
+	$ dbdeployer redeploy rsandbox_8_0_16 --dry-run
+`,
+	Run: redeploySandbox,
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Shows past deployments",
+	Long: `Shows the deployments recorded in the history file of the log directory, and the ones in the
+catalog that are not in the history, with their state:
+  deployed   the sandbox is still the one that was deployed
+  replaced   the sandbox was deployed again after this deployment
+  removed    the sandbox no longer exists
+A past deployment can be replayed with --replay, using its number in the list,
+provided that its sandbox does not exist.`,
+	Example: `
+	$ dbdeployer history
+	$ dbdeployer history --full
+	$ dbdeployer history --replay=3
+`,
+	Run: showHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(redeployCmd)
+	rootCmd.AddCommand(historyCmd)
+	redeployCmd.Flags().BoolP(globals.DryRunLabel, "", false, "Shows the removal and deployment commands without running them")
+	historyCmd.Flags().BoolP(globals.FullLabel, "", false, "Shows the command line of each deployment")
+	historyCmd.Flags().IntP(globals.ReplayLabel, "", 0, "Deploys again the deployment with the given number")
+	historyCmd.Flags().BoolP(globals.DryRunLabel, "", false, "Shows the deployment command of --replay without running it")
+}
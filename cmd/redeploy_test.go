@@ -0,0 +1,135 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+func TestHasFlag(t *testing.T) {
+	args := []string{"deploy", "single", "8.0.16", "--port=8016", "--gtid", "--sandbox-binary", "/opt/mysql"}
+	var tests = map[string]bool{
+		"port":           true,
+		"gtid":           true,
+		"sandbox-binary": true,
+		"gtid-mode":      false,
+		"single":         false,
+		"por":            false,
+	}
+	for label, expected := range tests {
+		compare.OkEqualBool("has flag "+label, hasFlag(args, label), expected, t)
+	}
+}
+
+func TestRemoveFlag(t *testing.T) {
+	type removeTest struct {
+		args     []string
+		label    string
+		expected []string
+	}
+	var tests = []removeTest{
+		{[]string{"deploy", "single", "8.0.16"}, "port", []string{"deploy", "single", "8.0.16"}},
+		{[]string{"deploy", "--port=8016", "single", "8.0.16"}, "port", []string{"deploy", "single", "8.0.16"}},
+		{[]string{"deploy", "--port", "8016", "single", "8.0.16"}, "port", []string{"deploy", "single", "8.0.16"}},
+		{[]string{"--port=1", "deploy", "--port", "2"}, "port", []string{"deploy"}},
+		{[]string{"deploy", "--port-as-string=8016"}, "port", []string{"deploy", "--port-as-string=8016"}},
+	}
+	for _, test := range tests {
+		compare.OkEqualString(fmt.Sprintf("remove %s from %v", test.label, test.args),
+			strings.Join(removeFlag(test.args, test.label), " "), strings.Join(test.expected, " "), t)
+	}
+}
+
+func TestReplayArgs(t *testing.T) {
+	sandboxHome := "/home/user/sandboxes"
+	home := "--" + globals.SandboxHomeLabel + "=" + sandboxHome
+	binary := "--" + globals.SandboxBinaryLabel + "=/opt/mysql"
+	type replayTest struct {
+		label       string
+		sandboxDir  string
+		sbType      string
+		ports       []int
+		commandArgs []string
+		commandLine string
+		expected    string
+		wantedError string
+	}
+	var tests = []replayTest{
+		{"single", "msb_8_0_16", "single", []int{8016, 18016},
+			[]string{"dbdeployer", "deploy", "single", "8.0.16"}, "",
+			home + " " + binary + " deploy single 8.0.16 --sandbox-directory=msb_8_0_16 --port=8016", ""},
+		{"replication", "rsandbox_8_0_16", "master-slave", []int{20617, 20618, 20619},
+			[]string{"dbdeployer", "deploy", "replication", "8.0.16"}, "",
+			home + " " + binary + " deploy replication 8.0.16 --sandbox-directory=rsandbox_8_0_16 --base-port=20616", ""},
+		{"original sandbox-home is replaced", "msb_8_0_16", "single", []int{8016},
+			[]string{"dbdeployer", "--sandbox-home", "/tmp/other", "deploy", "single", "8.0.16"}, "",
+			home + " " + binary + " deploy single 8.0.16 --sandbox-directory=msb_8_0_16 --port=8016", ""},
+		{"original sandbox-binary and ports are kept", "custom", "single", []int{8016},
+			[]string{"dbdeployer", "--sandbox-binary=/opt/other", "deploy", "single", "8.0.16",
+				"--sandbox-directory=custom", "--port=9000", "--my-cnf-options=sql_mode=''"}, "",
+			home + " --sandbox-binary=/opt/other deploy single 8.0.16 --sandbox-directory=custom --port=9000 --my-cnf-options=sql_mode=''", ""},
+		{"legacy command line", "msb_8_0_16", "single", []int{8016},
+			nil, "dbdeployer deploy single 8.0.16 --my-cnf-options=sql_mode='a b'",
+			"", "older version of dbdeployer"},
+		{"nothing recorded", "msb_8_0_16", "single", []int{8016}, nil, "", "", "no deployment command"},
+		{"not a deployment", "msb_8_0_16", "single", []int{8016},
+			[]string{"dbdeployer", "sandboxes"}, "dbdeployer sandboxes", "", "not created by 'dbdeployer deploy'"},
+	}
+	for _, test := range tests {
+		args, err := replayArgs(path.Join(sandboxHome, test.sandboxDir), "/opt/mysql", test.sbType, test.ports,
+			test.commandArgs, test.commandLine)
+		okError(test.label, err, test.wantedError, t)
+		if test.wantedError == "" {
+			compare.OkEqualString(test.label, strings.Join(args, " "), test.expected, t)
+		}
+	}
+}
+
+func TestCheckReplayArgs(t *testing.T) {
+	sandboxBinary := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-replay-%d", os.Getpid()))
+	defer os.RemoveAll(sandboxBinary)
+	err := os.MkdirAll(path.Join(sandboxBinary, "8.0.16"), globals.PublicDirectoryAttr)
+	compare.OkIsNil("creating sandbox-binary", err, t)
+	binary := "--" + globals.SandboxBinaryLabel + "=" + sandboxBinary
+
+	type checkTest struct {
+		label       string
+		args        []string
+		wantedError string
+	}
+	var tests = []checkTest{
+		{"single", []string{binary, "deploy", "single", "8.0.16", "--port=8016"}, ""},
+		{"short version", []string{binary, "deploy", "replication", "8.0", "--base-port=20616"}, ""},
+		{"full path", []string{"deploy", "single", path.Join(sandboxBinary, "8.0.16")}, ""},
+		{"missing binaries", []string{binary, "deploy", "single", "5.7.25"}, "binaries for 5.7.25 not found"},
+		{"missing short version", []string{binary, "deploy", "single", "5.7"}, "binaries for 5.7 not found"},
+		{"unknown flag", []string{binary, "deploy", "single", "8.0.16", "--no-such-flag"}, "unknown flag"},
+		{"no version", []string{binary, "deploy", "single"}, "no version"},
+		{"not a deployment", []string{binary, "sandboxes"}, "not a deployment command"},
+		// Parsed flags keep their values in the following checks: this one must be the last
+		{"download", []string{binary, "deploy", "single", "5.7.25", "--download"}, ""},
+	}
+	for _, test := range tests {
+		okError(test.label, checkReplayArgs(test.args), test.wantedError, t)
+	}
+}
@@ -18,20 +18,115 @@ package cmd
 import (
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/rest"
 	"github.com/spf13/cobra"
+	"os"
+	"path"
 	"regexp"
+	"runtime"
+	"sort"
 )
 
 func listRemoteFiles(cmd *cobra.Command, args []string) {
+	refresh, _ := cmd.Flags().GetBool(globals.RefreshLabel)
+	indexes := rest.GetRepositoryIndexes(refresh)
+
+	var seen = make(map[string]bool)
+	var versionFiles = make(map[string][]string)
+	var versions []string
+	found := false
+	for N, ri := range indexes {
+		if ri.Err != nil {
+			fmt.Printf("# WARNING: error getting index from %s: %s\n", ri.Repository, ri.Err)
+			continue
+		}
+		found = true
+		if len(indexes) == 1 {
+			fmt.Printf("Files available in %s\n", rest.IndexUrlFor(ri.Repository))
+		} else {
+			fmt.Printf("(%d) files available in %s\n", N+1, rest.IndexUrlFor(ri.Repository))
+		}
+		for version, files := range ri.Index {
+			for _, rf := range files {
+				// Files listed by more than one repository are taken from the first one
+				if seen[rf.Name] {
+					continue
+				}
+				seen[rf.Name] = true
+				name := rf.Name
+				if len(indexes) > 1 {
+					name = fmt.Sprintf("%s(%d)", rf.Name, N+1)
+				}
+				if len(versionFiles[version]) == 0 {
+					versions = append(versions, version)
+				}
+				versionFiles[version] = append(versionFiles[version], name)
+			}
+		}
+	}
+	if !found {
+		common.Exit(1, "no remote index could be retrieved")
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		fmt.Printf("%s -> %+v\n", version, versionFiles[version])
+	}
+}
+
+// A version, such as 5.7 or 5.7.26, optionally prefixed by "mysql-"
+var reRemoteVersion = regexp.MustCompile(`^(?:mysql-)?(\d+\.\d+(?:\.\d+)?)$`)
+
+// Finds in the remote index the tarball for the current operating system that best
+// matches a version, which can be short (5.7) or complete (5.7.26).
+// Returns the file name and the full version
+func resolveRemoteTarball(version, flavor string) (string, string, error) {
 	index, err := rest.GetRemoteIndex()
-	common.ErrCheckExitf(err, 1, "error getting remote index: %s", err)
+	if err != nil {
+		return "", "", err
+	}
+	remoteFile, fullVersion, err := rest.FindRemoteTarball(index, version, flavor, runtime.GOOS)
+	if err != nil {
+		return "", "", err
+	}
+	fileName := remoteFile.Name
+	// Older indexes list names without extension
+	if !common.IsATarball(fileName) {
+		fileName += globals.TarXzExt
+	}
+	return fileName, fullVersion, nil
+}
+
+// Downloads the tarball for the given version and flavor from the remote repositories,
+// and expands it into the binary directory
+func downloadAndUnpack(version, flavor, basedir string) {
+	fileName, fullVersion, err := resolveRemoteTarball(version, flavor)
+	common.ErrCheckExitf(err, 1, "error finding a remote tarball for %s: %s", version, err)
+	common.CondPrintf("# %s => %s\n", version, fileName)
 
-	fmt.Printf("Files available in %s\n", rest.IndexUrl())
-	for k, v := range index {
-		fmt.Printf("%s -> %+v\n", k, v)
+	err = os.MkdirAll(basedir, globals.PublicDirectoryAttr)
+	common.ErrCheckExitf(err, 1, "error creating directory %s: %s", basedir, err)
+	err = os.MkdirAll(defaults.CacheDir, globals.PublicDirectoryAttr)
+	common.ErrCheckExitf(err, 1, "error creating cache directory %s: %s", defaults.CacheDir, err)
+	tarball := path.Join(defaults.CacheDir, fileName)
+	options := rest.DefaultDownloadOptions
+	options.Progress = os.Stdout
+	result, err := rest.DownloadFromRepositories(tarball, fileName, "", options)
+	common.ErrCheckExitf(err, 1, "error getting remote file %s - %s", fileName, err)
+	if result.Checksum == "" {
+		common.CondPrintf("# WARNING: checksum not available for %s\n", fileName)
 	}
+	unpackTarballFile(tarball, unpackOptions{
+		basedir:      basedir,
+		verbosity:    1,
+		versionCheck: globals.VersionCheckWarn,
+		version:      fullVersion,
+		flavor:       flavor,
+	})
+	// The expanded directory is all we need. If there is a failure before this point,
+	// the tarball stays in the cache, and the next download of the same file does not restart.
+	_ = os.Remove(tarball)
 }
 
 func downloadFile(cmd *cobra.Command, args []string) {
@@ -47,20 +142,28 @@ func downloadFile(cmd *cobra.Command, args []string) {
 		fileName = args[1]
 	}
 
+	if reRemoteVersion.MatchString(version) {
+		resolvedName, _, err := resolveRemoteTarball(reRemoteVersion.FindStringSubmatch(version)[1], common.MySQLFlavor)
+		if err == nil {
+			common.CondPrintf("# %s => %s\n", version, resolvedName)
+			version = resolvedName
+			if len(args) < 2 {
+				fileName = resolvedName
+			}
+		} else {
+			match, _ := regexp.MatchString(`^(?:mysql-)?\d+\.\d+$`, version)
+			if match {
+				common.Exitf(1, "%s", err)
+			}
+			version += globals.TarXzExt
+		}
+	}
+
 	absPath, err := common.AbsolutePath(fileName)
 	if err != nil {
 		common.Exitf(1, "%s", err)
 	}
-	match, err := regexp.MatchString(`^(?:mysql-)?\d+\.\d+$`, version)
-	if match {
-		common.Exitf(1, " short version detected (%s). The version should have 3 numbers (#.#.#)", version)
-	}
-	match, err = regexp.MatchString(`^(?:mysql-)?\d+\.\d+\.\d+$`, version)
-	if match {
-		version += globals.TarXzExt
-	}
-
-	match, err = regexp.MatchString(`\d+\.\d+\.\d+$`, absPath)
+	match, _ := regexp.MatchString(`\d+\.\d+\.\d+$`, absPath)
 	if match {
 		absPath += globals.TarXzExt
 	}
@@ -68,25 +171,51 @@ func downloadFile(cmd *cobra.Command, args []string) {
 	if common.FileExists(absPath) {
 		common.Exitf(1, globals.ErrFileAlreadyExists, absPath)
 	}
-	err = rest.DownloadFile(absPath, rest.FileUrl(version))
+	checksum, _ := cmd.Flags().GetString(globals.ChecksumLabel)
+	options := rest.DefaultDownloadOptions
+	options.Retries, _ = cmd.Flags().GetInt(globals.RetriesLabel)
+	options.Progress = os.Stdout
+	result, err := rest.DownloadFromRepositories(absPath, version, checksum, options)
 	common.ErrCheckExitf(err, 1, "error getting remote file %s - %s", version, err)
-	fmt.Printf("File %s downloaded\n", absPath)
+	if result.Checksum != "" {
+		fmt.Printf("File %s downloaded and verified\n", absPath)
+	} else {
+		fmt.Printf("File %s downloaded (checksum not verified)\n", absPath)
+	}
 }
 
 var remoteDownloadCmd = &cobra.Command{
-	Use:     "download version [file-name]",
+	Use:     "download {version|file-name} [local-file-name]",
 	Aliases: []string{"get"},
 	Short:   "download a remote tarball into a local file",
-	Long:    `If no file name is given, the file name will be mysql-<version>.tar.xz`,
-	Run:     downloadFile,
+	Long: `The first argument can be the name of a remote file, or a version such as 8.0 or 8.0.16.
+A version is resolved using the remote index, choosing the most recent MySQL tarball
+for the current operating system that matches it.
+If no local file name is given, the remote file name is used.
+The remote repositories are tried in order, until one of them provides the file.
+When the remote index lists a checksum for the file, or one is given with --checksum,
+the downloaded file is verified, and removed if it does not match.
+The data is first saved into <file-name>.partial. If the transfer is interrupted,
+running the same command again resumes the download from where it stopped.
+Failed transfers are retried (see --retries), waiting longer after each attempt.`,
+	Run: downloadFile,
 }
 
 var remoteListCmd = &cobra.Command{
 	Use:     "list [version]",
 	Aliases: []string{"index"},
 	Short:   "list remote tarballs",
-	Long:    ``,
-	Run:     listRemoteFiles,
+	Long: `Shows the files listed in the index of each remote repository.
+When several repositories are defined (default "remote-repositories"), each file is marked
+with the number of the repository it would be downloaded from.
+The remote indexes are cached in $HOME/.dbdeployer/cache for one hour.
+Use --refresh to download them again.`,
+	Example: `
+    $ dbdeployer defaults update remote-repositories \
+        http://mirror.example.com/dbdata,/mnt/nfs/dbdata,https://raw.githubusercontent.com/datacharmer/mysql-docker-minimal/master/dbdata
+    $ dbdeployer remote list --refresh
+`,
+	Run: listRemoteFiles,
 }
 
 var remoteCmd = &cobra.Command{
@@ -99,4 +228,8 @@ func init() {
 	rootCmd.AddCommand(remoteCmd)
 	remoteCmd.AddCommand(remoteDownloadCmd)
 	remoteCmd.AddCommand(remoteListCmd)
+
+	remoteDownloadCmd.Flags().String(globals.ChecksumLabel, "", "Expected checksum of the file (e.g. sha256:abcd...)")
+	remoteDownloadCmd.Flags().Int(globals.RetriesLabel, rest.DefaultDownloadOptions.Retries, "How many times a failed download is attempted again")
+	remoteListCmd.Flags().Bool(globals.RefreshLabel, false, "Download the remote index even if a cached copy exists")
 }
@@ -69,23 +69,73 @@ func setPflag(cmd *cobra.Command, key string, abbr string, envVar string, defaul
 func checkDefaultsFile() {
 	flags := rootCmd.Flags()
 	defaults.CustomConfigurationFile, _ = flags.GetString(globals.ConfigLabel)
+	defaults.ActiveProfile, _ = flags.GetString(globals.ProfileLabel)
 	if defaults.CustomConfigurationFile != defaults.ConfigurationFile {
+		if defaults.ActiveProfile != "" {
+			common.Exitf(1, "options --%s and --%s can't be used together", globals.ConfigLabel, globals.ProfileLabel)
+		}
 		if common.FileExists(defaults.CustomConfigurationFile) {
 			defaults.ConfigurationFile = defaults.CustomConfigurationFile
 		} else {
 			common.Exitf(1, globals.ErrFileNotFound, defaults.CustomConfigurationFile)
 		}
 	}
+	// The profile commands can run when the active profile does not exist yet
+	if defaults.ActiveProfile != "" && !defaults.ProfileExists(defaults.ActiveProfile) && runningProfileCommand() {
+		defaults.ActiveProfile = ""
+	}
 	defaults.LoadConfiguration()
+	refreshFlagDefault(rootCmd, globals.SandboxHomeLabel, "SANDBOX_HOME", defaults.Defaults().SandboxHome)
+	refreshFlagDefault(rootCmd, globals.SandboxBinaryLabel, "SANDBOX_BINARY", defaults.Defaults().SandboxBinary)
+	refreshFlagDefault(deployCmd, globals.LogLogDirectoryLabel, "", defaults.Defaults().LogDirectory)
 	loadTemplates()
+	loadFlavors()
+}
+
+// Flags that get their default from dbdeployer defaults are defined before the configuration
+// file or the profile are loaded. Unless the user set them, they receive the loaded value
+func refreshFlagDefault(cmd *cobra.Command, label, envVar, value string) {
+	flag := cmd.PersistentFlags().Lookup(label)
+	if flag == nil || flag.Changed || (envVar != "" && os.Getenv(envVar) != "") {
+		return
+	}
+	err := flag.Value.Set(value)
+	common.ErrCheckExitf(err, 1, "error setting default for --%s: %s", label, err)
+	flag.DefValue = value
+}
+
+// Returns the defaults for the flags that are defined before the configuration file or the profile are loaded.
+// A profile that can't be read is reported by checkDefaultsFile, which also refreshes the flags
+func initialDefaults() defaults.DbdeployerDefaults {
+	current, err := defaults.LoadDefaults()
+	if err != nil {
+		return defaults.FactoryDefaults()
+	}
+	return current
+}
+
+// Returns true if the command being run is one of "defaults profile"
+func runningProfileCommand() bool {
+	command, _, err := rootCmd.Find(os.Args[1:])
+	return err == nil && command.Parent() == defaultsProfileCmd
+}
+
+// Adds the custom flavors, if any, to the built-in ones
+func loadFlavors() {
+	if !common.FileExists(defaults.FlavorsFile) {
+		return
+	}
+	err := common.LoadFlavors(defaults.FlavorsFile)
+	common.ErrCheckExitf(err, 1, "error loading flavors from %s: %s", defaults.FlavorsFile, err)
 }
 
 func init() {
 	cobra.OnInitialize(checkDefaultsFile)
 	// spew.Dump(rootCmd)
 	rootCmd.PersistentFlags().StringVar(&defaults.CustomConfigurationFile, globals.ConfigLabel, defaults.ConfigurationFile, "configuration file")
-	setPflag(rootCmd, globals.SandboxHomeLabel, "", "SANDBOX_HOME", defaults.Defaults().SandboxHome, "Sandbox deployment directory", false)
-	setPflag(rootCmd, globals.SandboxBinaryLabel, "", "SANDBOX_BINARY", defaults.Defaults().SandboxBinary, "Binary repository", false)
+	setPflag(rootCmd, globals.ProfileLabel, "", "DBDEPLOYER_PROFILE", "", "Profile of defaults to use (see 'dbdeployer defaults profile')", false)
+	setPflag(rootCmd, globals.SandboxHomeLabel, "", "SANDBOX_HOME", initialDefaults().SandboxHome, "Sandbox deployment directory", false)
+	setPflag(rootCmd, globals.SandboxBinaryLabel, "", "SANDBOX_BINARY", initialDefaults().SandboxBinary, "Binary repository", false)
 
 	rootCmd.InitDefaultVersionFlag()
 
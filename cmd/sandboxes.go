@@ -16,9 +16,11 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/datacharmer/dbdeployer/globals"
 	"path"
+	"sort"
 
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
@@ -26,28 +28,120 @@ import (
 	"strings"
 )
 
-func showSandboxesFromCatalog(currentSandboxHome string, header bool) {
+// Format of the status columns, shared by headers and rows
+const statusTemplate = "%-8s %8s %8s"
+
+// Returns the runtime state, uptime and data size of a sandbox as a text column
+func sandboxStatusText(sandboxDir string, ping bool) string {
+	status, err := common.GetSandboxStatus(sandboxDir, ping)
+	if err != nil {
+		return fmt.Sprintf(statusTemplate, "unknown", "-", "-")
+	}
+	return fmt.Sprintf(statusTemplate, status.State, common.HumanDuration(status.Uptime), common.HumanSize(status.DataSize))
+}
+
+// A sandbox with its runtime status, as shown by 'sandboxes --json'
+type sandboxStatusEntry struct {
+	Name    string                `json:"name"`
+	Dir     string                `json:"dir"`
+	Type    string                `json:"type"`
+	Version string                `json:"version"`
+	Ports   []int                 `json:"ports"`
+	Locked  bool                  `json:"locked"`
+	Status  *common.SandboxStatus `json:"status,omitempty"`
+	Error   string                `json:"error,omitempty"`
+}
+
+func newSandboxStatusEntry(sandboxDir, sbType, version string, ports []int, ping bool) sandboxStatusEntry {
+	entry := sandboxStatusEntry{
+		Name:    common.BaseName(sandboxDir),
+		Dir:     sandboxDir,
+		Type:    sbType,
+		Version: version,
+		Ports:   ports,
+		Locked: common.FileExists(path.Join(sandboxDir, globals.ScriptNoClear)) ||
+			common.FileExists(path.Join(sandboxDir, globals.ScriptNoClearAll)),
+	}
+	status, err := common.GetSandboxStatus(sandboxDir, ping)
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Status = &status
+	}
+	return entry
+}
+
+// Shows the sandboxes and their runtime status as a JSON list
+func showSandboxesJson(sandboxHome string, readCatalog, ping bool) {
+	entries := []sandboxStatusEntry{}
+	if readCatalog {
+		sandboxList, err := defaults.ReadCatalog()
+		common.ErrCheckExitf(err, 1, "error getting sandboxes from catalog: %s", err)
+		var names []string
+		for name := range sandboxList {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			item := sandboxList[name]
+			entries = append(entries, newSandboxStatusEntry(name, item.SBType, item.Version, item.Port, ping))
+		}
+	} else if common.DirExists(sandboxHome) {
+		sandboxList, err := common.GetInstalledSandboxes(sandboxHome)
+		common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
+		for _, sandboxInfo := range sandboxList {
+			sandboxDir := path.Join(sandboxHome, sandboxInfo.SandboxName)
+			var sbd common.SandboxDescription
+			if common.FileExists(path.Join(sandboxDir, globals.SandboxDescriptionName)) {
+				sbd, err = common.ReadSandboxDescription(sandboxDir)
+				common.ErrCheckExitf(err, 1, "error reading sandbox description from %s", sandboxDir)
+			}
+			entries = append(entries, newSandboxStatusEntry(sandboxDir, sbd.SBType, sbd.Version, sbd.Port, ping))
+		}
+	}
+	text, err := json.MarshalIndent(entries, "", "  ")
+	common.ErrCheckExitf(err, 1, "error encoding sandbox list: %s", err)
+	fmt.Println(string(text))
+}
+
+func showSandboxesFromCatalog(currentSandboxHome string, header, withStatus, ping bool) {
 	sandboxList, err := defaults.ReadCatalog()
 	common.ErrCheckExitf(err, 1, "error getting sandboxes from catalog: %s", err)
 	if len(sandboxList) == 0 {
 		return
 	}
-	template := "%-25s %-10s %-20s %5v %-25s %s \n"
-	if header {
-		fmt.Printf(template, "name", "version", "type", "nodes", "ports", "")
-		fmt.Printf(template, "----", "-------", "-----", "-----", "-----", "")
-	}
+	portsList := make(map[string]string)
+	portsWidth := 25
 	for name, contents := range sandboxList {
 		ports := "["
 		for _, p := range contents.Port {
 			ports += fmt.Sprintf("%d ", p)
 		}
 		ports += "]"
+		portsList[name] = ports
+		if len(ports) > portsWidth {
+			portsWidth = len(ports)
+		}
+	}
+	template := "%-25s %-10s %-20s %5v %-*s %s \n"
+	if header {
+		if withStatus {
+			fmt.Printf(template, "name", "version", "type", "nodes", portsWidth, "ports", fmt.Sprintf(statusTemplate, "status", "uptime", "size"))
+			fmt.Printf(template, "----", "-------", "-----", "-----", portsWidth, "-----", fmt.Sprintf(statusTemplate, "------", "------", "----"))
+		} else {
+			fmt.Printf(template, "name", "version", "type", "nodes", portsWidth, "ports", "")
+			fmt.Printf(template, "----", "-------", "-----", "-----", portsWidth, "-----", "")
+		}
+	}
+	for name, contents := range sandboxList {
 		extra := ""
 		if !strings.HasPrefix(contents.Destination, currentSandboxHome) {
 			extra = "(" + common.DirName(contents.Destination) + ")"
 		}
-		fmt.Printf(template, common.BaseName(name), contents.Version, contents.SBType, len(contents.Nodes), ports, extra)
+		if withStatus {
+			extra = strings.TrimSpace(sandboxStatusText(contents.Destination, ping) + " " + extra)
+		}
+		fmt.Printf(template, common.BaseName(name), contents.Version, contents.SBType, len(contents.Nodes), portsWidth, portsList[name], extra)
 	}
 }
 
@@ -57,8 +151,18 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 	SandboxHome, _ := flags.GetString(globals.SandboxHomeLabel)
 	readCatalog, _ := flags.GetBool(globals.CatalogLabel)
 	useHeader, _ := flags.GetBool(globals.HeaderLabel)
+	withStatus, _ := flags.GetBool(globals.StatusLabel)
+	ping, _ := flags.GetBool(globals.PingLabel)
+	asJson, _ := flags.GetBool(globals.JsonLabel)
+	if ping {
+		withStatus = true
+	}
+	if asJson {
+		showSandboxesJson(SandboxHome, readCatalog, ping)
+		return
+	}
 	if readCatalog {
-		showSandboxesFromCatalog(SandboxHome, useHeader)
+		showSandboxesFromCatalog(SandboxHome, useHeader, withStatus, ping)
 		return
 	}
 	var sandboxList []common.SandboxInfo
@@ -68,10 +172,26 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 		sandboxList, err = common.GetInstalledSandboxes(SandboxHome)
 		common.ErrCheckExitf(err, 1, globals.ErrRetrievingSandboxList, err)
 	}
-	var dirs []string
+	// Sandboxes with a description are shown in columns. Sandboxes deployed by old versions,
+	// which have no description, are shown as a line of text
+	type sandboxRow struct {
+		name    string
+		sbType  string
+		version string
+		ports   string
+		status  string
+		locked  string
+		text    string
+	}
+	var rows []sandboxRow
+	portsWidth := len("ports")
 	for _, sandboxInfo := range sandboxList {
 		fileName := sandboxInfo.SandboxName
 		description := "single"
+		status := ""
+		if withStatus {
+			status = sandboxStatusText(path.Join(SandboxHome, fileName), ping)
+		}
 		sbDesc := path.Join(SandboxHome, fileName, globals.SandboxDescriptionName)
 		if common.FileExists(sbDesc) {
 			sbd, err := common.ReadSandboxDescription(path.Join(SandboxHome, fileName))
@@ -80,15 +200,14 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			if sandboxInfo.Locked {
 				locked = "(LOCKED)"
 			}
+			ports := ""
 			if sbd.Nodes == 0 {
-				portText := ""
 				for _, p := range sbd.Port {
-					if portText != "" {
-						portText += " "
+					if ports != "" {
+						ports += " "
 					}
-					portText += fmt.Sprintf("%d", p)
+					ports += fmt.Sprintf("%d", p)
 				}
-				description = fmt.Sprintf("%-20s %10s [%s]", sbd.SBType, sbd.Version, portText)
 			} else {
 				var nodeDescriptions []common.SandboxDescription
 				innerSandboxList, err := common.GetInstalledSandboxes(path.Join(SandboxHome, fileName))
@@ -102,7 +221,6 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 						nodeDescriptions = append(nodeDescriptions, sbNode)
 					}
 				}
-				ports := ""
 				for _, nd := range nodeDescriptions {
 					for _, p := range nd.Port {
 						if ports != "" {
@@ -111,10 +229,19 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 						ports += fmt.Sprintf("%d", p)
 					}
 				}
-				//ports += " ]"
-				description = fmt.Sprintf("%-20s %10s [%s]", sbd.SBType, sbd.Version, ports)
 			}
-			dirs = append(dirs, fmt.Sprintf("%-25s : %s %s", fileName, description, locked))
+			ports = "[" + ports + "]"
+			if len(ports) > portsWidth {
+				portsWidth = len(ports)
+			}
+			rows = append(rows, sandboxRow{
+				name:    fileName,
+				sbType:  sbd.SBType,
+				version: sbd.Version,
+				ports:   ports,
+				status:  status,
+				locked:  locked,
+			})
 		} else {
 			locked := ""
 			noClear := path.Join(SandboxHome, fileName, globals.ScriptNoClear)
@@ -135,21 +262,41 @@ func showSandboxes(cmd *cobra.Command, args []string) {
 			if common.FileExists(initializeNodes) {
 				description = "group replication"
 			}
+			if status != "" {
+				description += " " + status
+			}
 			if common.FileExists(start) || common.FileExists(startAll) {
-				dirs = append(dirs, fmt.Sprintf("%-20s : *%s* %s ", fileName, description, locked))
+				rows = append(rows, sandboxRow{text: fmt.Sprintf("%-20s : *%s* %s ", fileName, description, locked)})
 			}
 		}
 	}
+	// The header and the rows use the same format:
+	// name, separator, type, version, ports, [status uptime size,] locked
+	template := "%-25s %s %-20s %10s %-*s"
+	if withStatus {
+		template += " %s"
+	}
+	template += " %s"
+	formatRow := func(name, separator, sbType, version, ports, status, locked string) string {
+		args := []interface{}{name, separator, sbType, version, portsWidth, ports}
+		if withStatus {
+			args = append(args, status)
+		}
+		args = append(args, locked)
+		return strings.TrimRight(fmt.Sprintf(template, args...), " ")
+	}
 	if useHeader {
-		//           1         2         3         4         5         6         7
-		//  12345678901234567890123456789012345678901234567890123456789012345678901234567890
-		//	fan_in_msb_5_7_21         : fan-in                   5.7.21 [14001 14002 14003]
-		template := "%-25s   %-23s %-8s %s\n"
-		fmt.Printf(template, "name", "type", "version", "ports")
-		fmt.Printf(template, "----------------", "-------", "-------", "-----")
+		fmt.Println(formatRow("name", " ", "type", "version", "ports",
+			fmt.Sprintf(statusTemplate, "status", "uptime", "size"), ""))
+		fmt.Println(formatRow("----------------", " ", "-------", "-------", "-----",
+			fmt.Sprintf(statusTemplate, "------", "------", "----"), ""))
 	}
-	for _, dir := range dirs {
-		fmt.Println(dir)
+	for _, row := range rows {
+		if row.text != "" {
+			fmt.Println(row.text)
+			continue
+		}
+		fmt.Println(formatRow(row.name, ":", row.sbType, row.version, row.ports, row.status, row.locked))
 	}
 }
 
@@ -161,6 +308,18 @@ If sandboxes are installed in a different location, use --sandbox-home to
 indicate where to look.
 Alternatively, using --catalog will list all sandboxes, regardless of where 
 they were deployed.
+With --status, each sandbox also shows whether it is running, stopped, or 
+partially running (some nodes up, some down), its uptime and the size of its 
+data directories. The state is detected through the pid file and socket of 
+each node. Using --ping also checks that every node port accepts connections.
+With --json, the sandboxes and the status of each node are shown as a JSON list
+(uptimes are in nanoseconds, data sizes in bytes).
+`,
+	Example: `
+	$ dbdeployer sandboxes --status
+	$ dbdeployer sandboxes --catalog --header --status
+	$ dbdeployer sandboxes --status --ping
+	$ dbdeployer sandboxes --json
 `,
 	Aliases: []string{"installed", "deployed"},
 	Run:     showSandboxes,
@@ -171,4 +330,7 @@ func init() {
 
 	sandboxesCmd.Flags().BoolP(globals.CatalogLabel, "", false, "Use sandboxes catalog instead of scanning directory")
 	sandboxesCmd.Flags().BoolP(globals.HeaderLabel, "", false, "Shows header with catalog output")
+	sandboxesCmd.Flags().BoolP(globals.StatusLabel, "", false, "Shows runtime status, uptime and data size of each sandbox")
+	sandboxesCmd.Flags().BoolP(globals.PingLabel, "", false, "Checks also that each node port accepts connections (implies --status)")
+	sandboxesCmd.Flags().BoolP(globals.JsonLabel, "", false, "Shows sandboxes and their runtime status in JSON format")
 }
@@ -24,6 +24,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/datacharmer/dbdeployer/api"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/sandbox"
@@ -44,13 +45,33 @@ func replaceTemplate(templateName string, fileName string) {
 		common.Exitf(1, "file %s is empty\n", fileName)
 	}
 	var newRec = sandbox.TemplateDesc{
-		Description: sandbox.AllTemplates[group][templateName].Description,
-		Notes:       sandbox.AllTemplates[group][templateName].Notes,
-		Contents:    newContents,
+		Description:       sandbox.AllTemplates[group][templateName].Description,
+		Notes:             sandbox.AllTemplates[group][templateName].Notes,
+		Contents:          newContents,
+		Variables:         sandbox.AllTemplates[group][templateName].Variables,
+		OptionalVariables: sandbox.AllTemplates[group][templateName].OptionalVariables,
 	}
 	sandbox.AllTemplates[group][templateName] = newRec
 }
 
+// Makes sure that the requested sets of extra scripts exist for some sandbox type
+func checkExtraScriptSets(requested []string) error {
+	available, err := sandbox.ExtraScriptSets()
+	if err != nil {
+		return err
+	}
+	var found = make(map[string]bool)
+	for _, set := range available {
+		found[set] = true
+	}
+	for _, set := range requested {
+		if set != "" && !found[set] {
+			return fmt.Errorf("set of extra scripts '%s' not found in %s", set, defaults.ExtraScriptsDir)
+		}
+	}
+	return nil
+}
+
 func checkTemplateChangeRequest(request string) (templateName, fileName string) {
 	re := regexp.MustCompile(`(\w+):(\S+)`)
 	reqList := re.FindAllStringSubmatch(request, -1)
@@ -104,6 +125,22 @@ func checkIfAbridgedVersion(version, basedir string) string {
 	return version
 }
 
+// Returns true if the argument is a version (such as 8.0 or 8.0.16)
+// for which there is no expanded tarball in basedir
+func isVersionMissing(version, basedir string) bool {
+	validPattern := regexp.MustCompile(`^\d+\.\d+(?:\.\d+)?$`)
+	if !validPattern.MatchString(version) {
+		return false
+	}
+	if !common.DirExists(basedir) {
+		return true
+	}
+	if common.IsVersion(version) {
+		return !common.DirExists(path.Join(basedir, version))
+	}
+	return common.LatestVersion(basedir, version) == ""
+}
+
 func checkForRootValue(value, label, defaultVal string) {
 	if value == "root" {
 		common.Exit(1, fmt.Sprintf("option --%s cannot be 'root'", label),
@@ -113,39 +150,6 @@ func checkForRootValue(value, label, defaultVal string) {
 	}
 }
 
-// Gets the Database flavor
-// If none is found, defaults to MySQL
-func getFlavor(userDefinedFlavor, basedir string) string {
-	flavorOrigin := ""
-	flavor := userDefinedFlavor
-	if userDefinedFlavor != "" {
-		flavorOrigin = "flag"
-	}
-	flavorFile := path.Join(basedir, globals.FlavorFileName)
-	if common.FileExists(flavorFile) {
-		flavorText, err := common.SlurpAsString(flavorFile)
-		if err != nil {
-			common.Exitf(1, "error reading flavor file %s: %s", flavorFile, err)
-		}
-		flavorText = strings.TrimSpace(flavorText)
-		if userDefinedFlavor != "" && userDefinedFlavor != flavorText {
-			common.Exitf(1, "user defined flavor %s doesn't match found flavor %s", userDefinedFlavor, flavorText)
-		}
-		flavor = flavorText
-		flavorOrigin = "FLAVOR file"
-	}
-	// Flavor detection based on tarball contents
-	if flavor == "" {
-		flavor = common.DetectBinaryFlavor(basedir)
-		flavorOrigin = "Binary examination"
-	}
-	err := common.CheckFlavorSupport(flavor)
-	if err != nil {
-		common.Exitf(1, "flavor detected from %s unsupported: %s", flavorOrigin, err)
-	}
-	return flavor
-}
-
 func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxDef, error) {
 	var sd sandbox.SandboxDef
 
@@ -168,6 +172,17 @@ func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxD
 		replaceTemplate(tname, fname)
 	}
 
+	sd.ExtraScripts, _ = flags.GetStringSlice(globals.ExtraScriptsLabel)
+	err = checkExtraScriptSets(sd.ExtraScripts)
+	if err != nil {
+		return sd, err
+	}
+	hookDefinitions, _ := flags.GetStringSlice(globals.HookLabel)
+	sd.Hooks, err = sandbox.ParseHooks(append(defaults.Defaults().Hooks, hookDefinitions...))
+	if err != nil {
+		return sd, err
+	}
+
 	basedir, err := getAbsolutePathFromFlag(cmd, globals.SandboxBinaryLabel)
 	if err != nil {
 		return sd, err
@@ -179,6 +194,14 @@ func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxD
 	versionFromOption := false
 	sd.Version, _ = flags.GetString(globals.BinaryVersionLabel)
 	if sd.Version == "" {
+		download, _ := flags.GetBool(globals.DownloadLabel)
+		if download && isVersionMissing(args[0], basedir) {
+			flavor, _ := flags.GetString(globals.FlavorLabel)
+			if flavor == "" {
+				flavor = common.MySQLFlavor
+			}
+			downloadAndUnpack(args[0], flavor, basedir)
+		}
 		sd.Version = args[0]
 		oldVersion := sd.Version
 		sd.Version = checkIfAbridgedVersion(sd.Version, basedir)
@@ -219,24 +242,45 @@ func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxD
 		// common.CondPrintf("NEW bd <%s> - v: <%s>\n",basedir, sd.Version )
 	}
 
-	sd.Port, err = common.VersionToPort(sd.Version)
-	common.ErrCheckExitf(err, 1, "can't convert '%s' into port number", sd.Version)
-	if sd.Port < 0 {
-		common.Exitf(1, "unsupported version format (%s)", sd.Version)
-	}
-	sd.UserPort, _ = flags.GetInt(globals.PortLabel)
-	sd.BasePort, _ = flags.GetInt(globals.BasePortLabel)
-	sd.DirName, _ = flags.GetString(globals.SandboxDirectoryLabel)
-
-	if sd.UserPort > 0 {
-		sd.Port = sd.UserPort
+	sandboxHome, err := getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
+	if err != nil {
+		return sd, err
 	}
-
-	sd.Basedir = path.Join(basedir, sd.BasedirName)
-	// sd.Basedir = path.Join(basedir, args[0])
-	if !common.DirExists(sd.Basedir) {
-		common.Exitf(1, "basedir '%s' not found", sd.Basedir)
+	var options = api.DeployOptions{
+		Version:       sd.Version,
+		BasedirName:   sd.BasedirName,
+		SandboxHome:   sandboxHome,
+		SandboxBinary: basedir,
+	}
+	options.Flavor, _ = flags.GetString(globals.FlavorLabel)
+	options.SandboxDirectory, _ = flags.GetString(globals.SandboxDirectoryLabel)
+	options.Port, _ = flags.GetInt(globals.PortLabel)
+	options.BasePort, _ = flags.GetInt(globals.BasePortLabel)
+	options.DbUser, _ = flags.GetString(globals.DbUserLabel)
+	options.DbPassword, _ = flags.GetString(globals.DbPasswordLabel)
+	options.RplUser, _ = flags.GetString(globals.RplUserLabel)
+	options.RplPassword, _ = flags.GetString(globals.RplPasswordLabel)
+	options.RemoteAccess, _ = flags.GetString(globals.RemoteAccessLabel)
+	options.BindAddress, _ = flags.GetString(globals.BindAddressLabel)
+	options.MyCnfOptions, _ = flags.GetStringSlice(globals.MyCnfOptionsLabel)
+	options.InitOptions, _ = flags.GetStringSlice(globals.InitOptionsLabel)
+	options.PreGrantsSql, _ = flags.GetStringSlice(globals.PreGrantsSqlLabel)
+	options.PostGrantsSql, _ = flags.GetStringSlice(globals.PostGrantsSqlLabel)
+	options.Gtid, _ = flags.GetBool(globals.GtidLabel)
+	options.SkipStart, _ = flags.GetBool(globals.SkipStartLabel)
+	options.Force, _ = flags.GetBool(globals.ForceLabel)
+	checkForRootValue(options.DbUser, globals.DbUserLabel, globals.DbUserValue)
+	checkForRootValue(options.RplUser, globals.RplUserLabel, globals.RplUserValue)
+
+	// The definition is filled by the same function used by the API,
+	// and completed with the options that only the command line has
+	definition, err := api.SandboxDefinition(options)
+	if err != nil {
+		return sd, err
 	}
+	definition.ExtraScripts = sd.ExtraScripts
+	definition.Hooks = sd.Hooks
+	sd = definition
 
 	sd.ClientBasedir, _ = flags.GetString(globals.ClientFromLabel)
 	if sd.ClientBasedir != "" {
@@ -246,30 +290,8 @@ func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxD
 		}
 		sd.ClientBasedir = clientBasedir
 	}
-	err = common.CheckTarballOperatingSystem(sd.Basedir)
-	common.ErrCheckExitf(err, 1, "incorrect tarball detected")
-
-	sd.SandboxDir, err = getAbsolutePathFromFlag(cmd, globals.SandboxHomeLabel)
-	if err != nil {
-		return sd, err
-	}
-
-	err = common.CheckSandboxDir(sd.SandboxDir)
-	if err != nil {
-		return sd, err
-	}
-	sd.InstalledPorts, err = common.GetInstalledPorts(sd.SandboxDir)
-	if err != nil {
-		return sd, err
-	}
-
-	for _, p := range defaults.Defaults().ReservedPorts {
-		sd.InstalledPorts = append(sd.InstalledPorts, p)
-	}
-	sd.LoadGrants = true
-	sd.SkipStart, _ = flags.GetBool(globals.SkipStartLabel)
 	skipLoadGrants, _ := flags.GetBool(globals.SkipLoadGrantsLabel)
-	if skipLoadGrants || sd.SkipStart {
+	if skipLoadGrants {
 		sd.LoadGrants = false
 	}
 	sd.SlavesReadOnly, _ = flags.GetBool(globals.ReadOnlyLabel)
@@ -279,29 +301,12 @@ func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxD
 	sd.DisableMysqlX, _ = flags.GetBool(globals.DisableMysqlXLabel)
 	sd.EnableMysqlX, _ = flags.GetBool(globals.EnableMysqlXLabel)
 	sd.HistoryDir, _ = flags.GetString(globals.HistoryDirLabel)
-	sd.DbUser, _ = flags.GetString(globals.DbUserLabel)
-	sd.DbPassword, _ = flags.GetString(globals.DbPasswordLabel)
-	sd.RplUser, _ = flags.GetString(globals.RplUserLabel)
-	sd.Flavor, _ = flags.GetString(globals.FlavorLabel)
-
-	sd.Flavor = getFlavor(sd.Flavor, sd.Basedir)
-	checkForRootValue(sd.DbUser, globals.DbUserLabel, globals.DbUserValue)
-	checkForRootValue(sd.RplUser, globals.RplUserLabel, globals.RplUserValue)
-
-	sd.RplPassword, _ = flags.GetString(globals.RplPasswordLabel)
-	sd.RemoteAccess, _ = flags.GetString(globals.RemoteAccessLabel)
-	sd.BindAddress, _ = flags.GetString(globals.BindAddressLabel)
 	sd.CustomMysqld, _ = flags.GetString(globals.CustomMysqldLabel)
-	sd.InitOptions, _ = flags.GetStringSlice(globals.InitOptionsLabel)
-	sd.MyCnfOptions, _ = flags.GetStringSlice(globals.MyCnfOptionsLabel)
 	sd.PreGrantsSqlFile, _ = flags.GetString(globals.PreGrantsSqlFileLabel)
-	sd.PreGrantsSql, _ = flags.GetStringSlice(globals.PreGrantsSqlLabel)
-	sd.PostGrantsSql, _ = flags.GetStringSlice(globals.PostGrantsSqlLabel)
 	sd.PostGrantsSqlFile, _ = flags.GetString(globals.PostGrantsSqlFileLabel)
 	sd.MyCnfFile, _ = flags.GetString(globals.MyCnfFileLabel)
 	sd.NativeAuthPlugin, _ = flags.GetBool(globals.NativeAuthPluginLabel)
 	sd.KeepUuid, _ = flags.GetBool(globals.KeepServerUuidLabel)
-	sd.Force, _ = flags.GetBool(globals.ForceLabel)
 	sd.ExposeDdTables, _ = flags.GetBool(globals.ExposeDdTablesLabel)
 	sd.InitGeneralLog, _ = flags.GetBool(globals.InitGeneralLogLabel)
 	sd.EnableGeneralLog, _ = flags.GetBool(globals.EnableGeneralLogLabel)
@@ -317,38 +322,14 @@ func fillSandboxDdefinition(cmd *cobra.Command, args []string) (sandbox.SandboxD
 	newDefaults, _ := flags.GetStringSlice(globals.DefaultsLabel)
 	processDefaults(newDefaults)
 
-	var gtid bool
 	var master bool
 	var replCrashSafe bool
 	master, _ = flags.GetBool(globals.MasterLabel)
-	gtid, _ = flags.GetBool(globals.GtidLabel)
 	replCrashSafe, _ = flags.GetBool(globals.ReplCrashSafeLabel)
 	if master {
 		sd.ReplOptions = sandbox.SingleTemplates["replication_options"].Contents
 		sd.ServerId = sd.Port
 	}
-	if gtid {
-		templateName := "gtid_options_56"
-		// 5.7.0
-		// isEnhancedGtid, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumEnhancedGtidVersion)
-		isEnhancedGtid, err := common.HasCapability(sd.Flavor, common.EnhancedGTID, sd.Version)
-		common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
-		if isEnhancedGtid {
-			templateName = "gtid_options_57"
-		}
-		// 5.6.9
-		//isMinimumGtid, err := common.GreaterOrEqualVersion(sd.Version, globals.MinimumGtidVersion)
-		isMinimumGtid, err := common.HasCapability(sd.Flavor, common.GTID, sd.Version)
-		common.ErrCheckExitf(err, 1, globals.ErrWhileComparingVersions)
-		if isMinimumGtid {
-			sd.GtidOptions = sandbox.SingleTemplates[templateName].Contents
-			sd.ReplCrashSafeOptions = sandbox.SingleTemplates["repl_crash_safe_options"].Contents
-			sd.ReplOptions = sandbox.SingleTemplates["replication_options"].Contents
-			sd.ServerId = sd.Port
-		} else {
-			common.Exitf(1, globals.ErrOptionRequiresVersion, globals.GtidLabel, common.IntSliceToDottedString(globals.MinimumGtidVersion))
-		}
-	}
 	if replCrashSafe && sd.ReplCrashSafeOptions == "" {
 		// 5.6.2
 
@@ -22,8 +22,11 @@ import (
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/datacharmer/dbdeployer/sandbox"
 	"github.com/spf13/cobra"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -93,6 +96,14 @@ func listTemplates(cmd *cobra.Command, args []string) {
 	}
 	flags := cmd.Flags()
 	simpleList, _ := flags.GetBool(globals.SimpleLabel)
+	version, _ := flags.GetString(globals.VersionLabel)
+	flavor, _ := flags.GetString(globals.FlavorLabel)
+	if version != "" && flavor == "" {
+		flavor = common.MySQLFlavor
+	}
+	if version != "" && !common.IsVersion(version) {
+		common.Exitf(1, "version '%s' should have 3 numbers (#.#.#)", version)
+	}
 
 	templates := getTemplatesList(wanted)
 	for _, template := range templates {
@@ -100,10 +111,22 @@ func listTemplates(cmd *cobra.Command, args []string) {
 		if template.TemplateInFile {
 			origin = "{F}"
 		}
+		name := template.Name
+		if version != "" {
+			// Shows only the generic names, with the variant that would be used
+			if strings.Contains(name, sandbox.TemplateVariantSeparator) {
+				continue
+			}
+			winner := sandbox.ResolveTemplateName(sandbox.AllTemplates[template.Group], name, flavor, version)
+			if winner != name {
+				name = fmt.Sprintf("%s => %s", name, winner)
+				origin = "{F}"
+			}
+		}
 		if simpleList {
-			fmt.Printf("%s %-13s %-25s\n", origin, "["+template.Group+"]", template.Name)
+			fmt.Printf("%s %-13s %-25s\n", origin, "["+template.Group+"]", name)
 		} else {
-			fmt.Printf("%s %-13s %-25s : %s\n", origin, "["+template.Group+"]", template.Name, template.Description)
+			fmt.Printf("%s %-13s %-25s : %s\n", origin, "["+template.Group+"]", name, template.Description)
 		}
 	}
 }
@@ -131,6 +154,21 @@ func getTemplatesDescription(requested string, completeListing bool) string {
 	out += fmt.Sprintf("# Notes     	: %s\n", sandbox.AllTemplates[group][templateName].Notes)
 	out += fmt.Sprintf("# Length     	: %d\n", len(contents))
 	if completeListing {
+		variables := sandbox.TemplateVariablesFor(sandbox.AllTemplates[group][templateName])
+		if len(variables) > 0 {
+			out += "# Variables   	:\n"
+		}
+		for _, variable := range variables {
+			name := variable.Name
+			if variable.List != "" {
+				name = fmt.Sprintf("%s[].%s", variable.List, variable.Name)
+			}
+			required := "optional"
+			if variable.Required {
+				required = "required"
+			}
+			out += fmt.Sprintf("#   %-25s %-8s %-8s %s\n", name, variable.Type, required, variable.Description)
+		}
 		out += fmt.Sprintf("##START %s\n", templateName)
 		out += fmt.Sprintf("%s\n", contents)
 		out += fmt.Sprintf("##END %s\n\n", templateName)
@@ -197,6 +235,20 @@ func exportTemplates(cmd *cobra.Command, args []string) {
 	fmt.Printf("Exported to %s\n", dirName)
 }
 
+// Returns the names of the files in groupDir that define a template:
+// the generic one and its variants (name@flavor, name@flavor-major.minor)
+func templateFileNames(groupDir, name string) []string {
+	var names []string
+	if common.FileExists(path.Join(groupDir, name)) {
+		names = append(names, name)
+	}
+	variants, _ := filepath.Glob(path.Join(groupDir, name+sandbox.TemplateVariantSeparator+"*"))
+	for _, variant := range variants {
+		names = append(names, path.Base(variant))
+	}
+	return names
+}
+
 // Called by rootCmd when dbdeployer starts
 func loadTemplates() {
 	loadDir := path.Join(defaults.ConfigurationDir, "templates"+common.CompatibleVersion)
@@ -208,20 +260,30 @@ func loadTemplates() {
 		if !common.DirExists(groupDir) {
 			continue
 		}
+		var loaded = make(sandbox.TemplateCollection)
 		for name, template := range group {
-			fileName := path.Join(groupDir, name)
-			if !common.FileExists(fileName) {
+			if strings.Contains(name, sandbox.TemplateVariantSeparator) {
 				continue
 			}
-			newContents, err := common.SlurpAsString(fileName)
-			if err != nil {
-				common.Exitf(1, "error reading %s\n", fileName)
+			for _, fileName := range templateFileNames(groupDir, name) {
+				newContents, err := common.SlurpAsString(path.Join(groupDir, fileName))
+				if err != nil {
+					common.Exitf(1, "error reading %s\n", fileName)
+				}
+				newTemplate := template
+				newTemplate.TemplateInFile = true
+				newTemplate.Contents = newContents
+				// The variables declared by the built-in template still apply.
+				// Undeclared variables are reported when the template is used
+				if fileName != name {
+					newTemplate.Notes = fmt.Sprintf("Variant of %s", name)
+				}
+				loaded[fileName] = newTemplate
+				// fmt.Printf("# Template %s loaded from %s\n",name, file_name)
 			}
-			newTemplate := template
-			newTemplate.TemplateInFile = true
-			newTemplate.Contents = newContents
-			sandbox.AllTemplates[groupName][name] = newTemplate
-			// fmt.Printf("# Template %s loaded from %s\n",name, file_name)
+		}
+		for name, template := range loaded {
+			sandbox.AllTemplates[groupName][name] = template
 		}
 	}
 }
@@ -278,11 +340,14 @@ func importTemplates(cmd *cobra.Command, args []string) {
 		if !common.DirExists(groupDir) {
 			continue
 		}
-		for name, _ := range group {
-			fileName := path.Join(groupDir, name)
-			if !common.FileExists(fileName) {
-				continue
+		var fileNames []string
+		for name := range group {
+			if !strings.Contains(name, sandbox.TemplateVariantSeparator) {
+				fileNames = append(fileNames, templateFileNames(groupDir, name)...)
 			}
+		}
+		for _, name := range fileNames {
+			fileName := path.Join(groupDir, name)
 			if groupName == wanted || wanted == "" {
 				foundGroup = true
 			} else {
@@ -326,6 +391,115 @@ func importTemplates(cmd *cobra.Command, args []string) {
 	}
 }
 
+// Checks one template, printing its problems. Returns the number of problems found
+// Returns the names of the template groups in alphabetical order
+func sortedTemplateGroups() []string {
+	var names []string
+	for name := range sandbox.AllTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the names of the templates of a group in alphabetical order
+func sortedTemplateNames(group sandbox.TemplateCollection) []string {
+	var names []string
+	for name := range group {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkOneTemplate(groupName, name, contents string) int {
+	problems := sandbox.CheckTemplate(groupName, name, contents)
+	for _, problem := range problems {
+		fmt.Printf("%s/%s: %s\n", groupName, name, problem)
+	}
+	return len(problems)
+}
+
+func checkTemplates(cmd *cobra.Command, args []string) {
+	checked := 0
+	failed := 0
+	if len(args) > 0 {
+		// Checks the templates in a directory, as created by "templates export"
+		dirName := args[0]
+		if !common.DirExists(dirName) {
+			common.Exitf(1, globals.ErrDirectoryNotFound, dirName)
+		}
+		for _, groupName := range sortedTemplateGroups() {
+			group := sandbox.AllTemplates[groupName]
+			groupDir := path.Join(dirName, groupName)
+			if !common.DirExists(groupDir) {
+				continue
+			}
+			var known = make(map[string]bool)
+			for _, name := range sortedTemplateNames(group) {
+				if strings.Contains(name, sandbox.TemplateVariantSeparator) {
+					continue
+				}
+				for _, fileName := range templateFileNames(groupDir, name) {
+					known[fileName] = true
+					contents, err := common.SlurpAsString(path.Join(groupDir, fileName))
+					common.ErrCheckExitf(err, 1, "error reading template %s", fileName)
+					checked++
+					if checkOneTemplate(groupName, fileName, contents) > 0 {
+						failed++
+					}
+				}
+			}
+			files, err := ioutil.ReadDir(groupDir)
+			common.ErrCheckExitf(err, 1, "error reading directory %s", groupDir)
+			for _, f := range files {
+				if !f.IsDir() && !known[f.Name()] {
+					fmt.Printf("%s/%s: not a template of group '%s'. It will not be imported\n", groupName, f.Name(), groupName)
+				}
+			}
+		}
+	} else {
+		for _, groupName := range sortedTemplateGroups() {
+			group := sandbox.AllTemplates[groupName]
+			for _, name := range sortedTemplateNames(group) {
+				checked++
+				if checkOneTemplate(groupName, name, group[name].Contents) > 0 {
+					failed++
+				}
+			}
+		}
+	}
+	if checked == 0 {
+		common.Exitf(1, "no templates found")
+	}
+	if failed > 0 {
+		common.Exitf(1, "%d templates checked - %d with errors", checked, failed)
+	}
+	fmt.Printf("%d templates checked - no errors found\n", checked)
+}
+
+func renderTemplate(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		common.Exitf(1, globals.ErrArgumentRequired, "template name")
+	}
+	flags := cmd.Flags()
+	version, _ := flags.GetString(globals.VersionLabel)
+	flavor, _ := flags.GetString(globals.FlavorLabel)
+	if !common.IsVersion(version) {
+		common.Exitf(1, "version '%s' should have 3 numbers (#.#.#)", version)
+	}
+	if flavor == "" {
+		flavor = common.MySQLFlavor
+	}
+	groupName, templateName, _ := findTemplate(args[0])
+	// Uses the variant that a deployment of this flavor and version would use
+	templateName = sandbox.ResolveTemplateName(sandbox.AllTemplates[groupName], templateName, flavor, version)
+	contents := sandbox.AllTemplates[groupName][templateName].Contents
+	output, err := sandbox.RenderTemplate(groupName, templateName, contents, version, flavor)
+	common.ErrCheckExitf(err, 1, "error rendering template %s: %s", templateName, err)
+	fmt.Print(output)
+}
+
 func resetTemplates(cmd *cobra.Command, args []string) {
 	// TODO: loop through the templates directories and remove all the ones that have compatible versions.
 	templatesDir := path.Join(defaults.ConfigurationDir, "templates"+common.CompatibleVersion)
@@ -353,8 +527,19 @@ to create and manipulate sandboxes.
 	templatesListCmd = &cobra.Command{
 		Use:   "list [group]",
 		Short: "list available templates",
-		Long:  ``,
-		Run:   listTemplates,
+		Long: `Lists the templates, marking with {F} the ones loaded from files.
+Besides the generic templates, the templates directory can hold variants
+for a flavor (name@flavor) or for a flavor and version (name@flavor-major.minor),
+which take precedence when deploying sandboxes of that flavor and version.
+With --version (and optionally --flavor), shows which variant would be used.`,
+		Example: `
+    $ dbdeployer defaults templates export single /tmp/templates my_cnf
+    $ cp /tmp/templates/single/my_cnf_template /tmp/templates/single/my_cnf_template@mariadb-10.3
+    $ vim /tmp/templates/single/my_cnf_template@mariadb-10.3
+    $ dbdeployer defaults templates import single /tmp/templates my_cnf
+    $ dbdeployer defaults templates list single --version 10.3.13 --flavor mariadb
+`,
+		Run: listTemplates,
 	}
 
 	templatesShowCmd = &cobra.Command{
@@ -382,6 +567,34 @@ to create and manipulate sandboxes.
 		Long:  `Imports a group of templates (or "ALL") from a given directory`,
 		Run:   importTemplates,
 	}
+	templatesCheckCmd = &cobra.Command{
+		Use:   "check [directory_name]",
+		Short: "Checks templates for errors",
+		Long: `Checks that templates have a valid syntax, use only the variables that
+dbdeployer provides to their group, and can be rendered.
+Without arguments, checks the templates in use (built-in and imported).
+With a directory name, checks the templates in that directory, as created by
+"templates export", before importing them.
+Exits with an error if any template has problems.`,
+		Example: `
+    $ dbdeployer defaults templates check
+    $ dbdeployer defaults templates export single /tmp/templates
+    $ vim /tmp/templates/single/my_cnf_template
+    $ dbdeployer defaults templates check /tmp/templates
+`,
+		Run: checkTemplates,
+	}
+	templatesRenderCmd = &cobra.Command{
+		Use:   "render template_name",
+		Short: "Shows a template filled with sample data",
+		Long: `Shows the output of a template, filled with sample data for the given version and flavor.
+If there is a variant of the template for that flavor and version, the variant is used.`,
+		Example: `
+    $ dbdeployer defaults templates render my_cnf --version=8.0.16
+    $ dbdeployer defaults templates render start --version=10.3.13 --flavor=mariadb
+`,
+		Run: renderTemplate,
+	}
 	templatesResetCmd = &cobra.Command{
 		Use:     "reset",
 		Aliases: []string{"remove"},
@@ -399,7 +612,13 @@ func init() {
 	templatesCmd.AddCommand(templatesExportCmd)
 	templatesCmd.AddCommand(templatesImportCmd)
 	templatesCmd.AddCommand(templatesResetCmd)
+	templatesCmd.AddCommand(templatesCheckCmd)
+	templatesCmd.AddCommand(templatesRenderCmd)
 
 	templatesListCmd.Flags().BoolP(globals.SimpleLabel, "s", false, "Shows only the template names, without description")
+	templatesListCmd.Flags().String(globals.VersionLabel, "", "Shows the template variants used for this version")
+	templatesListCmd.Flags().String(globals.FlavorLabel, "", "Shows the template variants used for this flavor (with --version)")
+	templatesRenderCmd.Flags().String(globals.VersionLabel, sandbox.SampleTemplateVersion, "Version used for the sample data")
+	templatesRenderCmd.Flags().String(globals.FlavorLabel, common.MySQLFlavor, "Flavor used for the sample data")
 	templatesDescribeCmd.Flags().BoolP(globals.WithContentsLabel, "", false, "Shows complete structure and contents")
 }
@@ -24,41 +24,75 @@ import (
 	"strings"
 
 	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/unpack"
 	"github.com/spf13/cobra"
 )
 
-// Tries to detect the database flavor from tarball name
-func detectTarballFlavor(tarballName string) string {
-	flavor := ""
-	flavorsRegexps := map[string]string{
-		common.MySQLFlavor:         `mysql`,
-		common.PerconaServerFlavor: `Percona-Server`,
-		common.MariaDbFlavor:       `mariadb`,
-		common.NDBFlavor:           `mysql-cluster`,
-		common.TiDbFlavor:          `tidb`,
-	}
-
-	for key, value := range flavorsRegexps {
-		re := regexp.MustCompile(value)
-		if re.MatchString(tarballName) {
-			return key
-		}
-	}
-	return flavor
+// Settings for the expansion of a tarball into the binary directory
+type unpackOptions struct {
+	basedir         string
+	verbosity       int
+	isShell         bool
+	target          string
+	versionCheck    string
+	skipPatterns    []string
+	filter          *unpack.SkipFilter
+	flavor          string
+	version         string
+	prefix          string
+	checksum        string
+	verifySignature bool
 }
 
 func unpackTarball(cmd *cobra.Command, args []string) {
 	flags := cmd.Flags()
-	Basedir, err := getAbsolutePathFromFlag(cmd, "sandbox-binary")
+	var options unpackOptions
+	var err error
+	options.basedir, err = getAbsolutePathFromFlag(cmd, "sandbox-binary")
 	common.ErrCheckExitf(err, 1, "error getting absolute path for 'sandbox-binary'")
-	verbosity, _ := flags.GetInt(globals.VerbosityLabel)
+	options.verbosity, _ = flags.GetInt(globals.VerbosityLabel)
+
+	options.isShell, _ = flags.GetBool(globals.ShellLabel)
+	options.target, _ = flags.GetString(globals.TargetServerLabel)
+	if !options.isShell && options.target != "" {
+		common.Exit(1,
+			"unpack: Option --target-server can only be used with --shell")
+	}
+
+	options.versionCheck, _ = flags.GetString(globals.VersionCheckLabel)
+	minimal, _ := flags.GetBool(globals.MinimalLabel)
+	options.skipPatterns, _ = flags.GetStringSlice(globals.SkipPatternLabel)
+	if minimal || len(options.skipPatterns) > 0 {
+		if options.isShell {
+			common.Exitf(1, "unpack: option --%s can't be used with --%s", globals.MinimalLabel, globals.ShellLabel)
+		}
+		if len(options.skipPatterns) == 0 {
+			options.skipPatterns = defaults.Defaults().MinimalUnpackSkip
+		}
+		if len(options.skipPatterns) == 0 {
+			options.skipPatterns = unpack.DefaultSkipPatterns
+		}
+		options.filter, err = unpack.NewSkipFilter(options.skipPatterns)
+		common.ErrCheckExitf(err, 1, "unpack: %s", err)
+	}
+	options.flavor, _ = flags.GetString(globals.FlavorLabel)
+	options.version, _ = flags.GetString(globals.UnpackVersionLabel)
+	options.prefix, _ = flags.GetString(globals.PrefixLabel)
+	options.checksum, _ = flags.GetString(globals.ChecksumLabel)
+	options.verifySignature, _ = flags.GetBool(globals.VerifySignatureLabel)
+	unpackTarballFile(args[0], options)
+}
+
+// Expands a tarball into the binary directory, and returns the resulting directory
+func unpackTarballFile(tarball string, options unpackOptions) string {
+	Basedir := options.basedir
+	verbosity := options.verbosity
 	if !common.DirExists(Basedir) {
 		common.Exit(1,
 			fmt.Sprintf(globals.ErrDirectoryNotFound, Basedir),
 			"You should create it or provide an alternate base directory using --sandbox-binary")
 	}
-	tarball := args[0]
 	reVersion := regexp.MustCompile(`(\d+\.\d+\.\d+)`)
 	verList := reVersion.FindAllStringSubmatch(tarball, -1)
 
@@ -68,78 +102,107 @@ func unpackTarball(cmd *cobra.Command, args []string) {
 	}
 	// common.CondPrintf(">> %#v %s\n",verList, detected_version)
 
-	isShell, _ := flags.GetBool(globals.ShellLabel)
-	target, _ := flags.GetString(globals.TargetServerLabel)
-	if !isShell && target != "" {
-		common.Exit(1,
-			"unpack: Option --target-server can only be used with --shell")
+	isShell := options.isShell
+	target := options.target
+	versionCheck := options.versionCheck
+	if versionCheck != globals.VersionCheckWarn && versionCheck != globals.VersionCheckStrict &&
+		versionCheck != globals.VersionCheckNone {
+		common.Exitf(1, "unpack: --%s must be one of '%s', '%s', '%s'", globals.VersionCheckLabel,
+			globals.VersionCheckWarn, globals.VersionCheckStrict, globals.VersionCheckNone)
 	}
-
-	flavor, _ := flags.GetString(globals.FlavorLabel)
+	filter := options.filter
+	flavor := options.flavor
 	if flavor == "" {
-		flavor = detectTarballFlavor(tarball)
-		if flavor == "" {
-			common.Exitf(1, "No flavor detected in %s. Please use --%s", tarball, globals.FlavorLabel)
-		}
+		flavor = common.DetectTarballFlavor(tarball)
 	}
-	Version, _ := flags.GetString(globals.UnpackVersionLabel)
+	Version := options.version
 	if Version == "" {
 		Version = detectedVersion
 	}
-	if Version == "" {
+	// Without a version, we can only find the destination after examining the server binary
+	if Version == "" && (isShell || versionCheck == globals.VersionCheckNone) {
 		common.Exit(1,
 			"unpack: No version was detected from tarball name. ",
 			"Flag --unpack-version becomes mandatory")
 	}
-	// This call used to ensure that the port provided is in the right format
-	_, err = common.VersionToPort(Version)
-	if err != nil {
-		common.Exitf(1, "version %s not in the required format", Version)
+	var err error
+	if Version != "" {
+		// This call used to ensure that the port provided is in the right format
+		_, err = common.VersionToPort(Version)
+		if err != nil {
+			common.Exitf(1, "version %s not in the required format", Version)
+		}
 	}
-	Prefix, _ := flags.GetString(globals.PrefixLabel)
+	Prefix := options.prefix
 
 	destination := path.Join(Basedir, Prefix+Version)
 	if target != "" {
 		destination = path.Join(Basedir, target)
 	}
-	if common.DirExists(destination) && !isShell {
+	if Version != "" && common.DirExists(destination) && !isShell {
 		common.Exitf(1, globals.ErrNamedDirectoryAlreadyExists, "destination directory", destination)
 	}
-	extracted := path.Base(tarball)
-	var bareName string
-
-	var extractFunc func(string, string, int) error
-	var foundExtension string
-
-	switch {
-	case strings.HasSuffix(tarball, globals.TarGzExt):
-		extractFunc = unpack.UnpackTar
-		foundExtension = globals.TarGzExt
-	case strings.HasSuffix(tarball, globals.TarXzExt):
-		extractFunc = unpack.UnpackXzTar
-		foundExtension = globals.TarXzExt
-	default:
-		common.Exitf(1, "tarball extension must be either '%s' or '%s'", globals.TarGzExt, globals.TarXzExt)
+	if !common.FileExists(tarball) {
+		common.Exitf(1, globals.ErrFileNotFound, tarball)
+	}
+	_, err = unpack.FindExtractor(tarball)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	if options.checksum != "" {
+		err = common.VerifyChecksum(tarball, options.checksum)
+		common.ErrCheckExitf(err, 1, "unpack: %s", err)
+		common.CondPrintf("Checksum verified for %s\n", tarball)
+	}
+	if options.verifySignature {
+		verifiedWith, err := common.VerifyDetached(tarball)
+		common.ErrCheckExitf(err, 1, "unpack: %s", err)
+		common.CondPrintf("%s verified with %s\n", tarball, verifiedWith)
 	}
-	bareName = extracted[0 : len(extracted)-len(globals.TarGzExt)]
+	bareName := unpack.ArchiveBareName(tarball)
 	if isShell {
 		common.CondPrintf("Merging shell tarball %s to %s\n", common.ReplaceLiteralHome(tarball), common.ReplaceLiteralHome(destination))
-		err := unpack.MergeShell(tarball, foundExtension, Basedir, destination, bareName, verbosity)
+		err := unpack.MergeShell(tarball, Basedir, destination, bareName, verbosity)
 		common.ErrCheckExitf(err, 1, "error while unpacking mysql shell tarball : %s", err)
-		return
+		return destination
 	}
 
-	common.CondPrintf("Unpacking tarball %s to %s\n", tarball, common.ReplaceLiteralHome(destination))
+	finalName := path.Join(Basedir, bareName)
+	if Version == "" && common.DirExists(finalName) {
+		common.Exitf(1, globals.ErrNamedDirectoryAlreadyExists, "extraction directory", finalName)
+	}
+	if Version != "" {
+		common.CondPrintf("Unpacking tarball %s to %s\n", tarball, common.ReplaceLiteralHome(destination))
+	} else {
+		common.CondPrintf("Unpacking tarball %s to %s\n", tarball, common.ReplaceLiteralHome(finalName))
+	}
 	//verbosity_level := unpack.VERBOSE
-	// err := unpack.UnpackTar(tarball, Basedir, verbosity)
-	err = extractFunc(tarball, Basedir, verbosity)
+	err = unpack.UnpackArchiveWithFilter(tarball, Basedir, verbosity, filter)
 	common.ErrCheckExitf(err, 1, "%s", err)
-	finalName := path.Join(Basedir, bareName)
+	if filter != nil {
+		common.CondPrintf("Skipped %d files (%s) matching %v\n", filter.SkippedFiles,
+			common.HumanSize(filter.SkippedBytes), options.skipPatterns)
+	}
 	// If the directory was not created, it probably means that the tarball was not well organised
 	// and either lacked the top directory or the top directory had a different name
 	if !common.DirExists(finalName) {
 		common.Exitf(1, "problem with tarball %s: directory %s was not created", tarball, finalName)
 	}
+	if versionCheck != globals.VersionCheckNone {
+		Version, flavor, err = verifyServerBinary(finalName, Version, flavor, versionCheck == globals.VersionCheckStrict)
+		if err != nil {
+			_ = os.RemoveAll(finalName)
+			common.Exitf(1, "unpack: %s", err)
+		}
+		if target == "" {
+			destination = path.Join(Basedir, Prefix+Version)
+		}
+		if finalName != destination && common.DirExists(destination) {
+			_ = os.RemoveAll(finalName)
+			common.Exitf(1, globals.ErrNamedDirectoryAlreadyExists, "destination directory", destination)
+		}
+	}
+	if flavor == "" {
+		flavor = common.DetectBinaryFlavor(finalName)
+	}
 	if finalName != destination {
 		common.CondPrintf("Renaming directory %s to %s\n", finalName, destination)
 		err = os.Rename(finalName, destination)
@@ -147,6 +210,47 @@ func unpackTarball(cmd *cobra.Command, args []string) {
 	}
 	err = common.WriteString(flavor, path.Join(destination, globals.FlavorFileName))
 	common.ErrCheckExitf(err, 1, "error writing %s in %s", globals.FlavorFileName, destination)
+	return destination
+}
+
+// Compares version and flavor expected from the tarball name or from the command line
+// with the ones reported by the server binary.
+// Returns the version and flavor to use, or an error when the binary cannot be verified
+// or there is a mismatch and strict is set.
+func verifyServerBinary(basedir, version, flavor string, strict bool) (string, string, error) {
+	binVersion, binFlavor, err := common.DetectServerVersion(basedir)
+	if err != nil {
+		if version == "" {
+			return version, flavor, fmt.Errorf("no version was detected from tarball name or server binary (%s). "+
+				"Flag --%s becomes mandatory", err, globals.UnpackVersionLabel)
+		}
+		if strict {
+			return version, flavor, fmt.Errorf("could not verify server binary: %s", err)
+		}
+		common.CondPrintf("# WARNING: could not verify server binary: %s\n", err)
+		return version, flavor, nil
+	}
+	var mismatches []string
+	if version == "" {
+		version = binVersion
+		common.CondPrintf("# Version %s detected from server binary\n", version)
+	} else if version != binVersion {
+		mismatches = append(mismatches, fmt.Sprintf("version %s expected, server binary reports %s", version, binVersion))
+	}
+	if flavor == "" {
+		flavor = binFlavor
+	} else if binFlavor != "" && flavor != binFlavor {
+		mismatches = append(mismatches, fmt.Sprintf("flavor %s expected, server binary reports %s", flavor, binFlavor))
+	}
+	if len(mismatches) > 0 {
+		if strict {
+			return version, flavor, fmt.Errorf("mismatch with server binary: %s", strings.Join(mismatches, "; "))
+		}
+		for _, mismatch := range mismatches {
+			common.CondPrintf("# WARNING: %s\n", mismatch)
+		}
+	}
+	return version, flavor, nil
 }
 
 // unpackCmd represents the unpack command
@@ -155,12 +259,23 @@ var unpackCmd = &cobra.Command{
 	Args:    cobra.ExactArgs(1),
 	Aliases: []string{"extract", "untar", "unzip", "inflate", "expand"},
 	Short:   "unpack a tarball into the binary directory",
-	Long: `If you want to create a sandbox from a tarball (.tar.gz or .tar.xz), you first need to unpack it
-into the sandbox-binary directory. This command carries out that task, so that afterwards 
+	Long: `If you want to create a sandbox from a tarball (.tar.gz, .tar.xz, .tar.bz2, .tar.zst, .zip, or .tar),
+you first need to unpack it into the sandbox-binary directory. The archive format is detected
+from the file contents. This command carries out that task, so that afterwards 
 you can call 'deploy single', 'deploy multiple', and 'deploy replication' commands with only 
 the MySQL version for that tarball.
-If the version is not contained in the tarball name, it should be supplied using --unpack-version.
+If the version is not contained in the tarball name, it is taken from the server binary
+(bin/mysqld --version) after extraction. When that fails, it should be supplied using --unpack-version.
+Version and flavor from the tarball name are checked against the ones reported by the server binary.
+A mismatch causes a warning, or an error with --version-check=strict.
 If there is already an expanded tarball with the same version, a new one can be differentiated with --prefix.
+Before extraction, the tarball can be checked with --checksum, or with --verify-signature,
+which uses a detached signature (.asc, .sig) or checksum file (.sha512, .sha256, .sha1, .md5)
+found next to the tarball.
+With --minimal, files that are not needed to run a sandbox (test suite, documentation,
+static libraries, debug binaries) are not extracted. The list of patterns to skip can be
+changed with --skip-pattern, or with the "minimal-unpack-skip" default.
+The client libraries that identify flavor and operating system are always extracted.
 `,
 	Run: unpackTarball,
 	Example: `
@@ -172,6 +287,11 @@ If there is already an expanded tarball with the same version, a new one can be
 
     $ dbdeployer unpack --unpack-version=8.0.18 --prefix=bld mysql-mybuild.tar.gz
     Unpacking tarball mysql-mybuild.tar.gz to $HOME/opt/mysql/bld8.0.18
+
+    $ dbdeployer unpack --minimal mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz
+    $ dbdeployer unpack --checksum=md5:55a2d4d43ee8d5ee8bb3d6a9cd4c3c3f mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz
+    $ dbdeployer unpack --verify-signature mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz
+    $ dbdeployer unpack --skip-pattern=mysql-test --skip-pattern='lib/*.a' mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz
 	`,
 }
 
@@ -184,4 +304,10 @@ func init() {
 	unpackCmd.PersistentFlags().Bool(globals.ShellLabel, false, "Unpack a shell tarball into the corresponding server directory")
 	unpackCmd.PersistentFlags().String(globals.TargetServerLabel, "", "Uses a different server to unpack a shell tarball")
 	unpackCmd.PersistentFlags().String(globals.FlavorLabel, "", "Defines the tarball flavor (MySQL, NDB, Percona Server, etc)")
+	unpackCmd.PersistentFlags().Bool(globals.MinimalLabel, false, "Does not extract files unneeded for sandboxes (tests, docs, static libraries, debug binaries)")
+	unpackCmd.PersistentFlags().StringSlice(globals.SkipPatternLabel, []string{}, "Paths inside the tarball to skip (wildcards allowed). Implies --minimal")
+	unpackCmd.PersistentFlags().String(globals.ChecksumLabel, "", "Expected checksum of the tarball (e.g. sha256:abcd...)")
+	unpackCmd.PersistentFlags().Bool(globals.VerifySignatureLabel, false, "Verifies the tarball with a detached signature or checksum file")
+	unpackCmd.PersistentFlags().String(globals.VersionCheckLabel, globals.VersionCheckWarn,
+		"How to check the server binary version against the tarball name (warn, strict, none)")
 }
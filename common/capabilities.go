@@ -14,9 +14,39 @@
 // limitations under the License.
 package common
 
-import "github.com/datacharmer/dbdeployer/globals"
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/globals"
+)
 
 type MinimumVersion []int
+
+// Accepts a version either as a list of integers ([5, 7, 9])
+// or as a string ("5.7.9"), to make flavor files easier to write
+func (mv *MinimumVersion) UnmarshalJSON(data []byte) error {
+	var versionList []int
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "\"") {
+		var versionText string
+		err := json.Unmarshal(data, &versionText)
+		if err != nil {
+			return err
+		}
+		versionList, err = VersionToList(versionText)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := json.Unmarshal(data, &versionList)
+		if err != nil {
+			return err
+		}
+	}
+	*mv = versionList
+	return nil
+}
+
 type Capability struct {
 	Description string         `json:"description"`
 	Since       MinimumVersion `json:"since"`
@@ -20,6 +20,7 @@ import (
 	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/pkg/errors"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"regexp"
@@ -35,6 +36,9 @@ type SandboxInfo struct {
 
 var portDebug bool = IsEnvSet("PORT_DEBUG")
 
+// When set, ports are considered used if any process on this host listens to them
+var checkHostPorts bool = !IsEnvSet("SKIP_HOST_PORT_CHECK")
+
 type PortMap map[int]bool
 
 // Returns a list of inner sandboxes
@@ -169,46 +173,104 @@ func GetInstalledPorts(sandboxHome string) ([]int, error) {
 	return portCollection, nil
 }
 
-func CheckFlavorSupport(flavor string) error {
-	supportedFlavors := []string{
-		MySQLFlavor,
-		MariaDbFlavor,
-		PerconaServerFlavor,
-		TiDbFlavor,
+// Criteria used to select sandboxes in global operations.
+// Each list accepts shell patterns (see path.Match).
+// An empty list means "no restriction".
+type SandboxFilter struct {
+	Types    []string
+	Versions []string
+	Flavors  []string
+	Names    []string
+	Excludes []string
+}
+
+// Returns true if the value matches at least one of the given patterns
+func matchAnyPattern(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		matches, err := path.Match(pattern, value)
+		if err != nil {
+			matches = pattern == value
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the version matches at least one of the given patterns.
+// A pattern without wildcards, such as "8.0", also matches all the versions
+// that start with it ("8.0.15", "8.0.16")
+func matchVersionPattern(patterns []string, version string) bool {
+	if matchAnyPattern(patterns, version) {
+		return true
 	}
-	for _, sf := range supportedFlavors {
-		if sf == flavor {
-			return nil
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") && strings.HasPrefix(version, pattern+".") {
+			return true
 		}
 	}
-	return fmt.Errorf("flavor '%s' is not supported", flavor)
+	return false
 }
 
-// Tries to detect the database flavor from files in the tarball directory
-func DetectBinaryFlavor(basedir string) string {
-	type FlavorIndicator struct {
-		dir      string
-		fileName string
-		flavor   string
+// Returns true if the filter does not restrict the selection
+func (filter SandboxFilter) IsEmpty() bool {
+	return len(filter.Types) == 0 && len(filter.Versions) == 0 && len(filter.Flavors) == 0 &&
+		len(filter.Names) == 0 && len(filter.Excludes) == 0
+}
+
+// Returns true if the sandbox satisfies all the criteria in the filter.
+// A sandbox without description can only be selected by name.
+func (filter SandboxFilter) Matches(name string, sbd SandboxDescription, hasDescription bool) bool {
+	if matchAnyPattern(filter.Excludes, name) {
+		return false
+	}
+	if len(filter.Names) > 0 && !matchAnyPattern(filter.Names, name) {
+		return false
+	}
+	if len(filter.Types) == 0 && len(filter.Versions) == 0 && len(filter.Flavors) == 0 {
+		return true
+	}
+	if !hasDescription {
+		return false
+	}
+	if len(filter.Types) > 0 && !matchAnyPattern(filter.Types, sbd.SBType) {
+		return false
+	}
+	if len(filter.Versions) > 0 && !matchVersionPattern(filter.Versions, sbd.Version) {
+		return false
+	}
+	flavor := sbd.Flavor
+	if flavor == "" {
+		flavor = MySQLFlavor
+	}
+	if len(filter.Flavors) > 0 && !matchAnyPattern(filter.Flavors, flavor) {
+		return false
 	}
-	var findingList = []FlavorIndicator{
-		{"bin", "aria_chk", MariaDbFlavor},
-		{"bin", "tidb-server", TiDbFlavor},
-		{"lib", "libmariadbclient.a", MariaDbFlavor},
-		{"lib", "libmariadb.a", MariaDbFlavor},
-		{"lib", "libmariadb.dylib", MariaDbFlavor},
-		{"lib", "libmariadb.dylib", MariaDbFlavor},
-		{"lib", "libperconaserverclient.a", PerconaServerFlavor},
-		{"lib", "libperconaserverclient.so", PerconaServerFlavor},
-		{"lib", "libperconaserverclient.dylib", PerconaServerFlavor},
-	}
-	for _, fi := range findingList {
-		target := path.Join(basedir, fi.dir, fi.fileName)
-		if FileExists(target) {
-			return fi.flavor
+	return true
+}
+
+// Returns the sandboxes in sandboxHome that satisfy the filter
+func FilterInstalledSandboxes(sandboxHome string, sandboxList []SandboxInfo, filter SandboxFilter) ([]SandboxInfo, error) {
+	if filter.IsEmpty() {
+		return sandboxList, nil
+	}
+	var selected []SandboxInfo
+	for _, sbInfo := range sandboxList {
+		var sbd SandboxDescription
+		var err error
+		hasDescription := FileExists(path.Join(sandboxHome, sbInfo.SandboxName, globals.SandboxDescriptionName))
+		if hasDescription {
+			sbd, err = ReadSandboxDescription(path.Join(sandboxHome, sbInfo.SandboxName))
+			if err != nil {
+				return []SandboxInfo{}, err
+			}
+		}
+		if filter.Matches(sbInfo.SandboxName, sbd, hasDescription) {
+			selected = append(selected, sbInfo)
 		}
 	}
-	return MySQLFlavor
+	return selected, nil
 }
 
 /* Checks that the extracted tarball directory
@@ -228,14 +290,20 @@ func CheckTarballOperatingSystem(basedir string) error {
 		isBinary bool
 	}
 	var findingList = map[string]OSFinding{
-		"libmysqlclient.a":             {"lib", "linux", MySQLFlavor, true}, // 4.1 and old 5.0 releases
-		"libmysqlclient.so":            {"lib", "linux", MySQLFlavor, true},
-		"libperconaserverclient.so":    {"lib", "linux", PerconaServerFlavor, true},
-		"libperconaserverclient.dylib": {"lib", "darwin", PerconaServerFlavor, true},
-		"libmysqlclient.dylib":         {"lib", "darwin", MySQLFlavor, true},
-		"tidb-server":                  {"bin", "any", TiDbFlavor, true},
-		"table.h":                      {"sql", "source", "any", false},
-		"mysqlprovision.zip":           {"share/mysqlsh", "shell", "any", false},
+		"table.h":            {"sql", "source", "any", false},
+		"mysqlprovision.zip": {"share/mysqlsh", "shell", "any", false},
+	}
+	for _, def := range FlavorDefinitions() {
+		for _, bf := range def.BinaryFiles {
+			if _, found := findingList[bf.FileName]; found {
+				continue
+			}
+			fileOs := bf.OS
+			if fileOs == "" {
+				fileOs = "any"
+			}
+			findingList[bf.FileName] = OSFinding{bf.Dir, fileOs, def.Name, true}
+		}
 	}
 	wantedOsFound := false
 	var foundList = make(map[string]OSFinding)
@@ -276,9 +344,10 @@ func CheckTarballOperatingSystem(basedir string) error {
 // Returns true if the file name has a recognized tarball extension
 // for use with dbdeployer
 func IsATarball(fileName string) bool {
-	if strings.HasSuffix(fileName, ".tar.gz") ||
-		strings.HasSuffix(fileName, ".tar.xz") {
-		return true
+	for _, extension := range globals.ArchiveExtensions {
+		if strings.HasSuffix(fileName, extension) {
+			return true
+		}
 	}
 	return false
 }
@@ -466,6 +535,31 @@ func GreaterOrEqualVersionList(verList, comparedTo []int) (bool, error) {
 	return versionText >= compareText, nil
 }
 
+// Returns true if no process is listening to the given port on this host
+func IsPortFreeOnHost(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	return true
+}
+
+// Returns true if the port is used by a sandbox or, when host checks are
+// enabled, by any other process
+func isPortUsed(port int, usedPorts PortMap) bool {
+	if usedPorts[port] {
+		return true
+	}
+	if checkHostPorts && !IsPortFreeOnHost(port) {
+		if portDebug {
+			CondPrintf("- port %d is busy on this host\n", port)
+		}
+		return true
+	}
+	return false
+}
+
 // Finds the first free port available, starting at
 // requestedPort.
 // usedPorts is a map of ports already used by other sandboxes.
@@ -475,8 +569,7 @@ func findFreePortSingle(requestedPort int, usedPorts PortMap) (int, error) {
 	foundPort := 0
 	candidatePort := requestedPort
 	for foundPort == 0 {
-		_, exists := usedPorts[candidatePort]
-		if exists {
+		if isPortUsed(candidatePort, usedPorts) {
 			if portDebug {
 				CondPrintf("- port %d not free\n", candidatePort)
 			}
@@ -506,8 +599,7 @@ func findFreePortRange(basePort int, usedPorts PortMap, howMany int) (int, error
 	for foundPort == 0 {
 		numPorts := 0
 		for counter < howMany {
-			_, exists := usedPorts[candidatePort+counter]
-			if exists {
+			if isPortUsed(candidatePort+counter, usedPorts) {
 				if portDebug {
 					CondPrintf("- port %d is not free\n", candidatePort+counter)
 				}
@@ -18,6 +18,7 @@ package common
 import (
 	"fmt"
 	"github.com/datacharmer/dbdeployer/compare"
+	"net"
 	"testing"
 )
 
@@ -47,11 +48,16 @@ func TestIsATarball(t *testing.T) {
 	}
 	var data = []testTarball{
 		{"dummy.tar.gz", true},
+		{"dummy.tgz", true},
 		{"dummy.tar.xz", true},
+		{"dummy.tar.bz2", true},
+		{"dummy.tar.zst", true},
+		{"dummy.zip", true},
+		{"dummy.tar", true},
 		{"dummy.targz", false},
-		{"dummy.tar", false},
 		{"dummy.gz", false},
 		{"dummy.xz", false},
+		{"dummy.zst", false},
 	}
 	for _, tv := range data {
 		result := IsATarball(tv.candidate)
@@ -60,6 +66,10 @@ func TestIsATarball(t *testing.T) {
 }
 
 func TestFindFreePort(t *testing.T) {
+	// The expected results depend only on the ports given in the test data
+	savedCheckHostPorts := checkHostPorts
+	checkHostPorts = false
+	defer func() { checkHostPorts = savedCheckHostPorts }()
 	type testFreePort struct {
 		usedPorts []int
 		basePort  int
@@ -83,3 +93,62 @@ func TestFindFreePort(t *testing.T) {
 		compare.OkEqualInt(fmt.Sprintf("Free ports %v : %d:%d", d.usedPorts, d.basePort, d.howMany), d.expected, result, t)
 	}
 }
+
+func TestSandboxFilter(t *testing.T) {
+	type testFilter struct {
+		filter         SandboxFilter
+		name           string
+		sbd            SandboxDescription
+		hasDescription bool
+		expected       bool
+	}
+	group80 := SandboxDescription{SBType: "group", Version: "8.0.15"}
+	group57 := SandboxDescription{SBType: "group", Version: "5.7.25", Flavor: "percona"}
+	single80 := SandboxDescription{SBType: "single", Version: "8.0.15"}
+	var data = []testFilter{
+		{SandboxFilter{}, "msb_8_0_15", single80, true, true},
+		{SandboxFilter{}, "old_sandbox", SandboxDescription{}, false, true},
+		{SandboxFilter{Types: []string{"group"}}, "group_msb_8_0_15", group80, true, true},
+		{SandboxFilter{Types: []string{"group"}}, "msb_8_0_15", single80, true, false},
+		{SandboxFilter{Types: []string{"group"}}, "old_sandbox", SandboxDescription{}, false, false},
+		{SandboxFilter{Versions: []string{"8.0.*"}}, "group_msb_8_0_15", group80, true, true},
+		{SandboxFilter{Versions: []string{"8.0"}}, "group_msb_8_0_15", group80, true, true},
+		{SandboxFilter{Versions: []string{"8.0"}}, "group_msb_5_7_25", group57, true, false},
+		{SandboxFilter{Versions: []string{"8.0.15"}}, "msb_8_0_15", single80, true, true},
+		{SandboxFilter{Versions: []string{"8.0.1"}}, "msb_8_0_15", single80, true, false},
+		{SandboxFilter{Types: []string{"group"}, Versions: []string{"8.0.*"}}, "group_msb_5_7_25", group57, true, false},
+		{SandboxFilter{Flavors: []string{"percona"}}, "group_msb_5_7_25", group57, true, true},
+		{SandboxFilter{Flavors: []string{"mysql"}}, "group_msb_5_7_25", group57, true, false},
+		{SandboxFilter{Flavors: []string{"mysql"}}, "msb_8_0_15", single80, true, true},
+		{SandboxFilter{Names: []string{"group_*"}}, "group_msb_8_0_15", group80, true, true},
+		{SandboxFilter{Names: []string{"group_*"}}, "msb_8_0_15", single80, true, false},
+		{SandboxFilter{Names: []string{"old_*"}}, "old_sandbox", SandboxDescription{}, false, true},
+		{SandboxFilter{Excludes: []string{"*_5_7_*"}}, "group_msb_5_7_25", group57, true, false},
+		{SandboxFilter{Types: []string{"group"}, Excludes: []string{"*_5_7_*"}}, "group_msb_8_0_15", group80, true, true},
+	}
+	for _, d := range data {
+		result := d.filter.Matches(d.name, d.sbd, d.hasDescription)
+		compare.OkEqualBool(fmt.Sprintf("filter %+v on %s", d.filter, d.name), result, d.expected, t)
+	}
+}
+
+func TestFindFreePortOnHost(t *testing.T) {
+	savedCheckHostPorts := checkHostPorts
+	checkHostPorts = true
+	defer func() { checkHostPorts = savedCheckHostPorts }()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("could not open a listener: %s", err)
+	}
+	defer listener.Close()
+	busyPort := listener.Addr().(*net.TCPAddr).Port
+	compare.OkEqualBool(fmt.Sprintf("port %d free on host", busyPort), IsPortFreeOnHost(busyPort), false, t)
+	result, err := FindFreePort(busyPort, []int{}, 1)
+	compare.OkIsNil("FindFreePort result", err, t)
+	if result <= busyPort {
+		t.Logf("not ok - port %d returned although busy on host", result)
+		t.Fail()
+	} else {
+		t.Logf("ok - busy port %d skipped (found %d)", busyPort, result)
+	}
+}
@@ -0,0 +1,208 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+)
+
+// Length of the hexadecimal digest for each algorithm
+var checksumLengths = map[string]int{
+	ChecksumMD5:    32,
+	ChecksumSHA1:   40,
+	ChecksumSHA256: 64,
+	ChecksumSHA512: 128,
+}
+
+// Extensions of detached checksum files, in order of preference
+var checksumExtensions = []string{ChecksumSHA512, ChecksumSHA256, ChecksumSHA1, ChecksumMD5}
+
+// Extensions of detached signature files
+var signatureExtensions = []string{"asc", "sig"}
+
+var reHexDigest = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm '%s'", algorithm)
+}
+
+// Splits a checksum given as "algorithm:digest".
+// When the algorithm is missing, it is deduced from the digest length
+func ParseChecksum(checksum string) (algorithm, digest string, err error) {
+	checksum = strings.TrimSpace(checksum)
+	digest = checksum
+	if strings.Contains(checksum, ":") {
+		parts := strings.SplitN(checksum, ":", 2)
+		algorithm = strings.ToLower(parts[0])
+		digest = parts[1]
+	}
+	digest = strings.ToLower(digest)
+	if !reHexDigest.MatchString(digest) {
+		return "", "", fmt.Errorf("invalid checksum digest '%s'", digest)
+	}
+	if algorithm == "" {
+		for name, length := range checksumLengths {
+			if len(digest) == length {
+				algorithm = name
+			}
+		}
+		if algorithm == "" {
+			return "", "", fmt.Errorf("can't detect checksum algorithm for '%s'", checksum)
+		}
+	}
+	expectedLength, ok := checksumLengths[algorithm]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported checksum algorithm '%s'", algorithm)
+	}
+	if len(digest) != expectedLength {
+		return "", "", fmt.Errorf("%s digest should be %d characters long. Got %d", algorithm, expectedLength, len(digest))
+	}
+	return algorithm, digest, nil
+}
+
+// Returns the hexadecimal digest of a file
+func FileChecksum(fileName, algorithm string) (string, error) {
+	hasher, err := newChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = io.Copy(hasher, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Checks that a file matches the given checksum ("algorithm:digest")
+func VerifyChecksum(fileName, checksum string) error {
+	algorithm, expected, err := ParseChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	found, err := FileChecksum(fileName, algorithm)
+	if err != nil {
+		return err
+	}
+	if found != expected {
+		return fmt.Errorf("%s checksum mismatch for %s: expected %s - found %s", algorithm, fileName, expected, found)
+	}
+	return nil
+}
+
+// Reads the checksum for a file from a checksum file, as created by sha256sum, md5sum and the like.
+// Lines have the format "digest  file-name". A file containing only the digest is also accepted
+func ReadChecksumFile(checksumFile, algorithm, fileName string) (string, error) {
+	lines, err := SlurpAsLines(checksumFile)
+	if err != nil {
+		return "", err
+	}
+	baseName := path.Base(fileName)
+	var digests []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		digest := fields[0]
+		if len(fields) == 1 {
+			digests = append(digests, digest)
+			continue
+		}
+		// sha256sum marks binary files with a leading '*'
+		name := path.Base(strings.TrimPrefix(fields[len(fields)-1], "*"))
+		if name == baseName {
+			return algorithm + ":" + digest, nil
+		}
+	}
+	if len(digests) == 1 {
+		return algorithm + ":" + digests[0], nil
+	}
+	return "", fmt.Errorf("no checksum for %s found in %s", baseName, checksumFile)
+}
+
+// Verifies a file using a detached signature (.asc, .sig) or checksum file
+// (.sha512, .sha256, .sha1, .md5) found next to it.
+// Returns a description of the verification performed.
+func VerifyDetached(fileName string) (string, error) {
+	for _, extension := range signatureExtensions {
+		signatureFile := fileName + "." + extension
+		if FileExists(signatureFile) {
+			return signatureFile, VerifySignature(fileName, signatureFile)
+		}
+	}
+	for _, algorithm := range checksumExtensions {
+		checksumFile := fileName + "." + algorithm
+		if !FileExists(checksumFile) {
+			continue
+		}
+		checksum, err := ReadChecksumFile(checksumFile, algorithm, fileName)
+		if err != nil {
+			return checksumFile, err
+		}
+		return checksumFile, VerifyChecksum(fileName, checksum)
+	}
+	return "", fmt.Errorf("no signature or checksum file found for %s", fileName)
+}
+
+// Checks a detached GPG signature. The signer key must be in the user keyring
+func VerifySignature(fileName, signatureFile string) error {
+	gpg := FindInPath("gpg")
+	if gpg == "" {
+		gpg = FindInPath("gpg2")
+	}
+	if gpg == "" {
+		return fmt.Errorf("gpg not found in PATH. It is needed to verify %s", signatureFile)
+	}
+	output, err := exec.Command(gpg, "--batch", "--verify", signatureFile, fileName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("signature verification failed for %s: %s\n%s", fileName, err, output)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+// Digests of the string "dbdeployer\n"
+const (
+	testMd5    = "56b2be3496d198787aeecc6e702f0ecc"
+	testSha256 = "9fe21216392f28ea4d6ab1a4241cff21b0561683dc237476b2b9cd5f01bc99a7"
+)
+
+func TestParseChecksum(t *testing.T) {
+	type checksumTest struct {
+		checksum  string
+		algorithm string
+		valid     bool
+	}
+	var data = []checksumTest{
+		{"sha256:" + testSha256, ChecksumSHA256, true},
+		{"SHA256:" + testSha256, ChecksumSHA256, true},
+		{testSha256, ChecksumSHA256, true},
+		{"md5:" + testMd5, ChecksumMD5, true},
+		{testMd5, ChecksumMD5, true},
+		{"sha1:" + testMd5, "", false},
+		{"crc32:abcd", "", false},
+		{"sha256:not-a-digest", "", false},
+		{"abcd", "", false},
+	}
+	for _, ct := range data {
+		algorithm, _, err := ParseChecksum(ct.checksum)
+		compare.OkEqualBool("valid checksum "+ct.checksum, err == nil, ct.valid, t)
+		compare.OkEqualString("algorithm for "+ct.checksum, algorithm, ct.algorithm, t)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-checksum-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	err := os.MkdirAll(baseDir, 0755)
+	compare.OkIsNil("base directory creation", err, t)
+	fileName := path.Join(baseDir, "mysql-8.0.16.tar.xz")
+	err = WriteString("dbdeployer\n", fileName)
+	compare.OkIsNil("file creation", err, t)
+
+	md5Digest, err := FileChecksum(fileName, ChecksumMD5)
+	compare.OkIsNil("md5 checksum", err, t)
+	compare.OkEqualString("md5 digest", md5Digest, testMd5, t)
+	sha256Digest, err := FileChecksum(fileName, ChecksumSHA256)
+	compare.OkIsNil("sha256 checksum", err, t)
+	compare.OkEqualString("sha256 digest", sha256Digest, testSha256, t)
+	compare.OkIsNil("md5 verification", VerifyChecksum(fileName, "md5:"+testMd5), t)
+	compare.OkIsNil("sha256 verification", VerifyChecksum(fileName, testSha256), t)
+	compare.OkIsNotNil("wrong checksum rejected", VerifyChecksum(fileName, "md5:"+strings.Repeat("0", 32)), t)
+
+	_, err = VerifyDetached(fileName)
+	compare.OkIsNotNil("verification without checksum file rejected", err, t)
+	// sha256sum format, with other files listed
+	checksumText := fmt.Sprintf("%s  other-file.tar.gz\n%s *mysql-8.0.16.tar.xz\n", strings.Repeat("0", 64), testSha256)
+	err = WriteString(checksumText, fileName+".sha256")
+	compare.OkIsNil("sha256 file creation", err, t)
+	verifiedWith, err := VerifyDetached(fileName)
+	compare.OkIsNil("sha256 file verification", err, t)
+	compare.OkEqualString("verification file", verifiedWith, fileName+".sha256", t)
+
+	// A checksum file with only the digest
+	err = WriteString(testMd5, fileName+".md5")
+	compare.OkIsNil("md5 file creation", err, t)
+	err = os.Remove(fileName + ".sha256")
+	compare.OkIsNil("sha256 file removal", err, t)
+	_, err = VerifyDetached(fileName)
+	compare.OkIsNil("md5 file verification", err, t)
+
+	err = WriteString("dbdeployer - changed\n", fileName)
+	compare.OkIsNil("file change", err, t)
+	_, err = VerifyDetached(fileName)
+	compare.OkIsNotNil("changed file rejected", err, t)
+}
@@ -39,19 +39,30 @@ type SandboxUser struct {
 	Privileges  string `json:"privileges"`
 }
 
+// Descriptions of the database users created in a sandbox
+const (
+	UserPurposeAdmin       = "admin"
+	UserPurposeDatabase    = "database"
+	UserPurposeReplication = "replication"
+)
+
 type SandboxDescription struct {
-	Basedir           string `json:"basedir"`
-	ClientBasedir     string `json:"client_basedir,omitempty"`
-	SBType            string `json:"type"` // single multi master-slave group
-	Version           string `json:"version"`
-	Flavor            string `json:"flavor,omitempty"`
-	Port              []int  `json:"port"`
-	Nodes             int    `json:"nodes"`
-	NodeNum           int    `json:"node_num"`
-	DbDeployerVersion string `json:"dbdeployer-version"`
-	Timestamp         string `json:"timestamp"`
-	CommandLine       string `json:"command-line"`
-	LogFile           string `json:"log-file,omitempty"`
+	Basedir           string        `json:"basedir"`
+	ClientBasedir     string        `json:"client_basedir,omitempty"`
+	SBType            string        `json:"type"` // single multi master-slave group
+	Version           string        `json:"version"`
+	Flavor            string        `json:"flavor,omitempty"`
+	Port              []int         `json:"port"`
+	Nodes             int           `json:"nodes"`
+	NodeNum           int           `json:"node_num"`
+	DbDeployerVersion string        `json:"dbdeployer-version"`
+	Timestamp         string        `json:"timestamp"`
+	CommandLine       string        `json:"command-line"`
+	CommandArgs       []string      `json:"command-args,omitempty"`
+	LogFile           string        `json:"log-file,omitempty"`
+	Role              string        `json:"role,omitempty"`
+	Hooks             []string      `json:"hooks,omitempty"`
+	Users             []SandboxUser `json:"users,omitempty"`
 }
 
 type KeyValue struct {
@@ -151,6 +162,7 @@ func WriteSandboxDescription(destination string, sd SandboxDescription) error {
 	sd.DbDeployerVersion = VersionDef
 	sd.Timestamp = time.Now().Format(time.UnixDate)
 	sd.CommandLine = strings.Join(CommandLineArgs, " ")
+	sd.CommandArgs = CommandLineArgs
 	b, err := json.MarshalIndent(sd, " ", "\t")
 	if err != nil {
 		return errors.Wrapf(err, "error encoding sandbox description")
@@ -313,20 +325,25 @@ func FindInPath(filename string) string {
 
 // Runs a command with arguments
 func RunCmdWithArgs(c string, args []string) (string, error) {
+	return RunCmdWithArgsCtrl(c, args, false)
+}
+
+// Runs a command with arguments, with optional quiet output
+func RunCmdWithArgsCtrl(c string, args []string, silent bool) (string, error) {
 	cmd := exec.Command(c, args...)
-	//var out bytes.Buffer
-	//var stderr bytes.Buffer
-	//cmd.Stdout = &out
-	//cmd.Stderr = &stderr
 	var out []byte
 	var err error
 	out, err = cmd.Output()
 	if err != nil {
-		CondPrintf("err: %s\n", err)
-		CondPrintf("cmd: %s %s\n", c, args)
-		CondPrintf("stdout: %s\n", out)
+		if !silent {
+			CondPrintf("err: %s\n", err)
+			CondPrintf("cmd: %s %s\n", c, args)
+			CondPrintf("stdout: %s\n", out)
+		}
 	} else {
-		CondPrintf("%s", out)
+		if !silent {
+			CondPrintf("%s", out)
+		}
 	}
 	return string(out), err
 }
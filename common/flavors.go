@@ -0,0 +1,295 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// A file that identifies a flavor or an operating system in an expanded tarball
+type FlavorFile struct {
+	Dir      string `json:"dir"`
+	FileName string `json:"file-name"`
+	// Operating system for which the file is expected: "linux", "darwin", or "any"
+	OS string `json:"os,omitempty"`
+}
+
+// Describes how to recognize a flavor and what it can do
+type FlavorDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Files whose presence in the tarball identifies this flavor
+	DetectionFiles []FlavorFile `json:"detection-files,omitempty"`
+	// Files that show the tarball contains binaries for a given operating system
+	BinaryFiles []FlavorFile `json:"binary-files,omitempty"`
+	// Regular expressions matching the tarball names for this flavor
+	TarballPatterns []string `json:"tarball-patterns,omitempty"`
+	// Regular expressions matching the version output of the server binary
+	VersionPatterns []string `json:"version-patterns,omitempty"`
+	// Command, relative to the base directory, that shows the server version.
+	// Defaults to "bin/mysqld --version"
+	VersionCommand []string `json:"version-command,omitempty"`
+	// Flavor from which the capabilities are inherited
+	BaseFlavor string `json:"base-flavor,omitempty"`
+	// Capabilities added to (or replacing) the ones of the base flavor
+	Capabilities FeatureList `json:"capabilities,omitempty"`
+	// Collection of templates replacing the single sandbox ones (e.g. "tidb")
+	TemplateSet string `json:"template-set,omitempty"`
+	// The tarball has no client, which must be taken from another one (--client-from)
+	NeedsClient bool `json:"needs-client,omitempty"`
+	// The flavor can be recognized, but not deployed
+	Unsupported bool `json:"unsupported,omitempty"`
+}
+
+// Built-in flavors. The order matters for detection: more specific
+// flavors must come before the generic MySQL one
+var builtinFlavors = []FlavorDefinition{
+	{
+		Name:            NDBFlavor,
+		Description:     "MySQL NDB Cluster",
+		TarballPatterns: []string{`mysql-cluster`},
+		VersionPatterns: []string{`-ndb-`},
+		Unsupported:     true,
+	},
+	{
+		Name:        PerconaServerFlavor,
+		Description: "Percona Server",
+		DetectionFiles: []FlavorFile{
+			{"lib", "libperconaserverclient.a", ""},
+			{"lib", "libperconaserverclient.so", ""},
+			{"lib", "libperconaserverclient.dylib", ""},
+		},
+		BinaryFiles: []FlavorFile{
+			{"lib", "libperconaserverclient.so", "linux"},
+			{"lib", "libperconaserverclient.dylib", "darwin"},
+		},
+		TarballPatterns: []string{`Percona-Server`},
+		VersionPatterns: []string{`Percona Server`},
+	},
+	{
+		Name:        MariaDbFlavor,
+		Description: "MariaDB",
+		DetectionFiles: []FlavorFile{
+			{"bin", "aria_chk", ""},
+			{"lib", "libmariadbclient.a", ""},
+			{"lib", "libmariadb.a", ""},
+			{"lib", "libmariadb.dylib", ""},
+		},
+		TarballPatterns: []string{`mariadb`},
+		VersionPatterns: []string{`MariaDB`},
+	},
+	{
+		Name:            TiDbFlavor,
+		Description:     "TiDB",
+		DetectionFiles:  []FlavorFile{{"bin", "tidb-server", ""}},
+		BinaryFiles:     []FlavorFile{{"bin", "tidb-server", "any"}},
+		TarballPatterns: []string{`tidb`},
+		VersionPatterns: []string{`Release Version:`},
+		VersionCommand:  []string{"bin/tidb-server", "-V"},
+		TemplateSet:     "tidb",
+		NeedsClient:     true,
+	},
+	{
+		Name:        MySQLFlavor,
+		Description: "MySQL Community and Enterprise server",
+		BinaryFiles: []FlavorFile{
+			{"lib", "libmysqlclient.a", "linux"}, // 4.1 and old 5.0 releases
+			{"lib", "libmysqlclient.so", "linux"},
+			{"lib", "libmysqlclient.dylib", "darwin"},
+		},
+		TarballPatterns: []string{`mysql`},
+		VersionPatterns: []string{`MySQL`},
+	},
+}
+
+var (
+	// Flavors loaded from file come first, so that they can override the built-in ones
+	flavorRegistry = append([]FlavorDefinition{}, builtinFlavors...)
+	flavorMutex    sync.Mutex
+)
+
+// Returns the registered flavors, in detection order
+func FlavorDefinitions() []FlavorDefinition {
+	flavorMutex.Lock()
+	defer flavorMutex.Unlock()
+	return append([]FlavorDefinition{}, flavorRegistry...)
+}
+
+// Returns the definition of a flavor
+func FindFlavor(name string) (FlavorDefinition, bool) {
+	for _, def := range FlavorDefinitions() {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return FlavorDefinition{}, false
+}
+
+// Adds a flavor to the registry, replacing any definition with the same name.
+// The new flavor takes precedence over the existing ones during detection.
+func RegisterFlavor(def FlavorDefinition) error {
+	if def.Name == "" {
+		return fmt.Errorf("flavor definition without name")
+	}
+	for _, pattern := range append(def.TarballPatterns, def.VersionPatterns...) {
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("flavor %s: invalid pattern '%s': %s", def.Name, pattern, err)
+		}
+	}
+	var features = make(FeatureList)
+	if def.BaseFlavor != "" {
+		base, ok := AllCapabilities[def.BaseFlavor]
+		if !ok {
+			return fmt.Errorf("flavor %s: base flavor '%s' not found", def.Name, def.BaseFlavor)
+		}
+		for name, capability := range base.Features {
+			features[name] = capability
+		}
+	}
+	for name, capability := range def.Capabilities {
+		features[name] = capability
+	}
+
+	flavorMutex.Lock()
+	defer flavorMutex.Unlock()
+	var newRegistry = []FlavorDefinition{def}
+	for _, existing := range flavorRegistry {
+		if existing.Name != def.Name {
+			newRegistry = append(newRegistry, existing)
+		}
+	}
+	flavorRegistry = newRegistry
+	AllCapabilities[def.Name] = Capabilities{Flavor: def.Name, Features: features}
+	return nil
+}
+
+// Reads a list of flavor definitions from a JSON file and adds them to the registry
+func LoadFlavors(fileName string) error {
+	contents, err := SlurpAsBytes(fileName)
+	if err != nil {
+		return err
+	}
+	var definitions []FlavorDefinition
+	err = json.Unmarshal(contents, &definitions)
+	if err != nil {
+		return fmt.Errorf("error decoding flavors file %s: %s", fileName, err)
+	}
+	// Registering in reverse order keeps the first definition in the file
+	// at the top of the detection order
+	for N := len(definitions) - 1; N >= 0; N-- {
+		err = RegisterFlavor(definitions[N])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tries to detect the database flavor from tarball name
+func DetectTarballFlavor(tarballName string) string {
+	for _, def := range FlavorDefinitions() {
+		for _, pattern := range def.TarballPatterns {
+			re, err := regexp.Compile(pattern)
+			if err == nil && re.MatchString(tarballName) {
+				return def.Name
+			}
+		}
+	}
+	return ""
+}
+
+// Tries to detect the database flavor from files in the tarball directory
+func DetectBinaryFlavor(basedir string) string {
+	for _, def := range FlavorDefinitions() {
+		for _, fi := range def.DetectionFiles {
+			if FileExists(path.Join(basedir, fi.Dir, fi.FileName)) {
+				return def.Name
+			}
+		}
+	}
+	return MySQLFlavor
+}
+
+func CheckFlavorSupport(flavor string) error {
+	def, found := FindFlavor(flavor)
+	if !found || def.Unsupported {
+		return fmt.Errorf("flavor '%s' is not supported", flavor)
+	}
+	return nil
+}
+
+var defaultVersionCommand = []string{"bin/mysqld", "--version"}
+
+// Matches "Ver 8.0.16" (mysqld) and "Release Version: v3.0.0" (tidb-server).
+// NDB servers report both versions, as in "Ver 5.7.25-ndb-7.6.9": the NDB one is used.
+var reServerVersion = regexp.MustCompile(`(?:Ver|Release Version:)\s+v?(\d+\.\d+\.\d+)(?:-ndb-(\d+\.\d+\.\d+))?`)
+
+// Extracts version and flavor from the output of a server binary version command.
+// The flavor is empty when the output does not match any registered flavor
+func ParseServerVersion(output string) (version, flavor string, err error) {
+	matches := reServerVersion.FindStringSubmatch(output)
+	if matches == nil {
+		return "", "", fmt.Errorf("no version found in server output '%s'", strings.TrimSpace(output))
+	}
+	version = matches[1]
+	if matches[2] != "" {
+		version = matches[2]
+	}
+	for _, def := range FlavorDefinitions() {
+		for _, pattern := range def.VersionPatterns {
+			re, err := regexp.Compile(pattern)
+			if err == nil && re.MatchString(output) {
+				return version, def.Name, nil
+			}
+		}
+	}
+	return version, "", nil
+}
+
+// Runs the server binary found in basedir to get its version and flavor
+func DetectServerVersion(basedir string) (version, flavor string, err error) {
+	var commands [][]string
+	var seen = make(map[string]bool)
+	for _, def := range FlavorDefinitions() {
+		command := def.VersionCommand
+		if len(command) == 0 {
+			command = defaultVersionCommand
+		}
+		key := strings.Join(command, " ")
+		if !seen[key] {
+			seen[key] = true
+			commands = append(commands, command)
+		}
+	}
+	for _, command := range commands {
+		executable := path.Join(basedir, command[0])
+		if !ExecExists(executable) {
+			continue
+		}
+		output, err := RunCmdWithArgsCtrl(executable, command[1:], true)
+		if err != nil {
+			return "", "", fmt.Errorf("error running %s %s: %s", executable, strings.Join(command[1:], " "), err)
+		}
+		return ParseServerVersion(output)
+	}
+	return "", "", fmt.Errorf("no server binary found in %s", basedir)
+}
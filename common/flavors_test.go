@@ -0,0 +1,150 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestDetectTarballFlavor(t *testing.T) {
+	var data = map[string]string{
+		"mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz":             MySQLFlavor,
+		"mysql-cluster-gpl-7.6.10-linux-glibc2.12-x86_64.tar.gz": NDBFlavor,
+		"Percona-Server-8.0.15-5-Linux.x86_64.ssl102.tar.gz":     PerconaServerFlavor,
+		"mariadb-10.3.13-linux-x86_64.tar.gz":                    MariaDbFlavor,
+		"tidb-master-darwin-amd64.tar.gz":                        TiDbFlavor,
+		"postgresql-11.2.tar.gz":                                 "",
+	}
+	for tarball, expected := range data {
+		compare.OkEqualString("flavor for "+tarball, DetectTarballFlavor(tarball), expected, t)
+	}
+}
+
+func TestLoadFlavors(t *testing.T) {
+	savedRegistry := flavorRegistry
+	defer func() {
+		flavorRegistry = savedRegistry
+		delete(AllCapabilities, "acme")
+	}()
+
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-flavors-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	err := os.MkdirAll(path.Join(baseDir, "lib"), 0755)
+	compare.OkIsNil("base directory creation", err, t)
+
+	flavorsFile := path.Join(baseDir, "flavors.json")
+	flavors := `[
+  {
+    "name": "acme",
+    "description": "patched Percona Server",
+    "detection-files": [ {"dir": "lib", "file-name": "libacme.so"} ],
+    "tarball-patterns": [ "Percona-Server-.*-acme" ],
+    "base-flavor": "percona",
+    "capabilities": {
+      "acmeFeature": { "description": "ACME extension", "since": "8.0.15" },
+      "GTID": { "description": "Global transaction identifiers", "since": [5, 7, 0] }
+    }
+  }
+]`
+	err = WriteString(flavors, flavorsFile)
+	compare.OkIsNil("flavors file", err, t)
+	err = LoadFlavors(flavorsFile)
+	compare.OkIsNil("flavors loading", err, t)
+
+	compare.OkEqualString("tarball with custom flavor",
+		DetectTarballFlavor("Percona-Server-8.0.15-5-acme-Linux.x86_64.tar.gz"), "acme", t)
+	compare.OkEqualString("tarball with built-in flavor",
+		DetectTarballFlavor("Percona-Server-8.0.15-5-Linux.x86_64.tar.gz"), PerconaServerFlavor, t)
+
+	compare.OkEqualString("binary flavor without custom files", DetectBinaryFlavor(baseDir), MySQLFlavor, t)
+	err = WriteString("", path.Join(baseDir, "lib", "libperconaserverclient.so"))
+	compare.OkIsNil("percona library", err, t)
+	compare.OkEqualString("binary flavor with percona files", DetectBinaryFlavor(baseDir), PerconaServerFlavor, t)
+	err = WriteString("", path.Join(baseDir, "lib", "libacme.so"))
+	compare.OkIsNil("custom library", err, t)
+	compare.OkEqualString("binary flavor with custom files", DetectBinaryFlavor(baseDir), "acme", t)
+
+	compare.OkIsNil("custom flavor supported", CheckFlavorSupport("acme"), t)
+	if CheckFlavorSupport(NDBFlavor) == nil {
+		t.Logf("not ok - flavor %s should not be supported", NDBFlavor)
+		t.Fail()
+	}
+
+	type capabilityTest struct {
+		feature  string
+		version  string
+		expected bool
+	}
+	var capabilities = []capabilityTest{
+		{"acmeFeature", "8.0.14", false},
+		{"acmeFeature", "8.0.15", true},
+		{GTID, "5.6.40", false},
+		{GTID, "5.7.0", true},
+		{SemiSynch, "5.5.40", true},
+		{Roles, "8.0.16", true},
+	}
+	for _, ct := range capabilities {
+		result, err := HasCapability("acme", ct.feature, ct.version)
+		compare.OkIsNil("capability "+ct.feature, err, t)
+		compare.OkEqualBool(fmt.Sprintf("acme %s %s", ct.feature, ct.version), result, ct.expected, t)
+	}
+
+	err = RegisterFlavor(FlavorDefinition{Name: "broken", BaseFlavor: "no-such-flavor"})
+	if err == nil {
+		t.Logf("not ok - flavor with unknown base accepted")
+		t.Fail()
+	}
+}
+
+func TestParseServerVersion(t *testing.T) {
+	type versionOutput struct {
+		output  string
+		version string
+		flavor  string
+	}
+	var data = []versionOutput{
+		{"/opt/mysql/8.0.16/bin/mysqld  Ver 8.0.16 for linux-glibc2.12 on x86_64 (MySQL Community Server - GPL)",
+			"8.0.16", MySQLFlavor},
+		{"mysqld  Ver 5.7.25 for linux-glibc2.12 on x86_64 (MySQL Community Server (GPL))",
+			"5.7.25", MySQLFlavor},
+		{"mysqld  Ver 8.0.15-5 for Linux on x86_64 (Percona Server (GPL), Release 5, Revision 0b4ed1c)",
+			"8.0.15", PerconaServerFlavor},
+		{"mysqld  Ver 10.3.13-MariaDB for Linux on x86_64 (MariaDB Server)",
+			"10.3.13", MariaDbFlavor},
+		{"mysqld  Ver 5.7.25-ndb-7.6.9 for linux-glibc2.12 on x86_64 (MySQL Community Server (GPL))",
+			"7.6.9", NDBFlavor},
+		{"Release Version: v3.0.0-rc.1-105-g5a4bd7f\nGit Commit Hash: 5a4bd7f\nGit Branch: master\n",
+			"3.0.0", TiDbFlavor},
+		{"mysqld  Ver 8.0.16 for Linux on x86_64 (Source distribution)",
+			"8.0.16", ""},
+	}
+	for _, vo := range data {
+		version, flavor, err := ParseServerVersion(vo.output)
+		compare.OkIsNil("version parsing", err, t)
+		compare.OkEqualString("version from "+vo.output, version, vo.version, t)
+		compare.OkEqualString("flavor from "+vo.output, flavor, vo.flavor, t)
+	}
+	_, _, err := ParseServerVersion("mysqld: unknown option '--version'")
+	if err == nil {
+		t.Logf("not ok - version detected from invalid output")
+		t.Fail()
+	}
+}
@@ -0,0 +1,235 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"github.com/datacharmer/dbdeployer/globals"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+	StatusPartial = "partial"
+)
+
+type NodeStatus struct {
+	Name    string        `json:"name"`
+	Dir     string        `json:"dir"`
+	Port    int           `json:"port"`
+	Socket  string        `json:"socket"`
+	PidFile string        `json:"pid-file"`
+	Datadir string        `json:"datadir"`
+	Pid     int           `json:"pid"`
+	Running bool          `json:"running"`
+	Uptime  time.Duration `json:"uptime-ns"`
+}
+
+type SandboxStatus struct {
+	State    string        `json:"state"`
+	Nodes    []NodeStatus  `json:"nodes"`
+	Uptime   time.Duration `json:"uptime-ns"`
+	DataSize int64         `json:"data-size"`
+}
+
+// Returns the directories of the nodes belonging to a sandbox.
+// A single sandbox is its own node.
+func GetSandboxNodeDirs(sandboxDir string) ([]string, error) {
+	if FileExists(path.Join(sandboxDir, globals.ScriptMySandboxCnf)) {
+		return []string{sandboxDir}, nil
+	}
+	files, err := ioutil.ReadDir(sandboxDir)
+	if err != nil {
+		return []string{}, err
+	}
+	var nodeDirs []string
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		if FileExists(path.Join(sandboxDir, f.Name(), globals.ScriptMySandboxCnf)) {
+			nodeDirs = append(nodeDirs, path.Join(sandboxDir, f.Name()))
+		}
+	}
+	return nodeDirs, nil
+}
+
+// Reads port, socket, pid file and data directory from a node configuration file
+func readNodeConfig(nodeDir string) (NodeStatus, error) {
+	node := NodeStatus{Name: BaseName(nodeDir), Dir: nodeDir}
+	config, err := ParseConfigFile(path.Join(nodeDir, globals.ScriptMySandboxCnf))
+	if err != nil {
+		return node, err
+	}
+	for _, kv := range config["mysqld"] {
+		value := strings.TrimSpace(kv.Value)
+		switch kv.Key {
+		case "port":
+			node.Port, _ = strconv.Atoi(value)
+		case "socket":
+			node.Socket = value
+		case "pid-file":
+			node.PidFile = value
+		case "datadir":
+			node.Datadir = value
+		}
+	}
+	return node, nil
+}
+
+// Returns true if a process with the given PID exists
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+// Returns true if the given port accepts TCP connections on the local host
+func isPortListening(port int) bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// Checks whether a node is running, using its pid file and socket.
+// When ping is set, the node port must also accept connections.
+func GetNodeStatus(nodeDir string, ping bool) (NodeStatus, error) {
+	node, err := readNodeConfig(nodeDir)
+	if err != nil {
+		return node, err
+	}
+	if node.PidFile == "" || !FileExists(node.PidFile) {
+		return node, nil
+	}
+	pidText, err := SlurpAsString(node.PidFile)
+	if err != nil {
+		return node, nil
+	}
+	node.Pid, _ = strconv.Atoi(strings.TrimSpace(pidText))
+	node.Running = isProcessAlive(node.Pid)
+	if node.Running && node.Socket != "" {
+		node.Running = FileExists(node.Socket)
+	}
+	if node.Running && ping && node.Port > 0 {
+		node.Running = isPortListening(node.Port)
+	}
+	if node.Running {
+		stat, err := os.Stat(node.PidFile)
+		if err == nil {
+			node.Uptime = time.Since(stat.ModTime())
+		}
+	}
+	return node, nil
+}
+
+// Returns the total size in bytes of the files under a directory
+func DirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Collects the runtime status of all the nodes in a sandbox
+func GetSandboxStatus(sandboxDir string, ping bool) (SandboxStatus, error) {
+	status := SandboxStatus{State: StatusStopped}
+	nodeDirs, err := GetSandboxNodeDirs(sandboxDir)
+	if err != nil {
+		return status, err
+	}
+	running := 0
+	for _, nodeDir := range nodeDirs {
+		node, err := GetNodeStatus(nodeDir, ping)
+		if err != nil {
+			return status, err
+		}
+		if node.Running {
+			running++
+			if node.Uptime > status.Uptime {
+				status.Uptime = node.Uptime
+			}
+		}
+		if node.Datadir != "" {
+			status.DataSize += DirSize(node.Datadir)
+		}
+		status.Nodes = append(status.Nodes, node)
+	}
+	if running > 0 {
+		if running == len(nodeDirs) {
+			status.State = StatusRunning
+		} else {
+			status.State = StatusPartial
+		}
+	}
+	return status, nil
+}
+
+// Returns a size in bytes as a short human readable string
+func HumanSize(size int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+// Returns a duration as a compact string, such as "2d3h" or "5m12s"
+func HumanDuration(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	d = d.Round(time.Second)
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	seconds := int(d.Seconds()) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	case minutes > 0:
+		return fmt.Sprintf("%dm%ds", minutes, seconds)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
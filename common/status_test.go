@@ -0,0 +1,131 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func makeTestNode(t *testing.T, nodeDir string, port int, running bool) {
+	datadir := path.Join(nodeDir, "data")
+	err := os.MkdirAll(datadir, 0755)
+	compare.OkIsNil("node directory creation", err, t)
+	socket := path.Join(nodeDir, "mysql.sock")
+	pidFile := path.Join(nodeDir, "mysql.pid")
+	config := fmt.Sprintf("[mysqld]\nport = %d\nsocket = %s\npid-file = %s\ndatadir = %s\n",
+		port, socket, pidFile, datadir)
+	err = WriteString(config, path.Join(nodeDir, globals.ScriptMySandboxCnf))
+	compare.OkIsNil("node configuration", err, t)
+	err = WriteString("1234567890", path.Join(datadir, "ibdata1"))
+	compare.OkIsNil("data file", err, t)
+	if running {
+		err = WriteString(fmt.Sprintf("%d", os.Getpid()), pidFile)
+		compare.OkIsNil("pid file", err, t)
+		err = WriteString("", socket)
+		compare.OkIsNil("socket", err, t)
+	}
+}
+
+func TestGetSandboxStatus(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-status-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+
+	type statusTest struct {
+		name     string
+		nodes    []bool
+		expected string
+	}
+	var data = []statusTest{
+		{"single_running", []bool{true}, StatusRunning},
+		{"single_stopped", []bool{false}, StatusStopped},
+		{"multi_running", []bool{true, true, true}, StatusRunning},
+		{"multi_partial", []bool{true, false, true}, StatusPartial},
+		{"multi_stopped", []bool{false, false}, StatusStopped},
+	}
+	for _, st := range data {
+		sandboxDir := path.Join(baseDir, st.name)
+		if len(st.nodes) == 1 {
+			makeTestNode(t, sandboxDir, 5000, st.nodes[0])
+		} else {
+			for i, running := range st.nodes {
+				makeTestNode(t, path.Join(sandboxDir, fmt.Sprintf("node%d", i+1)), 5000+i, running)
+			}
+		}
+		status, err := GetSandboxStatus(sandboxDir, false)
+		compare.OkIsNil("sandbox status "+st.name, err, t)
+		compare.OkEqualString("state "+st.name, status.State, st.expected, t)
+		compare.OkEqualInt("nodes "+st.name, len(status.Nodes), len(st.nodes), t)
+		compare.OkEqualInt("data size "+st.name, int(status.DataSize), 10*len(st.nodes), t)
+		if st.expected != StatusStopped && status.Uptime < 0 {
+			t.Logf("not ok - negative uptime for %s", st.name)
+			t.Fail()
+		}
+	}
+}
+
+func TestHumanFormats(t *testing.T) {
+	var sizes = map[int64]string{
+		0:                  "0B",
+		512:                "512B",
+		2048:               "2.0K",
+		1024 * 1024 * 3:    "3.0M",
+		1024 * 1024 * 1536: "1.5G",
+	}
+	for size, expected := range sizes {
+		compare.OkEqualString(fmt.Sprintf("size %d", size), HumanSize(size), expected, t)
+	}
+	var durations = map[time.Duration]string{
+		0:                             "-",
+		42 * time.Second:              "42s",
+		3*time.Minute + 5*time.Second: "3m5s",
+		2*time.Hour + 10*time.Minute:  "2h10m",
+		50 * time.Hour:                "2d2h",
+	}
+	for d, expected := range durations {
+		compare.OkEqualString(fmt.Sprintf("duration %s", d), HumanDuration(d), expected, t)
+	}
+}
+
+func TestSandboxStatusJson(t *testing.T) {
+	status := SandboxStatus{
+		State:    StatusPartial,
+		Uptime:   2 * time.Second,
+		DataSize: 10,
+		Nodes: []NodeStatus{
+			{Name: "node1", Port: 5001, Running: true, Uptime: 2 * time.Second},
+			{Name: "node2", Port: 5002},
+		},
+	}
+	text, err := json.Marshal(status)
+	compare.OkIsNil("status encoding", err, t)
+	var decoded map[string]interface{}
+	err = json.Unmarshal(text, &decoded)
+	compare.OkIsNil("status decoding", err, t)
+	compare.OkEqualString("state", decoded["state"].(string), StatusPartial, t)
+	compare.OkEqualInt("uptime", int(decoded["uptime-ns"].(float64)), int(2*time.Second), t)
+	compare.OkEqualInt("data size", int(decoded["data-size"].(float64)), 10, t)
+	nodes := decoded["nodes"].([]interface{})
+	compare.OkEqualInt("nodes", len(nodes), 2, t)
+	compare.OkEqualBool("first node running", nodes[0].(map[string]interface{})["running"].(bool), true, t)
+	compare.OkEqualInt("second node port", int(nodes[1].(map[string]interface{})["port"].(float64)), 5002, t)
+}
@@ -256,6 +256,25 @@ func AddToCleanupStack(cf CleanupFunc, funcName, arg string) {
 	cleanupActions.Push(CleanupRec{f: cf, label: funcName, target: arg})
 }
 
+// Removes the clean-up operations without running them,
+// when the operation that registered them has succeeded
+func ClearCleanupActions() {
+	cleanupActions.Reset()
+}
+
+// Returns the number of clean-up operations registered so far
+func CleanupActionsCount() int {
+	return cleanupActions.Len()
+}
+
+// Removes, without running them, the clean-up operations registered
+// after the first 'count' ones. The ones registered before are kept
+func DiscardCleanupActions(count int) {
+	for cleanupActions.Len() > count {
+		cleanupActions.Pop()
+	}
+}
+
 // Runs the cleanup actions (usually before Exit)
 func RunCleanupActions() {
 	if cleanupActions.Len() > 0 {
@@ -321,3 +340,55 @@ func LatestVersion(searchDir, pattern string) string {
 	}
 	return ""
 }
+
+// Restores the characters escaped by the MySQL client in batch mode
+func unescapeBatchValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var result strings.Builder
+	escaped := false
+	for _, c := range value {
+		if !escaped {
+			if c == '\\' {
+				escaped = true
+				continue
+			}
+			result.WriteRune(c)
+			continue
+		}
+		escaped = false
+		switch c {
+		case 't':
+			result.WriteRune('\t')
+		case 'n':
+			result.WriteRune('\n')
+		case '0':
+			result.WriteRune(0)
+		default:
+			result.WriteRune(c)
+		}
+	}
+	return result.String()
+}
+
+// Parses the output of a query run with the MySQL client in batch mode (-B).
+// Returns the column names and the rows
+func ParseBatchOutput(text string) (columns []string, rows [][]string) {
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return
+	}
+	for N, line := range strings.Split(text, "\n") {
+		var values []string
+		for _, value := range strings.Split(line, "\t") {
+			values = append(values, unescapeBatchValue(value))
+		}
+		if N == 0 {
+			columns = values
+		} else {
+			rows = append(rows, values)
+		}
+	}
+	return
+}
@@ -226,3 +226,24 @@ func TestIncludes(t *testing.T) {
 		compare.OkEqualBool(fmt.Sprintf("'%s' includes '%s'", d.mainStr, d.searchStr), result, d.expected, t)
 	}
 }
+
+func TestParseBatchOutput(t *testing.T) {
+	type batchData struct {
+		text            string
+		expectedColumns []string
+		expectedRows    [][]string
+	}
+	var data = []batchData{
+		{"", nil, nil},
+		{"@@version\n8.0.15\n", []string{"@@version"}, [][]string{{"8.0.15"}}},
+		{"@@port\t@@server_id\n5000\t100\n", []string{"@@port", "@@server_id"}, [][]string{{"5000", "100"}}},
+		{"id\tname\n1\tone\n2\ttwo", []string{"id", "name"}, [][]string{{"1", "one"}, {"2", "two"}}},
+		{"gtid\n00000000-0000:1-5,\\n11111111-1111:1-3\n", []string{"gtid"}, [][]string{{"00000000-0000:1-5,\n11111111-1111:1-3"}}},
+		{"txt\na\\tb\\\\c\n", []string{"txt"}, [][]string{{"a\tb\\c"}}},
+	}
+	for _, d := range data {
+		columns, rows := ParseBatchOutput(d.text)
+		compare.OkEqualString(fmt.Sprintf("columns for %q", d.text), fmt.Sprintf("%q", columns), fmt.Sprintf("%q", d.expectedColumns), t)
+		compare.OkEqualString(fmt.Sprintf("rows for %q", d.text), fmt.Sprintf("%q", rows), fmt.Sprintf("%q", d.expectedRows), t)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 )
@@ -37,6 +38,13 @@ type ExecCommand struct {
 	Cmd    string
 	Args   []string
 	Tracer Trace
+	// Environment for the command. When empty, the command inherits the current environment
+	Env []string
+	// Working directory for the command. When empty, the command runs in the current directory
+	Dir string
+	// When set, a failure of the command is returned by RunParallelTasksByPriority,
+	// and the tasks with a higher priority are not executed
+	StopOnError bool
 }
 
 type ExecCommands []ExecCommand
@@ -50,23 +58,51 @@ type ExecutionList struct {
 var DebugConcurrency bool
 var VerboseConcurrency bool
 
-func addTask(num int, wg *sync.WaitGroup, tasks CommonChan, cmd string, args []string) {
+type task struct {
+	cmd         *exec.Cmd
+	stopOnError bool
+}
+
+type taskErrors struct {
+	sync.Mutex
+	errors []string
+}
+
+func (te *taskErrors) add(message string) {
+	te.Lock()
+	defer te.Unlock()
+	te.errors = append(te.errors, message)
+}
+
+func addTask(num int, wg *sync.WaitGroup, tasks chan task, failures *taskErrors, ec ExecCommand) {
 	wg.Add(1)
-	go startTask(num, wg, tasks)
-	tasks <- exec.Command(cmd, args...)
+	go startTask(num, wg, tasks, failures)
+	command := exec.Command(ec.Cmd, ec.Args...)
+	if len(ec.Env) > 0 {
+		command.Env = ec.Env
+	}
+	command.Dir = ec.Dir
+	tasks <- task{cmd: command, stopOnError: ec.StopOnError}
 }
 
-func startTask(num int, w *sync.WaitGroup, tasks CommonChan) {
+func startTask(num int, w *sync.WaitGroup, tasks chan task, failures *taskErrors) {
 	defer w.Done()
 	var (
 		out []byte
 		err error
 	)
-	for cmd := range tasks { // this will exit the loop when the channel closes
-		out, err = cmd.Output()
+	for t := range tasks { // this will exit the loop when the channel closes
+		out, err = t.cmd.Output()
 		if err != nil {
 			fmt.Printf("Error executing goroutine %d : %s", num, err)
 			//os.Exit(1)
+			if t.stopOnError {
+				message := fmt.Sprintf("%s: %s", t.cmd.Path, err)
+				if exitError, ok := err.(*exec.ExitError); ok && len(exitError.Stderr) > 0 {
+					message += fmt.Sprintf(" (%s)", strings.TrimSpace(string(exitError.Stderr)))
+				}
+				failures.add(message)
+			}
 		}
 		if DebugConcurrency {
 			fmt.Printf("goroutine %d command output: %s", num, string(out))
@@ -80,19 +116,24 @@ func startTask(num int, w *sync.WaitGroup, tasks CommonChan) {
 
 // Run several tasks in parallel
 
-func runParallelTasks(priorityLevel int, operations ExecCommands) {
-	tasks := make(CommonChan, 64)
+func runParallelTasks(priorityLevel int, operations ExecCommands) error {
+	tasks := make(chan task, 64)
 
 	var wg sync.WaitGroup
+	var failures taskErrors
 
 	for N, ec := range operations {
-		addTask(N, &wg, tasks, ec.Cmd, ec.Args)
+		addTask(N, &wg, tasks, &failures, ec)
 	}
 	close(tasks)
 	wg.Wait()
 	if VerboseConcurrency {
 		fmt.Printf("#%d\n", priorityLevel)
 	}
+	if len(failures.errors) > 0 {
+		return fmt.Errorf("error executing tasks with priority %d: %s", priorityLevel, strings.Join(failures.errors, "; "))
+	}
+	return nil
 }
 
 /*
@@ -132,10 +173,12 @@ func runParallelTasks(priorityLevel int, operations ExecCommands) {
 	}
 */
 
-func RunParallelTasksByPriority(execLists []ExecutionList) {
+// Commands marked with StopOnError interrupt the execution when they fail:
+// the tasks with the same priority are completed, and the error is returned.
+func RunParallelTasksByPriority(execLists []ExecutionList) error {
 	maxPriority := 0
 	if len(execLists) == 0 {
-		return
+		return nil
 	}
 	if DebugConcurrency {
 		fmt.Printf("RunParallelTasksByPriority exec_list %#v\n", execLists)
@@ -162,8 +205,12 @@ func RunParallelTasksByPriority(execLists []ExecutionList) {
 		if DebugConcurrency {
 			fmt.Printf("%d %v\n", N, operations)
 		}
-		runParallelTasks(N, operations)
+		err := runParallelTasks(N, operations)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func init() {
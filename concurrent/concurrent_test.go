@@ -118,3 +118,33 @@ func TestConcurrency(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestStopOnError(t *testing.T) {
+	var executed []string
+	var trace = func(ti TraceInfo) {
+		executed = append(executed, ti.Cmd)
+	}
+	var execLists = []ExecutionList{
+		{Priority: 0, Command: ExecCommand{Cmd: "false", Tracer: trace}},
+		{Priority: 1, Command: ExecCommand{Cmd: "true", Tracer: trace}},
+	}
+	err := RunParallelTasksByPriority(execLists)
+	if err != nil {
+		t.Logf("not ok - a failing command without StopOnError was reported: %s", err)
+		t.Fail()
+	}
+
+	executed = nil
+	execLists[0].Command.StopOnError = true
+	err = RunParallelTasksByPriority(execLists)
+	if err == nil {
+		t.Logf("not ok - a failing command with StopOnError was not reported")
+		t.Fail()
+	}
+	if len(executed) != 1 {
+		t.Logf("not ok - commands with higher priority executed after a failure: %v", executed)
+		t.Fail()
+	} else {
+		t.Logf("ok - execution stopped after the failing command")
+	}
+}
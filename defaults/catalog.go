@@ -18,6 +18,7 @@ package defaults
 import (
 	"fmt"
 	"github.com/datacharmer/dbdeployer/globals"
+	"io/ioutil"
 	"os"
 	"strings"
 	"sync"
@@ -39,6 +40,7 @@ type SandboxItem struct {
 	Timestamp         string   `json:"timestamp"`
 	LogDirectory      string   `json:"log-directory,omitempty"`
 	CommandLine       string   `json:"command-line"`
+	CommandArgs       []string `json:"command-args,omitempty"`
 }
 
 type SandboxCatalog map[string]SandboxItem
@@ -50,10 +52,10 @@ const (
 var enableCatalogManagement bool = true
 var catalogMutex sync.Mutex
 
-func isLocked() bool {
-	return common.FileExists(SandboxRegistryLock)
-}
+// File holding the catalog lock while the current process owns it
+var catalogLockFile *os.File
 
+// Gets an exclusive lock on the catalog, shared across processes
 func setLock(label string) error {
 	if !enableCatalogManagement {
 		return nil
@@ -70,19 +72,13 @@ func setLock(label string) error {
 			return err
 		}
 	}
-	elapsed := 0
-	for isLocked() {
-		elapsed += 1
-		time.Sleep(1000 * time.Millisecond)
-		if elapsed > timeout {
-			return fmt.Errorf("timeout error for setLock")
-		}
-	}
-	err := common.WriteString(label, SandboxRegistryLock)
+	catalogMutex.Lock()
+	file, err := acquireFileLock(SandboxRegistryLock, label, timeout)
 	if err != nil {
+		catalogMutex.Unlock()
 		return err
 	}
-	catalogMutex.Lock()
+	catalogLockFile = file
 	return nil
 }
 
@@ -90,25 +86,44 @@ func releaseLock() error {
 	if !enableCatalogManagement {
 		return nil
 	}
-	if isLocked() {
-		err := os.Remove(SandboxRegistryLock)
-		if err != nil {
-			return err
-		}
-	}
-	catalogMutex.Unlock()
-	return nil
+	defer catalogMutex.Unlock()
+	file := catalogLockFile
+	catalogLockFile = nil
+	return releaseFileLock(file)
 }
 
+// Writes the catalog to a temporary file, which then replaces the current
+// catalog, so that readers never see a partially written file
 func WriteCatalog(sc SandboxCatalog) error {
 	if !enableCatalogManagement {
 		return nil
 	}
 	byteBuf, err := json.MarshalIndent(sc, " ", "\t")
 	common.ErrCheckExitf(err, 1, "error encoding sandbox catalog: %s", err)
-	jsonString := fmt.Sprintf("%s", byteBuf)
 	filename := SandboxRegistry
-	return common.WriteString(jsonString, filename)
+	tempFile, err := ioutil.TempFile(common.DirName(filename), common.BaseName(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempName := tempFile.Name()
+	_, err = tempFile.Write(byteBuf)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	err1 := tempFile.Close()
+	if err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tempName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tempName, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tempName)
+	}
+	return err
 }
 
 func ReadCatalog() (sc SandboxCatalog, err error) {
@@ -146,6 +161,7 @@ func UpdateCatalog(sbName string, details SandboxItem) error {
 	details.DbDeployerVersion = common.VersionDef
 	details.Timestamp = time.Now().Format(time.UnixDate)
 	details.CommandLine = strings.Join(common.CommandLineArgs, " ")
+	details.CommandArgs = common.CommandLineArgs
 	if !enableCatalogManagement {
 		return nil
 	}
@@ -168,7 +184,15 @@ func UpdateCatalog(sbName string, details SandboxItem) error {
 		if err1 != nil {
 			panic(fmt.Sprintf("%s", err))
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		// The history is informative: failing to update it must not affect the deployment
+		err = addToHistory(sbName, details)
+		if err != nil {
+			common.CondPrintf("# WARNING: error updating deployment history: %s\n", err)
+		}
+		return LeasePorts(sbName, details.Port)
 	} else {
 		common.CondPrintf("%s\n", globals.HashLine)
 		common.CondPrintf("# UpdateCatalog Could not get lock on %s\n", SandboxRegistryLock)
@@ -204,7 +228,10 @@ func DeleteFromCatalog(sbName string) error {
 		if err1 != nil {
 			panic(fmt.Sprintf("%s", err))
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		return ReleasePorts(sbName)
 	} else {
 		common.CondPrintf("%s\n", globals.HashLine)
 		common.CondPrintf("# DeleteFromCatalog Could not get lock on %s\n", SandboxRegistryLock)
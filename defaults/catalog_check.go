@@ -0,0 +1,341 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Kinds of discrepancies between the catalog and the sandboxes on disk
+const (
+	// The catalog has an entry for a sandbox that is no longer on disk
+	CatalogOrphanEntry = "orphan-entry"
+	// A sandbox on disk is not registered in the catalog
+	CatalogMissingEntry = "missing-entry"
+	// The ports registered in the catalog differ from the ones used by the sandbox
+	CatalogStalePorts = "stale-ports"
+	// Type, version, or nodes registered in the catalog differ from the sandbox description
+	CatalogMismatch = "mismatch"
+)
+
+const errCatalogDisabled = "catalog management is disabled (SKIP_DBDEPLOYER_CATALOG is set)"
+
+type CatalogIssue struct {
+	Kind    string
+	Name    string
+	Details string
+	// Sandbox definition as found on disk. Empty for orphan entries
+	Item SandboxItem
+}
+
+// Returns the list of directories where sandboxes may be found:
+// the default sandbox home, the homes of the sandboxes in the catalog,
+// and any additional directories given by the caller
+func CatalogSandboxHomes(extraHomes ...string) []string {
+	var seen = make(map[string]bool)
+	var homes []string
+	addHome := func(home string) {
+		if home == "" || seen[home] {
+			return
+		}
+		seen[home] = true
+		homes = append(homes, home)
+	}
+	addHome(Defaults().SandboxHome)
+	for _, home := range extraHomes {
+		addHome(home)
+	}
+	catalog, err := ReadCatalog()
+	if err == nil {
+		for _, item := range catalog {
+			addHome(common.DirName(item.Destination))
+		}
+	}
+	sort.Strings(homes)
+	return homes
+}
+
+// Creates a catalog item from the sandbox description files in a directory
+func SandboxItemFromDisk(sandboxDir string) (SandboxItem, error) {
+	sbd, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return SandboxItem{}, err
+	}
+	item := SandboxItem{
+		Origin:            sbd.Basedir,
+		SBType:            sbd.SBType,
+		Version:           sbd.Version,
+		Flavor:            sbd.Flavor,
+		Port:              []int{},
+		Nodes:             []string{},
+		Destination:       sandboxDir,
+		DbDeployerVersion: sbd.DbDeployerVersion,
+		Timestamp:         sbd.Timestamp,
+		CommandLine:       sbd.CommandLine,
+		CommandArgs:       sbd.CommandArgs,
+	}
+	if sbd.LogFile != "" {
+		item.LogDirectory = common.DirName(sbd.LogFile)
+	}
+	var seenPorts = make(map[int]bool)
+	addPorts := func(ports []int) {
+		for _, port := range ports {
+			if !seenPorts[port] {
+				seenPorts[port] = true
+				item.Port = append(item.Port, port)
+			}
+		}
+	}
+	addPorts(sbd.Port)
+	if sbd.Nodes > 0 {
+		innerSandboxes, err := common.GetInstalledSandboxes(sandboxDir)
+		if err != nil {
+			return item, err
+		}
+		for _, inner := range common.SandboxInfoToFileNames(innerSandboxes) {
+			nodeDir := path.Join(sandboxDir, inner)
+			if !common.FileExists(path.Join(nodeDir, globals.SandboxDescriptionName)) {
+				continue
+			}
+			nodeDesc, err := common.ReadSandboxDescription(nodeDir)
+			if err != nil {
+				return item, err
+			}
+			item.Nodes = append(item.Nodes, inner)
+			addPorts(nodeDesc.Port)
+		}
+	}
+	return item, nil
+}
+
+// Returns the catalog items for all the sandboxes found in the given directories
+func ScanSandboxHomes(sandboxHomes []string) (SandboxCatalog, error) {
+	var found = make(SandboxCatalog)
+	for _, home := range sandboxHomes {
+		if !common.DirExists(home) {
+			continue
+		}
+		sandboxes, err := common.GetInstalledSandboxes(home)
+		if err != nil {
+			return found, err
+		}
+		for _, name := range common.SandboxInfoToFileNames(sandboxes) {
+			sandboxDir := path.Join(home, name)
+			if !common.FileExists(path.Join(sandboxDir, globals.SandboxDescriptionName)) {
+				continue
+			}
+			item, err := SandboxItemFromDisk(sandboxDir)
+			if err != nil {
+				return found, err
+			}
+			found[sandboxDir] = item
+		}
+	}
+	return found, nil
+}
+
+func samePorts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]int{}, a...)
+	sortedB := append([]int{}, b...)
+	sort.Ints(sortedA)
+	sort.Ints(sortedB)
+	for N := range sortedA {
+		if sortedA[N] != sortedB[N] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameNodes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for N := range sortedA {
+		if sortedA[N] != sortedB[N] {
+			return false
+		}
+	}
+	return true
+}
+
+// Compares the catalog with the sandboxes found in the given directories
+// and returns the list of discrepancies
+func CheckCatalog(sandboxHomes []string) ([]CatalogIssue, error) {
+	var issues []CatalogIssue
+	if !enableCatalogManagement {
+		return issues, fmt.Errorf("%s: the catalog cannot be checked", errCatalogDisabled)
+	}
+	catalog, err := ReadCatalog()
+	if err != nil {
+		return issues, err
+	}
+	onDisk, err := ScanSandboxHomes(sandboxHomes)
+	if err != nil {
+		return issues, err
+	}
+	for name, entry := range catalog {
+		if !common.FileExists(path.Join(name, globals.SandboxDescriptionName)) {
+			issues = append(issues, CatalogIssue{
+				Kind:    CatalogOrphanEntry,
+				Name:    name,
+				Details: "sandbox directory or description not found",
+			})
+			continue
+		}
+		item, ok := onDisk[name]
+		if !ok {
+			item, err = SandboxItemFromDisk(name)
+			if err != nil {
+				return issues, err
+			}
+		}
+		if entry.SBType != item.SBType || entry.Version != item.Version || !sameNodes(entry.Nodes, item.Nodes) {
+			issues = append(issues, CatalogIssue{
+				Kind: CatalogMismatch,
+				Name: name,
+				Details: fmt.Sprintf("catalog: %s %s %v - disk: %s %s %v",
+					entry.SBType, entry.Version, entry.Nodes, item.SBType, item.Version, item.Nodes),
+				Item: item,
+			})
+		}
+		if !samePorts(entry.Port, item.Port) {
+			issues = append(issues, CatalogIssue{
+				Kind:    CatalogStalePorts,
+				Name:    name,
+				Details: fmt.Sprintf("catalog: %v - disk: %v", entry.Port, item.Port),
+				Item:    item,
+			})
+		}
+	}
+	for name, item := range onDisk {
+		if _, ok := catalog[name]; !ok {
+			issues = append(issues, CatalogIssue{
+				Kind:    CatalogMissingEntry,
+				Name:    name,
+				Details: fmt.Sprintf("%s %s not in catalog", item.SBType, item.Version),
+				Item:    item,
+			})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Name == issues[j].Name {
+			return issues[i].Kind < issues[j].Kind
+		}
+		return issues[i].Name < issues[j].Name
+	})
+	return issues, nil
+}
+
+// Replaces the catalog contents using the given function, while holding the lock
+func modifyCatalog(label string, modify func(SandboxCatalog) SandboxCatalog) error {
+	if !enableCatalogManagement {
+		return fmt.Errorf("%s: the catalog was not changed", errCatalogDisabled)
+	}
+	err := setLock(label)
+	if err != nil {
+		return fmt.Errorf("could not get lock on %s: %s", SandboxRegistryLock, err)
+	}
+	current, err := ReadCatalog()
+	if err == nil {
+		if current == nil {
+			current = make(SandboxCatalog)
+		}
+		err = WriteCatalog(modify(current))
+	}
+	err1 := releaseLock()
+	if err1 != nil {
+		panic(fmt.Sprintf("%s", err1))
+	}
+	return err
+}
+
+// Repairs the catalog for the given issues.
+// Missing entries are added, and mismatched entries are updated from disk.
+// Orphan entries are removed only when prune is set.
+// Returns the issues that were fixed.
+func FixCatalog(issues []CatalogIssue, prune bool) ([]CatalogIssue, error) {
+	var fixed []CatalogIssue
+	err := modifyCatalog("fix", func(catalog SandboxCatalog) SandboxCatalog {
+		for _, issue := range issues {
+			switch issue.Kind {
+			case CatalogOrphanEntry:
+				if !prune {
+					continue
+				}
+				delete(catalog, issue.Name)
+			case CatalogMissingEntry:
+				catalog[issue.Name] = issue.Item
+			case CatalogMismatch, CatalogStalePorts:
+				entry := catalog[issue.Name]
+				entry.SBType = issue.Item.SBType
+				entry.Version = issue.Item.Version
+				entry.Flavor = issue.Item.Flavor
+				entry.Port = issue.Item.Port
+				entry.Nodes = issue.Item.Nodes
+				catalog[issue.Name] = entry
+			}
+			fixed = append(fixed, issue)
+		}
+		return catalog
+	})
+	if err != nil {
+		return []CatalogIssue{}, err
+	}
+	for _, issue := range fixed {
+		err = ReleasePorts(issue.Name)
+		if err == nil && issue.Kind != CatalogOrphanEntry {
+			err = LeasePorts(issue.Name, issue.Item.Port)
+		}
+		if err != nil {
+			return fixed, err
+		}
+	}
+	return fixed, nil
+}
+
+// Replaces the catalog with the sandboxes found in the given directories
+func RebuildCatalog(sandboxHomes []string) (SandboxCatalog, error) {
+	onDisk, err := ScanSandboxHomes(sandboxHomes)
+	if err != nil {
+		return onDisk, err
+	}
+	err = modifyCatalog("rebuild", func(SandboxCatalog) SandboxCatalog {
+		return onDisk
+	})
+	if err != nil {
+		return onDisk, err
+	}
+	for name, item := range onDisk {
+		err = LeasePorts(name, item.Port)
+		if err != nil {
+			return onDisk, err
+		}
+	}
+	return onDisk, nil
+}
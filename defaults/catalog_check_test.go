@@ -0,0 +1,148 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Points configuration, catalog, port registry, and logs to a temporary directory
+func setTestDefaults(t *testing.T, baseDir string) {
+	configDir := path.Join(baseDir, "config")
+	err := os.MkdirAll(configDir, 0755)
+	compare.OkIsNil("configuration directory", err, t)
+	err = os.Unsetenv(portRegistryLabel)
+	compare.OkIsNil("port registry variable", err, t)
+	ConfigurationDir = configDir
+	ConfigurationFile = path.Join(configDir, ConfigurationFileName)
+	SandboxRegistry = path.Join(configDir, SandboxRegistryName)
+	SandboxRegistryLock = path.Join(configDir, SandboxRegistryLockName)
+	ProfilesDir = path.Join(configDir, ProfilesDirName)
+	ActiveProfile = ""
+	enableCatalogManagement = true
+	currentDefaults = factoryDefaults
+	currentDefaults.SandboxHome = path.Join(baseDir, "sandboxes")
+	currentDefaults.LogDirectory = path.Join(baseDir, "logs")
+	currentDefaults.PortRegistry = path.Join(configDir, PortRegistryName)
+}
+
+// Creates a sandbox description in the given directory
+func makeTestDescription(t *testing.T, sandboxDir, sbType, version string, ports []int) {
+	err := os.MkdirAll(sandboxDir, 0755)
+	compare.OkIsNil("sandbox directory", err, t)
+	err = common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		Basedir: "/opt/mysql/" + version,
+		SBType:  sbType,
+		Version: version,
+		Port:    ports,
+	})
+	compare.OkIsNil("sandbox description", err, t)
+	// A sandbox is recognized by its start script
+	err = common.WriteString("", path.Join(sandboxDir, globals.ScriptStart))
+	compare.OkIsNil("start script", err, t)
+}
+
+func issueKinds(issues []CatalogIssue) map[string]string {
+	kinds := make(map[string]string)
+	for _, issue := range issues {
+		kinds[common.BaseName(issue.Name)] += issue.Kind + " "
+	}
+	return kinds
+}
+
+func TestCheckAndFixCatalog(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-catalog-check-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	sandboxHome := Defaults().SandboxHome
+	homes := []string{sandboxHome}
+
+	makeTestDescription(t, path.Join(sandboxHome, "msb_ok"), "single", "8.0.16", []int{8016})
+	makeTestDescription(t, path.Join(sandboxHome, "msb_missing"), "single", "5.7.25", []int{5725})
+	makeTestDescription(t, path.Join(sandboxHome, "msb_ports"), "single", "8.0.15", []int{8015})
+	makeTestDescription(t, path.Join(sandboxHome, "msb_type"), "single", "8.0.14", []int{8014})
+	catalog := SandboxCatalog{
+		path.Join(sandboxHome, "msb_ok"):     {SBType: "single", Version: "8.0.16", Port: []int{8016}, Nodes: []string{}},
+		path.Join(sandboxHome, "msb_ports"):  {SBType: "single", Version: "8.0.15", Port: []int{9999}, Nodes: []string{}},
+		path.Join(sandboxHome, "msb_type"):   {SBType: "multiple", Version: "8.0.14", Port: []int{8014}, Nodes: []string{}},
+		path.Join(sandboxHome, "msb_orphan"): {SBType: "single", Version: "5.6.41", Port: []int{5641}, Nodes: []string{}},
+	}
+	err := WriteCatalog(catalog)
+	compare.OkIsNil("write catalog", err, t)
+
+	issues, err := CheckCatalog(homes)
+	compare.OkIsNil("check catalog", err, t)
+	kinds := issueKinds(issues)
+	var expected = map[string]string{
+		"msb_missing": CatalogMissingEntry + " ",
+		"msb_orphan":  CatalogOrphanEntry + " ",
+		"msb_ports":   CatalogStalePorts + " ",
+		"msb_type":    CatalogMismatch + " ",
+	}
+	compare.OkEqualInt("number of issues", len(issues), len(expected), t)
+	for name, kind := range expected {
+		compare.OkEqualString("issue for "+name, kinds[name], kind, t)
+	}
+	compare.OkEqualString("no issue for msb_ok", kinds["msb_ok"], "", t)
+
+	// Without prune, the orphan entry stays in the catalog
+	fixed, err := FixCatalog(issues, false)
+	compare.OkIsNil("fix catalog", err, t)
+	compare.OkEqualInt("fixed issues", len(fixed), 3, t)
+	issues, err = CheckCatalog(homes)
+	compare.OkIsNil("check catalog after fix", err, t)
+	compare.OkEqualInt("issues after fix", len(issues), 1, t)
+	if len(issues) == 1 {
+		compare.OkEqualString("remaining issue", issues[0].Kind, CatalogOrphanEntry, t)
+	}
+	catalog, err = ReadCatalog()
+	compare.OkIsNil("read catalog", err, t)
+	compare.OkEqualIntSlices(t, catalog[path.Join(sandboxHome, "msb_ports")].Port, []int{8015})
+	compare.OkEqualString("fixed type", catalog[path.Join(sandboxHome, "msb_type")].SBType, "single", t)
+	compare.OkEqualString("added entry", catalog[path.Join(sandboxHome, "msb_missing")].Version, "5.7.25", t)
+
+	fixed, err = FixCatalog(issues, true)
+	compare.OkIsNil("prune catalog", err, t)
+	compare.OkEqualInt("pruned issues", len(fixed), 1, t)
+	issues, err = CheckCatalog(homes)
+	compare.OkIsNil("check catalog after prune", err, t)
+	compare.OkEqualInt("issues after prune", len(issues), 0, t)
+
+	registry, err := readPortRegistry(PortRegistryFile())
+	compare.OkIsNil("read port registry", err, t)
+	compare.OkEqualString("leased port", registry[8015].Sandbox, path.Join(sandboxHome, "msb_ports"), t)
+	_, found := registry[5641]
+	compare.OkEqualBool("orphan port released", found, false, t)
+}
+
+func TestCatalogManagementDisabled(t *testing.T) {
+	enableCatalogManagement = false
+	defer func() { enableCatalogManagement = true }()
+	noHome := path.Join(os.TempDir(), "dbdeployer-no-such-home")
+	_, err := CheckCatalog([]string{noHome})
+	compare.OkIsNotNil("check with disabled catalog", err, t)
+	_, err = FixCatalog([]CatalogIssue{{Kind: CatalogMissingEntry, Name: "/tmp/msb_x"}}, false)
+	compare.OkIsNotNil("fix with disabled catalog", err, t)
+	_, err = RebuildCatalog([]string{noHome})
+	compare.OkIsNotNil("rebuild with disabled catalog", err, t)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/datacharmer/dbdeployer/globals"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -58,20 +59,62 @@ type DbdeployerDefaults struct {
 	FanInPrefix       string `json:"fan-in-prefix"`
 	AllMastersPrefix  string `json:"all-masters-prefix"`
 	ReservedPorts     []int  `json:"reserved-ports"`
+	PortRegistry      string `json:"port-registry,omitempty"`
 	RemoteRepository  string `json:"remote-repository"`
 	RemoteIndexFile   string `json:"remote-index-file"`
+	// Repositories searched in order by "remote" commands. When empty, remote-repository is used.
+	// Entries can be URLs (http://, https://, file://) or local directories
+	RemoteRepositories []string `json:"remote-repositories,omitempty"`
+	// Hooks for all deployments and deletions, as "event:file_name"
+	Hooks []string `json:"hooks,omitempty"`
+	// Paths skipped by "unpack --minimal". When empty, a built-in list is used
+	MinimalUnpackSkip []string `json:"minimal-unpack-skip,omitempty"`
 	// GaleraPrefix                   string `json:"galera-prefix"`
 	// PxcPrefix                      string `json:"pxc-prefix"`
 	// NdbPrefix                      string `json:"ndb-prefix"`
 	Timestamp string `json:"timestamp"`
 }
 
+// Returns the file with custom flavor definitions.
+// DBDEPLOYER_FLAVORS_FILE takes precedence over the default location
+func flavorsFileName() string {
+	fileName := os.Getenv("DBDEPLOYER_FLAVORS_FILE")
+	if fileName == "" {
+		fileName = path.Join(ConfigurationDir, FlavorsFileName)
+	}
+	return fileName
+}
+
+// Returns the directory containing the user scripts added to new sandboxes.
+// DBDEPLOYER_EXTRA_SCRIPTS_DIR takes precedence over the default location
+func extraScriptsDirName() string {
+	dirName := os.Getenv("DBDEPLOYER_EXTRA_SCRIPTS_DIR")
+	if dirName == "" {
+		dirName = path.Join(ConfigurationDir, ExtraScriptsDirName)
+	}
+	return dirName
+}
+
+// Returns the directory where downloaded data, such as the remote index, is cached.
+// DBDEPLOYER_CACHE_DIR takes precedence over the default location
+func cacheDirName() string {
+	dirName := os.Getenv("DBDEPLOYER_CACHE_DIR")
+	if dirName == "" {
+		dirName = path.Join(ConfigurationDir, CacheDirName)
+	}
+	return dirName
+}
+
 const (
 	minPortValue            int    = 11000
 	maxPortValue            int    = 30000
 	ConfigurationDirName    string = ".dbdeployer"
 	ConfigurationFileName   string = "config.json"
 	SandboxRegistryName     string = "sandboxes.json"
+	FlavorsFileName         string = "flavors.json"
+	CacheDirName            string = "cache"
+	ExtraScriptsDirName     string = "extra-scripts"
+	ProfilesDirName         string = "profiles"
 	SandboxRegistryLockName string = "sandboxes.lock"
 )
 
@@ -82,6 +125,11 @@ var (
 	CustomConfigurationFile string = ""
 	SandboxRegistry         string = path.Join(ConfigurationDir, SandboxRegistryName)
 	SandboxRegistryLock     string = path.Join(ConfigurationDir, SandboxRegistryLockName)
+	FlavorsFile             string = flavorsFileName()
+	CacheDir                string = cacheDirName()
+	ExtraScriptsDir         string = extraScriptsDirName()
+	ProfilesDir             string = path.Join(ConfigurationDir, ProfilesDirName)
+	ActiveProfile           string = os.Getenv("DBDEPLOYER_PROFILE")
 	LogSBOperations         bool   = common.IsEnvSet("DBDEPLOYER_LOGGING")
 
 	factoryDefaults = DbdeployerDefaults{
@@ -127,10 +175,30 @@ var (
 	currentDefaults DbdeployerDefaults
 )
 
-func Defaults() DbdeployerDefaults {
+// Returns the factory defaults, which don't depend on the configuration file or the profile
+func FactoryDefaults() DbdeployerDefaults {
+	defaults := factoryDefaults
+	defaults.ReservedPorts = append([]int{}, factoryDefaults.ReservedPorts...)
+	return defaults
+}
+
+// Returns the current defaults, reading them from the active profile or the configuration file
+// the first time. Unlike Defaults, it returns an error instead of exiting when they can't be read
+func LoadDefaults() (DbdeployerDefaults, error) {
 	if currentDefaults.Version == "" {
-		if common.FileExists(ConfigurationFile) {
-			currentDefaults = ReadDefaultsFile(ConfigurationFile)
+		// A profile that does not exist is an error, not a reason to use other defaults
+		if ActiveProfile != "" {
+			profileDefaults, err := ReadProfile(ActiveProfile)
+			if err != nil {
+				return DbdeployerDefaults{}, err
+			}
+			currentDefaults = profileDefaults
+		} else if common.FileExists(ConfigurationFile) {
+			fileDefaults, err := readDefaultsFile(ConfigurationFile)
+			if err != nil {
+				return DbdeployerDefaults{}, err
+			}
+			currentDefaults = fileDefaults
 		} else {
 			currentDefaults = factoryDefaults
 		}
@@ -138,12 +206,20 @@ func Defaults() DbdeployerDefaults {
 	if currentDefaults.LogSBOperations {
 		LogSBOperations = true
 	}
-	return currentDefaults
+	return currentDefaults, nil
+}
+
+func Defaults() DbdeployerDefaults {
+	current, err := LoadDefaults()
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return current
 }
 
 func ShowDefaults(defaults DbdeployerDefaults) {
 	defaults = replaceLiteralEnvValues(defaults)
-	if common.FileExists(ConfigurationFile) {
+	if ActiveProfile != "" {
+		common.CondPrintf("# Profile %s: %s\n", ActiveProfile, ProfileFile(ActiveProfile))
+	} else if common.FileExists(ConfigurationFile) {
 		common.CondPrintf("# Configuration file: %s\n", ConfigurationFile)
 	} else {
 		common.CondPrintln("# Internal values:")
@@ -151,6 +227,28 @@ func ShowDefaults(defaults DbdeployerDefaults) {
 	b, err := json.MarshalIndent(defaults, " ", "\t")
 	common.ErrCheckExitf(err, 1, globals.ErrEncodingDefaults, err)
 	common.CondPrintf("%s\n", b)
+	if ActiveProfile != "" {
+		showOrigins()
+	}
+}
+
+// Returns the file where the defaults are stored: the one of the active profile, if any,
+// or the configuration file
+func DefaultsDestination() string {
+	if ActiveProfile != "" {
+		return ProfileFile(ActiveProfile)
+	}
+	return ConfigurationFile
+}
+
+// Stores the defaults in the active profile, or in the configuration file
+func StoreDefaults(defaults DbdeployerDefaults) {
+	if ActiveProfile != "" {
+		err := WriteProfile(ActiveProfile, defaults)
+		common.ErrCheckExitf(err, 1, "error writing profile %s: %s", ActiveProfile, err)
+		return
+	}
+	WriteDefaultsFile(ConfigurationFile, defaults)
 }
 
 func WriteDefaultsFile(filename string, defaults DbdeployerDefaults) {
@@ -182,14 +280,22 @@ func replaceLiteralEnvValues(defaults DbdeployerDefaults) DbdeployerDefaults {
 	return defaults
 }
 
-func ReadDefaultsFile(filename string) (defaults DbdeployerDefaults) {
+func readDefaultsFile(filename string) (defaults DbdeployerDefaults, err error) {
 	defaultsBlob, err := common.SlurpAsBytes(filename)
-	common.ErrCheckExitf(err, 1, "error reading defaults file %s: %s", filename, err)
-
+	if err != nil {
+		return defaults, fmt.Errorf("error reading defaults file %s: %s", filename, err)
+	}
 	err = json.Unmarshal(defaultsBlob, &defaults)
-	common.ErrCheckExitf(err, 1, globals.ErrEncodingDefaults, err)
-	defaults = expandEnvironmentVariables(defaults)
-	return
+	if err != nil {
+		return defaults, fmt.Errorf(globals.ErrEncodingDefaults, err)
+	}
+	return expandEnvironmentVariables(defaults), nil
+}
+
+func ReadDefaultsFile(filename string) DbdeployerDefaults {
+	defaults, err := readDefaultsFile(filename)
+	common.ErrCheckExitf(err, 1, "%s", err)
+	return defaults
 }
 
 func checkInt(name string, val, min, max int) bool {
@@ -278,6 +384,15 @@ func ValidateDefaults(nd DbdeployerDefaults) bool {
 }
 
 func RemoveDefaultsFile() {
+	if ActiveProfile != "" {
+		// A profile without values uses the factory defaults
+		err := RemoveProfile(ActiveProfile)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		err = CreateProfile(ActiveProfile)
+		common.ErrCheckExitf(err, 1, "%s", err)
+		common.CondPrintf("# Values of profile %s removed\n", ActiveProfile)
+		return
+	}
 	if common.FileExists(ConfigurationFile) {
 		err := os.Remove(ConfigurationFile)
 		common.ErrCheckExitf(err, 1, "%s", err)
@@ -364,6 +479,14 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 		newDefaults.RemoteIndexFile = value
 	case "reserved-ports":
 		newDefaults.ReservedPorts = strToSlice("reserved-ports", value)
+	case "remote-repositories":
+		newDefaults.RemoteRepositories = strings.Split(value, ",")
+	case "hooks":
+		newDefaults.Hooks = strings.Split(value, ",")
+	case "minimal-unpack-skip":
+		newDefaults.MinimalUnpackSkip = strings.Split(value, ",")
+	case "port-registry":
+		newDefaults.PortRegistry = value
 	// case "galera-prefix":
 	// 	new_defaults.GaleraPrefix = value
 	// case "pxc-prefix":
@@ -376,7 +499,7 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 	if ValidateDefaults(newDefaults) {
 		currentDefaults = newDefaults
 		if storeDefaults {
-			WriteDefaultsFile(ConfigurationFile, Defaults())
+			StoreDefaults(Defaults())
 			common.CondPrintf("# Updated %s -> \"%s\"\n", label, value)
 		}
 	} else {
@@ -385,16 +508,23 @@ func UpdateDefaults(label, value string, storeDefaults bool) {
 }
 
 func LoadConfiguration() {
-	if !common.FileExists(ConfigurationFile) {
-		// WriteDefaultsFile(ConfigurationFile, Defaults())
-		return
+	var newDefaults DbdeployerDefaults
+	if ActiveProfile != "" {
+		var err error
+		newDefaults, err = ReadProfile(ActiveProfile)
+		common.ErrCheckExitf(err, 1, "%s", err)
+	} else {
+		if !common.FileExists(ConfigurationFile) {
+			// WriteDefaultsFile(ConfigurationFile, Defaults())
+			return
+		}
+		newDefaults = ReadDefaultsFile(ConfigurationFile)
 	}
-	newDefaults := ReadDefaultsFile(ConfigurationFile)
 	if ValidateDefaults(newDefaults) {
 		currentDefaults = newDefaults
 	} else {
 		common.CondPrintln(globals.StarLine)
-		common.CondPrintf("Defaults file %s not validated.\n", ConfigurationFile)
+		common.CondPrintf("Defaults file %s not validated.\n", DefaultsDestination())
 		common.CondPrintln("Loading internal defaults")
 		common.CondPrintln(globals.StarLine)
 		common.CondPrintln("")
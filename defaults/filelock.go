@@ -0,0 +1,116 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+type lockOwner struct {
+	Pid       int    `json:"pid"`
+	Host      string `json:"host"`
+	Label     string `json:"label"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Interval between attempts to get a lock, in milliseconds
+const lockRetryInterval = 100
+
+// Reads the information about the process that holds (or last held) a lock
+func readLockOwner(lockName string) (owner lockOwner, err error) {
+	contents, err := common.SlurpAsBytes(lockName)
+	if err != nil {
+		return owner, err
+	}
+	err = json.Unmarshal(contents, &owner)
+	return owner, err
+}
+
+// Returns true if the owner record was left by a process of this host that is no longer running.
+// The kernel releases the flock of a process that dies, so such a record does not block anyone
+func isStaleLock(owner lockOwner) bool {
+	host, err := os.Hostname()
+	if err != nil || owner.Host != host || owner.Pid <= 0 || owner.Pid == os.Getpid() {
+		return false
+	}
+	err = syscall.Kill(owner.Pid, syscall.Signal(0))
+	return err == syscall.ESRCH
+}
+
+// Gets an exclusive advisory lock (flock) on the given file, waiting up to
+// timeoutSeconds. The lock file records PID, host, and label of the owner,
+// and it is never removed: a new holder may have taken the lock without
+// having rewritten the owner record yet.
+// Returns the open lock file, which must be passed to releaseFileLock
+func acquireFileLock(lockName, label string, timeoutSeconds int) (*os.File, error) {
+	deadline := time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	for {
+		file, err := os.OpenFile(lockName, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening lock file %s: %s", lockName, err)
+		}
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			previous, readErr := readLockOwner(lockName)
+			if readErr == nil && isStaleLock(previous) {
+				common.CondPrintf("# Recovered lock %s left by process %d (%s)\n", lockName, previous.Pid, previous.Label)
+			}
+			host, _ := os.Hostname()
+			owner, _ := json.Marshal(lockOwner{
+				Pid:       os.Getpid(),
+				Host:      host,
+				Label:     label,
+				Timestamp: time.Now().Format(time.RFC3339),
+			})
+			_ = file.Truncate(0)
+			_, _ = file.WriteAt(owner, 0)
+			return file, nil
+		}
+		_ = file.Close()
+		if err != syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("error locking %s: %s", lockName, err)
+		}
+		if time.Now().After(deadline) {
+			owner, readErr := readLockOwner(lockName)
+			if readErr == nil {
+				return nil, fmt.Errorf("timeout error getting lock %s: locked by process %d on %s (%s) since %s",
+					lockName, owner.Pid, owner.Host, owner.Label, owner.Timestamp)
+			}
+			return nil, fmt.Errorf("timeout error getting lock %s", lockName)
+		}
+		time.Sleep(lockRetryInterval * time.Millisecond)
+	}
+}
+
+// Releases a lock obtained with acquireFileLock
+func releaseFileLock(file *os.File) error {
+	if file == nil {
+		return nil
+	}
+	_ = file.Truncate(0)
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	err1 := file.Close()
+	if err != nil {
+		return err
+	}
+	return err1
+}
@@ -0,0 +1,116 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestAcquireFileLockConcurrently(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-filelock-%d", os.Getpid()))
+	err := os.MkdirAll(baseDir, 0755)
+	compare.OkIsNil("lock directory", err, t)
+	defer os.RemoveAll(baseDir)
+	lockName := path.Join(baseDir, "test.lock")
+
+	// flock locks belong to open files, so goroutines that open the lock file
+	// separately compete like different processes do
+	const workers = 8
+	const rounds = 5
+	var holders, maxHolders int32
+	var acquired int32
+	var wg sync.WaitGroup
+	lockErrors := make(chan error, workers*rounds)
+	for N := 0; N < workers; N++ {
+		wg.Add(1)
+		go func(N int) {
+			defer wg.Done()
+			for R := 0; R < rounds; R++ {
+				file, err := acquireFileLock(lockName, fmt.Sprintf("worker %d", N), 10)
+				if err != nil {
+					lockErrors <- err
+					return
+				}
+				current := atomic.AddInt32(&holders, 1)
+				for {
+					max := atomic.LoadInt32(&maxHolders)
+					if current <= max || atomic.CompareAndSwapInt32(&maxHolders, max, current) {
+						break
+					}
+				}
+				atomic.AddInt32(&acquired, 1)
+				time.Sleep(2 * time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				err = releaseFileLock(file)
+				if err != nil {
+					lockErrors <- err
+					return
+				}
+			}
+		}(N)
+	}
+	wg.Wait()
+	close(lockErrors)
+	for err := range lockErrors {
+		compare.OkIsNil("lock error", err, t)
+	}
+	compare.OkEqualInt("locks acquired", int(acquired), workers*rounds, t)
+	compare.OkEqualInt("maximum simultaneous holders", int(maxHolders), 1, t)
+	compare.OkEqualBool("lock file kept", common.FileExists(lockName), true, t)
+}
+
+func TestAcquireFileLockTimeout(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-filelock-timeout-%d", os.Getpid()))
+	err := os.MkdirAll(baseDir, 0755)
+	compare.OkIsNil("lock directory", err, t)
+	defer os.RemoveAll(baseDir)
+	lockName := path.Join(baseDir, "test.lock")
+
+	holder, err := acquireFileLock(lockName, "holder", 1)
+	compare.OkIsNil("first lock", err, t)
+	_, err = acquireFileLock(lockName, "waiter", 1)
+	compare.OkIsNotNil("second lock", err, t)
+	if err != nil {
+		compare.OkEqualBool("owner in timeout error", strings.Contains(err.Error(), "(holder)"), true, t)
+	}
+	err = releaseFileLock(holder)
+	compare.OkIsNil("release", err, t)
+
+	// An owner record left by a dead process does not block the lock
+	host, _ := os.Hostname()
+	record, _ := json.Marshal(lockOwner{Pid: 999999999, Host: host, Label: "dead"})
+	err = common.WriteString(string(record), lockName)
+	compare.OkIsNil("stale owner record", err, t)
+	file, err := acquireFileLock(lockName, "after dead owner", 1)
+	compare.OkIsNil("lock after dead owner", err, t)
+	owner, err := readLockOwner(lockName)
+	compare.OkIsNil("read owner", err, t)
+	compare.OkEqualString("new owner", owner.Label, "after dead owner", t)
+	compare.OkEqualInt("new owner pid", owner.Pid, os.Getpid(), t)
+	err = releaseFileLock(file)
+	compare.OkIsNil("release after dead owner", err, t)
+}
@@ -0,0 +1,116 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// File in the log directory where every deployment is recorded, one JSON object per line.
+// Unlike the catalog, it keeps the deployments of sandboxes that were removed
+const DeploymentHistoryName = "deployment-history.json"
+
+// A deployment, as recorded in the history or in the catalog
+type HistoryItem struct {
+	Sandbox string `json:"sandbox"`
+	SandboxItem
+}
+
+// Returns the file containing the deployment history
+func DeploymentHistoryFile() string {
+	return path.Join(Defaults().LogDirectory, DeploymentHistoryName)
+}
+
+// Adds a deployment to the history
+func addToHistory(sbName string, details SandboxItem) error {
+	logDirectory := Defaults().LogDirectory
+	if !common.DirExists(logDirectory) {
+		err := os.MkdirAll(logDirectory, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(HistoryItem{Sandbox: sbName, SandboxItem: details})
+	if err != nil {
+		return err
+	}
+	historyFile, err := os.OpenFile(DeploymentHistoryFile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = historyFile.Write(append(b, '\n'))
+	err1 := historyFile.Close()
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
+// Returns the deployments recorded in the history, followed by the ones in the catalog
+// that are not in the history, sorted by time
+func ReadHistory() ([]HistoryItem, error) {
+	var items []HistoryItem
+	var seen = make(map[string]bool)
+	if common.FileExists(DeploymentHistoryFile()) {
+		historyFile, err := os.Open(DeploymentHistoryFile())
+		if err != nil {
+			return nil, err
+		}
+		defer historyFile.Close()
+		scanner := bufio.NewScanner(historyFile)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var item HistoryItem
+			err = json.Unmarshal(scanner.Bytes(), &item)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding line %d of %s: %s", lineNumber, DeploymentHistoryFile(), err)
+			}
+			seen[item.Sandbox+item.Timestamp] = true
+			items = append(items, item)
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	catalog, err := ReadCatalog()
+	if err != nil {
+		return nil, err
+	}
+	for sbName, details := range catalog {
+		if !seen[sbName+details.Timestamp] {
+			items = append(items, HistoryItem{Sandbox: sbName, SandboxItem: details})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		ti, _ := time.Parse(time.UnixDate, items[i].Timestamp)
+		tj, _ := time.Parse(time.UnixDate, items[j].Timestamp)
+		return ti.Before(tj)
+	})
+	return items, nil
+}
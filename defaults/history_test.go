@@ -0,0 +1,106 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func historySandboxes(items []HistoryItem) string {
+	var names []string
+	for _, item := range items {
+		names = append(names, common.BaseName(item.Sandbox))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestReadHistory(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-history-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	sandboxHome := Defaults().SandboxHome
+
+	history, err := ReadHistory()
+	compare.OkIsNil("empty history", err, t)
+	compare.OkEqualInt("empty history", len(history), 0, t)
+
+	timestamp := func(minutes int) string {
+		return time.Date(2019, 3, 1, 10, minutes, 0, 0, time.UTC).Format(time.UnixDate)
+	}
+	// Deployments recorded in the history, including one that was removed from the catalog
+	for _, item := range []HistoryItem{
+		{Sandbox: path.Join(sandboxHome, "msb_b"), SandboxItem: SandboxItem{SBType: "single", Timestamp: timestamp(3)}},
+		{Sandbox: path.Join(sandboxHome, "msb_a"), SandboxItem: SandboxItem{SBType: "single", Timestamp: timestamp(1)}},
+		{Sandbox: path.Join(sandboxHome, "msb_c"), SandboxItem: SandboxItem{SBType: "single", Timestamp: timestamp(4)}},
+	} {
+		err = addToHistory(item.Sandbox, item.SandboxItem)
+		compare.OkIsNil("adding "+item.Sandbox+" to history", err, t)
+	}
+	// The catalog has one of the recorded deployments, and one made before the history existed
+	err = WriteCatalog(SandboxCatalog{
+		path.Join(sandboxHome, "msb_b"):   {SBType: "single", Timestamp: timestamp(3), Nodes: []string{}},
+		path.Join(sandboxHome, "msb_old"): {SBType: "single", Timestamp: timestamp(2), Nodes: []string{}},
+	})
+	compare.OkIsNil("writing catalog", err, t)
+	history, err = ReadHistory()
+	compare.OkIsNil("reading history", err, t)
+	compare.OkEqualString("history order", historySandboxes(history), "msb_a,msb_old,msb_b,msb_c", t)
+
+	// Empty lines are skipped, while invalid ones are reported
+	historyFile, err := os.OpenFile(DeploymentHistoryFile(), os.O_WRONLY|os.O_APPEND, 0644)
+	compare.OkIsNil("opening history file", err, t)
+	_, err = historyFile.WriteString("\n")
+	compare.OkIsNil("writing empty line", err, t)
+	history, err = ReadHistory()
+	compare.OkIsNil("history with empty line", err, t)
+	compare.OkEqualInt("history with empty line", len(history), 4, t)
+	_, err = historyFile.WriteString("{ not json\n")
+	compare.OkIsNil("writing invalid line", err, t)
+	err = historyFile.Close()
+	compare.OkIsNil("closing history file", err, t)
+	_, err = ReadHistory()
+	compare.OkIsNotNil("history with invalid line", err, t)
+	if err != nil {
+		compare.OkMatchesString("invalid line", err.Error(), "line 5", t)
+	}
+}
+
+func TestUpdateCatalogWithoutHistory(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-no-history-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	sandboxDir := path.Join(Defaults().SandboxHome, "msb_8_0_16")
+
+	// The history can't be written, as the log directory is a file
+	err := common.WriteString("", Defaults().LogDirectory)
+	compare.OkIsNil("replacing log directory with a file", err, t)
+	err = UpdateCatalog(sandboxDir, SandboxItem{SBType: "single", Version: "8.0.16", Port: []int{8016}, Nodes: []string{}})
+	compare.OkIsNil("updating catalog without history", err, t)
+	catalog, err := ReadCatalog()
+	compare.OkIsNil("reading catalog", err, t)
+	compare.OkEqualString("catalog entry", catalog[sandboxDir].Version, "8.0.16", t)
+	registry, err := readPortRegistry(PortRegistryFile())
+	compare.OkIsNil("reading port registry", err, t)
+	compare.OkEqualString("leased port", registry[8016].Sandbox, sandboxDir, t)
+}
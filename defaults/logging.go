@@ -12,57 +12,241 @@
 // WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 // See the License for the specific language governing permissions and
 // limitations under the License.
+
 package defaults
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/datacharmer/dbdeployer/common"
-	"io/ioutil"
 	"runtime"
 )
 
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarning
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+func (level LogLevel) String() string {
+	if level < LogDebug || level > LogError {
+		return "unknown"
+	}
+	return logLevelNames[level]
+}
+
+// Returns the level corresponding to a name, such as "debug" or "warning"
+func ParseLogLevel(name string) (LogLevel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warn" {
+		name = "warning"
+	}
+	for N, levelName := range logLevelNames {
+		if levelName == name {
+			return LogLevel(N), nil
+		}
+	}
+	return LogInfo, fmt.Errorf("unknown log level '%s'. Accepted: %s", name, strings.Join(logLevelNames, ", "))
+}
+
+// A single line of a sandbox operations log
+type LogEntry struct {
+	Time        string `json:"time"`
+	Level       string `json:"level"`
+	Pid         int    `json:"pid"`
+	OperationId string `json:"operation-id,omitempty"`
+	Caller      string `json:"caller,omitempty"`
+	Sandbox     string `json:"sandbox,omitempty"`
+	Node        string `json:"node,omitempty"`
+	Operation   string `json:"operation,omitempty"`
+	DurationMs  int64  `json:"duration-ms,omitempty"`
+	Outcome     string `json:"outcome,omitempty"`
+	Message     string `json:"message"`
+}
+
+// The file shared by all the loggers created with NewLogger for the same name
+type logOutput struct {
+	mutex      sync.Mutex
+	file       *os.File
+	fileName   string
+	size       int64
+	maxSize    int64
+	maxBackups int
+}
+
 type Logger struct {
-	logger *log.Logger
+	out      *logOutput
+	sandbox  string
+	node     string
+	minLevel LogLevel
+}
+
+// Tracks the duration of an operation, which is logged when End is called
+type LogOperation struct {
+	logger *Logger
+	name   string
+	start  time.Time
+}
+
+const (
+	defaultLogMaxSizeMb  = 10
+	defaultLogMaxBackups = 5
+	// Fixed width time format, so that entries can be sorted as strings
+	logTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Logger settings, which can be changed through environment variables
+var (
+	logMinLevel   = LogInfo
+	logMaxSize    = int64(defaultLogMaxSizeMb * 1024 * 1024)
+	logMaxBackups = defaultLogMaxBackups
+)
+
+// Returns a logger that records the given sandbox and node in every entry
+func (l *Logger) WithContext(sandbox, node string) *Logger {
+	newLogger := *l
+	newLogger.sandbox = sandbox
+	newLogger.node = node
+	return &newLogger
+}
+
+// Moves the current log file to a numbered backup, shifting the older ones,
+// and starts a new file. Must be called while holding the output mutex
+func (out *logOutput) rotate() error {
+	err := out.file.Close()
+	if err != nil {
+		return err
+	}
+	for N := out.maxBackups - 1; N >= 1; N-- {
+		older := fmt.Sprintf("%s.%d", out.fileName, N)
+		if common.FileExists(older) {
+			_ = os.Rename(older, fmt.Sprintf("%s.%d", out.fileName, N+1))
+		}
+	}
+	if out.maxBackups > 0 {
+		_ = os.Rename(out.fileName, out.fileName+".1")
+	} else {
+		_ = os.Remove(out.fileName)
+	}
+	out.file, err = os.OpenFile(out.fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	out.size = 0
+	return err
+}
+
+func (out *logOutput) write(entry LogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	if out.file == nil {
+		return
+	}
+	if out.maxSize > 0 && out.size > 0 && out.size+int64(len(line)) > out.maxSize {
+		if out.rotate() != nil {
+			return
+		}
+	}
+	n, _ := out.file.Write(line)
+	out.size += int64(n)
+}
+
+func (l *Logger) log(level LogLevel, caller, operation string, duration time.Duration, outcome, message string) {
+	if l.out == nil || level < l.minLevel {
+		return
+	}
+	entry := LogEntry{
+		Time:        time.Now().Format(logTimeFormat),
+		Level:       level.String(),
+		Pid:         os.Getpid(),
+		OperationId: getOperationNumber(),
+		Caller:      common.BaseName(caller),
+		Sandbox:     l.sandbox,
+		Node:        l.node,
+		Operation:   operation,
+		DurationMs:  int64(duration / time.Millisecond),
+		Outcome:     outcome,
+		Message:     strings.TrimRight(message, "\n"),
+	}
+	l.out.write(entry)
 }
 
-// Calling Logger.Printf will print what was requested,
-// with an additional prefix made of :
-// 		* dbdeployer Process ID
-// 		* the current operation number
-// 		* the name of the caller function
+// Calling Logger.Printf will log what was requested at "info" level,
+// adding the dbdeployer process ID, the current operation number,
+// and the name of the caller function
 func (l *Logger) Printf(format string, args ...interface{}) {
-	var newArgs []interface{}
-	caller := CallFuncName()
-	opNum := getOperationNumber(caller)
+	l.log(LogInfo, CallFuncName(), "", 0, "", fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LogDebug, CallFuncName(), "", 0, "", fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LogInfo, CallFuncName(), "", 0, "", fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(LogWarning, CallFuncName(), "", 0, "", fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LogError, CallFuncName(), "", 0, "", fmt.Sprintf(format, args...))
+}
+
+// Starts timing an operation
+func (l *Logger) StartOperation(name string) *LogOperation {
+	l.log(LogDebug, CallFuncName(), name, 0, "started", "operation "+name+" started")
+	return &LogOperation{logger: l, name: name, start: time.Now()}
+}
 
-	// injects operation number and caller into the function arguments
-	newArgs = append(newArgs, opNum)
-	for _, arg := range args {
-		newArgs = append(newArgs, arg)
+// Logs the outcome and duration of an operation.
+// Returns the given error, so that it can be used in return statements
+func (op *LogOperation) End(err error) error {
+	level := LogInfo
+	outcome := "success"
+	message := "operation " + op.name + " completed"
+	if err != nil {
+		level = LogError
+		outcome = "failure"
+		message = fmt.Sprintf("operation %s failed: %s", op.name, err)
 	}
-	// Calls the logger's Printf function, with the additional prefix
-	l.logger.Printf("[%s] "+format, newArgs...)
+	op.logger.log(level, CallFuncName(), op.name, time.Since(op.start), outcome, message)
+	return err
 }
 
 var operationNum int
+var operationMutex sync.Mutex
 
-func getOperationNumber(caller string) string {
-	caller = common.BaseName(caller)
-	var m sync.Mutex
-	m.Lock()
+func getOperationNumber() string {
+	operationMutex.Lock()
+	defer operationMutex.Unlock()
 	operationNum += 1
-	operationId := fmt.Sprintf("%07d-%05d %s", os.Getpid(), operationNum, caller)
-	m.Unlock()
-	return operationId
+	return fmt.Sprintf("%07d-%05d", os.Getpid(), operationNum)
 }
 
+var logOutputs = make(map[string]*logOutput)
+var logOutputsMutex sync.Mutex
+
 func NewLogger(logDir, logFileName string) (*Logger, string, error) {
-	noLogger := &Logger{logger: log.New(ioutil.Discard, "", log.Ldate|log.Ltime)}
+	noLogger := &Logger{}
 	if !LogSBOperations {
 		return noLogger, "", nil
 	}
@@ -80,13 +264,23 @@ func NewLogger(logDir, logFileName string) (*Logger, string, error) {
 		}
 	}
 	var logFileFullName string = path.Join(fullLogDir, logFileName+".log")
-	var err error
-	var logFile *os.File
-	logFile, err = os.OpenFile(logFileFullName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		return noLogger, "", fmt.Errorf("error opening log file %s : %v", logFileFullName, err)
+	logOutputsMutex.Lock()
+	defer logOutputsMutex.Unlock()
+	// Loggers for the same file share the output, to keep size and rotation consistent
+	out, ok := logOutputs[logFileFullName]
+	if !ok {
+		logFile, err := os.OpenFile(logFileFullName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return noLogger, "", fmt.Errorf("error opening log file %s : %v", logFileFullName, err)
+		}
+		out = &logOutput{file: logFile, fileName: logFileFullName, maxSize: logMaxSize, maxBackups: logMaxBackups}
+		stat, err := logFile.Stat()
+		if err == nil {
+			out.size = stat.Size()
+		}
+		logOutputs[logFileFullName] = out
 	}
-	return &Logger{logger: log.New(logFile, "", log.Ldate|log.Ltime)}, logFileFullName, nil
+	return &Logger{out: out, minLevel: logMinLevel}, logFileFullName, nil
 }
 
 func CallFuncName() string {
@@ -100,3 +294,117 @@ func CallFuncName() string {
 	}
 	return ""
 }
+
+// Returns the log files in a directory, oldest first.
+// Rotated files (name.log.N) come before the current one (name.log)
+func logFilesInDirectory(logDir string) ([]string, error) {
+	currentFiles, err := filepath.Glob(path.Join(logDir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(currentFiles)
+	var logFiles []string
+	for _, current := range currentFiles {
+		rotated, _ := filepath.Glob(current + ".*")
+		var backups []int
+		for _, name := range rotated {
+			num, err := strconv.Atoi(strings.TrimPrefix(name, current+"."))
+			if err == nil {
+				backups = append(backups, num)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(backups)))
+		for _, num := range backups {
+			logFiles = append(logFiles, fmt.Sprintf("%s.%d", current, num))
+		}
+		logFiles = append(logFiles, current)
+	}
+	return logFiles, nil
+}
+
+// Reads all the entries from the log files in a directory, sorted by time.
+// Lines that are not in JSON format, written by older versions, are returned as messages
+// without time. They are sorted as if written just before the next timed entry of their
+// file, or at the time the file was last modified
+func ReadLogEntries(logDir string) ([]LogEntry, error) {
+	var entries []LogEntry
+	// Time used for sorting each entry
+	var sortKeys []string
+	logFiles, err := logFilesInDirectory(logDir)
+	if err != nil {
+		return entries, err
+	}
+	for _, logFile := range logFiles {
+		file, err := os.Open(logFile)
+		if err != nil {
+			return entries, err
+		}
+		var untimed []int
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var entry LogEntry
+			if json.Unmarshal([]byte(line), &entry) != nil {
+				entry = LogEntry{Level: LogInfo.String(), Message: line}
+			}
+			if entry.Time == "" {
+				untimed = append(untimed, len(entries))
+			} else {
+				for _, N := range untimed {
+					sortKeys[N] = entry.Time
+				}
+				untimed = nil
+			}
+			entries = append(entries, entry)
+			sortKeys = append(sortKeys, entry.Time)
+		}
+		err = scanner.Err()
+		if err == nil && len(untimed) > 0 {
+			var stat os.FileInfo
+			stat, err = file.Stat()
+			if err == nil {
+				for _, N := range untimed {
+					sortKeys[N] = stat.ModTime().Format(logTimeFormat)
+				}
+			}
+		}
+		_ = file.Close()
+		if err != nil {
+			return entries, err
+		}
+	}
+	order := make([]int, len(entries))
+	for N := range order {
+		order[N] = N
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sortKeys[order[i]] < sortKeys[order[j]]
+	})
+	sorted := make([]LogEntry, len(entries))
+	for N, index := range order {
+		sorted[N] = entries[index]
+	}
+	return sorted, nil
+}
+
+func init() {
+	levelName := os.Getenv("DBDEPLOYER_LOG_LEVEL")
+	if levelName != "" {
+		level, err := ParseLogLevel(levelName)
+		if err == nil {
+			logMinLevel = level
+		}
+	}
+	maxSize, err := strconv.Atoi(os.Getenv("DBDEPLOYER_LOG_MAX_SIZE"))
+	if err == nil && maxSize > 0 {
+		logMaxSize = int64(maxSize) * 1024 * 1024
+	}
+	maxBackups, err := strconv.Atoi(os.Getenv("DBDEPLOYER_LOG_BACKUPS"))
+	if err == nil && maxBackups >= 0 {
+		logMaxBackups = maxBackups
+	}
+}
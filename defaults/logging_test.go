@@ -0,0 +1,110 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestLogRotation(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-log-rotation-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	savedLogging, savedSize, savedBackups := LogSBOperations, logMaxSize, logMaxBackups
+	defer func() {
+		LogSBOperations, logMaxSize, logMaxBackups = savedLogging, savedSize, savedBackups
+	}()
+	LogSBOperations = true
+	logMaxSize = 1024
+	logMaxBackups = 2
+
+	logger, logFile, err := NewLogger("msb_rotation", "single")
+	compare.OkIsNil("new logger", err, t)
+	logger = logger.WithContext("msb_rotation", "")
+	for N := 1; N <= 60; N++ {
+		logger.Infof("message %03d", N)
+	}
+	logDir := common.DirName(logFile)
+	logFiles, err := logFilesInDirectory(logDir)
+	compare.OkIsNil("log files", err, t)
+	compare.OkEqualStringSlices(t, logFiles, []string{logFile + ".2", logFile + ".1", logFile})
+	compare.OkEqualBool("no third backup", common.FileExists(logFile+".3"), false, t)
+	for _, name := range logFiles {
+		stat, err := os.Stat(name)
+		compare.OkIsNil("stat "+name, err, t)
+		compare.OkEqualBool("size within limit for "+name, stat.Size() <= logMaxSize, true, t)
+	}
+
+	entries, err := ReadLogEntries(logDir)
+	compare.OkIsNil("read entries", err, t)
+	compare.OkEqualBool("older entries dropped", len(entries) < 60, true, t)
+	if len(entries) > 0 {
+		compare.OkEqualString("last entry", entries[len(entries)-1].Message, "message 060", t)
+		compare.OkEqualString("sandbox", entries[0].Sandbox, "msb_rotation", t)
+	}
+	for N := 1; N < len(entries); N++ {
+		if entries[N].Message < entries[N-1].Message {
+			t.Logf("not ok - entry %d (%s) listed after %s", N, entries[N].Message, entries[N-1].Message)
+			t.Fail()
+		}
+	}
+}
+
+func TestReadLegacyLogEntries(t *testing.T) {
+	logDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-log-legacy-%d", os.Getpid()))
+	err := os.MkdirAll(logDir, 0755)
+	compare.OkIsNil("log directory", err, t)
+	defer os.RemoveAll(logDir)
+
+	jsonLine := func(time, message string) string {
+		return fmt.Sprintf(`{"time":"%s","level":"info","pid":1,"message":"%s"}`, time, message)
+	}
+	// An old log, continued in JSON format after an upgrade
+	upgraded := path.Join(logDir, "upgraded.log")
+	err = common.WriteString("legacy a1\nlegacy a2\n"+
+		jsonLine("2019-03-01T10:00:00.000000Z", "json a3")+"\n", upgraded)
+	compare.OkIsNil("upgraded log", err, t)
+	// An old log that was never continued: its lines are placed at the file modification time
+	legacy := path.Join(logDir, "legacy.log")
+	err = common.WriteString("legacy b1\nlegacy b2\n", legacy)
+	compare.OkIsNil("legacy log", err, t)
+	modTime := time.Date(2019, 2, 1, 0, 0, 0, 0, time.Local)
+	err = os.Chtimes(legacy, modTime, modTime)
+	compare.OkIsNil("legacy log time", err, t)
+	current := path.Join(logDir, "current.log")
+	err = common.WriteString(jsonLine("2019-01-01T10:00:00.000000Z", "json c1")+"\n"+
+		jsonLine("2019-04-01T10:00:00.000000Z", "json c2")+"\n", current)
+	compare.OkIsNil("current log", err, t)
+
+	entries, err := ReadLogEntries(logDir)
+	compare.OkIsNil("read entries", err, t)
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	compare.OkEqualStringSlices(t, messages,
+		[]string{"json c1", "legacy b1", "legacy b2", "legacy a1", "legacy a2", "json a3", "json c2"})
+	if len(entries) > 1 {
+		compare.OkEqualString("legacy entries have no time", entries[1].Time, "", t)
+	}
+}
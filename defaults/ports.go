@@ -0,0 +1,242 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// A port assigned to a sandbox.
+// Ports chosen by a deployment in progress are reserved with the process ID and host of the deployment,
+// which are removed when the sandbox is added to the catalog
+type PortLease struct {
+	Sandbox   string `json:"sandbox"`
+	User      string `json:"user"`
+	Timestamp string `json:"timestamp"`
+	Pid       int    `json:"pid,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+type PortRegistry map[int]PortLease
+
+const (
+	PortRegistryName  = "ports.json"
+	portRegistryLabel = "DBDEPLOYER_PORT_REGISTRY"
+)
+
+var portRegistryMutex sync.Mutex
+
+// Returns the name of the port registry file.
+// The environment variable DBDEPLOYER_PORT_REGISTRY takes precedence over
+// the "port-registry" default. Pointing several users to the same file
+// prevents port collisions among their sandboxes
+func PortRegistryFile() string {
+	registry := os.Getenv(portRegistryLabel)
+	if registry == "" {
+		registry = Defaults().PortRegistry
+	}
+	if registry == "" {
+		registry = path.Join(ConfigurationDir, PortRegistryName)
+	}
+	return registry
+}
+
+func readPortRegistry(filename string) (PortRegistry, error) {
+	registry := make(PortRegistry)
+	if !common.FileExists(filename) {
+		return registry, nil
+	}
+	contents, err := common.SlurpAsBytes(filename)
+	if err != nil {
+		return registry, err
+	}
+	if len(contents) < 2 {
+		return registry, nil
+	}
+	err = json.Unmarshal(contents, &registry)
+	if err != nil {
+		return make(PortRegistry), fmt.Errorf("error decoding port registry %s: %s", filename, err)
+	}
+	return registry, nil
+}
+
+func writePortRegistry(filename string, registry PortRegistry) error {
+	contents, err := json.MarshalIndent(registry, " ", "\t")
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(common.DirName(filename), common.BaseName(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempName := tempFile.Name()
+	_, err = tempFile.Write(contents)
+	err1 := tempFile.Close()
+	if err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(tempName, 0666)
+	}
+	if err == nil {
+		err = os.Rename(tempName, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tempName)
+	}
+	return err
+}
+
+// Changes the port registry while holding its lock
+func modifyPortRegistry(label string, modify func(PortRegistry)) error {
+	if !enableCatalogManagement {
+		return nil
+	}
+	filename := PortRegistryFile()
+	registryDir := common.DirName(filename)
+	if !common.DirExists(registryDir) {
+		err := os.MkdirAll(registryDir, globals.PublicDirectoryAttr)
+		if err != nil {
+			return fmt.Errorf(globals.ErrCreatingDirectory, registryDir, err)
+		}
+	}
+	portRegistryMutex.Lock()
+	defer portRegistryMutex.Unlock()
+	lock, err := acquireFileLock(filename+".lock", label, timeout)
+	if err != nil {
+		return err
+	}
+	// The registry may be shared among users
+	_ = os.Chmod(filename+".lock", 0666)
+	registry, err := readPortRegistry(filename)
+	if err == nil {
+		modify(registry)
+		err = writePortRegistry(filename, registry)
+	}
+	err1 := releaseFileLock(lock)
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
+// Returns true if the lease belongs to the given sandbox or to one of its nodes
+func leaseBelongsTo(lease PortLease, sandboxDir string) bool {
+	return lease.Sandbox == sandboxDir || strings.HasPrefix(lease.Sandbox, sandboxDir+"/")
+}
+
+// Returns true if the lease belongs to the same deployment as the given sandbox:
+// the sandbox itself, one of its nodes, or the sandbox that contains it
+func leaseSharedWith(lease PortLease, sandboxDir string) bool {
+	return leaseBelongsTo(lease, sandboxDir) || strings.HasPrefix(sandboxDir, lease.Sandbox+"/")
+}
+
+// Returns true if the port is still in use: the sandbox exists, or it is being deployed.
+// A reservation is abandoned when the deploying process of this host is no longer running
+func isLeaseActive(lease PortLease) bool {
+	if common.DirExists(lease.Sandbox) {
+		return true
+	}
+	if lease.Pid <= 0 {
+		return false
+	}
+	host, err := os.Hostname()
+	if err != nil || lease.Host != host {
+		return true
+	}
+	err = syscall.Kill(lease.Pid, syscall.Signal(0))
+	return err != syscall.ESRCH
+}
+
+// Registers the given ports as used by a sandbox
+func LeasePorts(sandboxDir string, ports []int) error {
+	user := os.Getenv("USER")
+	now := time.Now().Format(time.UnixDate)
+	return modifyPortRegistry(sandboxDir, func(registry PortRegistry) {
+		for _, port := range ports {
+			registry[port] = PortLease{Sandbox: sandboxDir, User: user, Timestamp: now}
+		}
+	})
+}
+
+// Reserves the given ports for a sandbox being deployed, so that concurrent deployments
+// don't choose them. If some of the ports are used by other sandboxes, nothing is reserved,
+// and the ports in use are returned
+func ReservePorts(sandboxDir string, ports []int) ([]int, error) {
+	var taken []int
+	user := os.Getenv("USER")
+	host, _ := os.Hostname()
+	now := time.Now().Format(time.UnixDate)
+	err := modifyPortRegistry(sandboxDir, func(registry PortRegistry) {
+		for _, port := range ports {
+			lease, found := registry[port]
+			if found && !leaseSharedWith(lease, sandboxDir) && isLeaseActive(lease) {
+				taken = append(taken, port)
+			}
+		}
+		if len(taken) > 0 {
+			return
+		}
+		for _, port := range ports {
+			registry[port] = PortLease{Sandbox: sandboxDir, User: user, Timestamp: now, Pid: os.Getpid(), Host: host}
+		}
+	})
+	return taken, err
+}
+
+// Removes all the port leases of a sandbox and of its nodes
+func ReleasePorts(sandboxDir string) error {
+	return modifyPortRegistry(sandboxDir, func(registry PortRegistry) {
+		for port, lease := range registry {
+			if leaseBelongsTo(lease, sandboxDir) {
+				delete(registry, port)
+			}
+		}
+	})
+}
+
+// Returns the ports leased to existing sandboxes or reserved by deployments in progress.
+// Leases belonging to sandboxes that were removed without dbdeployer are ignored
+func LeasedPorts() ([]int, error) {
+	var ports []int
+	if !enableCatalogManagement {
+		return ports, nil
+	}
+	registry, err := readPortRegistry(PortRegistryFile())
+	if err != nil {
+		return ports, err
+	}
+	for port, lease := range registry {
+		if !isLeaseActive(lease) {
+			continue
+		}
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
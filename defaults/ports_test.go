@@ -0,0 +1,151 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestPortLeases(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-ports-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	sandboxHome := Defaults().SandboxHome
+	sandboxA := path.Join(sandboxHome, "msb_8_0_16")
+	sandboxB := path.Join(sandboxHome, "rsandbox_8_0_16")
+	sandboxC := path.Join(sandboxHome, "rsandbox_8_0_1")
+	makeTestDescription(t, sandboxA, "single", "8.0.16", []int{8016, 18016})
+
+	// Lease and release
+	err := LeasePorts(sandboxA, []int{18016, 8016})
+	compare.OkIsNil("lease", err, t)
+	ports, err := LeasedPorts()
+	compare.OkIsNil("leased ports", err, t)
+	compare.OkEqualIntSlices(t, ports, []int{8016, 18016})
+
+	// Leases of sandboxes that don't exist anymore are ignored
+	err = LeasePorts(path.Join(sandboxHome, "msb_removed"), []int{5000})
+	compare.OkIsNil("lease of removed sandbox", err, t)
+	ports, err = LeasedPorts()
+	compare.OkIsNil("leased ports without removed sandbox", err, t)
+	compare.OkEqualIntSlices(t, ports, []int{8016, 18016})
+
+	// A reservation collides with the ports of other sandboxes, and then nothing is reserved
+	taken, err := ReservePorts(sandboxB, []int{8015, 8016, 8017})
+	compare.OkIsNil("colliding reservation", err, t)
+	compare.OkEqualIntSlices(t, taken, []int{8016})
+	ports, err = LeasedPorts()
+	compare.OkIsNil("leased ports after collision", err, t)
+	compare.OkEqualIntSlices(t, ports, []int{8016, 18016})
+
+	// The ports of a removed sandbox can be reserved
+	taken, err = ReservePorts(sandboxB, []int{5000, 20617, 20618})
+	compare.OkIsNil("reservation", err, t)
+	compare.OkEqualInt("ports taken", len(taken), 0, t)
+	ports, err = LeasedPorts()
+	compare.OkIsNil("leased ports after reservation", err, t)
+	compare.OkEqualIntSlices(t, ports, []int{5000, 8016, 18016, 20617, 20618})
+
+	// The reservation of a deployment in progress blocks other deployments,
+	// but not the sandbox that owns it
+	taken, err = ReservePorts(sandboxC, []int{20618, 20619})
+	compare.OkIsNil("reservation of other deployment", err, t)
+	compare.OkEqualIntSlices(t, taken, []int{20618})
+	taken, err = ReservePorts(sandboxB, []int{20618, 20619})
+	compare.OkIsNil("second reservation of the same sandbox", err, t)
+	compare.OkEqualInt("ports taken from itself", len(taken), 0, t)
+
+	// The nodes of a sandbox can use the ports reserved by the sandbox
+	taken, err = ReservePorts(path.Join(sandboxB, "master"), []int{20617})
+	compare.OkIsNil("reservation of node", err, t)
+	compare.OkEqualInt("ports taken from the node", len(taken), 0, t)
+
+	// Releasing a sandbox removes the leases of its nodes, but not the ones of similarly named sandboxes
+	err = LeasePorts(path.Join(sandboxB, "node1"), []int{30617})
+	compare.OkIsNil("lease of node", err, t)
+	taken, err = ReservePorts(sandboxC, []int{8001})
+	compare.OkIsNil("reservation of similar sandbox", err, t)
+	compare.OkEqualInt("ports taken from similar sandbox", len(taken), 0, t)
+	err = ReleasePorts(sandboxB)
+	compare.OkIsNil("release", err, t)
+	registry, err := readPortRegistry(PortRegistryFile())
+	compare.OkIsNil("reading registry", err, t)
+	for _, port := range []int{5000, 20617, 20618, 20619, 30617} {
+		_, found := registry[port]
+		compare.OkEqualBool(fmt.Sprintf("port %d released", port), found, false, t)
+	}
+	compare.OkEqualString("port of similar sandbox", registry[8001].Sandbox, sandboxC, t)
+	compare.OkEqualString("port of other sandbox", registry[8016].Sandbox, sandboxA, t)
+
+	// The reservation of a deployment that died is abandoned
+	finished := exec.Command("true")
+	err = finished.Run()
+	compare.OkIsNil("finished process", err, t)
+	host, _ := os.Hostname()
+	registry[9000] = PortLease{Sandbox: sandboxC, Pid: finished.Process.Pid, Host: host}
+	registry[9001] = PortLease{Sandbox: sandboxC, Pid: finished.Process.Pid, Host: "another-" + host}
+	err = writePortRegistry(PortRegistryFile(), registry)
+	compare.OkIsNil("writing registry", err, t)
+	taken, err = ReservePorts(sandboxB, []int{9000, 9001})
+	compare.OkIsNil("reservation over abandoned ones", err, t)
+	// The process of another host can't be checked, and its reservation is kept
+	compare.OkEqualIntSlices(t, taken, []int{9001})
+	taken, err = ReservePorts(sandboxB, []int{9000})
+	compare.OkIsNil("reservation over abandoned one", err, t)
+	compare.OkEqualInt("abandoned reservation", len(taken), 0, t)
+}
+
+func TestPortRegistryVariable(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-ports-env-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	defer os.Unsetenv(portRegistryLabel)
+	defaultRegistry := PortRegistryFile()
+	compare.OkEqualString("default registry", defaultRegistry, path.Join(ConfigurationDir, PortRegistryName), t)
+
+	sharedRegistry := path.Join(baseDir, "shared", "ports.json")
+	err := os.Setenv(portRegistryLabel, sharedRegistry)
+	compare.OkIsNil("setting port registry variable", err, t)
+	compare.OkEqualString("registry from variable", PortRegistryFile(), sharedRegistry, t)
+
+	sandboxDir := path.Join(Defaults().SandboxHome, "msb_5_7_25")
+	makeTestDescription(t, sandboxDir, "single", "5.7.25", []int{5725})
+	err = LeasePorts(sandboxDir, []int{5725})
+	compare.OkIsNil("lease in shared registry", err, t)
+	compare.OkEqualBool("shared registry created", common.FileExists(sharedRegistry), true, t)
+	compare.OkEqualBool("default registry not created", common.FileExists(defaultRegistry), false, t)
+	ports, err := LeasedPorts()
+	compare.OkIsNil("leased ports from shared registry", err, t)
+	compare.OkEqualIntSlices(t, ports, []int{5725})
+
+	// Another user sharing the registry can't reserve the same port
+	taken, err := ReservePorts(path.Join(baseDir, "other-home", "msb_5_7_25"), []int{5725})
+	compare.OkIsNil("reservation in shared registry", err, t)
+	compare.OkEqualIntSlices(t, taken, []int{5725})
+
+	err = os.Unsetenv(portRegistryLabel)
+	compare.OkIsNil("unsetting port registry variable", err, t)
+	ports, err = LeasedPorts()
+	compare.OkIsNil("leased ports from default registry", err, t)
+	compare.OkEqualInt("ports in default registry", len(ports), 0, t)
+}
@@ -0,0 +1,248 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// A profile is a named set of defaults, stored in ProfilesDir/<name>.json.
+// It contains only the values that it changes: all the others come from the factory defaults.
+
+const (
+	OriginFactory    = "factory defaults"
+	OriginConfigFile = "configuration file"
+)
+
+var profileNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Returns the file containing a profile
+func ProfileFile(name string) string {
+	return path.Join(ProfilesDir, name+".json")
+}
+
+func ProfileExists(name string) bool {
+	return common.FileExists(ProfileFile(name))
+}
+
+func checkProfileName(name string) error {
+	if !profileNameRe.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s'. Use only letters, digits, '.', '-', and '_'", name)
+	}
+	return nil
+}
+
+// Returns the names of the available profiles
+func ProfileNames() ([]string, error) {
+	var names []string
+	if !common.DirExists(ProfilesDir) {
+		return names, nil
+	}
+	files, err := ioutil.ReadDir(ProfilesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ".json") {
+			names = append(names, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Creates a profile that does not change any value
+func CreateProfile(name string) error {
+	err := checkProfileName(name)
+	if err != nil {
+		return err
+	}
+	if ProfileExists(name) {
+		return fmt.Errorf("profile '%s' already exists", name)
+	}
+	err = os.MkdirAll(ProfilesDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	return common.WriteString("{\n}", ProfileFile(name))
+}
+
+func RemoveProfile(name string) error {
+	err := checkProfileName(name)
+	if err != nil {
+		return err
+	}
+	if !ProfileExists(name) {
+		return fmt.Errorf("profile '%s' not found", name)
+	}
+	return os.Remove(ProfileFile(name))
+}
+
+// Returns the values set in a file of defaults, indexed by label
+func readDefaultsValues(filename string) (map[string]json.RawMessage, error) {
+	var values = make(map[string]json.RawMessage)
+	blob, err := common.SlurpAsBytes(filename)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(blob, &values)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %s", filename, err)
+	}
+	return values, nil
+}
+
+func defaultsToValues(defaults DbdeployerDefaults) (map[string]json.RawMessage, error) {
+	var values = make(map[string]json.RawMessage)
+	blob, err := json.Marshal(replaceLiteralEnvValues(defaults))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(blob, &values)
+	return values, err
+}
+
+// Returns the defaults of a profile: the factory defaults, with the values that the profile changes
+func ReadProfile(name string) (DbdeployerDefaults, error) {
+	err := checkProfileName(name)
+	if err != nil {
+		return DbdeployerDefaults{}, err
+	}
+	if !ProfileExists(name) {
+		return DbdeployerDefaults{}, fmt.Errorf("profile '%s' not found. "+
+			"It can be created with 'dbdeployer defaults profile create %s'", name, name)
+	}
+	defaults := factoryDefaults
+	defaults.ReservedPorts = append([]int{}, factoryDefaults.ReservedPorts...)
+	blob, err := common.SlurpAsBytes(ProfileFile(name))
+	if err != nil {
+		return DbdeployerDefaults{}, err
+	}
+	err = json.Unmarshal(blob, &defaults)
+	if err != nil {
+		return DbdeployerDefaults{}, fmt.Errorf("error decoding profile %s: %s", name, err)
+	}
+	return expandEnvironmentVariables(defaults), nil
+}
+
+// Saves the defaults into a profile. The profile keeps the values that it already set,
+// and adds the ones that differ from the factory defaults
+func WriteProfile(name string, defaults DbdeployerDefaults) error {
+	err := checkProfileName(name)
+	if err != nil {
+		return err
+	}
+	var existing = make(map[string]json.RawMessage)
+	if ProfileExists(name) {
+		existing, err = readDefaultsValues(ProfileFile(name))
+		if err != nil {
+			return err
+		}
+	}
+	newValues, err := defaultsToValues(defaults)
+	if err != nil {
+		return err
+	}
+	factoryValues, err := defaultsToValues(factoryDefaults)
+	if err != nil {
+		return err
+	}
+	var profile = make(map[string]json.RawMessage)
+	for label, value := range newValues {
+		if label == "timestamp" {
+			continue
+		}
+		_, wasSet := existing[label]
+		if wasSet || string(value) != string(factoryValues[label]) {
+			profile[label] = value
+		}
+	}
+	b, err := json.MarshalIndent(profile, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(ProfilesDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return err
+	}
+	return common.WriteString(string(b), ProfileFile(name))
+}
+
+// Returns the origin of the current defaults, indexed by label:
+// the active profile or the configuration file when they set the value,
+// factory defaults otherwise
+func DefaultsOrigins() (map[string]string, error) {
+	factoryValues, err := defaultsToValues(factoryDefaults)
+	if err != nil {
+		return nil, err
+	}
+	var origins = make(map[string]string)
+	for label := range factoryValues {
+		origins[label] = OriginFactory
+	}
+	var values map[string]json.RawMessage
+	var origin string
+	if ActiveProfile != "" {
+		err = checkProfileName(ActiveProfile)
+		if err != nil {
+			return nil, err
+		}
+		values, err = readDefaultsValues(ProfileFile(ActiveProfile))
+		origin = "profile " + ActiveProfile
+	} else if common.FileExists(ConfigurationFile) {
+		values, err = readDefaultsValues(ConfigurationFile)
+		origin = OriginConfigFile
+	}
+	if err != nil {
+		return nil, err
+	}
+	for label := range values {
+		origins[label] = origin
+	}
+	return origins, nil
+}
+
+// Shows which values of the current defaults are set by the active profile
+func showOrigins() {
+	origins, err := DefaultsOrigins()
+	common.ErrCheckExitf(err, 1, "error reading origin of defaults: %s", err)
+	var labels []string
+	for label, origin := range origins {
+		if origin != OriginFactory && label != "timestamp" {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	if len(labels) == 0 {
+		common.CondPrintf("# All values come from %s\n", OriginFactory)
+		return
+	}
+	common.CondPrintln("# Origin of values:")
+	for _, label := range labels {
+		common.CondPrintf("#   %-35s %s\n", label, origins[label])
+	}
+	common.CondPrintf("#   %-35s %s\n", "(all others)", OriginFactory)
+}
@@ -0,0 +1,160 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defaults
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestProfiles(t *testing.T) {
+	baseDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-profiles-%d", os.Getpid()))
+	defer os.RemoveAll(baseDir)
+	setTestDefaults(t, baseDir)
+	defer func() {
+		ActiveProfile = ""
+		currentDefaults = factoryDefaults
+	}()
+
+	_, err := ReadProfile("missing")
+	compare.OkIsNotNil("reading a missing profile", err, t)
+	for _, name := range []string{"", "-dash", "with space", "with/slash"} {
+		err = CreateProfile(name)
+		compare.OkIsNotNil(fmt.Sprintf("creating profile '%s'", name), err, t)
+	}
+
+	err = CreateProfile("empty")
+	compare.OkIsNil("creating profile 'empty'", err, t)
+	err = CreateProfile("empty")
+	compare.OkIsNotNil("creating profile 'empty' twice", err, t)
+	profile, err := ReadProfile("empty")
+	compare.OkIsNil("reading profile 'empty'", err, t)
+	compare.OkEqualString("empty profile master name", profile.MasterName, factoryDefaults.MasterName, t)
+	compare.OkEqualInt("empty profile base port", profile.MasterSlaveBasePort, factoryDefaults.MasterSlaveBasePort, t)
+
+	// A profile stores only the values that differ from the factory defaults
+	changed := factoryDefaults
+	changed.MasterName = "primary"
+	changed.MasterSlaveBasePort = 21000
+	err = WriteProfile("test", changed)
+	compare.OkIsNil("writing profile 'test'", err, t)
+	values, err := readDefaultsValues(ProfileFile("test"))
+	compare.OkIsNil("reading values of profile 'test'", err, t)
+	var labels []string
+	for label := range values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	compare.OkEqualStringSlices(t, labels, []string{"master-name", "master-slave-base-port"})
+
+	// Values that the profile already set are kept, even when they are back to the factory defaults
+	changed.MasterName = factoryDefaults.MasterName
+	changed.SlavePrefix = "replica"
+	err = WriteProfile("test", changed)
+	compare.OkIsNil("updating profile 'test'", err, t)
+	profile, err = ReadProfile("test")
+	compare.OkIsNil("reading profile 'test'", err, t)
+	compare.OkEqualString("profile master name", profile.MasterName, factoryDefaults.MasterName, t)
+	compare.OkEqualString("profile slave prefix", profile.SlavePrefix, "replica", t)
+	compare.OkEqualInt("profile base port", profile.MasterSlaveBasePort, 21000, t)
+	compare.OkEqualString("profile node prefix", profile.NodePrefix, factoryDefaults.NodePrefix, t)
+	values, err = readDefaultsValues(ProfileFile("test"))
+	compare.OkIsNil("reading values of profile 'test'", err, t)
+	compare.OkEqualInt("values in profile 'test'", len(values), 3, t)
+
+	names, err := ProfileNames()
+	compare.OkIsNil("profile names", err, t)
+	compare.OkEqualStringSlices(t, names, []string{"empty", "test"})
+
+	// Without a profile or a configuration file, all values come from the factory defaults
+	origins, err := DefaultsOrigins()
+	compare.OkIsNil("origins of factory defaults", err, t)
+	compare.OkEqualString("origin of master name", origins["master-name"], OriginFactory, t)
+
+	// The configuration file sets the values when there is no active profile
+	fileDefaults := factoryDefaults
+	fileDefaults.MasterName = "boss"
+	fileDefaults.NodePrefix = "server"
+	WriteDefaultsFile(ConfigurationFile, fileDefaults)
+	origins, err = DefaultsOrigins()
+	compare.OkIsNil("origins of configuration file", err, t)
+	compare.OkEqualString("origin of master name", origins["master-name"], OriginConfigFile, t)
+	currentDefaults = DbdeployerDefaults{}
+	current, err := LoadDefaults()
+	compare.OkIsNil("loading defaults from configuration file", err, t)
+	compare.OkEqualString("configuration file master name", current.MasterName, "boss", t)
+
+	// The active profile takes precedence over both the configuration file and the factory defaults
+	ActiveProfile = "test"
+	currentDefaults = DbdeployerDefaults{}
+	current, err = LoadDefaults()
+	compare.OkIsNil("loading defaults from profile", err, t)
+	compare.OkEqualString("active profile slave prefix", current.SlavePrefix, "replica", t)
+	compare.OkEqualInt("active profile base port", current.MasterSlaveBasePort, 21000, t)
+	compare.OkEqualString("active profile master name", current.MasterName, factoryDefaults.MasterName, t)
+	compare.OkEqualString("active profile node prefix", current.NodePrefix, factoryDefaults.NodePrefix, t)
+	compare.OkEqualString("defaults destination", DefaultsDestination(), ProfileFile("test"), t)
+	origins, err = DefaultsOrigins()
+	compare.OkIsNil("origins of profile", err, t)
+	compare.OkEqualString("origin of slave prefix", origins["slave-prefix"], "profile test", t)
+	compare.OkEqualString("origin of master name", origins["master-name"], "profile test", t)
+	compare.OkEqualString("origin of node prefix", origins["node-prefix"], OriginFactory, t)
+
+	// A broken profile is reported as an error
+	err = common.WriteString("{ not json", ProfileFile("test"))
+	compare.OkIsNil("breaking profile 'test'", err, t)
+	_, err = ReadProfile("test")
+	compare.OkIsNotNil("reading broken profile", err, t)
+	currentDefaults = DbdeployerDefaults{}
+	_, err = LoadDefaults()
+	compare.OkIsNotNil("loading broken profile", err, t)
+	_, err = DefaultsOrigins()
+	compare.OkIsNotNil("origins of broken profile", err, t)
+
+	// A missing active profile is reported as an error, instead of falling back to other defaults
+	ActiveProfile = "missing"
+	currentDefaults = DbdeployerDefaults{}
+	_, err = LoadDefaults()
+	compare.OkIsNotNil("loading missing profile", err, t)
+
+	// Profiles outside of the profiles directory can't be read, even when the file exists
+	outside := "../outside"
+	err = common.WriteString("{\n}", ProfileFile(outside))
+	compare.OkIsNil("creation of file outside profiles directory", err, t)
+	_, err = ReadProfile(outside)
+	compare.OkIsNotNil("reading profile outside profiles directory", err, t)
+	ActiveProfile = outside
+	currentDefaults = DbdeployerDefaults{}
+	_, err = LoadDefaults()
+	compare.OkIsNotNil("loading profile outside profiles directory", err, t)
+	_, err = DefaultsOrigins()
+	compare.OkIsNotNil("origins of profile outside profiles directory", err, t)
+	err = RemoveProfile(outside)
+	compare.OkIsNotNil("removing profile outside profiles directory", err, t)
+	compare.OkEqualBool("file outside profiles directory kept", common.FileExists(ProfileFile(outside)), true, t)
+	ActiveProfile = "test"
+
+	err = RemoveProfile("test")
+	compare.OkIsNil("removing profile 'test'", err, t)
+	err = RemoveProfile("test")
+	compare.OkIsNotNil("removing profile 'test' twice", err, t)
+}
@@ -25,6 +25,7 @@ var UsingDbDeployer = false
 const (
 	// Instantiated in cmd/root.go
 	ConfigLabel        = "config"
+	ProfileLabel       = "profile"
 	SandboxBinaryLabel = "sandbox-binary"
 	SandboxHomeLabel   = "sandbox-home"
 
@@ -41,11 +42,14 @@ const (
 	DbUserValue            = "msandbox"
 	DefaultsLabel          = "defaults"
 	DisableMysqlXLabel     = "disable-mysqlx"
+	DownloadLabel          = "download"
 	EnableGeneralLogLabel  = "enable-general-log"
 	EnableMysqlXLabel      = "enable-mysqlx"
 	ExposeDdTablesLabel    = "expose-dd-tables"
+	ExtraScriptsLabel      = "extra-scripts"
 	ForceLabel             = "force"
 	GtidLabel              = "gtid"
+	HookLabel              = "hook"
 	HistoryDirLabel        = "history-dir"
 	InitGeneralLogLabel    = "init-general-log"
 	InitOptionsLabel       = "init-options"
@@ -78,6 +82,13 @@ const (
 	// Instantiated in cmd/single.go
 	MasterLabel = "master"
 
+	// Instantiated in cmd/apply.go
+	DryRunLabel = "dry-run"
+
+	// Instantiated in cmd/redeploy.go
+	FullLabel   = "full"
+	ReplayLabel = "replay"
+
 	// Instantiated in cmd/replication.go
 	AllMastersLabel     = "all-masters"
 	FanInLabel          = "fan-in"
@@ -100,26 +111,62 @@ const (
 	TopologyValue       = "master-slave"
 
 	// Instantiated in cmd/unpack.go and unpack/unpack.go
-	GzExt              = ".gz"
-	PrefixLabel        = "prefix"
-	ShellLabel         = "shell"
-	TarExt             = ".tar"
-	TarGzExt           = ".tar.gz"
-	TarXzExt           = ".tar.xz"
-	TargetServerLabel  = "target-server"
-	TgzExt             = ".tgz"
-	UnpackVersionLabel = "unpack-version"
-	VerbosityLabel     = "verbosity"
-	FlavorLabel        = "flavor"
-	FlavorFileName     = "FLAVOR"
+	GzExt                = ".gz"
+	PrefixLabel          = "prefix"
+	ShellLabel           = "shell"
+	TarExt               = ".tar"
+	TarGzExt             = ".tar.gz"
+	TarXzExt             = ".tar.xz"
+	TarBz2Ext            = ".tar.bz2"
+	TarZstExt            = ".tar.zst"
+	ZipExt               = ".zip"
+	TargetServerLabel    = "target-server"
+	TgzExt               = ".tgz"
+	UnpackVersionLabel   = "unpack-version"
+	VerbosityLabel       = "verbosity"
+	FlavorLabel          = "flavor"
+	FlavorFileName       = "FLAVOR"
+	VersionCheckLabel    = "version-check"
+	VersionCheckWarn     = "warn"
+	VersionCheckStrict   = "strict"
+	VersionCheckNone     = "none"
+	MinimalLabel         = "minimal"
+	SkipPatternLabel     = "skip-pattern"
+	ChecksumLabel        = "checksum"
+	VerifySignatureLabel = "verify-signature"
+
+	// Instantiated in cmd/catalog.go
+	PruneLabel   = "prune"
+	RebuildLabel = "rebuild"
 
 	// Instantiated in cmd/delete.go
 	SkipConfirmLabel = "skip-confirm"
 	ConfirmLabel     = "confirm"
 
+	// Instantiated in cmd/global.go
+	TypeLabel            = "type"
+	VersionLabel         = "version"
+	NameLabel            = "name"
+	ExcludeLabel         = "exclude"
+	ContinueOnErrorLabel = "continue-on-error"
+	FormatLabel          = "format"
+
+	// Instantiated in cmd/log.go
+	LogLevelLabel  = "level"
+	NodeLabel      = "node"
+	OperationLabel = "operation"
+	LastLabel      = "last"
+	JsonLabel      = "json"
+
+	// Instantiated in cmd/remote.go
+	RetriesLabel = "retries"
+	RefreshLabel = "refresh"
+
 	// Instantiated in cmd/sandboxes.go
 	CatalogLabel = "catalog"
 	HeaderLabel  = "header"
+	StatusLabel  = "status"
+	PingLabel    = "ping"
 
 	// Instantiated in cmd/templates.go
 	SimpleLabel       = "simple"
@@ -258,6 +305,9 @@ const (
 	ForbiddenDirName       = "lost+found"
 )
 
+// Archive extensions recognized by dbdeployer
+var ArchiveExtensions = []string{TarGzExt, TgzExt, TarXzExt, TarBz2Ext, TarZstExt, ZipExt, TarExt}
+
 var (
 	DashLine     = strings.Repeat("-", lineLength)
 	StarLine     = strings.Repeat("*", lineLength)
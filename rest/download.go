@@ -0,0 +1,246 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"fmt"
+	"github.com/datacharmer/dbdeployer/common"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Controls how a file is downloaded
+type DownloadOptions struct {
+	// Number of attempts after the first one has failed
+	Retries int
+	// Wait before the first retry. It doubles at every new attempt
+	RetryDelay time.Duration
+	// Where the progress bar is displayed. Nil means no progress bar
+	Progress io.Writer
+}
+
+const (
+	// Suffix of the file that holds an incomplete download
+	PartialSuffix = ".partial"
+
+	maxRetryDelay    = 30 * time.Second
+	progressInterval = 250 * time.Millisecond
+	progressBarWidth = 30
+)
+
+// HTTP client that also reads file:// URLs, used for repositories in local or shared directories
+var httpClient = newHttpClient()
+
+func newHttpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return &http.Client{Transport: transport}
+}
+
+var DefaultDownloadOptions = DownloadOptions{
+	Retries:    5,
+	RetryDelay: time.Second,
+}
+
+// A download error that is not worth retrying
+type permanentError struct {
+	error
+}
+
+// Returns true if a network error is likely to go away by itself, such as a timeout
+// or a connection reset. Unknown hosts and refused connections are not retried
+func isTransientNetError(err error) bool {
+	if urlError, ok := err.(*neturl.Error); ok {
+		err = urlError.Err
+	}
+	if opError, ok := err.(*net.OpError); ok {
+		if dnsError, ok := opError.Err.(*net.DNSError); ok {
+			err = dnsError
+		}
+	}
+	if dnsError, ok := err.(*net.DNSError); ok {
+		return dnsError.IsTimeout
+	}
+	netError, ok := err.(net.Error)
+	return ok && (netError.Timeout() || netError.Temporary())
+}
+
+// Shows how much of a download has been completed
+type progressWriter struct {
+	out       io.Writer
+	total     int64
+	done      int64
+	resumed   int64
+	start     time.Time
+	lastShown time.Time
+}
+
+func (pw *progressWriter) Write(data []byte) (int, error) {
+	pw.done += int64(len(data))
+	if time.Since(pw.lastShown) >= progressInterval {
+		pw.show()
+	}
+	return len(data), nil
+}
+
+func (pw *progressWriter) show() {
+	pw.lastShown = time.Now()
+	elapsed := time.Since(pw.start).Seconds()
+	throughput := int64(0)
+	if elapsed > 0 {
+		throughput = int64(float64(pw.done-pw.resumed) / elapsed)
+	}
+	if pw.total <= 0 {
+		fmt.Fprintf(pw.out, "\r%10s  %10s/s", common.HumanSize(pw.done), common.HumanSize(throughput))
+		return
+	}
+	filled := int(pw.done * progressBarWidth / pw.total)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(pw.out, "\r[%s] %3d%% %8s/%-8s %8s/s", bar, pw.done*100/pw.total,
+		common.HumanSize(pw.done), common.HumanSize(pw.total), common.HumanSize(throughput))
+}
+
+func (pw *progressWriter) finish() {
+	pw.show()
+	fmt.Fprintln(pw.out)
+}
+
+// Downloads a URL to a local file, with the default options
+func DownloadFile(filepath string, url string) error {
+	return DownloadFileWithOptions(filepath, url, DefaultDownloadOptions)
+}
+
+// Downloads a URL to a local file. The data goes to a ".partial" file, which is renamed
+// to the final name when the download is complete. If the partial file exists,
+// the download resumes from where it stopped, provided that the server supports ranges.
+// Attempts that fail for transient reasons (timeouts, temporary network errors, server errors,
+// interrupted transfers) are retried with exponential back off. Other errors fail at once.
+func DownloadFileWithOptions(filepath string, url string, options DownloadOptions) error {
+	delay := options.RetryDelay
+	var err error
+	for attempt := 0; attempt <= options.Retries; attempt++ {
+		if attempt > 0 {
+			if options.Progress != nil {
+				fmt.Fprintf(options.Progress, "# %s. Retrying in %s (%d/%d)\n", err, delay, attempt, options.Retries)
+			}
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+		}
+		err = downloadAttempt(filepath, url, options.Progress)
+		if err == nil {
+			return nil
+		}
+		if perm, ok := err.(permanentError); ok {
+			return perm.error
+		}
+	}
+	return err
+}
+
+// Transfers a file, starting from the end of the partial download, if there is one
+func downloadAttempt(filepath string, url string, progress io.Writer) error {
+	partialFile := filepath + PartialSuffix
+	var offset int64
+	stat, err := os.Stat(partialFile)
+	if err == nil {
+		offset = stat.Size()
+	}
+
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return permanentError{fmt.Errorf("[DownloadFile] invalid URL %s: %s", url, err)}
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		if !isTransientNetError(err) {
+			return permanentError{fmt.Errorf("[DownloadFile] error getting %s: %s", url, err)}
+		}
+		return fmt.Errorf("[DownloadFile] error getting %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			// The server sent a range we did not ask for. Start again from scratch
+			_ = os.Remove(partialFile)
+			return fmt.Errorf("[DownloadFile] unexpected content range '%s'", resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range request, or there was nothing to resume
+		offset = 0
+		flags |= os.O_TRUNC
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file does not belong to the current remote file
+		_ = os.Remove(partialFile)
+		return fmt.Errorf("[DownloadFile] partial download of %s could not be resumed", url)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("[DownloadFile] received code %d ", resp.StatusCode)
+	default:
+		return permanentError{fmt.Errorf("[DownloadFile] received code %d ", resp.StatusCode)}
+	}
+
+	out, err := os.OpenFile(partialFile, flags, 0666)
+	if err != nil {
+		return permanentError{fmt.Errorf("[DownloadFile] error creating file %s: %s", partialFile, err)}
+	}
+	var writer io.Writer = out
+	var pw *progressWriter
+	if progress != nil {
+		total := int64(-1)
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+		now := time.Now()
+		pw = &progressWriter{out: progress, total: total, done: offset, resumed: offset, start: now, lastShown: now}
+		writer = io.MultiWriter(out, pw)
+	}
+	written, err := io.Copy(writer, resp.Body)
+	if pw != nil {
+		pw.finish()
+	}
+	err1 := out.Close()
+	if err == nil {
+		err = err1
+	}
+	if err != nil {
+		// A transfer interrupted by the network can be resumed, while a failing file system can't
+		if _, isFileError := err.(*os.PathError); isFileError {
+			return permanentError{fmt.Errorf("[DownloadFile] error during data writing to file %s: %s", filepath, err)}
+		}
+		return fmt.Errorf("[DownloadFile] error during data writing to file %s: %s", filepath, err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fmt.Errorf("[DownloadFile] incomplete transfer: got %d bytes out of %d", written, resp.ContentLength)
+	}
+	return os.Rename(partialFile, filepath)
+}
@@ -16,85 +16,395 @@
 package rest
 
 import (
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
 	"github.com/pkg/errors"
-	"io"
-	"net/http"
+	"io/ioutil"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type RemoteFilesMap = map[string][]string
+// A file listed in the remote index.
+// The index may list plain file names, or objects with name and checksum
+type RemoteFile struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum,omitempty"`
+	// Operating system of the binaries ("linux", "darwin"). When missing, it is deduced from the name
+	OS string `json:"os,omitempty"`
+}
+
+type RemoteFilesMap = map[string][]RemoteFile
+
+func (rf *RemoteFile) UnmarshalJSON(data []byte) error {
+	var name string
+	if json.Unmarshal(data, &name) == nil {
+		rf.Name = name
+		rf.Checksum = ""
+		return nil
+	}
+	// A different type prevents recursive calls to this method
+	type remoteFileObject RemoteFile
+	var object remoteFileObject
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return err
+	}
+	*rf = RemoteFile(object)
+	return nil
+}
 
 // var RemoteRepo string = "https://github.com/datacharmer/mysql-docker-minimal/blob/master/dbdata"
 // var RemoteRepoRaw string = "https://raw.githubusercontent.com/datacharmer/mysql-docker-minimal/master/dbdata"
 var FileUrlTemplate string = "{{.RemoteRepo}}/{{.FileName}}"
 var IndexUrlTemplate string = "{{.RemoteRepo}}/{{.FileName}}"
 
-func IndexUrl() string {
+// Returns the remote repositories, in the order in which they are searched.
+// Local directories are converted to file:// URLs
+func RemoteRepositories() []string {
+	repositories := defaults.Defaults().RemoteRepositories
+	if len(repositories) == 0 {
+		repositories = []string{defaults.Defaults().RemoteRepository}
+	}
+	var result []string
+	for _, repository := range repositories {
+		repository = strings.TrimRight(strings.TrimSpace(repository), "/")
+		if repository == "" {
+			continue
+		}
+		if strings.HasPrefix(repository, "/") {
+			repository = "file://" + repository
+		}
+		result = append(result, repository)
+	}
+	return result
+}
+
+// Returns the URL of the index in the given repository
+func IndexUrlFor(repository string) string {
 	var data = common.StringMap{
-		"RemoteRepo": defaults.Defaults().RemoteRepository,
+		"RemoteRepo": repository,
 		"FileName":   defaults.Defaults().RemoteIndexFile,
 	}
 	return common.TemplateFill(IndexUrlTemplate, data)
 }
 
-func FileUrl(fileName string) string {
+// Returns the URL of a file in the given repository
+func FileUrlFor(repository, fileName string) string {
 	var data = common.StringMap{
-		"RemoteRepo": defaults.Defaults().RemoteRepository,
+		"RemoteRepo": repository,
 		"FileName":   fileName,
 	}
 	return common.TemplateFill(FileUrlTemplate, data)
 }
 
-// DownloadFile will download a url to a local file. It's efficient because it will
-// write as it downloads and not load the whole file into memory.
-func DownloadFile(filepath string, url string) error {
+const errNoRepositories = "no remote repositories defined"
 
-	// Get the data
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("[DownloadFile] error getting %s: %s", url, err)
+// Returns the first repository of a list, or an error when the list is empty
+func firstRepository(repositories []string) (string, error) {
+	if len(repositories) == 0 {
+		return "", fmt.Errorf(errNoRepositories)
 	}
-	defer resp.Body.Close()
+	return repositories[0], nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("[DownloadFile] received code %d ", resp.StatusCode)
+// Returns the URL of the index in the first repository
+func IndexUrl() (string, error) {
+	repository, err := firstRepository(RemoteRepositories())
+	if err != nil {
+		return "", err
 	}
+	return IndexUrlFor(repository), nil
+}
 
-	// Create the file
-	out, err := os.Create(filepath)
+// Returns the URL of a file in the first repository
+func FileUrl(fileName string) (string, error) {
+	repository, err := firstRepository(RemoteRepositories())
 	if err != nil {
-		return fmt.Errorf("[DownloadFile] error creating file %s", filepath)
+		return "", err
 	}
-	defer out.Close()
+	return FileUrlFor(repository, fileName), nil
+}
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return fmt.Errorf("[DownloadFile] error during data writing to file %s: %s", filepath, err)
+// Time during which the cached remote index is used without downloading it again.
+// It can be changed with DBDEPLOYER_INDEX_TTL (minutes). Zero disables the cache
+var IndexTTL = indexTTL()
+
+func indexTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("DBDEPLOYER_INDEX_TTL"))
+	if err != nil || minutes < 0 {
+		minutes = 60
 	}
+	return time.Duration(minutes) * time.Minute
+}
 
-	return nil
+// Returns the cached copy of the index found at the given URL.
+// Each URL has its own file
+func indexCacheFile(indexUrl string) string {
+	return path.Join(defaults.CacheDir, fmt.Sprintf("index-%x.json", sha1.Sum([]byte(indexUrl))))
 }
 
-func GetRemoteIndex() (index RemoteFilesMap, err error) {
+// The index of a remote repository, or the error met while getting it
+type RepositoryIndex struct {
+	Repository string
+	Index      RemoteFilesMap
+	Err        error
+}
 
-	localFileName := "/tmp/available.json"
+// Returns the index of every remote repository, using the cached copies
+// when they are recent enough, unless refresh is requested
+func GetRepositoryIndexes(refresh bool) []RepositoryIndex {
+	ttl := IndexTTL
+	if refresh {
+		ttl = 0
+	}
+	var indexes []RepositoryIndex
+	for _, repository := range RemoteRepositories() {
+		index, err := getIndex(IndexUrlFor(repository), ttl)
+		indexes = append(indexes, RepositoryIndex{Repository: repository, Index: index, Err: err})
+	}
+	return indexes
+}
 
-	err = DownloadFile(localFileName, IndexUrl())
-	if err != nil {
-		return index, errors.Wrapf(err, "error retrieving downloads index")
+// Combines the indexes of all repositories. When a file is listed in
+// several repositories, the entry of the first one is used.
+// It fails only if no index could be retrieved
+func mergeIndexes(indexes []RepositoryIndex) (RemoteFilesMap, error) {
+	var merged = make(RemoteFilesMap)
+	var seen = make(map[string]bool)
+	var firstError error
+	found := false
+	for _, ri := range indexes {
+		if ri.Err != nil {
+			if firstError == nil {
+				firstError = ri.Err
+			}
+			continue
+		}
+		found = true
+		for version, files := range ri.Index {
+			for _, rf := range files {
+				if !seen[rf.Name] {
+					seen[rf.Name] = true
+					merged[version] = append(merged[version], rf)
+				}
+			}
+		}
+	}
+	if !found {
+		if firstError == nil {
+			firstError = fmt.Errorf(errNoRepositories)
+		}
+		return nil, firstError
 	}
+	return merged, nil
+}
 
+// Returns the combined index of the remote repositories,
+// using the cached copies when they are recent enough
+func GetRemoteIndex() (index RemoteFilesMap, err error) {
+	return mergeIndexes(GetRepositoryIndexes(false))
+}
+
+// Returns the combined index of the remote repositories, downloading them even if cached copies exist
+func RefreshRemoteIndex() (index RemoteFilesMap, err error) {
+	return mergeIndexes(GetRepositoryIndexes(true))
+}
+
+func readIndex(fileName string) (index RemoteFilesMap, err error) {
 	var availableText []byte
-	availableText, err = common.SlurpAsBytes(localFileName)
+	availableText, err = common.SlurpAsBytes(fileName)
 	if err != nil {
 		return
 	}
 	err = json.Unmarshal(availableText, &index)
 	return
+}
 
+// Options used to download the remote index
+var indexDownloadOptions = DefaultDownloadOptions
+
+func getIndex(indexUrl string, ttl time.Duration) (index RemoteFilesMap, err error) {
+	cacheFile := indexCacheFile(indexUrl)
+	if ttl > 0 {
+		stat, err := os.Stat(cacheFile)
+		if err == nil && time.Since(stat.ModTime()) < ttl {
+			index, err = readIndex(cacheFile)
+			if err == nil {
+				return index, nil
+			}
+		}
+	}
+
+	err = os.MkdirAll(defaults.CacheDir, globals.PublicDirectoryAttr)
+	if err != nil {
+		return index, errors.Wrapf(err, "error creating cache directory %s", defaults.CacheDir)
+	}
+	// Each invocation downloads to its own file. The cached copy is replaced
+	// by a rename, so that concurrent readers never see an incomplete index
+	tempFile, err := ioutil.TempFile(defaults.CacheDir, "index-*.tmp")
+	if err != nil {
+		return index, err
+	}
+	localFileName := tempFile.Name()
+	_ = tempFile.Close()
+	defer os.Remove(localFileName)
+	// A failed transfer must not leave a partial file behind, as it would never be resumed
+	defer os.Remove(localFileName + PartialSuffix)
+
+	err = DownloadFileWithOptions(localFileName, indexUrl, indexDownloadOptions)
+	if err != nil {
+		return index, errors.Wrapf(err, "error retrieving downloads index")
+	}
+	index, err = readIndex(localFileName)
+	if err != nil {
+		return index, errors.Wrapf(err, "error decoding downloads index")
+	}
+	err = os.Rename(localFileName, cacheFile)
+	return index, err
+}
+
+// Returns the index entry for a file name
+func FindRemoteFile(index RemoteFilesMap, fileName string) (RemoteFile, bool) {
+	for _, files := range index {
+		for _, rf := range files {
+			if rf.Name == fileName {
+				return rf, true
+			}
+		}
+	}
+	return RemoteFile{}, false
+}
+
+// Returns the operating system for which a remote file was built.
+// Names without any indication denote Linux tarballs, as in the default repository
+func (rf RemoteFile) OperatingSystem() string {
+	if rf.OS != "" {
+		return strings.ToLower(rf.OS)
+	}
+	name := strings.ToLower(rf.Name)
+	for _, marker := range []string{"macos", "darwin", "osx"} {
+		if strings.Contains(name, marker) {
+			return "darwin"
+		}
+	}
+	return "linux"
+}
+
+var reRemoteVersion = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+// Returns the tarball in the index that best matches the requested version, flavor and operating system,
+// together with its full version. A short version (such as 8.0) selects the latest release in that series.
+// When several tarballs match the same version, the first one listed in the index is returned,
+// looking at the index entries in alphabetical order
+func FindRemoteTarball(index RemoteFilesMap, version, flavor, operatingSystem string) (RemoteFile, string, error) {
+	var best RemoteFile
+	var bestVersion string
+	var bestList []int
+	var keys []string
+	for key := range index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, rf := range index[key] {
+			fileVersion := reRemoteVersion.FindString(rf.Name)
+			if fileVersion == "" {
+				continue
+			}
+			if fileVersion != version && !strings.HasPrefix(fileVersion, version+".") {
+				continue
+			}
+			fileFlavor := common.DetectTarballFlavor(rf.Name)
+			if fileFlavor == "" {
+				fileFlavor = common.MySQLFlavor
+			}
+			if fileFlavor != flavor || rf.OperatingSystem() != operatingSystem {
+				continue
+			}
+			versionList, err := common.VersionToList(fileVersion)
+			if err != nil {
+				continue
+			}
+			if bestList != nil {
+				greater, err := common.GreaterOrEqualVersionList(bestList, versionList)
+				if err != nil || greater {
+					continue
+				}
+			}
+			best, bestVersion, bestList = rf, fileVersion, versionList
+		}
+	}
+	if bestList == nil {
+		return best, "", fmt.Errorf("no %s tarball for version %s and operating system %s found in the remote index",
+			flavor, version, operatingSystem)
+	}
+	return best, bestVersion, nil
+}
+
+// Downloads a file and checks it against the expected checksum, if one is given.
+// The file is removed when the checksum does not match
+func DownloadVerifiedFile(filepath, url, checksum string, options DownloadOptions) error {
+	err := DownloadFileWithOptions(filepath, url, options)
+	if err != nil {
+		return err
+	}
+	if checksum == "" {
+		return nil
+	}
+	err = common.VerifyChecksum(filepath, checksum)
+	if err != nil {
+		_ = os.Remove(filepath)
+		return err
+	}
+	return nil
+}
+
+// Describes where a file was downloaded from
+type DownloadResult struct {
+	Repository string
+	Url        string
+	// The checksum that was verified. Empty if the file was not verified
+	Checksum string
+}
+
+// Downloads a file from the first remote repository that can provide it.
+// When no checksum is given, the one listed in the repository index, if any, is used.
+// Messages about each attempt go to the progress writer in options
+func DownloadFromRepositories(filepath, fileName, checksum string, options DownloadOptions) (DownloadResult, error) {
+	var errorList []string
+	for _, repository := range RemoteRepositories() {
+		result := DownloadResult{Repository: repository, Url: FileUrlFor(repository, fileName), Checksum: checksum}
+		if result.Checksum == "" {
+			index, err := getIndex(IndexUrlFor(repository), IndexTTL)
+			if err == nil {
+				remoteFile, found := FindRemoteFile(index, fileName)
+				if found {
+					result.Checksum = remoteFile.Checksum
+				}
+			}
+		}
+		if options.Progress != nil {
+			fmt.Fprintf(options.Progress, "Downloading %s\n", result.Url)
+		}
+		err := DownloadVerifiedFile(filepath, result.Url, result.Checksum, options)
+		if err == nil {
+			return result, nil
+		}
+		if options.Progress != nil {
+			fmt.Fprintf(options.Progress, "# %s\n", err)
+		}
+		// A partial download is kept: mirrors are expected to hold the same files,
+		// and the checksum, when available, detects any difference
+		errorList = append(errorList, fmt.Sprintf("%s: %s", repository, err))
+	}
+	return DownloadResult{}, fmt.Errorf("file %s not downloaded from any repository\n%s", fileName, strings.Join(errorList, "\n"))
 }
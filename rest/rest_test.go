@@ -15,17 +15,28 @@
 package rest
 
 import (
+	"encoding/json"
+	"fmt"
+	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestDownloadFile(t *testing.T) {
 	compare.SkipOnDemand("SKIP_REST_TEST", t)
 
 	fileName := "mysql-4.1.22.tar.xz"
-	url := FileUrl(fileName)
-	err := DownloadFile(fileName, url)
+	url, err := FileUrl(fileName)
+	compare.OkIsNil("file URL", err, t)
+	err = DownloadFile(fileName, url)
 	if err == nil {
 		t.Logf("OK\n")
 		_ = os.Remove(fileName)
@@ -46,3 +57,287 @@ func TestGetRemoteIndex(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestRemoteIndexChecksums(t *testing.T) {
+	indexText := `{
+  "4.1": ["mysql-4.1.22.tar.xz"],
+  "8.0": [
+    {"name": "mysql-8.0.16.tar.xz", "checksum": "sha256:9fe21216392f28ea4d6ab1a4241cff21b0561683dc237476b2b9cd5f01bc99a7"}
+  ]
+}`
+	var index RemoteFilesMap
+	err := json.Unmarshal([]byte(indexText), &index)
+	compare.OkIsNil("index decoding", err, t)
+	rf, found := FindRemoteFile(index, "mysql-4.1.22.tar.xz")
+	compare.OkEqualBool("file without checksum found", found, true, t)
+	compare.OkEqualString("empty checksum", rf.Checksum, "", t)
+	rf, found = FindRemoteFile(index, "mysql-8.0.16.tar.xz")
+	compare.OkEqualBool("file with checksum found", found, true, t)
+	compare.OkMatchesString("checksum", rf.Checksum, "^sha256:9fe2", t)
+	_, found = FindRemoteFile(index, "mysql-5.0.96.tar.xz")
+	compare.OkEqualBool("missing file", found, false, t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "dbdeployer\n")
+	}))
+	defer server.Close()
+	fileName := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-download-%d", os.Getpid()))
+	defer os.Remove(fileName)
+	err = DownloadVerifiedFile(fileName, server.URL, rf.Checksum, DefaultDownloadOptions)
+	compare.OkIsNil("verified download", err, t)
+	err = DownloadVerifiedFile(fileName, server.URL, "md5:"+strings.Repeat("0", 32), DefaultDownloadOptions)
+	compare.OkIsNotNil("download with wrong checksum rejected", err, t)
+	compare.OkEqualBool("file removed after checksum failure", common.FileExists(fileName), false, t)
+}
+
+func TestDownloadResume(t *testing.T) {
+	contents := strings.Repeat("dbdeployer resumable download\n", 1000)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "file.tar.gz", time.Time{}, strings.NewReader(contents))
+	}))
+	defer server.Close()
+
+	fileName := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-resume-%d", os.Getpid()))
+	defer os.Remove(fileName)
+	err := common.WriteString(contents[:1000], fileName+PartialSuffix)
+	compare.OkIsNil("partial file", err, t)
+
+	err = DownloadFileWithOptions(fileName, server.URL, DownloadOptions{})
+	compare.OkIsNil("resumed download", err, t)
+	compare.OkEqualInt("number of requests", len(ranges), 1, t)
+	if len(ranges) > 0 {
+		compare.OkEqualString("range requested", ranges[0], "bytes=1000-", t)
+	}
+	downloaded, err := common.SlurpAsBytes(fileName)
+	compare.OkIsNil("downloaded file", err, t)
+	compare.OkEqualString("downloaded contents", string(downloaded), contents, t)
+	compare.OkEqualBool("partial file removed", common.FileExists(fileName+PartialSuffix), false, t)
+}
+
+func TestDownloadRetry(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case attempts < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, "dbdeployer\n")
+		}
+	}))
+	defer server.Close()
+
+	fileName := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-retry-%d", os.Getpid()))
+	defer os.Remove(fileName)
+	options := DownloadOptions{Retries: 3, RetryDelay: time.Millisecond}
+	err := DownloadFileWithOptions(fileName, server.URL, options)
+	compare.OkIsNil("download after server errors", err, t)
+	compare.OkEqualInt("attempts for server errors", attempts, 3, t)
+
+	attempts = 0
+	err = DownloadFileWithOptions(fileName, server.URL+"/missing", options)
+	compare.OkIsNotNil("missing file rejected", err, t)
+	compare.OkEqualInt("attempts for missing file", attempts, 1, t)
+
+	// Errors that won't go away by themselves fail at once, without waiting for retries
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refusedUrl := refused.URL
+	refused.Close()
+	slowOptions := DownloadOptions{Retries: 3, RetryDelay: 10 * time.Second}
+	for _, url := range []string{refusedUrl, "http://no-such-host.invalid/index.json"} {
+		start := time.Now()
+		err = DownloadFileWithOptions(fileName, url, slowOptions)
+		compare.OkIsNotNil("download from "+url, err, t)
+		compare.OkEqualBool("no retries for "+url, time.Since(start) < slowOptions.RetryDelay, true, t)
+	}
+}
+
+func TestTransientNetError(t *testing.T) {
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+	_, err := client.Get(server.URL)
+	compare.OkIsNotNil("timeout", err, t)
+	compare.OkEqualBool("timeout is transient", isTransientNetError(err), true, t)
+
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	_, err = client.Get(refused.URL)
+	compare.OkIsNotNil("connection refused", err, t)
+	compare.OkEqualBool("connection refused is not transient", isTransientNetError(err), false, t)
+
+	_, err = client.Get("http://no-such-host.invalid/")
+	compare.OkIsNotNil("unknown host", err, t)
+	compare.OkEqualBool("unknown host is not transient", isTransientNetError(err), false, t)
+	compare.OkEqualBool("other errors are not transient", isTransientNetError(fmt.Errorf("error")), false, t)
+}
+
+func TestFirstRepository(t *testing.T) {
+	_, err := firstRepository(nil)
+	compare.OkIsNotNil("no repositories", err, t)
+	repository, err := firstRepository([]string{"file:///nfs", "https://public"})
+	compare.OkIsNil("repositories", err, t)
+	compare.OkEqualString("first repository", repository, "file:///nfs", t)
+	url, err := FileUrl("mysql-8.0.16.tar.xz")
+	compare.OkIsNil("file URL", err, t)
+	compare.OkEqualBool("file URL from first repository",
+		strings.HasPrefix(url, RemoteRepositories()[0]+"/"), true, t)
+}
+
+func TestIndexCache(t *testing.T) {
+	savedCacheDir := defaults.CacheDir
+	defaults.CacheDir = path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-cache-%d", os.Getpid()))
+	defer func() {
+		_ = os.RemoveAll(defaults.CacheDir)
+		defaults.CacheDir = savedCacheDir
+	}()
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"8.0": ["mysql-8.0.16.tar.xz"]}`)
+	}))
+	defer server.Close()
+
+	for N := 0; N < 3; N++ {
+		index, err := getIndex(server.URL, time.Hour)
+		compare.OkIsNil("index retrieval", err, t)
+		compare.OkEqualInt("index versions", len(index), 1, t)
+	}
+	compare.OkEqualInt("requests with cache", requests, 1, t)
+	compare.OkEqualBool("cache file", common.FileExists(indexCacheFile(server.URL)), true, t)
+
+	_, err := getIndex(server.URL, 0)
+	compare.OkIsNil("index refresh", err, t)
+	compare.OkEqualInt("requests after refresh", requests, 2, t)
+
+	leftovers, err := filepath.Glob(path.Join(defaults.CacheDir, "*.tmp*"))
+	compare.OkIsNil("temporary files search", err, t)
+	compare.OkEqualInt("temporary files left", len(leftovers), 0, t)
+}
+
+func TestIndexCleanupOnErrors(t *testing.T) {
+	savedCacheDir, savedOptions := defaults.CacheDir, indexDownloadOptions
+	defaults.CacheDir = path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-cache-errors-%d", os.Getpid()))
+	indexDownloadOptions = DownloadOptions{}
+	defer func() {
+		_ = os.RemoveAll(defaults.CacheDir)
+		defaults.CacheDir, indexDownloadOptions = savedCacheDir, savedOptions
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/truncated":
+			w.Header().Set("Content-Length", "1000")
+			fmt.Fprint(w, `{"8.0": [`)
+		case "/invalid":
+			fmt.Fprint(w, "not an index")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, name := range []string{"truncated", "invalid", "missing"} {
+		_, err := getIndex(server.URL+"/"+name, 0)
+		compare.OkIsNotNil("index error for "+name, err, t)
+		leftovers, err := filepath.Glob(path.Join(defaults.CacheDir, "*.tmp*"))
+		compare.OkIsNil("temporary files search", err, t)
+		compare.OkEqualInt("temporary files left after "+name, len(leftovers), 0, t)
+	}
+}
+
+func TestLocalRepository(t *testing.T) {
+	repoDir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-repo-%d", os.Getpid()))
+	err := os.MkdirAll(repoDir, 0755)
+	compare.OkIsNil("repository directory", err, t)
+	defer os.RemoveAll(repoDir)
+	err = common.WriteString("dbdeployer\n", path.Join(repoDir, "mysql-8.0.16.tar.xz"))
+	compare.OkIsNil("repository file", err, t)
+
+	fileName := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-local-%d", os.Getpid()))
+	defer os.Remove(fileName)
+	err = DownloadVerifiedFile(fileName, "file://"+repoDir+"/mysql-8.0.16.tar.xz",
+		"sha256:9fe21216392f28ea4d6ab1a4241cff21b0561683dc237476b2b9cd5f01bc99a7", DownloadOptions{})
+	compare.OkIsNil("download from local directory", err, t)
+	err = DownloadFileWithOptions(fileName, "file://"+repoDir+"/mysql-5.7.26.tar.xz", DownloadOptions{Retries: 2})
+	compare.OkIsNotNil("missing local file rejected", err, t)
+
+	indexes := []RepositoryIndex{
+		{Repository: "http://mirror", Err: fmt.Errorf("connection refused")},
+		{Repository: "file:///nfs", Index: RemoteFilesMap{
+			"8.0": {{Name: "mysql-8.0.16.tar.xz", Checksum: "md5:1"}},
+		}},
+		{Repository: "https://public", Index: RemoteFilesMap{
+			"8.0": {{Name: "mysql-8.0.16.tar.xz", Checksum: "md5:2"}, {Name: "mysql-8.0.15.tar.xz"}},
+			"5.7": {{Name: "mysql-5.7.26.tar.xz"}},
+		}},
+	}
+	merged, err := mergeIndexes(indexes)
+	compare.OkIsNil("merged index", err, t)
+	compare.OkEqualInt("merged versions", len(merged), 2, t)
+	compare.OkEqualInt("merged 8.0 files", len(merged["8.0"]), 2, t)
+	rf, found := FindRemoteFile(merged, "mysql-8.0.16.tar.xz")
+	compare.OkEqualBool("file in several repositories", found, true, t)
+	compare.OkEqualString("entry from first repository", rf.Checksum, "md5:1", t)
+	_, err = mergeIndexes(indexes[:1])
+	compare.OkIsNotNil("merge without any index rejected", err, t)
+}
+
+func TestFindRemoteTarball(t *testing.T) {
+	index := RemoteFilesMap{
+		"5.7": {{Name: "mysql-5.7.24.tar.xz"}, {Name: "mysql-5.7.26.tar.xz"}, {Name: "mysql-5.7.9.tar.xz"}},
+		"8.0": {
+			{Name: "mysql-8.0.15.tar.xz"},
+			{Name: "mysql-8.0.16-macos10.14-x86_64.tar.gz"},
+			{Name: "mysql-8.0.16-custom.tar.gz", OS: "darwin"},
+			{Name: "Percona-Server-8.0.16-7.tar.xz"},
+		},
+	}
+	type tarballTest struct {
+		version  string
+		flavor   string
+		os       string
+		expected string
+	}
+	var data = []tarballTest{
+		{"5.7", common.MySQLFlavor, "linux", "mysql-5.7.26.tar.xz"},
+		{"5.7.24", common.MySQLFlavor, "linux", "mysql-5.7.24.tar.xz"},
+		{"8.0", common.MySQLFlavor, "linux", "mysql-8.0.15.tar.xz"},
+		{"8.0", common.MySQLFlavor, "darwin", "mysql-8.0.16-macos10.14-x86_64.tar.gz"},
+		{"8.0", common.PerconaServerFlavor, "linux", "Percona-Server-8.0.16-7.tar.xz"},
+	}
+	for _, tt := range data {
+		rf, _, err := FindRemoteTarball(index, tt.version, tt.flavor, tt.os)
+		compare.OkIsNil(fmt.Sprintf("tarball for %s %s %s", tt.flavor, tt.version, tt.os), err, t)
+		compare.OkEqualString("tarball name", rf.Name, tt.expected, t)
+	}
+	_, fullVersion, _ := FindRemoteTarball(index, "5.7", common.MySQLFlavor, "linux")
+	compare.OkEqualString("full version", fullVersion, "5.7.26", t)
+	_, _, err := FindRemoteTarball(index, "5.6", common.MySQLFlavor, "linux")
+	compare.OkIsNotNil("missing version rejected", err, t)
+	_, _, err = FindRemoteTarball(index, "5.7", common.MySQLFlavor, "darwin")
+	compare.OkIsNotNil("missing operating system rejected", err, t)
+
+	// Tarballs of the same version are listed under different keys: the choice must not
+	// depend on the order of map iteration
+	sameVersion := RemoteFilesMap{
+		"8.0":         {{Name: "mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz"}},
+		"8.0-extra":   {{Name: "mysql-8.0.16-el7-x86_64.tar.gz"}},
+		"8.0-minimal": {{Name: "mysql-8.0.16-linux-x86_64-minimal.tar.xz"}},
+	}
+	for N := 0; N < 20; N++ {
+		rf, _, err := FindRemoteTarball(sameVersion, "8.0.16", common.MySQLFlavor, "linux")
+		compare.OkIsNil("tarball among same versions", err, t)
+		if rf.Name != "mysql-8.0.16-linux-glibc2.12-x86_64.tar.xz" {
+			t.Logf("not ok - attempt %d chose %s", N, rf.Name)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+)
+
+// Returns the extra scripts templates found in a directory.
+// Subdirectories and hidden files are not included
+func readExtraScripts(dirName string) (TemplateCollection, error) {
+	var collection = make(TemplateCollection)
+	if !common.DirExists(dirName) {
+		return collection, nil
+	}
+	files, err := ioutil.ReadDir(dirName)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		contents, err := common.SlurpAsString(path.Join(dirName, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		collection[f.Name()] = TemplateDesc{
+			TemplateInFile: true,
+			Description:    "extra script",
+			Contents:       contents,
+		}
+	}
+	return collection, nil
+}
+
+// Returns the names of the sets of extra scripts, i.e. the subdirectories of
+// the extra scripts directory for any sandbox type
+func ExtraScriptSets() ([]string, error) {
+	var sets []string
+	var seen = make(map[string]bool)
+	if !common.DirExists(defaults.ExtraScriptsDir) {
+		return sets, nil
+	}
+	sbTypes, err := ioutil.ReadDir(defaults.ExtraScriptsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, sbType := range sbTypes {
+		if !sbType.IsDir() {
+			continue
+		}
+		setDirs, err := ioutil.ReadDir(path.Join(defaults.ExtraScriptsDir, sbType.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, setDir := range setDirs {
+			if setDir.IsDir() && !seen[setDir.Name()] {
+				seen[setDir.Name()] = true
+				sets = append(sets, setDir.Name())
+			}
+		}
+	}
+	sort.Strings(sets)
+	return sets, nil
+}
+
+// Writes the user scripts for the sandbox type into the sandbox directory.
+// The scripts in ExtraScriptsDir/<sandbox-type>/ are added to every new sandbox,
+// while the ones in ExtraScriptsDir/<sandbox-type>/<set>/ only when the set is requested.
+// The scripts are templates, filled with the same data as the built-in ones,
+// and can have variants for flavor and version (script@flavor-major.minor)
+func writeExtraScripts(logger *defaults.Logger, sbType, sandboxDir string, data common.StringMap, sets []string) error {
+	typeDir := path.Join(defaults.ExtraScriptsDir, sbType)
+	if !common.DirExists(typeDir) {
+		return nil
+	}
+	dirs := []string{typeDir}
+	for _, set := range sets {
+		if set != "" {
+			dirs = append(dirs, path.Join(typeDir, set))
+		}
+	}
+	for _, dir := range dirs {
+		collection, err := readExtraScripts(dir)
+		if err != nil {
+			return fmt.Errorf("error reading extra scripts from %s: %s", dir, err)
+		}
+		var names []string
+		for name := range collection {
+			if !strings.Contains(name, TemplateVariantSeparator) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if common.FileExists(path.Join(sandboxDir, name)) {
+				return fmt.Errorf("extra script %s/%s would replace %s/%s", dir, name, sandboxDir, name)
+			}
+			err = writeScript(logger, collection, name, name, sandboxDir, data, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -50,7 +50,11 @@ loose-group-replication-single-primary-mode=off
 )
 
 func getBaseMysqlxPort(basePort int, sdef SandboxDef, nodes int) (int, error) {
-	baseMysqlxPort := basePort + defaults.Defaults().MysqlXPortDelta
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return 0, err
+	}
+	baseMysqlxPort := basePort + currentDefaults.MysqlXPortDelta
 	// 8.0.11
 	// isMinimumMySQLXDefault, err := common.GreaterOrEqualVersion(sdef.Version, globals.MinimumMysqlxDefaultVersion)
 	isMinimumMySQLXDefault, err := common.HasCapability(sdef.Flavor, common.MySQLX, sdef.Version)
@@ -61,7 +65,7 @@ func getBaseMysqlxPort(basePort int, sdef SandboxDef, nodes int) (int, error) {
 		// FindFreePort returns the first free port, but base_port will be used
 		// with a counter. Thus the availability will be checked using
 		// "base_port + 1"
-		firstGroupPort, err := common.FindFreePort(baseMysqlxPort+1, sdef.InstalledPorts, nodes)
+		firstGroupPort, err := findAndReservePorts(sdef.SandboxDir, baseMysqlxPort+1, sdef.InstalledPorts, nodes)
 		if err != nil {
 			return -1, errors.Wrapf(err, "error finding a free port for MySQLX")
 		}
@@ -78,8 +82,11 @@ func getBaseMysqlxPort(basePort int, sdef SandboxDef, nodes int) (int, error) {
 }
 
 func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return err
+	}
 	var execLists []concurrent.ExecutionList
-	var err error
 
 	var logger *defaults.Logger
 	if sandboxDef.Logger != nil {
@@ -93,6 +100,7 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		}
 		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
 	}
+	logger = logger.WithContext(common.BaseName(sandboxDef.SandboxDir), "")
 
 	readOnlyOptions, err := checkReadOnlyFlags(sandboxDef)
 	if err != nil {
@@ -108,9 +116,9 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		return err
 	}
 	rev := vList[2]
-	basePort := sandboxDef.Port + defaults.Defaults().GroupReplicationBasePort + (rev * 100)
+	basePort := sandboxDef.Port + currentDefaults.GroupReplicationBasePort + (rev * 100)
 	if sandboxDef.SinglePrimary {
-		basePort = sandboxDef.Port + defaults.Defaults().GroupReplicationSpBasePort + (rev * 100)
+		basePort = sandboxDef.Port + currentDefaults.GroupReplicationSpBasePort + (rev * 100)
 	}
 	if sandboxDef.BasePort > 0 {
 		basePort = sandboxDef.BasePort
@@ -129,13 +137,13 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	// FindFreePort returns the first free port, but base_port will be used
 	// with a counter. Thus the availability will be checked using
 	// "base_port + 1"
-	firstGroupPort, err := common.FindFreePort(basePort+1, sandboxDef.InstalledPorts, nodes)
+	firstGroupPort, err := findAndReservePorts(sandboxDef.SandboxDir, basePort+1, sandboxDef.InstalledPorts, nodes)
 	if err != nil {
 		return errors.Wrapf(err, "error retrieving free port for replication")
 	}
 	basePort = firstGroupPort - 1
-	baseGroupPort := basePort + defaults.Defaults().GroupPortDelta
-	firstGroupPort, err = common.FindFreePort(baseGroupPort+1, sandboxDef.InstalledPorts, nodes)
+	baseGroupPort := basePort + currentDefaults.GroupPortDelta
+	firstGroupPort, err = findAndReservePorts(sandboxDef.SandboxDir, baseGroupPort+1, sandboxDef.InstalledPorts, nodes)
 	if err != nil {
 		return errors.Wrapf(err, "error retrieving group replication free port")
 	}
@@ -156,6 +164,17 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	if err != nil {
 		return err
 	}
+	sbType := "group-multi-primary"
+	singleMultiPrimary := GroupReplMultiPrimary
+	if sandboxDef.SinglePrimary {
+		sbType = "group-single-primary"
+		singleMultiPrimary = GroupReplSinglePrimary
+	}
+	hookContext := topologyHookContext(sandboxDef, sbType, basePort+1, nodes)
+	err = runHooks(logger, sandboxDef.Hooks, HookPreDeploy, hookContext)
+	if err != nil {
+		return err
+	}
 	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
 	if err != nil {
 		return err
@@ -163,10 +182,6 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	common.AddToCleanupStack(common.Rmdir, "Rmdir", sandboxDef.SandboxDir)
 	logger.Printf("Creating directory %s\n", sandboxDef.SandboxDir)
 	timestamp := time.Now()
-	slaveLabel := defaults.Defaults().SlavePrefix
-	slaveAbbr := defaults.Defaults().SlaveAbbr
-	masterAbbr := defaults.Defaults().MasterAbbr
-	masterLabel := defaults.Defaults().MasterName
 	masterList := makeNodesList(nodes)
 	slaveList := masterList
 	if sandboxDef.SinglePrimary {
@@ -192,30 +207,13 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		}
 	}
 	changeMasterExtra := ""
-	nodeLabel := defaults.Defaults().NodePrefix
+	nodeLabel := currentDefaults.NodePrefix
 	//if common.GreaterOrEqualVersion(sdef.Version, []int{8,0,4}) {
 	//	if !sdef.NativeAuthPlugin {
 	//		change_master_extra = ", GET_MASTER_PUBLIC_KEY=1"
 	//	}
 	//}
-	var data = common.StringMap{
-		"Copyright":         Copyright,
-		"AppVersion":        common.VersionDef,
-		"DateTime":          timestamp.Format(time.UnixDate),
-		"SandboxDir":        sandboxDef.SandboxDir,
-		"MasterIp":          masterIp,
-		"MasterList":        masterList,
-		"NodeLabel":         nodeLabel,
-		"SlaveList":         slaveList,
-		"RplUser":           sandboxDef.RplUser,
-		"RplPassword":       sandboxDef.RplPassword,
-		"SlaveLabel":        slaveLabel,
-		"SlaveAbbr":         slaveAbbr,
-		"ChangeMasterExtra": changeMasterExtra,
-		"MasterLabel":       masterLabel,
-		"MasterAbbr":        masterAbbr,
-		"Nodes":             []common.StringMap{},
-	}
+	data := groupTemplateData(sandboxDef, currentDefaults, masterIp, masterList, slaveList, changeMasterExtra, timestamp)
 	connectionString := ""
 	for i := 0; i < nodes; i++ {
 		groupPort := baseGroupPort + i + 1
@@ -226,12 +224,6 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	}
 	logger.Printf("Creating connection string %s\n", connectionString)
 
-	sbType := "group-multi-primary"
-	singleMultiPrimary := GroupReplMultiPrimary
-	if sandboxDef.SinglePrimary {
-		sbType = "group-single-primary"
-		singleMultiPrimary = GroupReplSinglePrimary
-	}
 	logger.Printf("Defining group type %s\n", sbType)
 
 	sbDesc := common.SandboxDescription{
@@ -243,6 +235,8 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Hooks:   hookDefinitions(sandboxDef.Hooks, HookPreDelete, HookPostDelete),
+		Users:   sandboxUsers(sandboxDef),
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -261,21 +255,8 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 
 	for i := 1; i <= nodes; i++ {
 		groupPort := baseGroupPort + i
-		data["Nodes"] = append(data["Nodes"].([]common.StringMap), common.StringMap{
-			"Copyright":         Copyright,
-			"AppVersion":        common.VersionDef,
-			"DateTime":          timestamp.Format(time.UnixDate),
-			"Node":              i,
-			"MasterIp":          masterIp,
-			"NodeLabel":         nodeLabel,
-			"SlaveLabel":        slaveLabel,
-			"SlaveAbbr":         slaveAbbr,
-			"ChangeMasterExtra": changeMasterExtra,
-			"MasterLabel":       masterLabel,
-			"MasterAbbr":        masterAbbr,
-			"SandboxDir":        sandboxDef.SandboxDir,
-			"RplUser":           sandboxDef.RplUser,
-			"RplPassword":       sandboxDef.RplPassword})
+		data["Nodes"] = append(data["Nodes"].([]common.StringMap),
+			groupNodeData(sandboxDef, currentDefaults, masterIp, changeMasterExtra, i, basePort+i, timestamp))
 
 		sandboxDef.DirName = fmt.Sprintf("%s%d", nodeLabel, i)
 		sandboxDef.Port = basePort + i
@@ -284,8 +265,8 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		sbItem.Nodes = append(sbItem.Nodes, sandboxDef.DirName)
 		sbItem.Port = append(sbItem.Port, sandboxDef.Port)
 		sbDesc.Port = append(sbDesc.Port, sandboxDef.Port)
-		sbItem.Port = append(sbItem.Port, sandboxDef.Port+defaults.Defaults().GroupPortDelta)
-		sbDesc.Port = append(sbDesc.Port, sandboxDef.Port+defaults.Defaults().GroupPortDelta)
+		sbItem.Port = append(sbItem.Port, sandboxDef.Port+currentDefaults.GroupPortDelta)
+		sbDesc.Port = append(sbDesc.Port, sandboxDef.Port+currentDefaults.GroupPortDelta)
 
 		if !sandboxDef.RunConcurrently {
 			installationMessage := "Installing and starting %s %d\n"
@@ -330,19 +311,7 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		for _, list := range execList {
 			execLists = append(execLists, list)
 		}
-		var dataNode = common.StringMap{
-			"Copyright":         Copyright,
-			"AppVersion":        common.VersionDef,
-			"DateTime":          timestamp.Format(time.UnixDate),
-			"Node":              i,
-			"NodeLabel":         nodeLabel,
-			"MasterLabel":       masterLabel,
-			"MasterAbbr":        masterAbbr,
-			"ChangeMasterExtra": changeMasterExtra,
-			"SlaveLabel":        slaveLabel,
-			"SlaveAbbr":         slaveAbbr,
-			"SandboxDir":        sandboxDef.SandboxDir,
-		}
+		dataNode := groupNodeData(sandboxDef, currentDefaults, masterIp, changeMasterExtra, i, sandboxDef.Port, timestamp)
 		logger.Printf("Create node script for node %d\n", i)
 		err = writeScript(logger, MultipleTemplates, fmt.Sprintf("n%d", i), "node_template", sandboxDef.SandboxDir, dataNode, true)
 		if err != nil {
@@ -404,9 +373,16 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 			return err
 		}
 	}
+	err = writeExtraScripts(logger, sbType, sandboxDef.SandboxDir, data, sandboxDef.ExtraScripts)
+	if err != nil {
+		return err
+	}
 
 	logger.Printf("Running parallel tasks\n")
-	concurrent.RunParallelTasksByPriority(execLists)
+	err = concurrent.RunParallelTasksByPriority(execLists)
+	if err != nil {
+		return err
+	}
 	if !sandboxDef.SkipStart {
 		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDef.SandboxDir), globals.ScriptInitializeNodes))
 		logger.Printf("Running group replication initialization script\n")
@@ -414,6 +390,10 @@ func CreateGroupReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		if err != nil {
 			return fmt.Errorf("error initializing group replication: %s", err)
 		}
+		err = runHooks(logger, sandboxDef.Hooks, HookPostStart, hookContext)
+		if err != nil {
+			return err
+		}
 	}
 	common.CondPrintf("Replication directory installed in %s\n", common.ReplaceLiteralHome(sandboxDef.SandboxDir))
 	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
@@ -67,11 +67,15 @@ CHECK_NODE="select * from performance_schema.replication_group_members"
 			Description: "Initialize group replication after deployment",
 			Notes:       "",
 			Contents:    initNodesTemplate,
+			Variables: []string{"Copyright", "Nodes", "Nodes.ChangeMasterExtra", "Nodes.MasterIp",
+				"Nodes.Node", "Nodes.NodeLabel", "Nodes.RplPassword", "Nodes.RplUser", "Nodes.SandboxDir",
+				"SandboxDir"},
 		},
 		"check_nodes_template": TemplateDesc{
 			Description: "Checks the status of group replication",
 			Notes:       "",
 			Contents:    checkNodesTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 	}
 )
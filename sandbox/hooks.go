@@ -0,0 +1,357 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/concurrent"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Events of the sandbox life cycle where hooks can run
+const (
+	HookPreDeploy  = "pre-deploy"
+	HookPostInit   = "post-init"
+	HookPostStart  = "post-start"
+	HookPostGrants = "post-grants"
+	HookPreDelete  = "pre-delete"
+	HookPostDelete = "post-delete"
+)
+
+// Role of a composite sandbox, as opposed to the one of its nodes
+const RoleTopology = "topology"
+
+var HookEvents = []string{HookPreDeploy, HookPostInit, HookPostStart, HookPostGrants, HookPreDelete, HookPostDelete}
+
+// Events where the database server is running, and SQL files can be used as hooks
+var sqlHookEvents = map[string]bool{
+	HookPostStart:  true,
+	HookPostGrants: true,
+	HookPreDelete:  true,
+}
+
+// A script or SQL file that runs at an event of the sandbox life cycle
+type Hook struct {
+	Event string
+	File  string
+}
+
+func (h Hook) String() string {
+	return h.Event + ":" + h.File
+}
+
+func (h Hook) isSql() bool {
+	return strings.HasSuffix(h.File, ".sql")
+}
+
+// Describes the sandbox for which a hook runs
+type HookContext struct {
+	SandboxDir string
+	SBType     string
+	Role       string
+	Version    string
+	Flavor     string
+	Node       int
+	Ports      []int
+}
+
+// Parses hook definitions in the format "event:file".
+// Scripts must be executable. SQL files (*.sql) are only accepted
+// for the events where the database server is running
+func ParseHooks(definitions []string) ([]Hook, error) {
+	var hooks []Hook
+	var seen = make(map[string]bool)
+	for _, definition := range definitions {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+		parts := strings.SplitN(definition, ":", 2)
+		if len(parts) < 2 || parts[1] == "" {
+			return nil, fmt.Errorf("hook '%s' invalid. Required format is 'event:file_name'", definition)
+		}
+		hook := Hook{Event: parts[0], File: parts[1]}
+		knownEvent := false
+		for _, event := range HookEvents {
+			if event == hook.Event {
+				knownEvent = true
+			}
+		}
+		if !knownEvent {
+			return nil, fmt.Errorf("unknown hook event '%s'. Accepted events: %s", hook.Event, strings.Join(HookEvents, ", "))
+		}
+		fileName, err := common.AbsolutePath(hook.File)
+		if err != nil {
+			return nil, err
+		}
+		hook.File = fileName
+		if hook.isSql() {
+			if !common.FileExists(hook.File) {
+				return nil, fmt.Errorf(globals.ErrFileNotFound, hook.File)
+			}
+			if !sqlHookEvents[hook.Event] {
+				return nil, fmt.Errorf("SQL file %s can't be used for event %s, as the server is not running", hook.File, hook.Event)
+			}
+		} else if !common.ExecExists(hook.File) {
+			return nil, fmt.Errorf(globals.ErrExecutableNotFound, hook.File)
+		}
+		if !seen[hook.String()] {
+			seen[hook.String()] = true
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+// Returns the hooks of both lists, without duplicates
+func mergeHooks(hooks, others []Hook) []Hook {
+	var merged []Hook
+	var seen = make(map[string]bool)
+	for _, hook := range append(append([]Hook{}, hooks...), others...) {
+		if !seen[hook.String()] {
+			seen[hook.String()] = true
+			merged = append(merged, hook)
+		}
+	}
+	return merged
+}
+
+// Returns the definitions of the hooks for the given events
+func hookDefinitions(hooks []Hook, events ...string) []string {
+	var definitions []string
+	for _, hook := range hooks {
+		for _, event := range events {
+			if hook.Event == event {
+				definitions = append(definitions, hook.String())
+			}
+		}
+	}
+	return definitions
+}
+
+// Returns the environment for a hook: the current one,
+// plus variables describing the event and the sandbox
+func (hc HookContext) environment(event string) []string {
+	var ports []string
+	for _, port := range hc.Ports {
+		ports = append(ports, fmt.Sprintf("%d", port))
+	}
+	mainPort := ""
+	if len(ports) > 0 {
+		mainPort = ports[0]
+	}
+	return append(os.Environ(),
+		"DBDEPLOYER_HOOK="+event,
+		"DBDEPLOYER_SANDBOX_DIR="+hc.SandboxDir,
+		"DBDEPLOYER_SANDBOX_TYPE="+hc.SBType,
+		"DBDEPLOYER_SANDBOX_ROLE="+hc.Role,
+		"DBDEPLOYER_SANDBOX_VERSION="+hc.Version,
+		"DBDEPLOYER_SANDBOX_FLAVOR="+hc.Flavor,
+		fmt.Sprintf("DBDEPLOYER_SANDBOX_NODE=%d", hc.Node),
+		"DBDEPLOYER_SANDBOX_PORT="+mainPort,
+		"DBDEPLOYER_SANDBOX_PORTS="+strings.Join(ports, " "),
+	)
+}
+
+// Returns the commands that run the hooks for an event.
+// SQL files run through the "use" script of the sandbox. They are skipped
+// in sandboxes without one, such as the top directory of a replication sandbox
+func hookCommands(hooks []Hook, event string, context HookContext) []concurrent.ExecCommand {
+	var commands []concurrent.ExecCommand
+	for _, hook := range hooks {
+		if hook.Event != event {
+			continue
+		}
+		command := concurrent.ExecCommand{
+			Cmd:         hook.File,
+			Env:         context.environment(event),
+			StopOnError: true,
+		}
+		// After a deletion, the sandbox directory is gone, or about to be removed by a concurrent task
+		if event != HookPostDelete && common.DirExists(context.SandboxDir) {
+			command.Dir = context.SandboxDir
+		}
+		if hook.isSql() {
+			useScript := path.Join(context.SandboxDir, globals.ScriptUse)
+			if !common.ExecExists(useScript) {
+				continue
+			}
+			command.Cmd = useScript
+			command.Args = []string{"-e", "source " + hook.File}
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+// Runs the hooks for an event, one after the other.
+// Stops at the first hook that fails
+func runHooks(logger *defaults.Logger, hooks []Hook, event string, context HookContext) error {
+	for _, command := range hookCommands(hooks, event, context) {
+		if logger != nil {
+			logger.Printf("Running %s hook %s %v\n", event, command.Cmd, command.Args)
+		}
+		cmd := exec.Command(command.Cmd, command.Args...)
+		cmd.Env = command.Env
+		cmd.Dir = command.Dir
+		output, err := cmd.CombinedOutput()
+		if len(output) > 0 {
+			common.CondPrintf("%s", output)
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed for %s: %s", event, command.Cmd, context.SandboxDir, err)
+		}
+	}
+	return nil
+}
+
+// Adds the hooks for an event to an execution list, with the given priority.
+// As with runHooks, a failing hook interrupts the execution of the list
+func addHooksToExecList(execList []concurrent.ExecutionList, logger *defaults.Logger, hooks []Hook, event string,
+	context HookContext, priority int) []concurrent.ExecutionList {
+	for _, command := range hookCommands(hooks, event, context) {
+		if logger != nil {
+			logger.Printf("Adding %s hook %s to execution list\n", event, command.Cmd)
+		}
+		execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: priority, Command: command})
+	}
+	return execList
+}
+
+// Returns the context for the hooks of a composite sandbox, whose nodes use
+// the ports from firstPort on
+func topologyHookContext(sandboxDef SandboxDef, sbType string, firstPort, nodes int) HookContext {
+	var ports []int
+	for N := 0; N < nodes; N++ {
+		ports = append(ports, firstPort+N)
+	}
+	return HookContext{
+		SandboxDir: sandboxDef.SandboxDir,
+		SBType:     sbType,
+		Role:       RoleTopology,
+		Version:    sandboxDef.Version,
+		Flavor:     sandboxDef.Flavor,
+		Ports:      ports,
+	}
+}
+
+// Returns the context for the hooks of a composite sandbox, using the data of its templates
+func topologyHookContextFromData(sandboxDef SandboxDef, data common.StringMap) HookContext {
+	context := topologyHookContext(sandboxDef, sandboxDef.SBType, 0, 0)
+	nodes, _ := data["Nodes"].([]common.StringMap)
+	for _, node := range nodes {
+		if port, ok := node["NodePort"].(int); ok {
+			context.Ports = append(context.Ports, port)
+		}
+	}
+	return context
+}
+
+// Returns the context for the hooks of a sandbox, using its description
+func hookContextFromDescription(sandboxDir string) (HookContext, []Hook, error) {
+	sbDescription, err := common.ReadSandboxDescription(sandboxDir)
+	if err != nil {
+		return HookContext{}, nil, err
+	}
+	role := sbDescription.Role
+	if role == "" {
+		role = RoleTopology
+	}
+	context := HookContext{
+		SandboxDir: sandboxDir,
+		SBType:     sbDescription.SBType,
+		Role:       role,
+		Version:    sbDescription.Version,
+		Flavor:     sbDescription.Flavor,
+		Node:       sbDescription.NodeNum,
+		Ports:      sbDescription.Port,
+	}
+	return context, recordedDeleteHooks(sbDescription.Hooks), nil
+}
+
+// Returns the delete hooks recorded in a sandbox description.
+// They were validated during the deployment: here we only skip the ones
+// that can't run anymore, so that the sandbox can still be removed
+func recordedDeleteHooks(definitions []string) []Hook {
+	var hooks []Hook
+	for _, definition := range definitions {
+		parts := strings.SplitN(strings.TrimSpace(definition), ":", 2)
+		if len(parts) < 2 || parts[1] == "" {
+			common.CondPrintf("# WARNING: skipping invalid hook '%s'\n", definition)
+			continue
+		}
+		hook := Hook{Event: parts[0], File: parts[1]}
+		if hook.Event != HookPreDelete && hook.Event != HookPostDelete {
+			continue
+		}
+		if !common.FileExists(hook.File) {
+			common.CondPrintf("# WARNING: skipping %s hook %s: file not found\n", hook.Event, hook.File)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
+
+// Returns the contexts for the delete hooks of a sandbox: one for each node,
+// followed by the one for the sandbox itself
+func deleteHookContexts(sandboxDir string) ([]HookContext, [][]Hook, error) {
+	var contexts []HookContext
+	var hookLists [][]Hook
+	if !common.FileExists(path.Join(sandboxDir, globals.SandboxDescriptionName)) {
+		return contexts, hookLists, nil
+	}
+	files, err := ioutil.ReadDir(sandboxDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range files {
+		nodeDir := path.Join(sandboxDir, f.Name())
+		if !f.IsDir() || !common.FileExists(path.Join(nodeDir, globals.SandboxDescriptionName)) {
+			continue
+		}
+		context, hooks, err := hookContextFromDescription(nodeDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		contexts = append(contexts, context)
+		hookLists = append(hookLists, hooks)
+	}
+	context, hooks, err := hookContextFromDescription(sandboxDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The ports of a composite sandbox are the main ports of its nodes, as during the deployment
+	if len(contexts) > 0 {
+		context.Ports = []int{}
+		for _, nodeContext := range contexts {
+			if len(nodeContext.Ports) > 0 {
+				context.Ports = append(context.Ports, nodeContext.Ports[0])
+			}
+		}
+	}
+	contexts = append(contexts, context)
+	hookLists = append(hookLists, hooks)
+	return contexts, hookLists, nil
+}
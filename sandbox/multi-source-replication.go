@@ -95,6 +95,10 @@ func makeNodesList(nodes int) (nodesList string) {
 }
 
 func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return err
+	}
 	sandboxDef.SBType = "all-masters"
 
 	var logger *defaults.Logger
@@ -109,11 +113,12 @@ func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int
 		}
 		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
 	}
+	logger = logger.WithContext(common.BaseName(sandboxDef.SandboxDir), "")
 
 	sandboxDef.GtidOptions = SingleTemplates["gtid_options_57"].Contents
 	sandboxDef.ReplCrashSafeOptions = SingleTemplates["repl_crash_safe_options"].Contents
 	if sandboxDef.DirName == "" {
-		sandboxDef.DirName += defaults.Defaults().AllMastersPrefix + common.VersionToName(origin)
+		sandboxDef.DirName += currentDefaults.AllMastersPrefix + common.VersionToName(origin)
 	}
 	sandboxDir := sandboxDef.SandboxDir
 	sandboxDef.SandboxDir = common.DirName(sandboxDef.SandboxDir)
@@ -124,13 +129,8 @@ func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int
 	}
 	rev := vList[0]
 	if sandboxDef.BasePort == 0 {
-		sandboxDef.BasePort = sandboxDef.Port + defaults.Defaults().AllMastersReplicationBasePort + (rev * 100)
+		sandboxDef.BasePort = sandboxDef.Port + currentDefaults.AllMastersReplicationBasePort + (rev * 100)
 	}
-	masterAbbr := defaults.Defaults().MasterAbbr
-	slaveAbbr := defaults.Defaults().SlaveAbbr
-	masterLabel := defaults.Defaults().MasterName
-	slaveLabel := defaults.Defaults().SlavePrefix
-
 	readOnlyOptions, err := checkReadOnlyFlags(sandboxDef)
 	if err != nil {
 		return err
@@ -157,25 +157,7 @@ func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int
 		return err
 	}
 
-	setGlobal := "GLOBAL"
-	// persistent, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumRolesVersion)
-	persistent, err := common.HasCapability(sandboxDef.Flavor, common.SetPersist, sandboxDef.Version)
-	if persistent {
-		setGlobal = "PERSIST"
-	}
-
-	data["SlavesReadOnly"] = readOnlyOptions
-	data["SetGlobal"] = setGlobal
-	data["MasterIp"] = masterIp
-	data["MasterAbbr"] = masterAbbr
-	data["MasterLabel"] = masterLabel
-	data["MasterList"] = normalizeNodeList(masterList)
-	data["SlaveAbbr"] = slaveAbbr
-	data["SlaveLabel"] = slaveLabel
-	data["SlaveList"] = normalizeNodeList(masterList)
-	data["RplUser"] = sandboxDef.RplUser
-	data["RplPassword"] = sandboxDef.RplPassword
-	data["NodeLabel"] = defaults.Defaults().NodePrefix
+	setMultiSourceData(data, sandboxDef, currentDefaults, masterIp, masterList, masterList, readOnlyOptions)
 	logger.Printf("Writing master and slave scripts in %s\n", sandboxDef.SandboxDir)
 	for _, node := range slaveList {
 		data["Node"] = node
@@ -206,6 +188,10 @@ func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int
 	if err != nil {
 		return err
 	}
+	err = writeExtraScripts(logger, sandboxDef.SBType, sandboxDir, data, sandboxDef.ExtraScripts)
+	if err != nil {
+		return err
+	}
 	if !sandboxDef.SkipStart {
 		logger.Printf("Initializing all-masters replication \n")
 		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDir), globals.ScriptInitializeMsNodes))
@@ -213,6 +199,10 @@ func CreateAllMastersReplication(sandboxDef SandboxDef, origin string, nodes int
 		if err != nil {
 			return err
 		}
+		err = runHooks(logger, sandboxDef.Hooks, HookPostStart, topologyHookContextFromData(sandboxDef, data))
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -223,6 +213,10 @@ func normalizeNodeList(list string) string {
 }
 
 func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp, masterList, slaveList string) error {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return err
+	}
 	sandboxDef.SBType = "fan-in"
 
 	var logger *defaults.Logger
@@ -237,11 +231,12 @@ func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		}
 		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
 	}
+	logger = logger.WithContext(common.BaseName(sandboxDef.SandboxDir), "")
 
 	sandboxDef.GtidOptions = SingleTemplates["gtid_options_57"].Contents
 	sandboxDef.ReplCrashSafeOptions = SingleTemplates["repl_crash_safe_options"].Contents
 	if sandboxDef.DirName == "" {
-		sandboxDef.DirName = defaults.Defaults().FanInPrefix + common.VersionToName(origin)
+		sandboxDef.DirName = currentDefaults.FanInPrefix + common.VersionToName(origin)
 	}
 	vList, err := common.VersionToList(sandboxDef.Version)
 	if err != nil {
@@ -249,7 +244,7 @@ func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	}
 	rev := vList[0]
 	if sandboxDef.BasePort == 0 {
-		sandboxDef.BasePort = sandboxDef.Port + defaults.Defaults().FanInReplicationBasePort + (rev * 100)
+		sandboxDef.BasePort = sandboxDef.Port + currentDefaults.FanInReplicationBasePort + (rev * 100)
 	}
 	sandboxDir := sandboxDef.SandboxDir
 	sandboxDef.SandboxDir = common.DirName(sandboxDef.SandboxDir)
@@ -276,34 +271,11 @@ func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	} else {
 		return fmt.Errorf("Empty Sandbox directory received from multiple deployment")
 	}
-	masterAbbr := defaults.Defaults().MasterAbbr
-	slaveAbbr := defaults.Defaults().SlaveAbbr
-	masterLabel := defaults.Defaults().MasterName
-	slaveLabel := defaults.Defaults().SlavePrefix
-
-	setGlobal := "GLOBAL"
-	// persistent, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumRolesVersion)
-	persistent, err := common.HasCapability(sandboxDef.Flavor, common.SetPersist, sandboxDef.Version)
-	if persistent {
-		setGlobal = "PERSIST"
-	}
-
 	readOnlyOptions, err := checkReadOnlyFlags(sandboxDef)
 	if err != nil {
 		return err
 	}
-	data["SetGlobal"] = setGlobal
-	data["SlavesReadOnly"] = readOnlyOptions
-	data["MasterList"] = normalizeNodeList(masterList)
-	data["SlaveList"] = normalizeNodeList(slaveList)
-	data["MasterAbbr"] = masterAbbr
-	data["MasterLabel"] = masterLabel
-	data["SlaveAbbr"] = slaveAbbr
-	data["SlaveLabel"] = slaveLabel
-	data["RplUser"] = sandboxDef.RplUser
-	data["RplPassword"] = sandboxDef.RplPassword
-	data["NodeLabel"] = defaults.Defaults().NodePrefix
-	data["MasterIp"] = masterIp
+	setMultiSourceData(data, sandboxDef, currentDefaults, masterIp, masterList, slaveList, readOnlyOptions)
 	logger.Printf("Writing master and slave scripts in %s\n", sandboxDef.SandboxDir)
 	for _, slave := range slist {
 		data["Node"] = slave
@@ -337,6 +309,10 @@ func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 	if err != nil {
 		return err
 	}
+	err = writeExtraScripts(logger, sandboxDef.SBType, sandboxDir, data, sandboxDef.ExtraScripts)
+	if err != nil {
+		return err
+	}
 	if !sandboxDef.SkipStart {
 		logger.Printf("Initializing fan-in replication\n")
 		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDir), globals.ScriptInitializeMsNodes))
@@ -344,6 +320,10 @@ func CreateFanInReplication(sandboxDef SandboxDef, origin string, nodes int, mas
 		if err != nil {
 			return fmt.Errorf("error initializing fan-in sandbox: %s", err)
 		}
+		err = runHooks(logger, sandboxDef.Hooks, HookPostStart, topologyHookContextFromData(sandboxDef, data))
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
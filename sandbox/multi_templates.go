@@ -119,46 +119,56 @@ $SBDIR/{{.NodeLabel}}{{.Node}}/use "$@"
 			Description: "Starts all nodes (with optional mysqld arguments)",
 			Notes:       "",
 			Contents:    startMultiTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 		"restart_multi_template": TemplateDesc{
 			Description: "Restarts all nodes (with optional mysqld arguments)",
 			Notes:       "",
 			Contents:    restartMultiTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"use_multi_template": TemplateDesc{
 			Description: "Runs the same SQL query in all nodes",
 			Notes:       "",
 			Contents:    useMultiTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 		"stop_multi_template": TemplateDesc{
 			Description: "Stops all nodes",
 			Notes:       "",
 			Contents:    stopMultiTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 		"send_kill_multi_template": TemplateDesc{
 			Description: "Sends kill signal to all nodes",
 			Notes:       "",
 			Contents:    sendKillMultiTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 		"clear_multi_template": TemplateDesc{
 			Description: "Removes data from all nodes",
 			Notes:       "",
 			Contents:    clearMultiTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 		"status_multi_template": TemplateDesc{
 			Description: "Shows status for all nodes",
 			Notes:       "",
 			Contents:    statusMultiTemplate,
+			Variables: []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "Nodes.NodePort",
+				"SandboxDir"},
 		},
 		"test_sb_multi_template": TemplateDesc{
 			Description: "Run sb test on all nodes",
 			Notes:       "",
 			Contents:    testSbMultiTemplate,
+			Variables:   []string{"Copyright", "Nodes", "Nodes.Node", "Nodes.NodeLabel", "SandboxDir"},
 		},
 		"node_template": TemplateDesc{
 			Description: "Runs the MySQL client for a given node",
 			Notes:       "",
 			Contents:    nodeTemplate,
+			Variables:   []string{"Copyright", "Node", "NodeLabel", "SandboxDir"},
 		},
 	}
 )
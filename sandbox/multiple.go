@@ -36,7 +36,10 @@ type Node struct {
 }
 
 func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (common.StringMap, error) {
-
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return common.StringMap{}, err
+	}
 	var execLists []concurrent.ExecutionList
 	var emptyStringMap = common.StringMap{}
 
@@ -44,7 +47,6 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	if sbType == "" {
 		sbType = "multiple"
 	}
-	var err error
 	var logger *defaults.Logger
 	if sandboxDef.Logger != nil {
 		logger = sandboxDef.Logger
@@ -60,10 +62,11 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		return emptyStringMap, fmt.Errorf(globals.ErrBaseDirectoryNotFound, Basedir)
 	}
 	if sandboxDef.DirName == "" {
-		sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, defaults.Defaults().MultiplePrefix+common.VersionToName(origin))
+		sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, currentDefaults.MultiplePrefix+common.VersionToName(origin))
 	} else {
 		sandboxDef.SandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
 	}
+	logger = logger.WithContext(common.BaseName(sandboxDef.SandboxDir), "")
 	if common.DirExists(sandboxDef.SandboxDir) {
 		sandboxDef, err = checkDirectory(sandboxDef)
 		if err != nil {
@@ -73,14 +76,14 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 
 	vList, err := common.VersionToList(sandboxDef.Version)
 	rev := vList[2]
-	basePort := sandboxDef.Port + defaults.Defaults().MultipleBasePort + (rev * 100)
+	basePort := sandboxDef.Port + currentDefaults.MultipleBasePort + (rev * 100)
 	if sandboxDef.BasePort > 0 {
 		basePort = sandboxDef.BasePort
 	}
 	// FindFreePort returns the first free port, but base_port will be used
 	// with a counter. Thus the availability will be checked using
 	// "base_port + 1"
-	firstPort, err := common.FindFreePort(basePort+1, sandboxDef.InstalledPorts, nodes)
+	firstPort, err := findAndReservePorts(sandboxDef.SandboxDir, basePort+1, sandboxDef.InstalledPorts, nodes)
 	if err != nil {
 		return emptyStringMap, errors.Wrapf(err, "error getting free port for multiple deployment")
 	}
@@ -95,6 +98,11 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	if err != nil {
 		return emptyStringMap, err
 	}
+	hookContext := topologyHookContext(sandboxDef, sbType, basePort+1, nodes)
+	err = runHooks(logger, sandboxDef.Hooks, HookPreDeploy, hookContext)
+	if err != nil {
+		return emptyStringMap, err
+	}
 	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
 	if err != nil {
 		return emptyStringMap, err
@@ -110,13 +118,7 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		return emptyStringMap, fmt.Errorf("only one node requested. For single sandbox deployment, use the 'single' command")
 	}
 	timestamp := time.Now()
-	var data = common.StringMap{
-		"Copyright":  Copyright,
-		"AppVersion": common.VersionDef,
-		"DateTime":   timestamp.Format(time.UnixDate),
-		"SandboxDir": sandboxDef.SandboxDir,
-		"Nodes":      []common.StringMap{},
-	}
+	data := multipleTemplateData(sandboxDef, timestamp)
 
 	sbDesc := common.SandboxDescription{
 		Basedir: Basedir,
@@ -127,6 +129,8 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 		Nodes:   nodes,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Hooks:   hookDefinitions(sandboxDef.Hooks, HookPreDelete, HookPostDelete),
+		Users:   sandboxUsers(sandboxDef),
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -144,18 +148,10 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	}
 
 	logger.Printf("Defining multiple sandbox data: %v\n", stringMapToJson(data))
-	nodeLabel := defaults.Defaults().NodePrefix
+	nodeLabel := currentDefaults.NodePrefix
 	for i := 1; i <= nodes; i++ {
 		sandboxDef.Port = basePort + i
-		data["Nodes"] = append(data["Nodes"].([]common.StringMap), common.StringMap{
-			"Copyright":  Copyright,
-			"AppVersion": common.VersionDef,
-			"DateTime":   timestamp.Format(time.UnixDate),
-			"Node":       i,
-			"NodePort":   sandboxDef.Port,
-			"NodeLabel":  nodeLabel,
-			"SandboxDir": sandboxDef.SandboxDir,
-		})
+		data["Nodes"] = append(data["Nodes"].([]common.StringMap), multipleNodeData(sandboxDef, currentDefaults, i, sandboxDef.Port, timestamp))
 		sandboxDef.LoadGrants = true
 		sandboxDef.DirName = fmt.Sprintf("%s%d", nodeLabel, i)
 		sandboxDef.ServerId = (baseServerId + i) * 100
@@ -192,13 +188,7 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 			execLists = append(execLists, list)
 		}
 
-		var dataNode = common.StringMap{
-			"Node":       i,
-			"NodePort":   sandboxDef.Port,
-			"NodeLabel":  nodeLabel,
-			"SandboxDir": sandboxDef.SandboxDir,
-			"Copyright":  Copyright,
-		}
+		dataNode := multipleNodeData(sandboxDef, currentDefaults, i, sandboxDef.Port, timestamp)
 		logger.Printf("Creating node script for node %d\n", i)
 		logger.Printf("Defining multiple sandbox node inner data: %v\n", stringMapToJson(dataNode))
 		err = writeScript(logger, MultipleTemplates, fmt.Sprintf("n%d", i), "node_template", sandboxDef.SandboxDir, dataNode, true)
@@ -238,8 +228,25 @@ func CreateMultipleSandbox(sandboxDef SandboxDef, origin string, nodes int) (com
 	if err != nil {
 		return data, err
 	}
+	// Sandboxes built on top of multiple ones (all-masters, fan-in) add their extra scripts with their own data
+	if sbType == "multiple" {
+		err = writeExtraScripts(logger, sbType, sandboxDef.SandboxDir, data, sandboxDef.ExtraScripts)
+		if err != nil {
+			return data, err
+		}
+	}
 	logger.Printf("Run concurrent tasks\n")
-	concurrent.RunParallelTasksByPriority(execLists)
+	err = concurrent.RunParallelTasksByPriority(execLists)
+	if err != nil {
+		return data, err
+	}
+	// Sandboxes built on top of multiple ones run their post-start hooks after the replication setup
+	if sbType == "multiple" && !sandboxDef.SkipStart {
+		err = runHooks(logger, sandboxDef.Hooks, HookPostStart, hookContext)
+		if err != nil {
+			return data, err
+		}
+	}
 
 	common.CondPrintf("%s directory installed in %s\n", sbType, common.ReplaceLiteralHome(sandboxDef.SandboxDir))
 	common.CondPrintf("run 'dbdeployer usage multiple' for basic instructions'\n")
@@ -528,106 +528,141 @@ exit 0
 			Description: "Initialize slaves after deployment",
 			Notes:       "Can also be run after calling './clear_all'",
 			Contents:    initSlavesTemplate,
+			Variables: []string{"Copyright", "MasterIp", "MasterLabel", "RplPassword", "RplUser",
+				"SandboxDir", "Slaves", "Slaves.ChangeMasterExtra", "Slaves.MasterAutoPosition",
+				"Slaves.MasterIp", "Slaves.MasterPort", "Slaves.Node", "Slaves.NodeLabel",
+				"Slaves.RplPassword", "Slaves.RplUser", "Slaves.SlaveLabel"},
 		},
 		"semi_sync_start_template": TemplateDesc{
 			Description: "Starts semi synch replication ",
 			Notes:       "",
 			Contents:    semiSyncStartTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel"},
 		},
 		"start_all_template": TemplateDesc{
 			Description: "Starts nodes in replication order (with optional mysqld arguments)",
 			Notes:       "",
 			Contents:    startAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "SlaveLabel", "Slaves",
+				"Slaves.Node", "Slaves.NodeLabel", "Slaves.SlaveLabel"},
 		},
 		"restart_all_template": TemplateDesc{
 			Description: "stops all nodes and restarts them (with optional mysqld arguments)",
 			Notes:       "",
 			Contents:    restartAllTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"use_all_template": TemplateDesc{
 			Description: "Execute a query for all nodes",
 			Notes:       "",
 			Contents:    useAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel"},
 		},
 		"use_all_slaves_template": TemplateDesc{
 			Description: "Execute a query for all slaves",
 			Notes:       "master-slave topology",
 			Contents:    useAllSlavesTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"use_all_masters_template": TemplateDesc{
 			Description: "Execute a query for all masters",
 			Notes:       "master-slave topology",
 			Contents:    useAllMastersTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"stop_all_template": TemplateDesc{
 			Description: "Stops all nodes in reverse replication order",
 			Notes:       "",
 			Contents:    stopAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel", "Slaves.SlaveLabel"},
 		},
 		"send_kill_all_template": TemplateDesc{
 			Description: "Send kill signal to all nodes",
 			Notes:       "",
 			Contents:    sendKillAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel", "Slaves.SlaveLabel"},
 		},
 		"clear_all_template": TemplateDesc{
 			Description: "Remove data from all nodes",
 			Notes:       "",
 			Contents:    clearAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel", "Slaves.SlaveLabel"},
 		},
 		"status_all_template": TemplateDesc{
 			Description: "Show status of all nodes",
 			Notes:       "",
 			Contents:    statusAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "MasterPort", "SandboxDir", "Slaves",
+				"Slaves.Node", "Slaves.NodeLabel", "Slaves.NodePort"},
 		},
 		"test_sb_all_template": TemplateDesc{
 			Description: "Run sb test on all nodes",
 			Notes:       "",
 			Contents:    testSbAllTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel", "Slaves.SlaveLabel"},
 		},
 		"test_replication_template": TemplateDesc{
 			Description: "Tests replication flow",
 			Notes:       "",
 			Contents:    testReplicationTemplate,
+			Variables: []string{"Copyright", "MasterAbbr", "MasterLabel", "SandboxDir", "SlaveAbbr",
+				"SlaveLabel"},
 		},
 		"check_slaves_template": TemplateDesc{
 			Description: "Checks replication status in master and slaves",
 			Notes:       "",
 			Contents:    checkSlavesTemplate,
+			Variables: []string{"Copyright", "MasterLabel", "SandboxDir", "Slaves", "Slaves.Node",
+				"Slaves.NodeLabel", "Slaves.SlaveLabel"},
 		},
 		"master_template": TemplateDesc{
 			Description: "Runs the MySQL client for the master",
 			Notes:       "",
 			Contents:    masterTemplate,
+			Variables:   []string{"Copyright", "MasterLabel", "SandboxDir"},
 		},
 		"slave_template": TemplateDesc{
 			Description: "Runs the MySQL client for a slave",
 			Notes:       "",
 			Contents:    slaveTemplate,
+			Variables:   []string{"Copyright", "Node", "NodeLabel", "SandboxDir"},
 		},
 		"multi_source_template": TemplateDesc{
 			Description: "Initializes nodes for multi-source replication",
 			Notes:       "fan-in and all-masters",
 			Contents:    multiSourceTemplate,
+			Variables: []string{"Copyright", "MasterIp", "MasterList", "NodeLabel", "RplPassword", "RplUser",
+				"SandboxDir", "SetGlobal", "SlaveList", "SlavesReadOnly"},
 		},
 		"multi_source_use_slaves_template": TemplateDesc{
 			Description: "Runs a query for all slave nodes",
 			Notes:       "group replication and multi-source topologies",
 			Contents:    multiSourceUseSlavesTemplate,
+			Variables:   []string{"Copyright", "MasterList", "NodeLabel", "SandboxDir", "SlaveList"},
 		},
 		"multi_source_use_masters_template": TemplateDesc{
 			Description: "Runs a query for all master nodes",
 			Notes:       "group replication and multi-source topologies",
 			Contents:    multiSourceUseMastersTemplate,
+			Variables:   []string{"Copyright", "MasterList", "NodeLabel", "SandboxDir"},
 		},
 		"multi_source_test_template": TemplateDesc{
 			Description: "Test replication flow for multi-source replication",
 			Notes:       "fan-in and all-masters",
 			Contents:    multiSourceTestTemplate,
+			Variables:   []string{"Copyright", "MasterList", "NodeLabel", "SandboxDir", "SlaveList"},
 		},
 		"check_multi_source_template": TemplateDesc{
 			Description: "checks replication status for multi-source replication",
 			Notes:       "fan-in and all-masters",
 			Contents:    checkMultiSourceTemplate,
+			Variables:   []string{"Copyright", "MasterList", "NodeLabel", "SandboxDir", "SlaveList"},
 		},
 	}
 )
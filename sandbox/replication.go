@@ -71,7 +71,10 @@ func checkReadOnlyFlags(sandboxDef SandboxDef) (string, error) {
 }
 
 func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes int, masterIp string) error {
-
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return err
+	}
 	var execLists []concurrent.ExecutionList
 
 	var logger *defaults.Logger
@@ -86,6 +89,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		}
 		sandboxDef.LogFileName = common.ReplaceLiteralHome(fileName)
 	}
+	logger = logger.WithContext(common.BaseName(sandboxDef.SandboxDir), "")
 
 	sandboxDef.ReplOptions = SingleTemplates["replication_options"].Contents
 	vList, err := common.VersionToList(sandboxDef.Version)
@@ -93,16 +97,16 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		return err
 	}
 	rev := vList[2]
-	basePort := sandboxDef.Port + defaults.Defaults().MasterSlaveBasePort + (rev * 100)
+	basePort := sandboxDef.Port + currentDefaults.MasterSlaveBasePort + (rev * 100)
 	if sandboxDef.BasePort > 0 {
 		basePort = sandboxDef.BasePort
 	}
 	baseServerId := 0
-	sandboxDef.DirName = defaults.Defaults().MasterName
+	sandboxDef.DirName = currentDefaults.MasterName
 	// FindFreePort returns the first free port, but base_port will be used
 	// with a counter. Thus the availability will be checked using
 	// "base_port + 1"
-	firstPort, err := common.FindFreePort(basePort+1, sandboxDef.InstalledPorts, nodes)
+	firstPort, err := findAndReservePorts(sandboxDef.SandboxDir, basePort+1, sandboxDef.InstalledPorts, nodes)
 	if err != nil {
 		return errors.Wrapf(err, "error detecting free port for replication")
 	}
@@ -127,6 +131,11 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		return err
 	}
 
+	hookContext := topologyHookContext(sandboxDef, globals.MasterSlaveLabel, basePort+1, nodes)
+	err = runHooks(logger, sandboxDef.Hooks, HookPreDeploy, hookContext)
+	if err != nil {
+		return err
+	}
 	err = os.Mkdir(sandboxDef.SandboxDir, globals.PublicDirectoryAttr)
 	if err != nil {
 		return err
@@ -157,29 +166,19 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		}
 	}
 	slaves := nodes - 1
-	masterAbbr := defaults.Defaults().MasterAbbr
-	masterLabel := defaults.Defaults().MasterName
-	slaveLabel := defaults.Defaults().SlavePrefix
-	slaveAbbr := defaults.Defaults().SlaveAbbr
+	masterAbbr := currentDefaults.MasterAbbr
+	masterLabel := currentDefaults.MasterName
+	slaveLabel := currentDefaults.SlavePrefix
+	slaveAbbr := currentDefaults.SlaveAbbr
 	timestamp := time.Now()
 
-	var data = common.StringMap{
-		"Copyright":          Copyright,
-		"AppVersion":         common.VersionDef,
-		"DateTime":           timestamp.Format(time.UnixDate),
-		"SandboxDir":         sandboxDef.SandboxDir,
-		"MasterLabel":        masterLabel,
-		"MasterPort":         sandboxDef.Port,
-		"SlaveLabel":         slaveLabel,
-		"MasterAbbr":         masterAbbr,
-		"MasterIp":           masterIp,
-		"RplUser":            sandboxDef.RplUser,
-		"RplPassword":        sandboxDef.RplPassword,
-		"SlaveAbbr":          slaveAbbr,
-		"ChangeMasterExtra":  changeMasterExtra,
-		"MasterAutoPosition": masterAutoPosition,
-		"Slaves":             []common.StringMap{},
+	settings := replicationSettings{
+		masterIp:           masterIp,
+		masterPort:         masterPort,
+		changeMasterExtra:  changeMasterExtra,
+		masterAutoPosition: masterAutoPosition,
 	}
+	data := replicationTemplateData(sandboxDef, currentDefaults, settings, timestamp)
 
 	logger.Printf("Defining replication data: %v\n", stringMapToJson(data))
 	installationMessage := "Installing and starting %s\n"
@@ -195,6 +194,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	sandboxDef.Prompt = masterLabel
 	sandboxDef.NodeNum = 1
 	sandboxDef.SBType = "replication-node"
+	sandboxDef.Role = "master"
 	sandboxDef.ReadOnlyOptions = ""
 	logger.Printf("Creating single sandbox for master\n")
 	execList, err := CreateChildSandbox(sandboxDef)
@@ -214,6 +214,8 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		Nodes:   slaves,
 		NodeNum: 0,
 		LogFile: sandboxDef.LogFileName,
+		Hooks:   hookDefinitions(sandboxDef.Hooks, HookPreDelete, HookPostDelete),
+		Users:   sandboxUsers(sandboxDef),
 	}
 
 	sbItem := defaults.SandboxItem{
@@ -222,7 +224,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		Version:     sandboxDef.Version,
 		Flavor:      sandboxDef.Flavor,
 		Port:        []int{sandboxDef.Port},
-		Nodes:       []string{defaults.Defaults().MasterName},
+		Nodes:       []string{currentDefaults.MasterName},
 		Destination: sandboxDef.SandboxDir,
 	}
 
@@ -246,31 +248,16 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	}
 
 	sandboxDef.ReadOnlyOptions = readOnlyOptions
-	nodeLabel := defaults.Defaults().NodePrefix
+	nodeLabel := currentDefaults.NodePrefix
 	for i := 1; i <= slaves; i++ {
 		sandboxDef.Port = basePort + i + 1
-		data["Slaves"] = append(data["Slaves"].([]common.StringMap), common.StringMap{
-			"Copyright":          Copyright,
-			"AppVersion":         common.VersionDef,
-			"DateTime":           timestamp.Format(time.UnixDate),
-			"Node":               i,
-			"NodeLabel":          nodeLabel,
-			"NodePort":           sandboxDef.Port,
-			"SlaveLabel":         slaveLabel,
-			"MasterAbbr":         masterAbbr,
-			"SlaveAbbr":          slaveAbbr,
-			"SandboxDir":         sandboxDef.SandboxDir,
-			"MasterPort":         masterPort,
-			"MasterIp":           masterIp,
-			"ChangeMasterExtra":  changeMasterExtra,
-			"MasterAutoPosition": masterAutoPosition,
-			"RplUser":            sandboxDef.RplUser,
-			"RplPassword":        sandboxDef.RplPassword})
+		data["Slaves"] = append(data["Slaves"].([]common.StringMap), replicationSlaveData(sandboxDef, currentDefaults, settings, i, sandboxDef.Port, timestamp))
 		sandboxDef.LoadGrants = false
 		sandboxDef.Prompt = fmt.Sprintf("%s%d", slaveLabel, i)
 		sandboxDef.DirName = fmt.Sprintf("%s%d", nodeLabel, i)
 		sandboxDef.ServerId = (baseServerId + i + 1) * 100
 		sandboxDef.NodeNum = i + 1
+		sandboxDef.Role = "slave"
 		sbItem.Nodes = append(sbItem.Nodes, sandboxDef.DirName)
 		sbItem.Port = append(sbItem.Port, sandboxDef.Port)
 		sbDesc.Port = append(sbDesc.Port, sandboxDef.Port)
@@ -308,20 +295,7 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 		for _, list := range execListNode {
 			execLists = append(execLists, list)
 		}
-		var dataSlave = common.StringMap{
-			"Copyright":          Copyright,
-			"AppVersion":         common.VersionDef,
-			"DateTime":           timestamp.Format(time.UnixDate),
-			"Node":               i,
-			"NodeLabel":          nodeLabel,
-			"NodePort":           sandboxDef.Port,
-			"SlaveLabel":         slaveLabel,
-			"MasterAbbr":         masterAbbr,
-			"ChangeMasterExtra":  changeMasterExtra,
-			"MasterAutoPosition": masterAutoPosition,
-			"SlaveAbbr":          slaveAbbr,
-			"SandboxDir":         sandboxDef.SandboxDir,
-		}
+		dataSlave := replicationSlaveData(sandboxDef, currentDefaults, settings, i, sandboxDef.Port, timestamp)
 		logger.Printf("Defining replication node data: %v\n", stringMapToJson(dataSlave))
 		logger.Printf("Create slave script %d\n", i)
 		err = writeScripts(ScriptBatch{ReplicationTemplates, logger, sandboxDef.SandboxDir, dataSlave,
@@ -380,12 +354,24 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 	if err != nil {
 		return err
 	}
+	err = writeExtraScripts(logger, globals.MasterSlaveLabel, sandboxDef.SandboxDir, data, sandboxDef.ExtraScripts)
+	if err != nil {
+		return err
+	}
 	logger.Printf("Run concurrent sandbox scripts \n")
-	concurrent.RunParallelTasksByPriority(execLists)
+	err = concurrent.RunParallelTasksByPriority(execLists)
+	if err != nil {
+		return err
+	}
 	if !sandboxDef.SkipStart {
 		common.CondPrintln(path.Join(common.ReplaceLiteralHome(sandboxDef.SandboxDir), initializeSlaves))
 		logger.Printf("Run replication initialization script \n")
+		op := logger.StartOperation(initializeSlaves)
 		_, err = common.RunCmd(path.Join(sandboxDef.SandboxDir, initializeSlaves))
+		if op.End(err) != nil {
+			return err
+		}
+		err = runHooks(logger, sandboxDef.Hooks, HookPostStart, hookContext)
 		if err != nil {
 			return err
 		}
@@ -396,6 +382,10 @@ func CreateMasterSlaveReplication(sandboxDef SandboxDef, origin string, nodes in
 }
 
 func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, nodes int, masterIp, masterList, slaveList string) error {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return err
+	}
 	if !common.IsIPV4(masterIp) {
 		return fmt.Errorf("IP %s is not a valid IPV4", masterIp)
 	}
@@ -408,12 +398,12 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, n
 	sandboxDir := sdef.SandboxDir
 	switch topology {
 	case globals.MasterSlaveLabel:
-		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().MasterSlavePrefix+common.VersionToName(origin))
+		sdef.SandboxDir = path.Join(sdef.SandboxDir, currentDefaults.MasterSlavePrefix+common.VersionToName(origin))
 	case globals.GroupLabel:
 		if sdef.SinglePrimary {
-			sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().GroupSpPrefix+common.VersionToName(origin))
+			sdef.SandboxDir = path.Join(sdef.SandboxDir, currentDefaults.GroupSpPrefix+common.VersionToName(origin))
 		} else {
-			sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().GroupPrefix+common.VersionToName(origin))
+			sdef.SandboxDir = path.Join(sdef.SandboxDir, currentDefaults.GroupPrefix+common.VersionToName(origin))
 		}
 		// 5.7.17
 		// isMinimumGroupRepl, err := common.GreaterOrEqualVersion(sdef.Version, globals.MinimumGroupReplVersion)
@@ -434,7 +424,7 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, n
 		if !isMinimumMultiSource {
 			return fmt.Errorf(globals.ErrFeatureRequiresVersion, "multi-source replication", common.IntSliceToDottedString(globals.MinimumMultiSourceReplVersion))
 		}
-		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().FanInPrefix+common.VersionToName(origin))
+		sdef.SandboxDir = path.Join(sdef.SandboxDir, currentDefaults.FanInPrefix+common.VersionToName(origin))
 	case globals.AllMastersLabel:
 		// 5.7.9
 
@@ -446,7 +436,7 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, n
 		if !isMinimumMultiSource {
 			return fmt.Errorf(globals.ErrFeatureRequiresVersion, "multi-source replication", common.IntSliceToDottedString(globals.MinimumMultiSourceReplVersion))
 		}
-		sdef.SandboxDir = path.Join(sdef.SandboxDir, defaults.Defaults().AllMastersPrefix+common.VersionToName(origin))
+		sdef.SandboxDir = path.Join(sdef.SandboxDir, currentDefaults.AllMastersPrefix+common.VersionToName(origin))
 	default:
 		return fmt.Errorf("unrecognized topology. Accepted: '%s', '%s', '%s', '%s'",
 			globals.MasterSlaveLabel,
@@ -469,7 +459,6 @@ func CreateReplicationSandbox(sdef SandboxDef, origin string, topology string, n
 	if sdef.HistoryDir == "REPL_DIR" {
 		sdef.HistoryDir = sdef.SandboxDir
 	}
-	var err error
 	switch topology {
 	case globals.MasterSlaveLabel:
 		err = CreateMasterSlaveReplication(sdef, origin, nodes, masterIp)
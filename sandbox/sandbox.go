@@ -87,6 +87,9 @@ type SandboxDef struct {
 	Force                bool             // Overwrite an existing sandbox with same target
 	ExposeDdTables       bool             // Show hidden data dictionary tables (MySQL 8.0.0+)
 	RunConcurrently      bool             // Run multiple sandbox creation concurrently
+	ExtraScripts         []string         // Sets of user scripts to add from the extra scripts directory
+	Hooks                []Hook           // Scripts and SQL files to run at events of the sandbox life cycle
+	Role                 string           // Role of the sandbox in a topology (master, slave, node)
 }
 
 type ScriptDef struct {
@@ -152,7 +155,17 @@ func stringMapToJson(data common.StringMap) string {
 	return fmt.Sprintf("%s", b)
 }
 
-func isLocked(sbDir string) bool {
+// Returns the database users that the grants of a sandbox create
+func sandboxUsers(sandboxDef SandboxDef) []common.SandboxUser {
+	return []common.SandboxUser{
+		{Description: common.UserPurposeAdmin, Username: "root", Password: sandboxDef.DbPassword, Privileges: "all"},
+		{Description: common.UserPurposeDatabase, Username: sandboxDef.DbUser, Password: sandboxDef.DbPassword, Privileges: "all"},
+		{Description: common.UserPurposeReplication, Username: sandboxDef.RplUser, Password: sandboxDef.RplPassword, Privileges: "replication slave"},
+	}
+}
+
+// Returns true if the sandbox has been locked with "dbdeployer admin lock"
+func IsLocked(sbDir string) bool {
 	return common.FileExists(path.Join(sbDir, globals.ScriptNoClear)) || common.FileExists(path.Join(sbDir, globals.ScriptNoClearAll))
 }
 
@@ -160,7 +173,7 @@ func checkDirectory(sandboxDef SandboxDef) (SandboxDef, error) {
 	sandboxDir := sandboxDef.SandboxDir
 	if common.DirExists(sandboxDir) {
 		if sandboxDef.Force {
-			if isLocked(sandboxDir) {
+			if IsLocked(sandboxDir) {
 				return sandboxDef, fmt.Errorf("sandbox in %s is locked. Cannot be overwritten\nYou can unlock it with 'dbdeployer admin unlock %s'\n", sandboxDir, common.DirName(sandboxDir))
 			}
 			common.CondPrintf("Overwriting directory %s\n", sandboxDir)
@@ -230,6 +243,40 @@ func checkPortAvailability(caller string, sandboxType string, installedPorts []i
 	return nil
 }
 
+// Finds free ports like common.FindFreePort, and reserves them in the port registry for the sandbox
+// being deployed, so that a concurrent deployment can't choose them. The ports reserved meanwhile
+// by another deployment are skipped. The reservation is removed if the deployment is aborted
+func findAndReservePorts(sandboxDir string, basePort int, installedPorts []int, howMany int) (int, error) {
+	unavailable := append([]int{}, installedPorts...)
+	for {
+		firstPort, err := common.FindFreePort(basePort, unavailable, howMany)
+		if err != nil {
+			return firstPort, err
+		}
+		var ports []int
+		for port := firstPort; port < firstPort+howMany; port++ {
+			ports = append(ports, port)
+		}
+		taken, err := defaults.ReservePorts(sandboxDir, ports)
+		if err != nil {
+			return 0, err
+		}
+		if len(taken) == 0 {
+			common.AddToCleanupStack(releasePorts, "ReleasePorts", sandboxDir)
+			return firstPort, nil
+		}
+		unavailable = append(unavailable, taken...)
+	}
+}
+
+// Removes the port reservations of an aborted deployment
+func releasePorts(sandboxDir string) {
+	err := defaults.ReleasePorts(sandboxDir)
+	if err != nil {
+		common.CondPrintf("# WARNING: error releasing ports of %s: %s\n", sandboxDir, err)
+	}
+}
+
 func fixServerUuid(sandboxDef SandboxDef) (uuidDef string, uuidFile string, err error) {
 	// 5.6.9
 	// isMinimumGtid, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumGtidVersion)
@@ -263,10 +310,13 @@ func sliceToText(stringSlice []string) string {
 }
 
 func setMysqlxProperties(sandboxDef SandboxDef, globalTmpDir string) (SandboxDef, error) {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return SandboxDef{}, err
+	}
 	mysqlxPort := sandboxDef.MysqlXPort
 	if mysqlxPort == 0 {
-		var err error
-		mysqlxPort, err = common.FindFreePort(sandboxDef.Port+defaults.Defaults().MysqlXPortDelta, sandboxDef.InstalledPorts, 1)
+		mysqlxPort, err = findAndReservePorts(sandboxDef.SandboxDir, sandboxDef.Port+currentDefaults.MysqlXPortDelta, sandboxDef.InstalledPorts, 1)
 		if err != nil {
 			return SandboxDef{}, errors.Wrapf(err, "error detecting free port for MySQLX")
 		}
@@ -290,36 +340,65 @@ func sbError(reason, format string, args ...interface{}) error {
 	return fmt.Errorf(reason+" "+format, args...)
 }
 
-func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.ExecutionList, err error) {
+// Returns the templates for a single sandbox of the given flavor.
+// The ones in the template set of the flavor replace the corresponding main templates,
+// without changing SingleTemplates, which other deployments use
+func singleTemplatesFor(flavorDef common.FlavorDefinition) (TemplateCollection, error) {
+	if flavorDef.TemplateSet == "" {
+		return SingleTemplates, nil
+	}
+	templateSet, ok := AllTemplates[flavorDef.TemplateSet]
+	if !ok {
+		return nil, fmt.Errorf("template set '%s' for flavor '%s' not found", flavorDef.TemplateSet, flavorDef.Name)
+	}
+	var collection = make(TemplateCollection)
+	for name, templateDesc := range SingleTemplates {
+		collection[name] = templateDesc
+	}
+	re := regexp.MustCompile(`^` + flavorDef.TemplateSet + `_`)
+	for name, templateDesc := range templateSet {
+		collection[re.ReplaceAllString(name, "")] = templateDesc
+	}
+	return collection, nil
+}
 
+func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.ExecutionList, err error) {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return execList, err
+	}
 	var sandboxDir string
 	if sandboxDef.SBType == "" {
 		sandboxDef.SBType = "single"
 	}
+	if sandboxDef.Role == "" {
+		sandboxDef.Role = "single"
+		if sandboxDef.Multi {
+			sandboxDef.Role = "node"
+		}
+	}
 	// Assuming a default flavor for backward compatibility
 	if sandboxDef.Flavor == "" {
 		sandboxDef.Flavor = common.MySQLFlavor
 	}
 
-	if sandboxDef.Flavor == common.TiDbFlavor {
+	flavorDef, _ := common.FindFlavor(sandboxDef.Flavor)
+	if flavorDef.NeedsClient {
 		// Ensures that we can run a client.
-		// Since TiDB tarballs don't include a client, we need to use one from MySQL
+		// Since some tarballs (such as TiDB) don't include a client, we need to use one from MySQL
 		// In theory, it could be possible to circumvent this necessity by using
 		// the environment variable $MYSQL_EDITOR, but this would potentially
 		// interfere with other sandboxes isolation. So I better leave this feature
 		// undocumented and only to be used in emergencies.
 		if sandboxDef.ClientBasedir == "" {
 			return emptyExecutionList,
-				fmt.Errorf("flavor '%s' requires option --'%s'", common.TiDbFlavor, globals.ClientFromLabel)
-		}
-
-		// Replaces main templates with the ones needed for TiDB
-		for name, templateDesc := range TidbTemplates {
-			re := regexp.MustCompile(`^` + tidbPrefix)
-			singleName := re.ReplaceAllString(name, "")
-			SingleTemplates[singleName] = templateDesc
+				fmt.Errorf("flavor '%s' requires option --'%s'", sandboxDef.Flavor, globals.ClientFromLabel)
 		}
 	}
+	singleTemplates, err := singleTemplatesFor(flavorDef)
+	if err != nil {
+		return emptyExecutionList, err
+	}
 	logName := sandboxDef.SBType
 	if sandboxDef.NodeNum > 0 {
 		logName = fmt.Sprintf("%s-%d", logName, sandboxDef.NodeNum)
@@ -351,15 +430,20 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	}
 	if sandboxDef.DirName == "" {
 		if sandboxDef.Version != sandboxDef.BasedirName {
-			sandboxDef.DirName = defaults.Defaults().SandboxPrefix + sandboxDef.BasedirName
+			sandboxDef.DirName = currentDefaults.SandboxPrefix + sandboxDef.BasedirName
 		} else {
-			sandboxDef.DirName = defaults.Defaults().SandboxPrefix + versionFname
+			sandboxDef.DirName = currentDefaults.SandboxPrefix + versionFname
 		}
 	}
 	if sandboxDef.DirName == globals.ForbiddenDirName {
 		return emptyExecutionList, fmt.Errorf("the name %s cannot be used for a sandbox", sandboxDef.DirName)
 	}
 	sandboxDir = path.Join(sandboxDef.SandboxDir, sandboxDef.DirName)
+	if sandboxDef.NodeNum > 0 {
+		logger = logger.WithContext(common.BaseName(sandboxDef.SandboxDir), sandboxDef.DirName)
+	} else {
+		logger = logger.WithContext(sandboxDef.DirName, "")
+	}
 	sandboxDef.SandboxDir = sandboxDir
 	logger.Printf("Single Sandbox directory defined as %s\n", sandboxDef.SandboxDir)
 	dataDir := path.Join(sandboxDir, globals.DataDirName)
@@ -372,11 +456,11 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		return emptyExecutionList, fmt.Errorf("TMP directory %s does not exist", globalTmpDir)
 	}
 	if sandboxDef.NodeNum == 0 && !sandboxDef.Force {
-		sandboxDef.Port, err = common.FindFreePort(sandboxDef.Port, sandboxDef.InstalledPorts, 1)
+		sandboxDef.Port, err = findAndReservePorts(sandboxDir, sandboxDef.Port, sandboxDef.InstalledPorts, 1)
 		if err != nil {
 			return emptyExecutionList, errors.Wrapf(err, "error detecting free port for single sandbox")
 		}
-		logger.Printf("Port defined as %d using FindFreePort \n", sandboxDef.Port)
+		logger.Printf("Port defined as %d using findAndReservePorts \n", sandboxDef.Port)
 	}
 	usingPlugins := false
 	rightPluginDir := true // Assuming we can use the right plugin directory
@@ -423,7 +507,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		if !isMinimumDataDictionary {
 			return emptyExecutionList, fmt.Errorf(globals.ErrOptionRequiresVersion, "expose-dd-tables", common.IntSliceToDottedString(globals.MinimumDataDictionaryVersion))
 		}
-		sandboxDef.PostGrantsSql = append(sandboxDef.PostGrantsSql, SingleTemplates["expose_dd_tables"].Contents)
+		sandboxDef.PostGrantsSql = append(sandboxDef.PostGrantsSql, singleTemplates["expose_dd_tables"].Contents)
 		if sandboxDef.CustomMysqld != "" && sandboxDef.CustomMysqld != "mysqld-debug" {
 			return emptyExecutionList, fmt.Errorf("--expose-dd-tables requires mysqld-debug. A different file was indicated (--custom-mysqld=%s)\n%s",
 				sandboxDef.CustomMysqld, "Either use \"mysqld-debug\" or remove --custom-mysqld")
@@ -524,65 +608,13 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 				"directory for plugins was not found")
 		}
 	}
-	timestamp := time.Now()
-	verList, err := common.VersionToList(sandboxDef.Version)
-	if err != nil {
-		return emptyExecutionList, errors.Wrapf(err, "")
-	}
 	if sandboxDef.ClientBasedir == "" {
 		sandboxDef.ClientBasedir = sandboxDef.Basedir
 	}
 
-	var data = common.StringMap{
-		"Basedir":              sandboxDef.Basedir,
-		"ClientBasedir":        sandboxDef.ClientBasedir,
-		"Copyright":            SingleTemplates["Copyright"].Contents,
-		"AppVersion":           common.VersionDef,
-		"DateTime":             timestamp.Format(time.UnixDate),
-		"SandboxDir":           sandboxDir,
-		"CustomMysqld":         sandboxDef.CustomMysqld,
-		"Port":                 sandboxDef.Port,
-		"MysqlXPort":           sandboxDef.MysqlXPort,
-		"MysqlShell":           mysqlshExecutable,
-		"BasePort":             sandboxDef.BasePort,
-		"Prompt":               sandboxDef.Prompt,
-		"Version":              sandboxDef.Version,
-		"VersionMajor":         verList[0],
-		"VersionMinor":         verList[1],
-		"VersionRev":           verList[2],
-		"Datadir":              dataDir,
-		"Tmpdir":               tmpDir,
-		"GlobalTmpDir":         globalTmpDir,
-		"DbUser":               sandboxDef.DbUser,
-		"DbPassword":           sandboxDef.DbPassword,
-		"RplUser":              sandboxDef.RplUser,
-		"RplPassword":          sandboxDef.RplPassword,
-		"RemoteAccess":         sandboxDef.RemoteAccess,
-		"BindAddress":          sandboxDef.BindAddress,
-		"OsUser":               os.Getenv("USER"),
-		"ReplOptions":          sandboxDef.ReplOptions,
-		"GtidOptions":          sandboxDef.GtidOptions,
-		"ReplCrashSafeOptions": sandboxDef.ReplCrashSafeOptions,
-		"SemiSyncOptions":      sandboxDef.SemiSyncOptions,
-		"ReadOnlyOptions":      sandboxDef.ReadOnlyOptions,
-		"ExtraOptions":         sliceToText(sandboxDef.MyCnfOptions),
-		"ReportHost":           fmt.Sprintf("report-host=single-%d", sandboxDef.Port),
-		"ReportPort":           fmt.Sprintf("report-port=%d", sandboxDef.Port),
-		"HistoryDir":           sandboxDef.HistoryDir,
-	}
-	if sandboxDef.NodeNum != 0 {
-		data["ReportHost"] = fmt.Sprintf("report-host = node-%d", sandboxDef.NodeNum)
-	}
-	if sandboxDef.SkipReportHost || sandboxDef.SBType == "group-node" {
-		data["ReportHost"] = ""
-	}
-	if sandboxDef.SkipReportPort {
-		data["ReportPort"] = ""
-	}
-	if sandboxDef.ServerId > 0 {
-		data["ServerId"] = fmt.Sprintf("server-id=%d", sandboxDef.ServerId)
-	} else {
-		data["ServerId"] = ""
+	data, err := singleTemplateData(sandboxDef, globalTmpDir, mysqlshExecutable, time.Now())
+	if err != nil {
+		return emptyExecutionList, errors.Wrapf(err, "")
 	}
 	if common.DirExists(sandboxDir) {
 		sandboxDef, err = checkDirectory(sandboxDef)
@@ -595,6 +627,19 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if err != nil {
 		return emptyExecutionList, sbError("check port", "%s", err)
 	}
+	hookContext := HookContext{
+		SandboxDir: sandboxDir,
+		SBType:     sandboxDef.SBType,
+		Role:       sandboxDef.Role,
+		Version:    sandboxDef.Version,
+		Flavor:     sandboxDef.Flavor,
+		Node:       sandboxDef.NodeNum,
+		Ports:      append([]int{sandboxDef.Port}, sandboxDef.MorePorts...),
+	}
+	err = runHooks(logger, sandboxDef.Hooks, HookPreDeploy, hookContext)
+	if err != nil {
+		return emptyExecutionList, err
+	}
 
 	err = os.Mkdir(sandboxDir, globals.PublicDirectoryAttr)
 	if err != nil {
@@ -645,7 +690,6 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		}
 	}
 	data["InitScript"] = script
-	data["InitDefaults"] = ""
 	if script != "" {
 		data["InitDefaults"] = "--no-defaults"
 	}
@@ -653,8 +697,6 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		initScriptFlags = fmt.Sprintf("\\\n    %s", initScriptFlags)
 	}
 	data["ExtraInitFlags"] = initScriptFlags
-	data["FixUuidFile1"] = ""
-	data["FixUuidFile2"] = ""
 
 	if !sandboxDef.KeepUuid {
 		newUuid, uuidFname, err := fixServerUuid(sandboxDef)
@@ -668,7 +710,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		}
 	}
 
-	err = writeScript(logger, SingleTemplates, globals.ScriptInitDb, "init_db_template", sandboxDir, data, true)
+	err = writeScript(logger, singleTemplates, globals.ScriptInitDb, "init_db_template", sandboxDir, data, true)
 	if err != nil {
 		return emptyExecutionList, err
 	}
@@ -679,13 +721,16 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		}
 		logger.Printf("Added init_db script to execution list\n")
 		execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 0, Command: eCommand})
+		execList = addHooksToExecList(execList, logger, sandboxDef.Hooks, HookPostInit, hookContext, 1)
 	} else {
 		logger.Printf("Running init_db script \n")
 		initDbScript := path.Join(sandboxDir, globals.ScriptInitDb)
 		if !common.FileExists(initDbScript) {
 			return emptyExecutionList, fmt.Errorf(globals.ErrFileNotFound, initDbScript)
 		}
+		initOp := logger.StartOperation(globals.ScriptInitDb)
 		initOutput, err := common.RunCmdCtrl(initDbScript, true)
+		initOp.End(err)
 		if err == nil {
 			if !sandboxDef.Multi {
 				if globals.UsingDbDeployer {
@@ -693,6 +738,10 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 					common.CondPrintf("run 'dbdeployer usage single' for basic instructions'\n")
 				}
 			}
+			err = runHooks(logger, sandboxDef.Hooks, HookPostInit, hookContext)
+			if err != nil {
+				return emptyExecutionList, err
+			}
 		} else {
 			common.CondPrintf("InitDb output: %s\n", initOutput)
 			return emptyExecutionList, fmt.Errorf("InitDb failure: %s\n", err)
@@ -722,6 +771,9 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		Nodes:         0,
 		NodeNum:       sandboxDef.NodeNum,
 		LogFile:       sandboxDef.LogFileName,
+		Role:          sandboxDef.Role,
+		Hooks:         hookDefinitions(sandboxDef.Hooks, HookPreDelete, HookPostDelete),
+		Users:         sandboxUsers(sandboxDef),
 	}
 	if len(sandboxDef.MorePorts) > 0 {
 		for _, port := range sandboxDef.MorePorts {
@@ -745,7 +797,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		sandboxDir: sandboxDir,
 		data:       data,
 		logger:     logger,
-		tc:         SingleTemplates,
+		tc:         singleTemplates,
 		scripts: []ScriptDef{
 			{globals.ScriptStart, "start_template", true},
 			{globals.ScriptStatus, "status_template", true},
@@ -796,6 +848,10 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 	if err != nil {
 		return emptyExecutionList, err
 	}
+	err = writeExtraScripts(logger, sandboxDef.SBType, sandboxDir, data, sandboxDef.ExtraScripts)
+	if err != nil {
+		return emptyExecutionList, err
+	}
 	preGrantSqlFile := path.Join(sandboxDir, globals.ScriptPreGrantsSql)
 	postGrantSqlFile := path.Join(sandboxDir, globals.ScriptPostGrantsSql)
 	if sandboxDef.PreGrantsSqlFile != "" {
@@ -838,6 +894,7 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 		}
 		logger.Printf("Adding start command to execution list\n")
 		execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 2, Command: eCommand2})
+		execList = addHooksToExecList(execList, logger, sandboxDef.Hooks, HookPostStart, hookContext, 3)
 		if sandboxDef.LoadGrants {
 			var (
 				eCmdAfterStart = concurrent.ExecCommand{
@@ -865,32 +922,46 @@ func createSingleSandbox(sandboxDef SandboxDef) (execList []concurrent.Execution
 			execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 4, Command: eCmdPreGrants})
 			execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 5, Command: eCmdLoadGrants})
 			execList = append(execList, concurrent.ExecutionList{Logger: logger, Priority: 6, Command: eCmdPostGrants})
+			execList = addHooksToExecList(execList, logger, sandboxDef.Hooks, HookPostGrants, hookContext, 7)
 		}
 	} else {
 		if !sandboxDef.SkipStart {
 			logger.Printf("Running start script\n")
+			op := logger.StartOperation(globals.ScriptStart)
 			_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptStart))
-			if err != nil {
+			if op.End(err) != nil {
 				return emptyExecutionList, err
 			}
 			logger.Printf("Running after start script\n")
+			op = logger.StartOperation(globals.ScriptAfterStart)
 			_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptAfterStart))
+			if op.End(err) != nil {
+				return emptyExecutionList, err
+			}
+			err = runHooks(logger, sandboxDef.Hooks, HookPostStart, hookContext)
 			if err != nil {
 				return emptyExecutionList, err
 			}
 			if sandboxDef.LoadGrants {
 				logger.Printf("Running pre grants script\n")
+				op = logger.StartOperation(globals.ScriptPreGrantsSql)
 				_, err = common.RunCmdWithArgs(path.Join(sandboxDir, globals.ScriptLoadGrants), []string{globals.ScriptPreGrantsSql})
-				if err != nil {
+				if op.End(err) != nil {
 					return emptyExecutionList, err
 				}
 				logger.Printf("Running load grants script\n")
+				op = logger.StartOperation(globals.ScriptLoadGrants)
 				_, err = common.RunCmd(path.Join(sandboxDir, globals.ScriptLoadGrants))
-				if err != nil {
+				if op.End(err) != nil {
 					return emptyExecutionList, err
 				}
 				logger.Printf("Running post grants script\n")
+				op = logger.StartOperation(globals.ScriptPostGrantsSql)
 				_, err = common.RunCmdWithArgs(path.Join(sandboxDir, globals.ScriptLoadGrants), []string{globals.ScriptPostGrantsSql})
+				if op.End(err) != nil {
+					return emptyExecutionList, err
+				}
+				err = runHooks(logger, sandboxDef.Hooks, HookPostGrants, hookContext)
 				if err != nil {
 					return emptyExecutionList, err
 				}
@@ -911,21 +982,57 @@ func writeScripts(scriptBatch ScriptBatch) error {
 	return nil
 }
 
+// Separates a template name from its variant, as in "my_cnf_template@mariadb-10.3"
+const TemplateVariantSeparator = "@"
+
+// Returns the names of the templates that can be used for a given flavor and version,
+// from the most specific (name@flavor-major.minor) to the generic one
+func TemplateVariantNames(templateName, flavor, version string) []string {
+	var names []string
+	if flavor != "" {
+		verList, err := common.VersionToList(version)
+		if err == nil {
+			names = append(names, fmt.Sprintf("%s%s%s-%d.%d", templateName, TemplateVariantSeparator, flavor, verList[0], verList[1]))
+		}
+		names = append(names, templateName+TemplateVariantSeparator+flavor)
+	}
+	return append(names, templateName)
+}
+
+// Returns the name of the template in the collection that applies to the given flavor and version
+func ResolveTemplateName(tempVar TemplateCollection, templateName, flavor, version string) string {
+	for _, name := range TemplateVariantNames(templateName, flavor, version) {
+		_, ok := tempVar[name]
+		if ok {
+			return name
+		}
+	}
+	return templateName
+}
+
 func writeScript(logger *defaults.Logger, tempVar TemplateCollection, scriptName, templateName, directory string,
 	data common.StringMap, makeExecutable bool) error {
 	if directory == "" {
 		return fmt.Errorf("writeScript (%s): missing directory", scriptName)
 	}
+	// Variants of the template for the sandbox flavor and version take precedence
+	flavor, _ := data["Flavor"].(string)
+	version, _ := data["Version"].(string)
+	templateName = ResolveTemplateName(tempVar, templateName, flavor, version)
 	_, ok := tempVar[templateName]
 	if !ok {
 		return fmt.Errorf("writeScript (%s): template %s not found", scriptName, templateName)
 	}
-	template := tempVar[templateName].Contents
-	template = common.TrimmedLines(template)
+	desc := tempVar[templateName]
+	template := common.TrimmedLines(desc.Contents)
 	data["TemplateName"] = templateName
+	// Templates loaded from files keep the declarations of the built-in ones, and can use any of the data
+	err := CheckRequiredVariables(templateName, TemplateVariablesFor(desc), data)
+	if err != nil {
+		return fmt.Errorf("writeScript (%s): %s", scriptName, err)
+	}
 	text := common.TemplateFill(template, data)
 	executableStatus := ""
-	var err error
 	if makeExecutable {
 		err = writeExec(scriptName, text, directory)
 		executableStatus = " executable"
@@ -980,6 +1087,13 @@ func getLogDirFromSbDescription(fullPath string) (string, error) {
 }
 
 func RemoveSandbox(sandboxDir, sandbox string, runConcurrently bool) (execList []concurrent.ExecutionList, err error) {
+	return RemoveSandboxWithHooks(sandboxDir, sandbox, runConcurrently, nil)
+}
+
+// Removes a sandbox, running the pre-delete and post-delete hooks recorded in the
+// sandbox description and the ones given as argument. In composite sandboxes,
+// the hooks run for every node and then for the whole sandbox
+func RemoveSandboxWithHooks(sandboxDir, sandbox string, runConcurrently bool, hooks []Hook) (execList []concurrent.ExecutionList, err error) {
 	fullPath := path.Join(sandboxDir, sandbox)
 	if !common.DirExists(fullPath) {
 		return emptyExecutionList, fmt.Errorf(globals.ErrDirectoryNotFound, fullPath)
@@ -1004,6 +1118,19 @@ func RemoveSandbox(sandboxDir, sandbox string, runConcurrently bool) (execList [
 	if !common.ExecExists(stop) {
 		return emptyExecutionList, fmt.Errorf(globals.ErrExecutableNotFound, stop)
 	}
+	hookContexts, hookLists, err := deleteHookContexts(fullPath)
+	if err != nil {
+		return emptyExecutionList, err
+	}
+	for N, context := range hookContexts {
+		hookLists[N] = mergeHooks(hookLists[N], hooks)
+		// A failing pre-delete hook (for example, a SQL file in a sandbox that is not running)
+		// must not prevent the removal of the sandbox
+		err = runHooks(nil, hookLists[N], HookPreDelete, context)
+		if err != nil {
+			common.CondPrintf("# WARNING: %s\n", err)
+		}
+	}
 
 	if runConcurrently {
 		var eCommand1 = concurrent.ExecCommand{
@@ -1049,6 +1176,16 @@ func RemoveSandbox(sandboxDir, sandbox string, runConcurrently bool) (execList [
 			}
 		}
 	}
+	for N, context := range hookContexts {
+		if runConcurrently {
+			execList = addHooksToExecList(execList, nil, hookLists[N], HookPostDelete, context, 2)
+		} else {
+			err = runHooks(nil, hookLists[N], HookPostDelete, context)
+			if err != nil {
+				return emptyExecutionList, err
+			}
+		}
+	}
 	// common.CondPrintf("%#v\n",execList)
 	return execList, nil
 }
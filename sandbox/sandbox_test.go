@@ -19,12 +19,14 @@ import (
 	"fmt"
 	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/compare"
+	"github.com/datacharmer/dbdeployer/concurrent"
 	"github.com/datacharmer/dbdeployer/defaults"
 	"github.com/datacharmer/dbdeployer/globals"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 func okPortExists(t *testing.T, dirName string, port int) {
@@ -631,3 +633,388 @@ func TestCreateSandbox(t *testing.T) {
 	t.Run("expectedFailures", testFailSandboxConditions)
 	t.Run("flavors", testDetectFlavor)
 }
+
+func TestResolveTemplateName(t *testing.T) {
+	var collection = TemplateCollection{
+		"my_cnf_template":              {Contents: "generic"},
+		"my_cnf_template@mariadb":      {Contents: "mariadb"},
+		"my_cnf_template@mysql-5.6":    {Contents: "mysql 5.6"},
+		"my_cnf_template@mariadb-10.3": {Contents: "mariadb 10.3"},
+	}
+	type resolveTest struct {
+		flavor   string
+		version  string
+		expected string
+	}
+	var data = []resolveTest{
+		{common.MySQLFlavor, "8.0.16", "my_cnf_template"},
+		{common.MySQLFlavor, "5.6.41", "my_cnf_template@mysql-5.6"},
+		{common.MariaDbFlavor, "10.3.13", "my_cnf_template@mariadb-10.3"},
+		{common.MariaDbFlavor, "10.2.22", "my_cnf_template@mariadb"},
+		{"", "5.6.41", "my_cnf_template"},
+	}
+	for _, rt := range data {
+		compare.OkEqualString(fmt.Sprintf("template for %s %s", rt.flavor, rt.version),
+			ResolveTemplateName(collection, "my_cnf_template", rt.flavor, rt.version), rt.expected, t)
+	}
+	compare.OkEqualString("missing template", ResolveTemplateName(collection, "start_template", common.MariaDbFlavor, "10.3.13"),
+		"start_template", t)
+}
+
+func TestCheckTemplate(t *testing.T) {
+	for groupName, group := range AllTemplates {
+		for name, template := range group {
+			problems := CheckTemplate(groupName, name, template.Contents)
+			for _, problem := range problems {
+				t.Logf("%s/%s: %s", groupName, name, problem)
+			}
+			compare.OkEqualInt(fmt.Sprintf("problems in template %s/%s", groupName, name), len(problems), 0, t)
+		}
+	}
+	var broken = []struct {
+		contents string
+		expected string
+	}{
+		{"port={{.Port}", "syntax error"},
+		{"port={{.NoSuchPort}}", "unknown variable 'NoSuchPort'"},
+		{"{{range .Slaves}}{{.NoSuchNode}}{{end}}", "unknown variable 'NoSuchNode'"},
+	}
+	for _, b := range broken {
+		groupName := "single"
+		if strings.Contains(b.contents, "Slaves") {
+			groupName = "replication"
+		}
+		problems := CheckTemplate(groupName, "broken", b.contents)
+		compare.OkEqualInt(fmt.Sprintf("problems in '%s'", b.contents), len(problems), 1, t)
+		if len(problems) > 0 {
+			compare.OkMatchesString("problem description", problems[0].Error(), b.expected, t)
+		}
+	}
+
+	// Variants are checked with the flavor and version in their name
+	variantOnly := `{{if ne .Flavor "mariadb"}}{{template "wrong_flavor"}}{{end}}` +
+		`{{if ne .Version "10.3.0"}}{{template "wrong_version"}}{{end}}`
+	problems := CheckTemplate("single", "sample_template@mariadb-10.3", variantOnly)
+	compare.OkEqualInt("problems in variant", len(problems), 0, t)
+	problems = CheckTemplate("single", "sample_template", variantOnly)
+	compare.OkEqualInt("problems in generic template", len(problems), 1, t)
+
+	// Overrides can use variables that the built-in template does not declare
+	override := "{{.Version}} {{.Flavor}} {{.MysqlXPort}} {{.MasterPort}} {{range .Nodes}}{{.NodePort}}{{end}}"
+	problems = CheckTemplate("single", "my_cnf_template", override)
+	for _, problem := range problems {
+		t.Logf("override: %s", problem)
+	}
+	compare.OkEqualInt("problems in override", len(problems), 0, t)
+}
+
+func TestTemplateVariantTarget(t *testing.T) {
+	var data = []struct {
+		name    string
+		flavor  string
+		version string
+	}{
+		{"my_cnf_template", common.MySQLFlavor, SampleTemplateVersion},
+		{"my_cnf_template@", common.MySQLFlavor, SampleTemplateVersion},
+		{"my_cnf_template@mariadb", common.MariaDbFlavor, SampleTemplateVersion},
+		{"my_cnf_template@mariadb-10.3", common.MariaDbFlavor, "10.3.0"},
+		{"my_cnf_template@percona-5.7", common.PerconaServerFlavor, "5.7.0"},
+		{"my_cnf_template@my-flavor-2.1", "my-flavor", "2.1.0"},
+	}
+	for _, d := range data {
+		flavor, version := TemplateVariantTarget(d.name)
+		compare.OkEqualString(d.name+" flavor", flavor, d.flavor, t)
+		compare.OkEqualString(d.name+" version", version, d.version, t)
+	}
+}
+
+func TestSingleTemplateData(t *testing.T) {
+	sandboxDef := SandboxDef{
+		Version:    "8.0.16",
+		Flavor:     common.MySQLFlavor,
+		SandboxDir: "/tmp/msb_8_0_16",
+		Port:       8016,
+	}
+	data, err := singleTemplateData(sandboxDef, "/tmp", "mysqlsh", time.Now())
+	compare.OkIsNil("single template data", err, t)
+	compare.OkEqualString("report host", data["ReportHost"].(string), "report-host=single-8016", t)
+	compare.OkEqualString("no server id", data["ServerId"].(string), "", t)
+	compare.OkEqualString("data directory", data["Datadir"].(string), "/tmp/msb_8_0_16/data", t)
+	compare.OkEqualInt("minor version", data["VersionMinor"].(int), 0, t)
+
+	sandboxDef.NodeNum = 2
+	sandboxDef.ServerId = 200
+	sandboxDef.SkipReportPort = true
+	data, err = singleTemplateData(sandboxDef, "/tmp", "mysqlsh", time.Now())
+	compare.OkIsNil("node template data", err, t)
+	compare.OkEqualString("node report host", data["ReportHost"].(string), "report-host = node-2", t)
+	compare.OkEqualString("skipped report port", data["ReportPort"].(string), "", t)
+	compare.OkEqualString("server id", data["ServerId"].(string), "server-id=200", t)
+
+	sandboxDef.SBType = "group-node"
+	data, err = singleTemplateData(sandboxDef, "/tmp", "mysqlsh", time.Now())
+	compare.OkIsNil("group node template data", err, t)
+	compare.OkEqualString("group node report host", data["ReportHost"].(string), "", t)
+
+	sandboxDef.Version = "8.0"
+	_, err = singleTemplateData(sandboxDef, "/tmp", "mysqlsh", time.Now())
+	compare.OkIsNotNil("invalid version", err, t)
+}
+
+func TestTemplateVariables(t *testing.T) {
+	for groupName, group := range AllTemplates {
+		for name, template := range group {
+			label := fmt.Sprintf("%s/%s", groupName, name)
+			for _, variable := range TemplateVariablesFor(template) {
+				_, ok := TemplateVariableCatalog[variable.Name]
+				compare.OkEqualBool(fmt.Sprintf("variable %s of %s in catalog", variable.Name, label), ok, true, t)
+			}
+			compare.OkIsNil("declarations of "+label, CheckTemplateDeclarations(name, template), t)
+			// Declared variables must be used, or the declaration is stale
+			used, err := usedTemplateVariables(name, template.Contents)
+			compare.OkIsNil("variables of "+label, err, t)
+			var isUsed = make(map[string]bool)
+			for _, variable := range used {
+				isUsed[variable] = true
+			}
+			for _, variable := range append(template.Variables, template.OptionalVariables...) {
+				compare.OkEqualBool(fmt.Sprintf("variable %s used in %s", variable, label), isUsed[variable], true, t)
+			}
+		}
+	}
+	template := TemplateDesc{
+		Contents:          `{{.SandboxDir}} {{if .ExtraOptions}}{{.ExtraOptions}}{{end}} {{range .Nodes}}{{.NodePort}}{{end}}`,
+		Variables:         []string{"SandboxDir", "Nodes", "Nodes.NodePort"},
+		OptionalVariables: []string{"ExtraOptions"},
+	}
+	compare.OkIsNil("declarations", CheckTemplateDeclarations("test", template), t)
+	variables := TemplateVariablesFor(template)
+	compare.OkEqualInt("number of variables", len(variables), 4, t)
+	var data = common.StringMap{
+		"SandboxDir": "/tmp/sandbox",
+		"Nodes":      []common.StringMap{{"NodePort": 1000}},
+	}
+	compare.OkIsNil("all required variables", CheckRequiredVariables("test", variables, data), t)
+
+	data["Nodes"] = []common.StringMap{{"NodePort": 1000}, {"Node": 2}}
+	err := CheckRequiredVariables("test", variables, data)
+	compare.OkIsNotNil("missing list variable", err, t)
+	if err != nil {
+		compare.OkMatchesString("missing list variable", err.Error(), `Nodes\[1\]\.NodePort`, t)
+	}
+	delete(data, "SandboxDir")
+	err = CheckRequiredVariables("test", variables, data)
+	compare.OkIsNotNil("missing variable", err, t)
+	if err != nil {
+		compare.OkMatchesString("missing variable", err.Error(), "SandboxDir", t)
+	}
+
+	template.Contents += "{{.Port}}"
+	err = CheckTemplateDeclarations("test", template)
+	compare.OkIsNotNil("undeclared variable", err, t)
+	if err != nil {
+		compare.OkMatchesString("undeclared variable", err.Error(), "Port", t)
+	}
+	template.Contents = "{{.SandboxDir}"
+	compare.OkIsNotNil("template syntax error", CheckTemplateDeclarations("test", template), t)
+}
+
+func TestWriteScriptOverride(t *testing.T) {
+	sandboxDir := path.Join(os.TempDir(), "dbdeployer_override_test")
+	err := os.MkdirAll(sandboxDir, globals.PublicDirectoryAttr)
+	compare.OkIsNil("creation of "+sandboxDir, err, t)
+	defer os.RemoveAll(sandboxDir)
+
+	builtIn := SingleTemplates["start_template"]
+	override := builtIn
+	override.Contents = "{{.SandboxDir}} {{.Version}} {{.Custom}}"
+	override.TemplateInFile = true
+	var collection = TemplateCollection{"start_template": override}
+	var data = common.StringMap{"SandboxDir": sandboxDir, "Version": "8.0.16", "Custom": "extra"}
+	for _, name := range builtIn.Variables {
+		if _, ok := data[name]; !ok {
+			data[name] = ""
+		}
+	}
+	err = writeScript(nil, collection, "start", "start_template", sandboxDir, data, true)
+	compare.OkIsNil("override with variables not declared by the built-in template", err, t)
+	text, err := common.SlurpAsString(path.Join(sandboxDir, "start"))
+	compare.OkIsNil("reading start", err, t)
+	compare.OkEqualString("contents of start", text, sandboxDir+" 8.0.16 extra", t)
+
+	// Required variables are still checked
+	delete(data, "SandboxDir")
+	err = writeScript(nil, collection, "start", "start_template", sandboxDir, data, true)
+	compare.OkIsNotNil("override without required variables", err, t)
+}
+
+func TestWriteExtraScripts(t *testing.T) {
+	saveExtraScriptsDir := defaults.ExtraScriptsDir
+	defer func() { defaults.ExtraScriptsDir = saveExtraScriptsDir }()
+	tempDir := path.Join(os.TempDir(), "dbdeployer_extra_scripts_test")
+	defaults.ExtraScriptsDir = path.Join(tempDir, "extra-scripts")
+	sandboxDir := path.Join(tempDir, "sandbox")
+	defer os.RemoveAll(tempDir)
+	for _, dir := range []string{path.Join(defaults.ExtraScriptsDir, "single", "fixtures"), sandboxDir} {
+		err := os.MkdirAll(dir, globals.PublicDirectoryAttr)
+		compare.OkIsNil("creation of "+dir, err, t)
+	}
+	var scripts = map[string]string{
+		"single/explain_all":            "port={{.Port}}",
+		"single/explain_all@mariadb":    "mariadb port={{.Port}}",
+		"single/fixtures/load_fixtures": "dir={{.SandboxDir}}",
+	}
+	for name, contents := range scripts {
+		err := common.WriteString(contents, path.Join(defaults.ExtraScriptsDir, name))
+		compare.OkIsNil("creation of "+name, err, t)
+	}
+	sets, err := ExtraScriptSets()
+	compare.OkIsNil("extra script sets", err, t)
+	compare.OkEqualString("extra script sets", strings.Join(sets, ","), "fixtures", t)
+
+	var data = common.StringMap{"Port": 5000, "SandboxDir": sandboxDir, "Flavor": common.MariaDbFlavor, "Version": "10.3.13"}
+	err = writeExtraScripts(nil, "single", sandboxDir, data, []string{"fixtures"})
+	compare.OkIsNil("extra scripts", err, t)
+	text, err := common.SlurpAsString(path.Join(sandboxDir, "explain_all"))
+	compare.OkIsNil("reading explain_all", err, t)
+	compare.OkEqualString("variant of explain_all", text, "mariadb port=5000", t)
+	text, err = common.SlurpAsString(path.Join(sandboxDir, "load_fixtures"))
+	compare.OkIsNil("reading load_fixtures", err, t)
+	compare.OkEqualString("contents of load_fixtures", text, "dir="+sandboxDir, t)
+
+	// Existing scripts are not replaced
+	err = writeExtraScripts(nil, "single", sandboxDir, data, nil)
+	compare.OkIsNotNil("replacing existing script", err, t)
+}
+
+func TestHooks(t *testing.T) {
+	tempDir := path.Join(os.TempDir(), "dbdeployer_hooks_test")
+	sandboxDir := path.Join(tempDir, "sandbox")
+	defer os.RemoveAll(tempDir)
+	err := os.MkdirAll(sandboxDir, globals.PublicDirectoryAttr)
+	compare.OkIsNil("creation of "+sandboxDir, err, t)
+	script := path.Join(tempDir, "hook.sh")
+	output := path.Join(tempDir, "hook.out")
+	err = common.WriteString("#!/bin/sh\n"+
+		"echo \"$DBDEPLOYER_HOOK $DBDEPLOYER_SANDBOX_ROLE $DBDEPLOYER_SANDBOX_NODE $DBDEPLOYER_SANDBOX_PORT $DBDEPLOYER_SANDBOX_PORTS\" > "+output+"\n",
+		script)
+	compare.OkIsNil("creation of hook script", err, t)
+	err = os.Chmod(script, globals.ExecutableFileAttr)
+	compare.OkIsNil("hook script permissions", err, t)
+	sqlFile := path.Join(tempDir, "hook.sql")
+	err = common.WriteString("select 1;\n", sqlFile)
+	compare.OkIsNil("creation of SQL hook", err, t)
+
+	var invalid = []string{
+		"post-start",
+		"post-start:",
+		"no-such-event:" + script,
+		"post-start:" + path.Join(tempDir, "no-such-script"),
+		"pre-deploy:" + sqlFile,
+		"post-delete:" + sqlFile,
+	}
+	for _, definition := range invalid {
+		_, err = ParseHooks([]string{definition})
+		compare.OkIsNotNil("invalid hook "+definition, err, t)
+	}
+	hooks, err := ParseHooks([]string{"", "post-start:" + script, "post-start:" + script, "post-grants:" + sqlFile})
+	compare.OkIsNil("valid hooks", err, t)
+	compare.OkEqualInt("number of hooks", len(hooks), 2, t)
+	compare.OkEqualString("delete hooks", strings.Join(hookDefinitions(hooks, HookPreDelete, HookPostDelete), ","), "", t)
+	compare.OkEqualInt("merged hooks", len(mergeHooks(hooks, hooks[:1])), 2, t)
+
+	context := HookContext{
+		SandboxDir: sandboxDir,
+		SBType:     "replication-node",
+		Role:       "slave",
+		Version:    "8.0.16",
+		Flavor:     common.MySQLFlavor,
+		Node:       2,
+		Ports:      []int{8017, 18017},
+	}
+	// There is no "use" script in the sandbox, so the SQL hook is skipped
+	compare.OkEqualInt("post-grants commands", len(hookCommands(hooks, HookPostGrants, context)), 0, t)
+	err = runHooks(nil, hooks, HookPostStart, context)
+	compare.OkIsNil("running hooks", err, t)
+	text, err := common.SlurpAsString(output)
+	compare.OkIsNil("reading hook output", err, t)
+	compare.OkEqualString("hook environment", strings.TrimSpace(text), "post-start slave 2 8017 8017 18017", t)
+
+	failing := path.Join(tempDir, "failing.sh")
+	err = common.WriteString("#!/bin/sh\nexit 1\n", failing)
+	compare.OkIsNil("creation of failing hook", err, t)
+	err = os.Chmod(failing, globals.ExecutableFileAttr)
+	compare.OkIsNil("failing hook permissions", err, t)
+	err = runHooks(nil, []Hook{{Event: HookPreDeploy, File: failing}}, HookPreDeploy, context)
+	compare.OkIsNotNil("failing hook", err, t)
+
+	// In concurrent mode, hooks run in the sandbox directory, and their failures are reported
+	pwdScript := path.Join(tempDir, "pwd.sh")
+	err = common.WriteString("#!/bin/sh\npwd > "+output+"\n", pwdScript)
+	compare.OkIsNil("creation of pwd hook", err, t)
+	err = os.Chmod(pwdScript, globals.ExecutableFileAttr)
+	compare.OkIsNil("pwd hook permissions", err, t)
+	execList := addHooksToExecList(nil, nil, []Hook{{Event: HookPostStart, File: pwdScript}}, HookPostStart, context, 0)
+	compare.OkEqualInt("concurrent hooks", len(execList), 1, t)
+	err = concurrent.RunParallelTasksByPriority(execList)
+	compare.OkIsNil("running concurrent hooks", err, t)
+	text, err = common.SlurpAsString(output)
+	compare.OkIsNil("reading concurrent hook output", err, t)
+	compare.OkEqualString("concurrent hook directory", strings.TrimSpace(text), sandboxDir, t)
+	execList = addHooksToExecList(nil, nil, []Hook{{Event: HookPostDelete, File: failing}}, HookPostDelete, context, 0)
+	err = concurrent.RunParallelTasksByPriority(execList)
+	compare.OkIsNotNil("failing concurrent hook", err, t)
+
+	// Recorded hooks are not validated again: the ones that can't run are skipped
+	recorded := recordedDeleteHooks([]string{
+		"post-start:" + script,
+		"pre-delete",
+		"pre-delete:" + path.Join(tempDir, "no-such-script"),
+		"pre-delete:" + sqlFile,
+		"post-delete:" + failing,
+	})
+	compare.OkEqualString("recorded hooks", strings.Join(hookDefinitions(recorded, HookPreDelete, HookPostDelete), ","),
+		"pre-delete:"+sqlFile+",post-delete:"+failing, t)
+	err = common.WriteSandboxDescription(sandboxDir, common.SandboxDescription{
+		Basedir: tempDir,
+		SBType:  "single",
+		Version: "8.0.16",
+		Port:    []int{8016},
+		Nodes:   0,
+		Hooks:   []string{"pre-delete:" + path.Join(tempDir, "no-such-script"), "post-delete:" + pwdScript},
+	})
+	compare.OkIsNil("writing sandbox description", err, t)
+	contexts, hookLists, err := deleteHookContexts(sandboxDir)
+	compare.OkIsNil("delete hook contexts", err, t)
+	compare.OkEqualInt("delete hook contexts", len(contexts), 1, t)
+	compare.OkEqualString("delete hooks", strings.Join(hookDefinitions(hookLists[0], HookPreDelete, HookPostDelete), ","),
+		"post-delete:"+pwdScript, t)
+}
+
+func TestFindAndReservePorts(t *testing.T) {
+	registry := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-reserve-%d.json", os.Getpid()))
+	defer os.Remove(registry)
+	defer os.Remove(registry + ".lock")
+	defer os.Unsetenv("DBDEPLOYER_PORT_REGISTRY")
+	err := os.Setenv("DBDEPLOYER_PORT_REGISTRY", registry)
+	compare.OkIsNil("port registry variable", err, t)
+	defer common.ClearCleanupActions()
+
+	// Two deployments that start from the same list of used ports get different ports
+	installedPorts := []int{21001}
+	firstDeployment := path.Join(os.TempDir(), "rsandbox_first")
+	secondDeployment := path.Join(os.TempDir(), "rsandbox_second")
+	firstPort, err := findAndReservePorts(firstDeployment, 21000, installedPorts, 3)
+	compare.OkIsNil("first reservation", err, t)
+	compare.OkEqualInt("first port", firstPort, 21002, t)
+	secondPort, err := findAndReservePorts(secondDeployment, 21000, installedPorts, 3)
+	compare.OkIsNil("second reservation", err, t)
+	compare.OkEqualInt("second port", secondPort, 21005, t)
+	compare.OkEqualIntSlices(t, installedPorts, []int{21001})
+
+	// Once the first deployment is aborted, its ports are available again
+	releasePorts(firstDeployment)
+	thirdPort, err := findAndReservePorts(path.Join(os.TempDir(), "rsandbox_third"), 21000, installedPorts, 3)
+	compare.OkIsNil("third reservation", err, t)
+	compare.OkEqualInt("third port", thirdPort, 21002, t)
+}
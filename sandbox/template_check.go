@@ -0,0 +1,343 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Version used to render templates when none is requested
+const SampleTemplateVersion = "8.0.16"
+
+var reVariantTarget = regexp.MustCompile(`^(.+)-(\d+)\.(\d+)$`)
+
+// Returns the flavor and version targeted by a template name. A variant "name@flavor-major.minor"
+// targets that flavor and the first release of that version, and "name@flavor" targets the flavor
+// with SampleTemplateVersion. Templates without variant target MySQL with SampleTemplateVersion
+func TemplateVariantTarget(templateName string) (flavor, version string) {
+	flavor = common.MySQLFlavor
+	version = SampleTemplateVersion
+	parts := strings.SplitN(templateName, TemplateVariantSeparator, 2)
+	if len(parts) < 2 || parts[1] == "" {
+		return flavor, version
+	}
+	flavor = parts[1]
+	matches := reVariantTarget.FindStringSubmatch(parts[1])
+	if len(matches) > 0 {
+		flavor = matches[1]
+		version = fmt.Sprintf("%s.%s.0", matches[2], matches[3])
+	}
+	return flavor, version
+}
+
+// Returns the group that contains a template
+func TemplateGroup(templateName string) (string, bool) {
+	for groupName, group := range AllTemplates {
+		if _, ok := group[templateName]; ok {
+			return groupName, true
+		}
+	}
+	return "", false
+}
+
+func mergeSampleData(maps ...common.StringMap) common.StringMap {
+	var merged = make(common.StringMap)
+	for _, m := range maps {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// Returns data like the one that the deployment functions pass to the templates of a group.
+// The data is built by the same functions used during deployment, with a sample sandbox
+// definition for version and flavor. A group receives the union of the data used with its templates
+func SampleTemplateData(groupName, version, flavor string) (common.StringMap, error) {
+	currentDefaults, err := defaults.LoadDefaults()
+	if err != nil {
+		return nil, err
+	}
+	port, err := common.VersionToPort(version)
+	if err != nil {
+		return nil, err
+	}
+	if flavor == "" {
+		flavor = common.MySQLFlavor
+	}
+	sandboxHome := currentDefaults.SandboxHome
+	pathVersion := strings.Replace(version, ".", "_", -1)
+	timestamp := time.Now()
+	masterIp := "127.0.0.1"
+	var sandboxDef = SandboxDef{
+		Version:       version,
+		Flavor:        flavor,
+		Basedir:       path.Join(currentDefaults.SandboxBinary, version),
+		ClientBasedir: path.Join(currentDefaults.SandboxBinary, version),
+		SandboxDir:    path.Join(sandboxHome, currentDefaults.SandboxPrefix+pathVersion),
+		HistoryDir:    path.Join(sandboxHome, currentDefaults.SandboxPrefix+pathVersion),
+		Port:          port,
+		MysqlXPort:    port + currentDefaults.MysqlXPortDelta,
+		Prompt:        "mysql",
+		ServerId:      100,
+		DbUser:        globals.DbUserValue,
+		DbPassword:    globals.DbPasswordValue,
+		RplUser:       globals.RplUserValue,
+		RplPassword:   globals.RplPasswordValue,
+		RemoteAccess:  globals.RemoteAccessValue,
+		BindAddress:   globals.BindAddressValue,
+	}
+
+	switch groupName {
+	case "mock":
+		return commonTemplateData(sandboxDef, timestamp), nil
+	case "single", "tidb":
+		data, err := singleTemplateData(sandboxDef, "/tmp", "mysqlsh", timestamp)
+		if err != nil {
+			return nil, err
+		}
+		// Used by sb_locked_template, which is filled when a sandbox is locked
+		data["ClearCmd"] = globals.ScriptClear
+		data["NoClearCmd"] = globals.ScriptNoClear
+		return data, nil
+	case "multiple":
+		// Used by multiple sandboxes and by group replication
+		sandboxDef.SandboxDir = path.Join(sandboxHome, currentDefaults.MultiplePrefix+pathVersion)
+		var nodes []common.StringMap
+		for N := 1; N <= 3; N++ {
+			nodes = append(nodes, mergeSampleData(multipleNodeData(sandboxDef, currentDefaults, N, port+N, timestamp),
+				groupNodeData(sandboxDef, currentDefaults, masterIp, "", N, port+N, timestamp)))
+		}
+		data := mergeSampleData(multipleTemplateData(sandboxDef, timestamp),
+			groupTemplateData(sandboxDef, currentDefaults, masterIp, "1 2 3", "", "", timestamp), nodes[0])
+		data["Nodes"] = nodes
+		return data, nil
+	case "replication":
+		// Used by master-slave and multi-source replication
+		sandboxDef.SandboxDir = path.Join(sandboxHome, currentDefaults.MasterSlavePrefix+pathVersion)
+		settings := replicationSettings{masterIp: masterIp, masterPort: port + 1}
+		var slaves []common.StringMap
+		var nodes []common.StringMap
+		for N := 1; N <= 2; N++ {
+			slaves = append(slaves, replicationSlaveData(sandboxDef, currentDefaults, settings, N, port+N+1, timestamp))
+			nodes = append(nodes, multipleNodeData(sandboxDef, currentDefaults, N, port+N, timestamp))
+		}
+		multiSource := multipleTemplateData(sandboxDef, timestamp)
+		setMultiSourceData(multiSource, sandboxDef, currentDefaults, masterIp, "1 2", "3", "")
+		data := mergeSampleData(multiSource, replicationTemplateData(sandboxDef, currentDefaults, settings, timestamp), slaves[0])
+		data["Slaves"] = slaves
+		data["Nodes"] = nodes
+		return data, nil
+	case "group":
+		sandboxDef.SandboxDir = path.Join(sandboxHome, currentDefaults.GroupPrefix+pathVersion)
+		var nodes []common.StringMap
+		for N := 1; N <= 3; N++ {
+			nodes = append(nodes, groupNodeData(sandboxDef, currentDefaults, masterIp, "", N, port+N, timestamp))
+		}
+		data := mergeSampleData(groupTemplateData(sandboxDef, currentDefaults, masterIp, "1 2 3", "", "", timestamp), nodes[0])
+		data["Nodes"] = nodes
+		return data, nil
+	}
+	return nil, fmt.Errorf("no sample data for template group '%s'", groupName)
+}
+
+// A variable found in a template
+type templateField struct {
+	name string
+	// The list that contains the variable, when it is used inside "range"
+	list string
+	// Inside "with", the origin of the variable is unknown
+	unknownScope bool
+	// The variable is only tested by "if"
+	condition bool
+}
+
+// Collects the variables used in a template tree
+func collectTemplateFields(node parse.Node, list string, unknownScope, condition bool, fields *[]templateField) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateFields(child, list, unknownScope, false, fields)
+		}
+	case *parse.ActionNode:
+		collectTemplateFields(n.Pipe, list, unknownScope, false, fields)
+	case *parse.TemplateNode:
+		collectTemplateFields(n.Pipe, list, unknownScope, false, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				collectTemplateFields(arg, list, unknownScope, condition, fields)
+			}
+		}
+	case *parse.FieldNode:
+		*fields = append(*fields, templateField{n.Ident[0], list, unknownScope, condition})
+	case *parse.VariableNode:
+		// Only $.Name refers to the data. Other variables are defined in the template
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			*fields = append(*fields, templateField{n.Ident[1], "", false, condition})
+		}
+	case *parse.IfNode:
+		collectTemplateFields(n.Pipe, list, unknownScope, true, fields)
+		collectTemplateFields(n.List, list, unknownScope, false, fields)
+		collectTemplateFields(n.ElseList, list, unknownScope, false, fields)
+	case *parse.RangeNode:
+		collectTemplateFields(n.Pipe, list, unknownScope, false, fields)
+		elementList := ""
+		elementScope := true
+		if !unknownScope && list == "" && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			if field, ok := n.Pipe.Cmds[0].Args[0].(*parse.FieldNode); ok && len(field.Ident) == 1 {
+				elementList = field.Ident[0]
+				elementScope = false
+			}
+		}
+		collectTemplateFields(n.List, elementList, elementScope, false, fields)
+		collectTemplateFields(n.ElseList, list, unknownScope, false, fields)
+	case *parse.WithNode:
+		collectTemplateFields(n.Pipe, list, unknownScope, true, fields)
+		collectTemplateFields(n.List, "", true, false, fields)
+		collectTemplateFields(n.ElseList, list, unknownScope, false, fields)
+	}
+}
+
+// Returns the variables used by a template
+func templateFields(templateName, contents string) ([]templateField, error) {
+	// Parsed as a text template, so that the built-in functions are known
+	tmpl, err := template.New(templateName).Parse(common.TrimmedLines(contents))
+	if err != nil {
+		return nil, err
+	}
+	var fields []templateField
+	for _, associated := range tmpl.Templates() {
+		if associated.Tree != nil {
+			collectTemplateFields(associated.Tree.Root, "", false, false, &fields)
+		}
+	}
+	return fields, nil
+}
+
+// Checks a template of the given group: the syntax must be valid, all the variables
+// must exist in the data that the group receives or be known to dbdeployer,
+// and it must render without errors.
+// Variants are checked with the flavor and version in their name.
+// Returns the list of problems found
+func CheckTemplate(groupName, templateName, contents string) []error {
+	var problems []error
+	// A variant is checked with the data of the flavor and version it is meant for
+	flavor, version := TemplateVariantTarget(templateName)
+	data, err := SampleTemplateData(groupName, version, flavor)
+	if err != nil {
+		return []error{err}
+	}
+	// Added when the template is filled
+	data["TemplateName"] = templateName
+	fields, err := templateFields(templateName, contents)
+	if err != nil {
+		return []error{fmt.Errorf("syntax error: %s", err)}
+	}
+	var unknown = make(map[string]bool)
+	for _, field := range fields {
+		if field.unknownScope {
+			continue
+		}
+		scope := data
+		if field.list != "" {
+			elements, ok := data[field.list].([]common.StringMap)
+			if !ok || len(elements) == 0 {
+				continue
+			}
+			scope = elements[0]
+		}
+		if _, ok := scope[field.name]; ok {
+			continue
+		}
+		// Variables that dbdeployer provides in other circumstances are filled with an empty value
+		known, ok := TemplateVariableCatalog[field.name]
+		if !ok {
+			unknown[field.name] = true
+			continue
+		}
+		scope[field.name] = placeholderValue(known.Type)
+	}
+	var unknownNames []string
+	for name := range unknown {
+		unknownNames = append(unknownNames, name)
+	}
+	sort.Strings(unknownNames)
+	for _, name := range unknownNames {
+		problems = append(problems, fmt.Errorf("unknown variable '%s'", name))
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	_, err = renderTemplate(templateName, contents, data)
+	if err != nil {
+		problems = append(problems, fmt.Errorf("rendering error: %s", err))
+	}
+	return problems
+}
+
+// Returns an empty value of the given type of template variable
+func placeholderValue(variableType string) interface{} {
+	switch variableType {
+	case TemplateVarInteger:
+		return 0
+	case TemplateVarList:
+		return []common.StringMap{}
+	default:
+		return ""
+	}
+}
+
+func renderTemplate(templateName, contents string, data common.StringMap) (string, error) {
+	tmpl, err := template.New(templateName).Option("missingkey=error").Parse(common.TrimmedLines(contents))
+	if err != nil {
+		return "", err
+	}
+	data["TemplateName"] = templateName
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Returns the output of a template of the given group, filled with sample data for version and flavor
+func RenderTemplate(groupName, templateName, contents, version, flavor string) (string, error) {
+	data, err := SampleTemplateData(groupName, version, flavor)
+	if err != nil {
+		return "", err
+	}
+	return renderTemplate(templateName, contents, data)
+}
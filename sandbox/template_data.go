@@ -0,0 +1,219 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+// The functions in this file build the data passed to the templates.
+// They are used by the deployment functions and by the template checker,
+// so that the checker sees the same variables that a deployment provides.
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/defaults"
+	"github.com/datacharmer/dbdeployer/globals"
+)
+
+// Data that the templates of every group receive
+func commonTemplateData(sandboxDef SandboxDef, timestamp time.Time) common.StringMap {
+	return common.StringMap{
+		"Copyright":  Copyright,
+		"AppVersion": common.VersionDef,
+		"DateTime":   timestamp.Format(time.UnixDate),
+		"SandboxDir": sandboxDef.SandboxDir,
+		"Version":    sandboxDef.Version,
+		"Flavor":     sandboxDef.Flavor,
+	}
+}
+
+// Data for the templates of a single sandbox, where sandboxDef.SandboxDir is the sandbox directory.
+// The variables about the initialization of the server are empty, and are filled by the deployment
+// once the installation script has been found
+func singleTemplateData(sandboxDef SandboxDef, globalTmpDir, mysqlShell string, timestamp time.Time) (common.StringMap, error) {
+	verList, err := common.VersionToList(sandboxDef.Version)
+	if err != nil {
+		return nil, err
+	}
+	data := commonTemplateData(sandboxDef, timestamp)
+	for key, value := range (common.StringMap{
+		"Copyright":            SingleTemplates["Copyright"].Contents,
+		"Basedir":              sandboxDef.Basedir,
+		"ClientBasedir":        sandboxDef.ClientBasedir,
+		"CustomMysqld":         sandboxDef.CustomMysqld,
+		"Port":                 sandboxDef.Port,
+		"MysqlXPort":           sandboxDef.MysqlXPort,
+		"MysqlShell":           mysqlShell,
+		"BasePort":             sandboxDef.BasePort,
+		"Prompt":               sandboxDef.Prompt,
+		"VersionMajor":         verList[0],
+		"VersionMinor":         verList[1],
+		"VersionRev":           verList[2],
+		"Datadir":              path.Join(sandboxDef.SandboxDir, globals.DataDirName),
+		"Tmpdir":               path.Join(sandboxDef.SandboxDir, "tmp"),
+		"GlobalTmpDir":         globalTmpDir,
+		"DbUser":               sandboxDef.DbUser,
+		"DbPassword":           sandboxDef.DbPassword,
+		"RplUser":              sandboxDef.RplUser,
+		"RplPassword":          sandboxDef.RplPassword,
+		"RemoteAccess":         sandboxDef.RemoteAccess,
+		"BindAddress":          sandboxDef.BindAddress,
+		"OsUser":               os.Getenv("USER"),
+		"ReplOptions":          sandboxDef.ReplOptions,
+		"GtidOptions":          sandboxDef.GtidOptions,
+		"ReplCrashSafeOptions": sandboxDef.ReplCrashSafeOptions,
+		"SemiSyncOptions":      sandboxDef.SemiSyncOptions,
+		"ReadOnlyOptions":      sandboxDef.ReadOnlyOptions,
+		"ExtraOptions":         sliceToText(sandboxDef.MyCnfOptions),
+		"ReportHost":           fmt.Sprintf("report-host=single-%d", sandboxDef.Port),
+		"ReportPort":           fmt.Sprintf("report-port=%d", sandboxDef.Port),
+		"HistoryDir":           sandboxDef.HistoryDir,
+		"ServerId":             "",
+		"InitScript":           "",
+		"InitDefaults":         "",
+		"ExtraInitFlags":       "",
+		"FixUuidFile1":         "",
+		"FixUuidFile2":         "",
+	}) {
+		data[key] = value
+	}
+	if sandboxDef.NodeNum != 0 {
+		data["ReportHost"] = fmt.Sprintf("report-host = node-%d", sandboxDef.NodeNum)
+	}
+	if sandboxDef.SkipReportHost || sandboxDef.SBType == "group-node" {
+		data["ReportHost"] = ""
+	}
+	if sandboxDef.SkipReportPort {
+		data["ReportPort"] = ""
+	}
+	if sandboxDef.ServerId > 0 {
+		data["ServerId"] = fmt.Sprintf("server-id=%d", sandboxDef.ServerId)
+	}
+	return data, nil
+}
+
+// Data for the scripts of a multiple sandbox. The nodes are added with multipleNodeData
+func multipleTemplateData(sandboxDef SandboxDef, timestamp time.Time) common.StringMap {
+	data := commonTemplateData(sandboxDef, timestamp)
+	data["Nodes"] = []common.StringMap{}
+	return data
+}
+
+// Data for a node of a multiple sandbox, used in the "Nodes" list and in the node script
+func multipleNodeData(sandboxDef SandboxDef, currentDefaults defaults.DbdeployerDefaults, node, nodePort int, timestamp time.Time) common.StringMap {
+	data := commonTemplateData(sandboxDef, timestamp)
+	data["Node"] = node
+	data["NodePort"] = nodePort
+	data["NodeLabel"] = currentDefaults.NodePrefix
+	return data
+}
+
+// Adds the variables of multi-source replication to the data of a multiple sandbox
+func setMultiSourceData(data common.StringMap, sandboxDef SandboxDef, currentDefaults defaults.DbdeployerDefaults, masterIp, masterList, slaveList, readOnlyOptions string) {
+	setGlobal := "GLOBAL"
+	// persistent, err := common.GreaterOrEqualVersion(sandboxDef.Version, globals.MinimumRolesVersion)
+	persistent, _ := common.HasCapability(sandboxDef.Flavor, common.SetPersist, sandboxDef.Version)
+	if persistent {
+		setGlobal = "PERSIST"
+	}
+	data["SetGlobal"] = setGlobal
+	data["SlavesReadOnly"] = readOnlyOptions
+	data["MasterList"] = normalizeNodeList(masterList)
+	data["SlaveList"] = normalizeNodeList(slaveList)
+	data["MasterAbbr"] = currentDefaults.MasterAbbr
+	data["MasterLabel"] = currentDefaults.MasterName
+	data["SlaveAbbr"] = currentDefaults.SlaveAbbr
+	data["SlaveLabel"] = currentDefaults.SlavePrefix
+	data["RplUser"] = sandboxDef.RplUser
+	data["RplPassword"] = sandboxDef.RplPassword
+	data["NodeLabel"] = currentDefaults.NodePrefix
+	data["MasterIp"] = masterIp
+}
+
+// Settings shared by the nodes of a master/slave replication
+type replicationSettings struct {
+	masterIp           string
+	masterPort         int
+	changeMasterExtra  string
+	masterAutoPosition string
+}
+
+// Data for the scripts of a master/slave replication. The slaves are added with replicationSlaveData
+func replicationTemplateData(sandboxDef SandboxDef, currentDefaults defaults.DbdeployerDefaults, settings replicationSettings, timestamp time.Time) common.StringMap {
+	data := commonTemplateData(sandboxDef, timestamp)
+	for key, value := range (common.StringMap{
+		"MasterLabel":        currentDefaults.MasterName,
+		"MasterPort":         settings.masterPort,
+		"SlaveLabel":         currentDefaults.SlavePrefix,
+		"MasterAbbr":         currentDefaults.MasterAbbr,
+		"MasterIp":           settings.masterIp,
+		"RplUser":            sandboxDef.RplUser,
+		"RplPassword":        sandboxDef.RplPassword,
+		"SlaveAbbr":          currentDefaults.SlaveAbbr,
+		"ChangeMasterExtra":  settings.changeMasterExtra,
+		"MasterAutoPosition": settings.masterAutoPosition,
+		"Slaves":             []common.StringMap{},
+	}) {
+		data[key] = value
+	}
+	return data
+}
+
+// Data for a slave of a master/slave replication, used in the "Slaves" list and in the slave script
+func replicationSlaveData(sandboxDef SandboxDef, currentDefaults defaults.DbdeployerDefaults, settings replicationSettings, node, nodePort int, timestamp time.Time) common.StringMap {
+	data := replicationTemplateData(sandboxDef, currentDefaults, settings, timestamp)
+	delete(data, "Slaves")
+	delete(data, "MasterLabel")
+	data["Node"] = node
+	data["NodeLabel"] = currentDefaults.NodePrefix
+	data["NodePort"] = nodePort
+	return data
+}
+
+// Data for the scripts of a group replication. The nodes are added with groupNodeData
+func groupTemplateData(sandboxDef SandboxDef, currentDefaults defaults.DbdeployerDefaults, masterIp, masterList, slaveList, changeMasterExtra string, timestamp time.Time) common.StringMap {
+	data := commonTemplateData(sandboxDef, timestamp)
+	for key, value := range (common.StringMap{
+		"MasterIp":          masterIp,
+		"MasterList":        masterList,
+		"NodeLabel":         currentDefaults.NodePrefix,
+		"SlaveList":         slaveList,
+		"RplUser":           sandboxDef.RplUser,
+		"RplPassword":       sandboxDef.RplPassword,
+		"SlaveLabel":        currentDefaults.SlavePrefix,
+		"SlaveAbbr":         currentDefaults.SlaveAbbr,
+		"ChangeMasterExtra": changeMasterExtra,
+		"MasterLabel":       currentDefaults.MasterName,
+		"MasterAbbr":        currentDefaults.MasterAbbr,
+		"Nodes":             []common.StringMap{},
+	}) {
+		data[key] = value
+	}
+	return data
+}
+
+// Data for a node of a group replication, used in the "Nodes" list and in the node script
+func groupNodeData(sandboxDef SandboxDef, currentDefaults defaults.DbdeployerDefaults, masterIp, changeMasterExtra string, node, nodePort int, timestamp time.Time) common.StringMap {
+	data := groupTemplateData(sandboxDef, currentDefaults, masterIp, "", "", changeMasterExtra, timestamp)
+	delete(data, "Nodes")
+	delete(data, "MasterList")
+	delete(data, "SlaveList")
+	data["Node"] = node
+	data["NodePort"] = nodePort
+	return data
+}
@@ -0,0 +1,228 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// Types of template variables
+const (
+	TemplateVarString  = "string"
+	TemplateVarInteger = "integer"
+	TemplateVarList    = "list"
+	TemplateVarUnknown = "unknown"
+)
+
+// Describes a variable that dbdeployer passes to templates
+type TemplateVariable struct {
+	Name        string
+	Type        string
+	Description string
+	// The list (such as "Nodes") whose elements contain the variable. Empty for top-level variables
+	List string
+	// A required variable must be in the data, or the deployment fails
+	Required bool
+}
+
+// Variables that dbdeployer provides to templates
+var TemplateVariableCatalog = map[string]TemplateVariable{
+	// Common to all groups
+	"Copyright":    {Type: TemplateVarString, Description: "Copyright notice for every sandbox script"},
+	"AppVersion":   {Type: TemplateVarString, Description: "dbdeployer version"},
+	"DateTime":     {Type: TemplateVarString, Description: "Time of the deployment"},
+	"TemplateName": {Type: TemplateVarString, Description: "Name of the template being filled"},
+	"SandboxDir":   {Type: TemplateVarString, Description: "Directory of the sandbox"},
+	"Version":      {Type: TemplateVarString, Description: "Database server version (such as 8.0.16)"},
+	"Flavor":       {Type: TemplateVarString, Description: "Database flavor (such as mysql, mariadb, percona)"},
+
+	// Single sandbox
+	"Basedir":              {Type: TemplateVarString, Description: "Directory containing the server binaries"},
+	"ClientBasedir":        {Type: TemplateVarString, Description: "Directory containing the client binaries"},
+	"CustomMysqld":         {Type: TemplateVarString, Description: "Alternative server executable, if requested"},
+	"Port":                 {Type: TemplateVarInteger, Description: "Server port"},
+	"MysqlXPort":           {Type: TemplateVarInteger, Description: "Port for the MySQLX protocol"},
+	"MysqlShell":           {Type: TemplateVarString, Description: "Path of the MySQL shell executable"},
+	"BasePort":             {Type: TemplateVarInteger, Description: "Base port for the sandbox"},
+	"Prompt":               {Type: TemplateVarString, Description: "Prompt for the database client"},
+	"VersionMajor":         {Type: TemplateVarInteger, Description: "Major component of the version"},
+	"VersionMinor":         {Type: TemplateVarInteger, Description: "Minor component of the version"},
+	"VersionRev":           {Type: TemplateVarInteger, Description: "Revision component of the version"},
+	"Datadir":              {Type: TemplateVarString, Description: "Data directory"},
+	"Tmpdir":               {Type: TemplateVarString, Description: "Temporary directory of the sandbox"},
+	"GlobalTmpDir":         {Type: TemplateVarString, Description: "System temporary directory, where the socket is created"},
+	"DbUser":               {Type: TemplateVarString, Description: "Database user"},
+	"DbPassword":           {Type: TemplateVarString, Description: "Password of the database user"},
+	"RemoteAccess":         {Type: TemplateVarString, Description: "Host from which database users can connect"},
+	"BindAddress":          {Type: TemplateVarString, Description: "Address the server listens to"},
+	"OsUser":               {Type: TemplateVarString, Description: "Operating system user running the sandbox"},
+	"ReplOptions":          {Type: TemplateVarString, Description: "Replication options for my.cnf"},
+	"GtidOptions":          {Type: TemplateVarString, Description: "GTID options for my.cnf"},
+	"ReplCrashSafeOptions": {Type: TemplateVarString, Description: "Crash safe replication options for my.cnf"},
+	"SemiSyncOptions":      {Type: TemplateVarString, Description: "Semi-synchronous replication options for my.cnf"},
+	"ReadOnlyOptions":      {Type: TemplateVarString, Description: "Read-only options for my.cnf"},
+	"ExtraOptions":         {Type: TemplateVarString, Description: "Options for my.cnf given by the user"},
+	"ReportHost":           {Type: TemplateVarString, Description: "report-host option for my.cnf, if needed"},
+	"ReportPort":           {Type: TemplateVarString, Description: "report-port option for my.cnf, if needed"},
+	"HistoryDir":           {Type: TemplateVarString, Description: "Directory of the client history file"},
+	"ServerId":             {Type: TemplateVarString, Description: "server-id option for my.cnf, if needed"},
+	"InitScript":           {Type: TemplateVarString, Description: "Command that initializes the database"},
+	"InitDefaults":         {Type: TemplateVarString, Description: "Defaults option for the initialization command"},
+	"ExtraInitFlags":       {Type: TemplateVarString, Description: "Options for the initialization command given by the user"},
+	"FixUuidFile1":         {Type: TemplateVarString, Description: "Command that creates a server UUID file, if needed"},
+	"FixUuidFile2":         {Type: TemplateVarString, Description: "Command that fills the server UUID file, if needed"},
+	"ClearCmd":             {Type: TemplateVarString, Description: "Name of the disabled 'clear' script of a locked sandbox"},
+	"NoClearCmd":           {Type: TemplateVarString, Description: "Name of the file holding the disabled 'clear' script"},
+
+	// Replication and multiple sandboxes
+	"RplUser":            {Type: TemplateVarString, Description: "Replication user"},
+	"RplPassword":        {Type: TemplateVarString, Description: "Password of the replication user"},
+	"MasterIp":           {Type: TemplateVarString, Description: "IP address of the master"},
+	"MasterPort":         {Type: TemplateVarInteger, Description: "Port of the master"},
+	"MasterLabel":        {Type: TemplateVarString, Description: "Name of the master script and directory"},
+	"MasterAbbr":         {Type: TemplateVarString, Description: "Abbreviation of the master name"},
+	"SlaveLabel":         {Type: TemplateVarString, Description: "Prefix of slave scripts and directories"},
+	"SlaveAbbr":          {Type: TemplateVarString, Description: "Abbreviation of the slave prefix"},
+	"NodeLabel":          {Type: TemplateVarString, Description: "Prefix of node scripts and directories"},
+	"ChangeMasterExtra":  {Type: TemplateVarString, Description: "Additional options for CHANGE MASTER TO"},
+	"MasterAutoPosition": {Type: TemplateVarString, Description: "MASTER_AUTO_POSITION option for CHANGE MASTER TO, if needed"},
+	"MasterList":         {Type: TemplateVarString, Description: "Space separated list of master node numbers"},
+	"SlaveList":          {Type: TemplateVarString, Description: "Space separated list of slave node numbers"},
+	"SlavesReadOnly":     {Type: TemplateVarString, Description: "Statement that makes slaves read-only, if needed"},
+	"SetGlobal":          {Type: TemplateVarString, Description: "Keyword used to set global variables (GLOBAL or PERSIST)"},
+	"Node":               {Type: TemplateVarInteger, Description: "Node number"},
+	"NodePort":           {Type: TemplateVarInteger, Description: "Port of the node"},
+	"Nodes":              {Type: TemplateVarList, Description: "Nodes of the sandbox"},
+	"Slaves":             {Type: TemplateVarList, Description: "Slaves of the replication sandbox"},
+}
+
+// Variables that are added to the data when a template is filled
+var automaticTemplateVariables = map[string]bool{
+	"AppVersion":   true,
+	"DateTime":     true,
+	"TemplateName": true,
+}
+
+func declaredVariable(declaration string, required bool) TemplateVariable {
+	variable := TemplateVariable{
+		Name:        declaration,
+		Type:        TemplateVarUnknown,
+		Description: "not provided by dbdeployer",
+		Required:    required,
+	}
+	if dot := strings.Index(declaration, "."); dot > 0 {
+		variable.List = declaration[:dot]
+		variable.Name = declaration[dot+1:]
+	}
+	known, ok := TemplateVariableCatalog[variable.Name]
+	if ok {
+		variable.Type = known.Type
+		variable.Description = known.Description
+	}
+	return variable
+}
+
+// Returns the variables declared by a template, with their description
+func TemplateVariablesFor(template TemplateDesc) []TemplateVariable {
+	var variables []TemplateVariable
+	for _, declaration := range template.Variables {
+		variables = append(variables, declaredVariable(declaration, true))
+	}
+	for _, declaration := range template.OptionalVariables {
+		variables = append(variables, declaredVariable(declaration, false))
+	}
+	return variables
+}
+
+// Returns the variables used by a template, written as List.Name when they belong
+// to the elements of a list. The ones used inside "with" are not included, as their origin is unknown
+func usedTemplateVariables(templateName, contents string) ([]string, error) {
+	fields, err := templateFields(templateName, contents)
+	if err != nil {
+		return nil, err
+	}
+	var seen = make(map[string]bool)
+	var used []string
+	for _, field := range fields {
+		if field.unknownScope {
+			continue
+		}
+		name := field.name
+		if field.list != "" {
+			name = field.list + "." + field.name
+		}
+		if !seen[name] {
+			seen[name] = true
+			used = append(used, name)
+		}
+	}
+	sort.Strings(used)
+	return used, nil
+}
+
+// Returns an error if the contents of a template use variables that the template does not declare
+func CheckTemplateDeclarations(templateName string, template TemplateDesc) error {
+	used, err := usedTemplateVariables(templateName, template.Contents)
+	if err != nil {
+		return fmt.Errorf("template %s: %s", templateName, err)
+	}
+	var declared = make(map[string]bool)
+	for _, name := range append(template.Variables, template.OptionalVariables...) {
+		declared[name] = true
+	}
+	var undeclared []string
+	for _, name := range used {
+		if !declared[name] && !automaticTemplateVariables[name] {
+			undeclared = append(undeclared, name)
+		}
+	}
+	if len(undeclared) > 0 {
+		return fmt.Errorf("template %s uses undeclared variables: %s", templateName, strings.Join(undeclared, ", "))
+	}
+	return nil
+}
+
+// Returns an error if the data lacks any of the variables that a template requires
+func CheckRequiredVariables(templateName string, variables []TemplateVariable, data common.StringMap) error {
+	var missing []string
+	for _, variable := range variables {
+		if !variable.Required || automaticTemplateVariables[variable.Name] {
+			continue
+		}
+		if variable.List == "" {
+			if _, ok := data[variable.Name]; !ok {
+				missing = append(missing, variable.Name)
+			}
+			continue
+		}
+		elements, _ := data[variable.List].([]common.StringMap)
+		for N, element := range elements {
+			if _, ok := element[variable.Name]; !ok {
+				missing = append(missing, fmt.Sprintf("%s[%d].%s", variable.List, N, variable.Name))
+				break
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("template %s requires variables that were not provided: %s",
+			templateName, strings.Join(missing, ", "))
+	}
+	return nil
+}
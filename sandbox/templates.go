@@ -25,6 +25,11 @@ type TemplateDesc struct {
 	Description    string
 	Notes          string
 	Contents       string
+	// Variables that must be in the data used to fill Contents, besides the ones
+	// added automatically. A variable of the elements of a list is written as List.Name
+	Variables []string
+	// Variables that Contents uses only when they are present, as in {{if .Name}}
+	OptionalVariables []string
 }
 
 type TemplateCollection map[string]TemplateDesc
@@ -1199,116 +1204,144 @@ function check_output
 			Description: "Initialization template for the database",
 			Notes:       "This should normally run only once",
 			Contents:    initDbTemplate,
+			Variables: []string{"Basedir", "Copyright", "ExtraInitFlags", "FixUuidFile1", "FixUuidFile2",
+				"InitDefaults", "InitScript", "OsUser", "SandboxDir"},
 		},
 		"start_template": TemplateDesc{
 			Description: "starts the database in a single sandbox (with optional mysqld arguments)",
 			Notes:       "",
 			Contents:    startTemplate,
+			Variables:   []string{"Copyright", "CustomMysqld", "SandboxDir"},
 		},
 		"use_template": TemplateDesc{
 			Description: "Invokes the MySQL client with the appropriate options",
 			Notes:       "",
 			Contents:    useTemplate,
+			Variables:   []string{"Copyright", "HistoryDir", "SandboxDir"},
 		},
 		"mysqlsh_template": TemplateDesc{
 			Description: "Invokes the MySQL shell with an appropriate URI",
 			Notes:       "",
 			Contents:    mysqlshTemplate,
+			Variables:   []string{"Copyright", "DbPassword", "MysqlShell", "MysqlXPort", "SandboxDir"},
 		},
 		"stop_template": TemplateDesc{
 			Description: "Stops a database in a single sandbox",
 			Notes:       "",
 			Contents:    stopTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"clear_template": TemplateDesc{
 			Description: "Remove all data from a single sandbox",
 			Notes:       "",
 			Contents:    clearTemplate,
+			Variables:   []string{"Copyright", "SandboxDir", "Version"},
 		},
 		"my_cnf_template": TemplateDesc{
 			Description: "Default options file for a sandbox",
 			Notes:       "",
 			Contents:    myCnfTemplate,
+			Variables: []string{"Basedir", "BindAddress", "Copyright", "Datadir", "DbPassword", "DbUser",
+				"ExtraOptions", "GlobalTmpDir", "GtidOptions", "OsUser", "Port", "Prompt", "ReadOnlyOptions",
+				"ReplCrashSafeOptions", "ReplOptions", "ReportHost", "ReportPort", "SemiSyncOptions",
+				"ServerId", "Tmpdir"},
 		},
 		"status_template": TemplateDesc{
 			Description: "Shows the status of a single sandbox",
 			Notes:       "",
 			Contents:    statusTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"restart_template": TemplateDesc{
 			Description: "Restarts the database (with optional mysqld arguments)",
 			Notes:       "",
 			Contents:    restartTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"send_kill_template": TemplateDesc{
 			Description: "Sends a kill signal to the database",
 			Notes:       "",
 			Contents:    sendKillTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"load_grants_template": TemplateDesc{
 			Description: "Loads the grants defined for the sandbox",
 			Notes:       "",
 			Contents:    loadGrantsTemplate,
+			Variables:   []string{"Copyright", "GlobalTmpDir", "Port", "SandboxDir"},
 		},
 		"grants_template5x": TemplateDesc{
 			Description: "Grants for sandboxes up to 5.6",
 			Notes:       "",
 			Contents:    grantsTemplate5x,
+			Variables:   []string{"DbPassword", "DbUser", "RemoteAccess", "RplPassword", "RplUser"},
 		},
 		"grants_template57": TemplateDesc{
 			Description: "Grants for sandboxes from 5.7+",
 			Notes:       "",
 			Contents:    grantsTemplate57,
+			Variables:   []string{"DbPassword", "DbUser", "RemoteAccess", "RplPassword", "RplUser"},
 		},
 		"grants_template8x": TemplateDesc{
 			Description: "Grants for sandboxes from 8.0+",
 			Notes:       "",
 			Contents:    grantsTemplate8x,
+			Variables:   []string{"DbPassword", "DbUser", "RemoteAccess", "RplPassword", "RplUser"},
 		},
 		"my_template": TemplateDesc{
 			Description: "Prefix script to run every my* command line tool",
 			Notes:       "",
 			Contents:    myTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"add_option_template": TemplateDesc{
 			Description: "Adds options to the my.sandbox.cnf file and restarts",
 			Notes:       "",
 			Contents:    addOptionTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"show_log_template": TemplateDesc{
 			Description: "Shows error log or custom log",
 			Notes:       "",
 			Contents:    showLogTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"show_binlog_template": TemplateDesc{
 			Description: "Shows a binlog for a single sandbox",
 			Notes:       "",
 			Contents:    showBinlogTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"show_relaylog_template": TemplateDesc{
 			Description: "Show the relaylog for a single sandbox",
 			Notes:       "",
 			Contents:    showRelaylogTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"test_sb_template": TemplateDesc{
 			Description: "Tests basic sandbox functionality",
 			Notes:       "",
 			Contents:    testSbTemplate,
+			Variables:   []string{"Copyright", "Port", "SandboxDir", "Version"},
 		},
 		"sb_locked_template": TemplateDesc{
 			Description: "locked sandbox script",
 			Notes:       "This script is replacing 'clear' when the sandbox is locked",
 			Contents:    sbLockedTemplate,
+			Variables:   []string{"ClearCmd", "Copyright", "NoClearCmd", "SandboxDir"},
 		},
 		"after_start_template": TemplateDesc{
 			Description: "commands to run after the database started",
 			Notes:       "This script does nothing. You can change it and reuse through --use-template",
 			Contents:    afterStartTemplate,
+			Variables:   []string{"Copyright", "SandboxDir"},
 		},
 		"sb_include_template": TemplateDesc{
 			Description: "Common variables and routines for sandboxes scripts",
 			Notes:       "",
 			Contents:    sbIncludeTemplate,
+			Variables: []string{"Basedir", "ClientBasedir", "Port", "SandboxDir", "Version", "VersionMajor",
+				"VersionMinor", "VersionRev"},
 		},
 	}
 	MockTemplates = TemplateCollection{
@@ -399,36 +399,43 @@ var TidbTemplates = TemplateCollection{
 		Description: "Initialization template for the TiDB server",
 		Notes:       "This should normally run only once",
 		Contents:    tidbInitTemplate,
+		Variables:   []string{"Basedir", "Copyright", "GlobalTmpDir", "Port", "SandboxDir"},
 	},
 	"tidb_my_cnf_template": TemplateDesc{
 		Description: "Default options file for a TiDB sandbox",
 		Notes:       "",
 		Contents:    tidbMyCnfTemplate,
+		Variables:   []string{"Copyright", "DbPassword", "DbUser", "GlobalTmpDir", "Port", "Prompt"},
 	},
 	"tidb_start_template": TemplateDesc{
 		Description: "Stops a database in a single TiDB sandbox",
 		Notes:       "",
 		Contents:    tidbStartTemplate,
+		Variables:   []string{"Copyright", "GlobalTmpDir", "Port", "SandboxDir"},
 	},
 	"tidb_stop_template": TemplateDesc{
 		Description: "Stops a database in a single TiDB sandbox",
 		Notes:       "",
 		Contents:    tidbStopTemplate,
+		Variables:   []string{"Copyright", "SandboxDir"},
 	},
 	"tidb_send_kill_template": TemplateDesc{
 		Description: "Sends a kill signal to the TiDB database",
 		Notes:       "",
 		Contents:    tidbSendKillTemplate,
+		Variables:   []string{"Copyright", "GlobalTmpDir", "Port", "SandboxDir"},
 	},
 	"tidb_grants_template5x": TemplateDesc{
 		Description: "Grants for TiDB sandboxes",
 		Notes:       "",
 		Contents:    tidbGrantsTemplate,
+		Variables:   []string{"DbPassword", "DbUser", "RemoteAccess", "RplPassword", "RplUser"},
 	},
 	"tidb_after_start_template": TemplateDesc{
 		Description: "commands to run after the database started",
 		Notes:       "This script does nothing. You can change it and reuse through --use-template",
 		Contents:    tidbAfterStartTemplate,
+		Variables:   []string{"Copyright", "SandboxDir"},
 	},
 }
 
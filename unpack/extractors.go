@@ -0,0 +1,314 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/globals"
+	"github.com/pkg/errors"
+	"github.com/xi2/xz"
+)
+
+// An archive format that dbdeployer can expand
+type Extractor interface {
+	// Short name of the format
+	Name() string
+	// Returns true if the beginning of a file belongs to this format
+	Matches(header []byte) bool
+	// Expands the archive into the destination directory, leaving out the entries skipped by the filter
+	Extract(file *os.File, destination string, filter *SkipFilter) error
+}
+
+// Size of the header needed to recognize all formats.
+// The tar signature is at offset 257
+const archiveHeaderSize = 512
+
+// A tar archive, possibly compressed
+type tarExtractor struct {
+	name   string
+	magic  []byte
+	offset int
+	// Returns a reader for the uncompressed tar stream
+	decompress func(io.Reader) (io.ReadCloser, error)
+}
+
+func (te tarExtractor) Name() string {
+	return te.name
+}
+
+func (te tarExtractor) Matches(header []byte) bool {
+	if len(header) < te.offset+len(te.magic) {
+		return false
+	}
+	return bytes.Equal(header[te.offset:te.offset+len(te.magic)], te.magic)
+}
+
+func (te tarExtractor) Extract(file *os.File, destination string, filter *SkipFilter) error {
+	reader, err := te.decompress(file)
+	if err != nil {
+		return err
+	}
+	err = unpackTarFiles(tar.NewReader(reader), destination, filter)
+	err1 := reader.Close()
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
+// A zip archive
+type zipExtractor struct{}
+
+func (ze zipExtractor) Name() string {
+	return "zip"
+}
+
+func (ze zipExtractor) Matches(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("PK\x03\x04"))
+}
+
+func (ze zipExtractor) Extract(file *os.File, destination string, filter *SkipFilter) error {
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	reader, err := zip.NewReader(file, stat.Size())
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, item := range reader.File {
+		if filter.Skip(item.Name, int64(item.UncompressedSize64)) {
+			continue
+		}
+		fileName := sanitizedName(item.Name)
+		if fileName == "" {
+			continue
+		}
+		fullName := path.Join(destination, fileName)
+		fileMode := item.Mode()
+		if fileMode.IsDir() {
+			err = os.MkdirAll(fullName, globals.PublicDirectoryAttr)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = os.MkdirAll(path.Dir(fullName), globals.PublicDirectoryAttr)
+		if err != nil {
+			return err
+		}
+		err = unpackZipFile(fileName, fullName, item)
+		if err != nil {
+			return err
+		}
+		count++
+		condPrint(fileName, true, CHATTY)
+		showProgress(count)
+	}
+	condPrint("Files ", false, CHATTY)
+	condPrint(fmt.Sprintf("%d", count), true, 1)
+	return nil
+}
+
+// Extracts a zip entry named fileName, relative to the destination, into fullName
+func unpackZipFile(fileName, fullName string, item *zip.File) error {
+	reader, err := item.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if item.Mode()&os.ModeSymlink != 0 {
+		// The target of a symbolic link is stored as the file contents
+		target, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		err = checkLinkTarget(fileName, string(target))
+		if err != nil {
+			return err
+		}
+		condPrint(fmt.Sprintf("%s -> %s", fileName, target), true, CHATTY)
+		return os.Symlink(string(target), fullName)
+	}
+	writer, err := os.OpenFile(fullName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, item.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	err1 := writer.Close()
+	if err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chmod(fullName, item.Mode().Perm())
+}
+
+// Reads the output of an external decompression command
+type commandReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (cr commandReader) Close() error {
+	// Reading may have stopped before the end of the stream.
+	// Draining it lets the command finish normally
+	_, _ = io.Copy(ioutil.Discard, cr.ReadCloser)
+	return cr.cmd.Wait()
+}
+
+// Returns a function that decompresses a stream using an external program
+func externalDecompressor(program string, args ...string) func(io.Reader) (io.ReadCloser, error) {
+	return func(reader io.Reader) (io.ReadCloser, error) {
+		executable := common.FindInPath(program)
+		if executable == "" {
+			return nil, fmt.Errorf("program '%s' not found in PATH. It is needed to expand this archive", program)
+		}
+		cmd := exec.Command(executable, args...)
+		cmd.Stdin = reader
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		err = cmd.Start()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error starting %s", program)
+		}
+		return commandReader{stdout, cmd}, nil
+	}
+}
+
+var extractors = []Extractor{
+	tarExtractor{
+		name:  "gzip",
+		magic: []byte{0x1f, 0x8b},
+		decompress: func(reader io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(reader)
+		},
+	},
+	tarExtractor{
+		name:  "xz",
+		magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		decompress: func(reader io.Reader) (io.ReadCloser, error) {
+			xzReader, err := xz.NewReader(reader, 0)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(xzReader), nil
+		},
+	},
+	tarExtractor{
+		name:  "bzip2",
+		magic: []byte("BZh"),
+		decompress: func(reader io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(reader)), nil
+		},
+	},
+	tarExtractor{
+		name:       "zstd",
+		magic:      []byte{0x28, 0xb5, 0x2f, 0xfd},
+		decompress: externalDecompressor("zstd", "-d", "-c", "-q"),
+	},
+	zipExtractor{},
+	tarExtractor{
+		name:   "tar",
+		magic:  []byte("ustar"),
+		offset: 257,
+		decompress: func(reader io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(reader), nil
+		},
+	},
+}
+
+// Returns the extractor for an archive, detecting its format from the file contents
+func FindExtractor(fileName string) (Extractor, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header := make([]byte, archiveHeaderSize)
+	size, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	header = header[:size]
+	for _, extractor := range extractors {
+		if extractor.Matches(header) {
+			return extractor, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized archive format for %s", fileName)
+}
+
+// Returns the name of an archive without directory and archive extension
+func ArchiveBareName(fileName string) string {
+	baseName := path.Base(fileName)
+	for _, extension := range globals.ArchiveExtensions {
+		if strings.HasSuffix(baseName, extension) {
+			return strings.TrimSuffix(baseName, extension)
+		}
+	}
+	return baseName
+}
+
+// Expands an archive into the destination directory.
+// The archive format is detected from its contents
+func UnpackArchive(fileName string, destination string, verbosityLevel int) error {
+	return UnpackArchiveWithFilter(fileName, destination, verbosityLevel, nil)
+}
+
+// Expands an archive into the destination directory, without the entries skipped by the filter
+func UnpackArchiveWithFilter(fileName string, destination string, verbosityLevel int, filter *SkipFilter) error {
+	Verbose = verbosityLevel
+	if !common.FileExists(fileName) {
+		return fmt.Errorf("file %s not found", fileName)
+	}
+	if !common.DirExists(destination) {
+		return fmt.Errorf("destination directory '%s' does not exist", destination)
+	}
+	extractor, err := FindExtractor(fileName)
+	if err != nil {
+		return err
+	}
+	fileName, err = common.AbsolutePath(fileName)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	condPrint(fmt.Sprintf("Archive format: %s", extractor.Name()), true, CHATTY)
+	return extractor.Extract(file, destination, filter)
+}
@@ -0,0 +1,261 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+// An entry of a test archive. Entries with a link are symbolic links
+type testEntry struct {
+	name     string
+	contents string
+	mode     os.FileMode
+	link     string
+}
+
+var testEntries = []testEntry{
+	{name: "mysql-8.0.16/bin/mysqld", contents: "#!/bin/sh\n", mode: 0755},
+	{name: "mysql-8.0.16/lib/libmysqlclient.so.21", contents: "library", mode: 0644},
+	{name: "mysql-8.0.16/lib/libmysqlclient.so", link: "libmysqlclient.so.21"},
+}
+
+func makeTestDir(t *testing.T, label string) string {
+	dir := path.Join(os.TempDir(), fmt.Sprintf("dbdeployer-unpack-%s-%d", label, os.Getpid()))
+	err := os.MkdirAll(dir, 0755)
+	compare.OkIsNil("test directory "+label, err, t)
+	return dir
+}
+
+func makeTarData(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: int64(entry.mode), Size: int64(len(entry.contents)), Typeflag: tar.TypeReg}
+		if entry.link != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0777, Linkname: entry.link, Typeflag: tar.TypeSymlink}
+		}
+		err := writer.WriteHeader(header)
+		compare.OkIsNil("tar header "+entry.name, err, t)
+		if entry.link == "" {
+			_, err = writer.Write([]byte(entry.contents))
+			compare.OkIsNil("tar contents "+entry.name, err, t)
+		}
+	}
+	err := writer.Close()
+	compare.OkIsNil("tar close", err, t)
+	return buf.Bytes()
+}
+
+func makeZipData(t *testing.T, entries []testEntry) []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		contents := entry.contents
+		if entry.link != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			contents = entry.link
+		} else {
+			header.SetMode(entry.mode)
+		}
+		w, err := writer.CreateHeader(header)
+		compare.OkIsNil("zip header "+entry.name, err, t)
+		_, err = w.Write([]byte(contents))
+		compare.OkIsNil("zip contents "+entry.name, err, t)
+	}
+	err := writer.Close()
+	compare.OkIsNil("zip close", err, t)
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
+	compare.OkIsNil("gzip write", err, t)
+	err = writer.Close()
+	compare.OkIsNil("gzip close", err, t)
+	return buf.Bytes()
+}
+
+// Compresses data with an external program, skipping the test when the program is not available
+func compressWith(t *testing.T, program string, data []byte) []byte {
+	executable := common.FindInPath(program)
+	if executable == "" {
+		t.Skipf("%s not found in PATH", program)
+	}
+	cmd := exec.Command(executable, "-c")
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.Output()
+	compare.OkIsNil("compression with "+program, err, t)
+	return out
+}
+
+// Expands an archive into an empty directory
+func unpackTestArchive(t *testing.T, baseDir, archiveName string, data []byte) (string, error) {
+	return unpackTestArchiveWithFilter(t, baseDir, archiveName, data, nil)
+}
+
+func unpackTestArchiveWithFilter(t *testing.T, baseDir, archiveName string, data []byte, filter *SkipFilter) (string, error) {
+	archive := path.Join(baseDir, archiveName)
+	err := ioutil.WriteFile(archive, data, 0644)
+	compare.OkIsNil("archive "+archiveName, err, t)
+	destination := path.Join(baseDir, "dest-"+archiveName)
+	err = os.MkdirAll(destination, 0755)
+	compare.OkIsNil("destination "+archiveName, err, t)
+	currentDir, err := os.Getwd()
+	compare.OkIsNil("current directory", err, t)
+	err = UnpackArchiveWithFilter(archive, destination, SILENT, filter)
+	// Unpacking does not change the current directory
+	afterDir, _ := os.Getwd()
+	compare.OkEqualString("current directory after unpacking "+archiveName, afterDir, currentDir, t)
+	return destination, err
+}
+
+func checkUnpackedEntries(t *testing.T, destination string, entries []testEntry) {
+	for _, entry := range entries {
+		fileName := path.Join(destination, entry.name)
+		stat, err := os.Lstat(fileName)
+		compare.OkIsNil("unpacked "+entry.name, err, t)
+		if err != nil {
+			continue
+		}
+		if entry.link != "" {
+			compare.OkEqualBool("symlink "+entry.name, stat.Mode()&os.ModeSymlink != 0, true, t)
+			target, err := os.Readlink(fileName)
+			compare.OkIsNil("link target "+entry.name, err, t)
+			compare.OkEqualString("link target "+entry.name, target, entry.link, t)
+			continue
+		}
+		compare.OkEqualString("mode "+entry.name, stat.Mode().Perm().String(), entry.mode.String(), t)
+		contents, err := common.SlurpAsString(fileName)
+		compare.OkIsNil("contents "+entry.name, err, t)
+		compare.OkEqualString("contents "+entry.name, contents, entry.contents, t)
+	}
+}
+
+func TestFindExtractor(t *testing.T) {
+	baseDir := makeTestDir(t, "find")
+	defer os.RemoveAll(baseDir)
+	tarData := makeTarData(t, testEntries)
+
+	type extractorTest struct {
+		label    string
+		contents []byte
+		expected string
+	}
+	var data = []extractorTest{
+		{"gzip", gzipData(t, tarData), "gzip"},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x04}, "xz"},
+		{"bzip2", []byte("BZh91AY&SY"), "bzip2"},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, "zstd"},
+		{"zip", makeZipData(t, testEntries), "zip"},
+		{"tar", tarData, "tar"},
+		{"text", []byte("just some text that is not an archive"), ""},
+		{"empty", []byte{}, ""},
+	}
+	for _, et := range data {
+		fileName := path.Join(baseDir, et.label)
+		err := ioutil.WriteFile(fileName, et.contents, 0644)
+		compare.OkIsNil("file "+et.label, err, t)
+		extractor, err := FindExtractor(fileName)
+		if et.expected == "" {
+			compare.OkIsNotNil("no extractor for "+et.label, err, t)
+			continue
+		}
+		compare.OkIsNil("extractor for "+et.label, err, t)
+		if extractor != nil {
+			compare.OkEqualString("extractor for "+et.label, extractor.Name(), et.expected, t)
+		}
+	}
+	_, err := FindExtractor(path.Join(baseDir, "missing"))
+	compare.OkIsNotNil("missing file", err, t)
+}
+
+func TestCheckLinkTarget(t *testing.T) {
+	type linkTest struct {
+		link   string
+		target string
+		ok     bool
+	}
+	var data = []linkTest{
+		{"mysql/lib/libssl.dylib", "libssl.1.0.0.dylib", true},
+		{"mysql/bin/mysql", "../lib/mysql", true},
+		{"mysql/bin/mysql", "../../mysql/bin/other", true},
+		{"mysql/bin/mysql", "../../../etc/passwd", false},
+		{"mysql/link", "..", true},
+		{"link", "..", false},
+		{"mysql/link", "/etc/passwd", false},
+	}
+	for _, lt := range data {
+		err := checkLinkTarget(lt.link, lt.target)
+		label := fmt.Sprintf("%s -> %s", lt.link, lt.target)
+		if lt.ok {
+			compare.OkIsNil(label, err, t)
+		} else {
+			compare.OkIsNotNil(label, err, t)
+		}
+	}
+}
+
+func TestUnpackZip(t *testing.T) {
+	baseDir := makeTestDir(t, "zip")
+	defer os.RemoveAll(baseDir)
+
+	destination, err := unpackTestArchive(t, baseDir, "mysql.zip", makeZipData(t, testEntries))
+	compare.OkIsNil("unpack zip", err, t)
+	checkUnpackedEntries(t, destination, testEntries)
+
+	for N, target := range []string{"../../../outside", "/etc/passwd"} {
+		entries := append([]testEntry{}, testEntries[0], testEntry{name: "mysql-8.0.16/lib/evil", link: target})
+		archiveName := fmt.Sprintf("evil%d.zip", N)
+		destination, err = unpackTestArchive(t, baseDir, archiveName, makeZipData(t, entries))
+		compare.OkIsNotNil("zip with link to "+target, err, t)
+		_, err = os.Lstat(path.Join(destination, "mysql-8.0.16/lib/evil"))
+		compare.OkEqualBool("link to "+target+" not created", os.IsNotExist(err), true, t)
+	}
+}
+
+func TestUnpackTar(t *testing.T) {
+	baseDir := makeTestDir(t, "tar")
+	defer os.RemoveAll(baseDir)
+
+	tarData := makeTarData(t, testEntries)
+	destination, err := unpackTestArchive(t, baseDir, "mysql.tar.gz", gzipData(t, tarData))
+	compare.OkIsNil("unpack tar.gz", err, t)
+	checkUnpackedEntries(t, destination, testEntries)
+
+	entries := append([]testEntry{}, testEntries[0], testEntry{name: "mysql-8.0.16/lib/evil", link: "../../../outside"})
+	_, err = unpackTestArchive(t, baseDir, "evil.tar.gz", gzipData(t, makeTarData(t, entries)))
+	compare.OkIsNotNil("tar with link outside the destination", err, t)
+
+	destination, err = unpackTestArchive(t, baseDir, "mysql.tar.xz", compressWith(t, "xz", tarData))
+	compare.OkIsNil("unpack tar.xz", err, t)
+	checkUnpackedEntries(t, destination, testEntries)
+}
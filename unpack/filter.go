@@ -0,0 +1,95 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/datacharmer/dbdeployer/common"
+)
+
+// Entries not needed to run a sandbox: test suite, documentation,
+// static libraries, and debug binaries.
+// The files used to detect flavor and operating system are never skipped
+var DefaultSkipPatterns = []string{
+	"mysql-test",
+	"docs",
+	"lib/*.a",
+	"bin/*-debug",
+	"lib/plugin/debug",
+}
+
+// Decides which archive entries are left out of the extraction
+type SkipFilter struct {
+	patterns     []string
+	keep         map[string]bool
+	SkippedFiles int
+	SkippedBytes int64
+}
+
+// Returns a filter for the given patterns.
+// Patterns use shell wildcards and apply to the entry path without its top directory.
+// A pattern matching a directory skips all its contents.
+// The detection and binary files of the registered flavors are always extracted
+func NewSkipFilter(patterns []string) (*SkipFilter, error) {
+	for _, pattern := range patterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid skip pattern '%s': %s", pattern, err)
+		}
+	}
+	keep := make(map[string]bool)
+	for _, flavor := range common.FlavorDefinitions() {
+		for _, files := range [][]common.FlavorFile{flavor.DetectionFiles, flavor.BinaryFiles} {
+			for _, file := range files {
+				keep[path.Join(file.Dir, file.FileName)] = true
+			}
+		}
+	}
+	return &SkipFilter{patterns: patterns, keep: keep}, nil
+}
+
+// Returns true if the entry should not be extracted, and keeps count of the skipped data.
+// A nil filter skips nothing
+func (sf *SkipFilter) Skip(entryName string, size int64) bool {
+	if sf == nil || len(sf.patterns) == 0 {
+		return false
+	}
+	components := strings.Split(strings.Trim(entryName, "/"), "/")
+	if len(components) < 2 {
+		return false
+	}
+	// The top directory is the one named after the tarball
+	components = components[1:]
+	if sf.keep[strings.Join(components, "/")] {
+		return false
+	}
+	for N := range components {
+		subPath := strings.Join(components[:N+1], "/")
+		for _, pattern := range sf.patterns {
+			matches, _ := path.Match(pattern, subPath)
+			if matches {
+				sf.SkippedFiles++
+				sf.SkippedBytes += size
+				condPrint(" - "+entryName, true, CHATTY)
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// DBDeployer - The MySQL Sandbox
+// Copyright © 2006-2019 Giuseppe Maxia
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unpack
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/datacharmer/dbdeployer/common"
+	"github.com/datacharmer/dbdeployer/compare"
+)
+
+func TestSkipFilter(t *testing.T) {
+	filter, err := NewSkipFilter(DefaultSkipPatterns)
+	compare.OkIsNil("default filter", err, t)
+
+	type skipTest struct {
+		entry string
+		skip  bool
+	}
+	var data = []skipTest{
+		{"mysql-8.0.16/bin/mysqld", false},
+		{"mysql-8.0.16/bin/mysqld-debug", true},
+		{"mysql-8.0.16/mysql-test/", true},
+		{"mysql-8.0.16/mysql-test/t/alias.test", true},
+		{"mysql-8.0.16/docs/INFO_SRC", true},
+		{"mysql-8.0.16/share/docs", false},
+		{"mysql-8.0.16/lib/libmysqlservices.a", true},
+		{"mysql-8.0.16/lib/plugin/debug/auth.so", true},
+		{"mysql-8.0.16/lib/plugin/auth.so", false},
+		{"mysql-8.0.16/lib/libmysqlclient.so.21", false},
+		// Files used to detect flavor and operating system
+		{"mysql-5.0.96/lib/libmysqlclient.a", false},
+		{"Percona-Server-5.7.25/lib/libperconaserverclient.a", false},
+		{"mariadb-10.3.13/lib/libmariadbclient.a", false},
+		{"mariadb-10.3.13/lib/libmariadb.a", false},
+		{"mariadb-10.3.13/lib/libmysqld.a", true},
+		// The top directory is never matched
+		{"docs/INFO_SRC", false},
+		{"docs", false},
+	}
+	for _, st := range data {
+		compare.OkEqualBool(st.entry, filter.Skip(st.entry, 100), st.skip, t)
+	}
+	skipped := 0
+	for _, st := range data {
+		if st.skip {
+			skipped++
+		}
+	}
+	compare.OkEqualInt("skipped files", filter.SkippedFiles, skipped, t)
+	compare.OkEqualInt("skipped bytes", int(filter.SkippedBytes), skipped*100, t)
+
+	var nilFilter *SkipFilter
+	compare.OkEqualBool("nil filter", nilFilter.Skip("mysql-8.0.16/docs/INFO_SRC", 1), false, t)
+	emptyFilter, err := NewSkipFilter(nil)
+	compare.OkIsNil("empty filter", err, t)
+	compare.OkEqualBool("empty filter", emptyFilter.Skip("mysql-8.0.16/docs/INFO_SRC", 1), false, t)
+
+	custom, err := NewSkipFilter([]string{"share/*.txt"})
+	compare.OkIsNil("custom filter", err, t)
+	compare.OkEqualBool("custom pattern", custom.Skip("mysql/share/errors.txt", 1), true, t)
+	compare.OkEqualBool("custom pattern in subdirectory", custom.Skip("mysql/share/english/errors.txt", 1), false, t)
+
+	_, err = NewSkipFilter([]string{"lib/["})
+	compare.OkIsNotNil("invalid pattern", err, t)
+}
+
+func TestSkipFilterInUnpack(t *testing.T) {
+	baseDir := makeTestDir(t, "filter")
+	defer os.RemoveAll(baseDir)
+	entries := append([]testEntry{}, testEntries...)
+	entries = append(entries,
+		testEntry{name: "mysql-8.0.16/lib/libmysqlclient.a", contents: "static client", mode: 0644},
+		testEntry{name: "mysql-8.0.16/lib/libmysqlservices.a", contents: "static", mode: 0644},
+		testEntry{name: "mysql-8.0.16/mysql-test/mtr", contents: "test", mode: 0755})
+	filter, err := NewSkipFilter(DefaultSkipPatterns)
+	compare.OkIsNil("default filter", err, t)
+	destination, err := unpackTestArchiveWithFilter(t, baseDir, "mysql.zip", makeZipData(t, entries), filter)
+	compare.OkIsNil("unpack with filter", err, t)
+	checkUnpackedEntries(t, destination, entries[:len(testEntries)+1])
+	compare.OkEqualInt("skipped files", filter.SkippedFiles, 2, t)
+	for _, skipped := range []string{"lib/libmysqlservices.a", "mysql-test/mtr"} {
+		compare.OkEqualBool(skipped+" not extracted", common.FileExists(path.Join(destination, "mysql-8.0.16", skipped)), false, t)
+	}
+}
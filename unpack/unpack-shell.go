@@ -23,7 +23,7 @@ import (
 	"path"
 )
 
-func MergeShell(tarball, extension, basedir, destination, bareName string, verbosity int) error {
+func MergeShell(tarball, basedir, destination, bareName string, verbosity int) error {
 	// fmt.Printf("<%s> <%s> <%s> <%s> %d\n",tarball, basedir, destination, bareName, verbosity)
 	if !common.DirExists(basedir) {
 		return fmt.Errorf(globals.ErrNamedDirectoryNotFound, "unpack directory", destination)
@@ -48,15 +48,7 @@ func MergeShell(tarball, extension, basedir, destination, bareName string, verbo
 		}
 	}
 
-	var err error
-	switch extension {
-	case globals.TarGzExt:
-		err = UnpackTar(tarball, basedir, verbosity)
-	case globals.TarXzExt:
-		err = UnpackXzTar(tarball, basedir, verbosity)
-	default:
-		return fmt.Errorf("unrecognized extension %s\n", extension)
-	}
+	err := UnpackArchive(tarball, basedir, verbosity)
 	if err != nil {
 		return err
 	}
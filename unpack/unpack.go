@@ -52,12 +52,8 @@ package unpack
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"fmt"
-	"github.com/datacharmer/dbdeployer/common"
 	"github.com/datacharmer/dbdeployer/globals"
-	"github.com/pkg/errors"
-	"github.com/xi2/xz"
 	"io"
 	"os"
 	"path"
@@ -78,91 +74,35 @@ func condPrint(s string, nl bool, level int) {
 		if nl {
 			fmt.Println(s)
 		} else {
-			fmt.Printf(s)
+			fmt.Print(s)
 		}
 	}
 }
 
-func validSuffix(filename string) bool {
-	for _, suffix := range []string{globals.TgzExt, globals.TarExt, globals.TarGzExt} {
-		if strings.HasSuffix(filename, suffix) {
-			return true
-		}
-	}
-	return false
-}
-
+// Expands a .tar.xz archive. Kept for compatibility: the format is now detected from the contents
 func UnpackXzTar(filename string, destination string, verbosityLevel int) (err error) {
-	Verbose = verbosityLevel
-	if !common.FileExists(filename) {
-		return fmt.Errorf("file %s not found", filename)
-	}
-	if !common.DirExists(destination) {
-		return fmt.Errorf("directory %s not found", destination)
-	}
-	filename, err = common.AbsolutePath(filename)
-	if err != nil {
-		return err
-	}
-	err = os.Chdir(destination)
-	if err != nil {
-		return errors.Wrapf(err, "error changing directory to %s", destination)
-	}
-	f, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	// Create an xz Reader
-	r, err := xz.NewReader(f, 0)
-	if err != nil {
-		return err
-	}
-	// Create a tar Reader
-	tr := tar.NewReader(r)
-	return unpackTarFiles(tr)
+	return UnpackArchive(filename, destination, verbosityLevel)
 }
 
+// Expands a .tar.gz or .tar archive. Kept for compatibility: the format is now detected from the contents
 func UnpackTar(filename string, destination string, verbosityLevel int) (err error) {
-	Verbose = verbosityLevel
-	f, err := os.Stat(destination)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("destination directory '%s' does not exist", destination)
-	}
-	filemode := f.Mode()
-	if filemode.IsDir() == false {
-		return fmt.Errorf("destination '%s' is not a directory", destination)
-	}
-	if !validSuffix(filename) {
-		return fmt.Errorf("unrecognized archive suffix")
-	}
-	var file *os.File
-	if file, err = os.Open(filename); err != nil {
-		return err
-	}
-	defer file.Close()
-	err = os.Chdir(destination)
-	if err != nil {
-		return errors.Wrapf(err, "error changing directory to %s", destination)
-	}
-	var fileReader io.Reader = file
-	var decompressor *gzip.Reader
-	if strings.HasSuffix(filename, globals.GzExt) {
-		if decompressor, err = gzip.NewReader(file); err != nil {
-			return err
+	return UnpackArchive(filename, destination, verbosityLevel)
+}
+
+// Shows a mark every 10 extracted files, and the count every 100
+func showProgress(count int) {
+	if count%10 == 0 {
+		mark := "."
+		if count%100 == 0 {
+			mark = strconv.Itoa(count)
+		}
+		if Verbose < CHATTY {
+			condPrint(mark, false, 1)
 		}
-		defer decompressor.Close()
-	}
-	var reader *tar.Reader
-	if decompressor != nil {
-		reader = tar.NewReader(decompressor)
-	} else {
-		reader = tar.NewReader(fileReader)
 	}
-	return unpackTarFiles(reader)
 }
 
-func unpackTarFiles(reader *tar.Reader) (err error) {
+func unpackTarFiles(reader *tar.Reader, destination string, filter *SkipFilter) (err error) {
 	var header *tar.Header
 	var count int = 0
 
@@ -213,9 +153,13 @@ func unpackTarFiles(reader *tar.Reader) (err error) {
 				PAXRecords:map[string]string(nil),
 				Format:0}
 		*/
+		if filter.Skip(header.Name, header.Size) {
+			continue
+		}
 		filemode := os.FileMode(header.Mode)
 		filename := sanitizedName(header.Name)
-		fileDir := path.Dir(filename)
+		fullName := path.Join(destination, filename)
+		fileDir := path.Dir(fullName)
 		if _, err := os.Stat(fileDir); os.IsNotExist(err) {
 			if err = os.MkdirAll(fileDir, globals.PublicDirectoryAttr); err != nil {
 				return err
@@ -227,32 +171,28 @@ func unpackTarFiles(reader *tar.Reader) (err error) {
 		}
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err = os.MkdirAll(filename, globals.PublicDirectoryAttr); err != nil {
+			if err = os.MkdirAll(fullName, globals.PublicDirectoryAttr); err != nil {
 				return err
 			}
 		case tar.TypeReg:
-			if err = unpackTarFile(filename, reader); err != nil {
+			if err = unpackTarFile(fullName, reader); err != nil {
 				return err
 			}
-			err = os.Chmod(filename, filemode)
+			err = os.Chmod(fullName, filemode)
 			if err != nil {
 				return err
 			}
 			count++
 			condPrint(filename, true, CHATTY)
-			if count%10 == 0 {
-				mark := "."
-				if count%100 == 0 {
-					mark = strconv.Itoa(count)
-				}
-				if Verbose < CHATTY {
-					condPrint(mark, false, 1)
-				}
-			}
+			showProgress(count)
 		case tar.TypeSymlink:
 			if header.Linkname != "" {
+				err = checkLinkTarget(filename, header.Linkname)
+				if err != nil {
+					return err
+				}
 				condPrint(fmt.Sprintf("%s -> %s", filename, header.Linkname), true, CHATTY)
-				err := os.Symlink(header.Linkname, filename)
+				err := os.Symlink(header.Linkname, fullName)
 				if err != nil {
 					return fmt.Errorf("%#v\n#ERROR: %s", header, err)
 				}
@@ -285,3 +225,16 @@ func sanitizedName(filename string) string {
 	filename = strings.Replace(filename, "../", "", -1)
 	return strings.Replace(filename, "..\\", "", -1)
 }
+
+// Returns an error if a symbolic link extracted as linkName would point outside
+// the destination directory
+func checkLinkTarget(linkName, target string) error {
+	if path.IsAbs(target) {
+		return fmt.Errorf("symbolic link %s points to absolute path %s", linkName, target)
+	}
+	resolved := path.Clean(path.Join(path.Dir(linkName), target))
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return fmt.Errorf("symbolic link %s points to %s, outside the destination directory", linkName, target)
+	}
+	return nil
+}